@@ -0,0 +1,53 @@
+// kubectl-website is a kubectl plugin for operating Website objects, invoked as `kubectl website`.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// commands are the subcommands of the plugin.
+var commands = map[string]func(args []string) error{
+	"rollback": rollback,
+	"tail":     tail,
+	"wait":     wait,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		fmt.Fprintln(os.Stderr, "usage: kubectl website <command> [flags]")
+		fmt.Fprintln(os.Stderr, "commands:")
+		fmt.Fprintln(os.Stderr, "  rollback <name> --to-revision=N [-o json|yaml]   restore a revision of a Website's rendered configuration")
+		fmt.Fprintln(os.Stderr, "  tail <name> --server=URL [-o json|yaml]              stream the requests of a Website")
+		fmt.Fprintln(os.Stderr, "  wait <name> --for=Ready --timeout=2m [-o json|yaml]  wait for a condition of a Website")
+		os.Exit(2)
+	}
+
+	err := commands[os.Args[1]](os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// newClient creates a client for the cluster of the current kubeconfig context.
+func newClient() (client.WithWatch, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	err = v1alpha1.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewWithWatch(cfg, client.Options{Scheme: scheme})
+}
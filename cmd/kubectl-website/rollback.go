@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// rollbackAnnotation must match the annotation handled by the controller.
+const rollbackAnnotation = "website-operator.io/rollback-to-revision"
+
+// RollbackReport is the output of the rollback command.
+type RollbackReport struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Revision  int64  `json:"revision"`
+}
+
+// rollback requests that the controller restores a revision of a Website's rendered configuration.
+func rollback(args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ExitOnError)
+	namespace := flags.String("n", "default", "namespace of the Website")
+	revision := flags.Int64("to-revision", 0, "revision to roll back to, as listed in status.revisions")
+	output := flags.String("o", "", "output format of the report: json or yaml, a line if empty")
+	args, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *revision <= 0 {
+		return errors.New("usage: kubectl website rollback <name> --to-revision=N [-n namespace] [-o json|yaml]")
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+
+	// Check that the revision is still in the history
+	ctx := context.Background()
+	website := &v1alpha1.Website{}
+	err = c.Get(ctx, types.NamespacedName{Namespace: *namespace, Name: args[0]}, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to get Website")
+	}
+	found := false
+	for _, r := range website.Status.Revisions {
+		if r.Revision == *revision {
+			found = true
+		}
+	}
+	if !found {
+		return errors.Errorf("revision %d is not in the history of Website %s", *revision, website.Name)
+	}
+
+	// Request the rollback
+	patched := website.DeepCopy()
+	if patched.Annotations == nil {
+		patched.Annotations = map[string]string{}
+	}
+	patched.Annotations[rollbackAnnotation] = strconv.FormatInt(*revision, 10)
+	err = c.Patch(ctx, patched, client.MergeFrom(website))
+	if err != nil {
+		return errors.Wrap(err, "failed to request rollback")
+	}
+
+	if *output != "" {
+		return cli.PrintReport(*output, RollbackReport{Namespace: website.Namespace, Name: website.Name, Revision: *revision})
+	}
+	fmt.Printf("website/%s rollback to revision %d requested\n", website.Name, *revision)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/yaml"
+
+	"github.com/website-operator/pkg/cli"
+)
+
+// tailRecord must match the records streamed by the controller's tail endpoint.
+type tailRecord struct {
+	Time        string  `json:"time"`
+	RemoteAddr  string  `json:"remoteAddr"`
+	Method      string  `json:"method"`
+	Request     string  `json:"request"`
+	Status      int     `json:"status"`
+	BytesSent   int64   `json:"bytesSent"`
+	RequestTime float64 `json:"requestTime"`
+}
+
+// tail streams the requests of a Website from the controller API until interrupted.
+func tail(args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ExitOnError)
+	namespace := flags.String("n", "default", "namespace of the Website")
+	server := flags.String("server", os.Getenv("WEBSITE_CONTROLLER_API"), "URL of the controller API (default $WEBSITE_CONTROLLER_API)")
+	token := flags.String("token", "", "bearer token (default the token of the current kubeconfig context)")
+	status := flags.String("status", "", `only show requests of a status class ("5xx") or code ("404")`)
+	path := flags.String("path", "", "only show requests below a path prefix")
+	raw := flags.Bool("json", false, "print the requests as JSON lines, like -o json")
+	output := flags.String("o", "", "output format of the requests: json lines or yaml documents, a line per request if empty")
+	args, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *server == "" {
+		return errors.New("usage: kubectl website tail <name> --server=URL [--status=5xx] [--path=/prefix] [-n namespace] [-o json|yaml]")
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+	if *raw {
+		*output = cli.OutputJSON
+	}
+
+	// Authenticate as the current kubeconfig user
+	if *token == "" {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to load kubeconfig")
+		}
+		*token = cfg.BearerToken
+		if *token == "" && cfg.BearerTokenFile != "" {
+			data, err := os.ReadFile(cfg.BearerTokenFile)
+			if err != nil {
+				return errors.Wrap(err, "failed to read token file")
+			}
+			*token = strings.TrimSpace(string(data))
+		}
+		if *token == "" {
+			return errors.New("the current kubeconfig context has no bearer token, pass --token")
+		}
+	}
+
+	// Stream the requests
+	query := url.Values{}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+	if *path != "" {
+		query.Set("path", *path)
+	}
+	endpoint := fmt.Sprintf("%s/api/websites/%s/%s/tail?%s", strings.TrimSuffix(*server, "/"), url.PathEscape(*namespace), url.PathEscape(args[0]), query.Encode())
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to controller API")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		return errors.Errorf("controller API returned %s: %s", resp.Status, strings.TrimSpace(body))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if *output == cli.OutputJSON {
+			fmt.Println(scanner.Text())
+			continue
+		}
+		record := tailRecord{}
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			continue
+		}
+		if *output == cli.OutputYAML {
+			out, err := yaml.Marshal(record)
+			if err != nil {
+				return errors.Wrap(err, "failed to encode request")
+			}
+			fmt.Printf("---\n%s", out)
+			continue
+		}
+		fmt.Printf("%s %s %d %s %s %dB %.3fs\n", record.Time, record.RemoteAddr, record.Status, record.Method, record.Request, record.BytesSent, record.RequestTime)
+	}
+	return scanner.Err()
+}
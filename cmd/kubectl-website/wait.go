@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// wait waits until a condition of a Website has the expected status for its current
+// generation, like `website-controller wait`.
+func wait(args []string) error {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	namespace := flags.String("n", "default", "namespace of the Website")
+	condition := flags.String("for", v1alpha1.ConditionReady, "condition to wait for, as Type or Type=Status")
+	timeout := flags.Duration("timeout", 2*time.Minute, "how long to wait for the condition")
+	output := flags.String("o", "", "output format of the report: json or yaml, a line if empty")
+	args, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: kubectl website wait <name> [--for=Type[=Status]] [--timeout=DURATION] [-n namespace] [-o json|yaml]")
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+	conditionType, status, err := cli.ParseWaitCondition(*condition)
+	if err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+
+	// Watch the Website until the condition is met for its generation
+	key := types.NamespacedName{Namespace: *namespace, Name: args[0]}
+	report, err := cli.Wait(c, key, conditionType, status, *timeout)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return cli.PrintReport(*output, report)
+	}
+	fmt.Printf("website/%s condition met: %s=%s\n", key.Name, conditionType, status)
+	return nil
+}
@@ -0,0 +1,57 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Capabilities of the nginx data plane.
+const (
+	CapabilityHTTP2  = "http2"
+	CapabilityBrotli = "brotli"
+	CapabilityGeoIP2 = "geoip2"
+	CapabilityNJS    = "njs"
+	CapabilityOtel   = "otel"
+
+	// CapabilityLeastTime is the least_time balancing method of nginx Plus.
+	CapabilityLeastTime = "least_time"
+)
+
+// NginxCapabilitiesStatus describes the nginx binary and modules of an edge.
+type NginxCapabilitiesStatus struct {
+	// Version is the nginx version.
+	Version string `json:"version"`
+
+	// Capabilities lists the detected capabilities, e.g. http2 or njs.
+	// +optional
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Time is when the capabilities were detected.
+	Time metav1.Time `json:"time"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+
+// NginxCapabilities reports what the nginx of an edge supports. It is named after the
+// controller's host and written by the controller at startup.
+type NginxCapabilities struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status NginxCapabilitiesStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NginxCapabilitiesList contains a list of NginxCapabilities.
+type NginxCapabilitiesList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NginxCapabilities `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NginxCapabilities{}, &NginxCapabilitiesList{})
+}
@@ -0,0 +1,128 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NginxGlobalConfigName is the name of the only NginxGlobalConfig the controller applies.
+const NginxGlobalConfigName = "default"
+
+// NginxGlobalConfigSpec defines the global nginx directives managed by the controller.
+// Unset fields keep the nginx defaults.
+type NginxGlobalConfigSpec struct {
+	// WorkerProcesses is "auto" or the number of worker processes.
+	// +kubebuilder:validation:Pattern=`^(auto|[1-9][0-9]*)$`
+	// +optional
+	WorkerProcesses string `json:"workerProcesses,omitempty"`
+
+	// WorkerConnections is the maximum number of connections per worker process.
+	// +kubebuilder:validation:Minimum=64
+	// +optional
+	WorkerConnections *int32 `json:"workerConnections,omitempty"`
+
+	// Resolvers are the name servers used to resolve upstream hostnames at runtime.
+	// +optional
+	Resolvers []string `json:"resolvers,omitempty"`
+
+	// ResolverValid overrides the TTL of resolved names.
+	// +optional
+	ResolverValid *metav1.Duration `json:"resolverValid,omitempty"`
+
+	// LogFormats defines named log formats Websites can refer to.
+	// +optional
+	LogFormats []LogFormat `json:"logFormats,omitempty"`
+
+	// SSL holds the TLS defaults of all Websites.
+	// +optional
+	SSL *SSLDefaults `json:"ssl,omitempty"`
+
+	// DefaultServer brands the pages of the managed default server, which answers requests
+	// for hostnames no Website serves: 404 over HTTP, and 421 over HTTPS connections that
+	// were established for another hostname.
+	// +optional
+	DefaultServer *DefaultServerSpec `json:"defaultServer,omitempty"`
+}
+
+// DefaultServerSpec holds the pages of the default server.
+type DefaultServerSpec struct {
+	// NotFoundPage is the HTML page of 404 responses.
+	// +optional
+	NotFoundPage string `json:"notFoundPage,omitempty"`
+
+	// MisdirectedPage is the HTML page of 421 responses.
+	// +optional
+	MisdirectedPage string `json:"misdirectedPage,omitempty"`
+}
+
+// LogFormat is a named nginx log_format.
+type LogFormat struct {
+	// Name is the name of the format.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z_][a-zA-Z0-9_]*$`
+	Name string `json:"name"`
+
+	// Format is the format string, including nginx variables.
+	Format string `json:"format"`
+
+	// Escape is the escaping applied to variables.
+	// +kubebuilder:validation:Enum=default;json;none
+	// +optional
+	Escape string `json:"escape,omitempty"`
+}
+
+// SSLDefaults holds TLS settings applied to every Website.
+type SSLDefaults struct {
+	// Protocols are the enabled TLS protocols, e.g. TLSv1.2 and TLSv1.3.
+	// +optional
+	Protocols []string `json:"protocols,omitempty"`
+
+	// Ciphers is the OpenSSL cipher list.
+	// +optional
+	Ciphers string `json:"ciphers,omitempty"`
+
+	// PreferServerCiphers prefers the server's cipher order over the client's.
+	// +optional
+	PreferServerCiphers *bool `json:"preferServerCiphers,omitempty"`
+
+	// SessionTimeout is how long TLS sessions can be reused.
+	// +optional
+	SessionTimeout *metav1.Duration `json:"sessionTimeout,omitempty"`
+}
+
+// NginxGlobalConfigStatus defines the observed state of an NginxGlobalConfig.
+type NginxGlobalConfigStatus struct {
+	// ObservedGeneration is the generation last applied to nginx.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe whether the configuration was applied.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+
+// NginxGlobalConfig holds the global nginx directives of the edge. Only the object
+// named "default" is applied.
+type NginxGlobalConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NginxGlobalConfigSpec   `json:"spec,omitempty"`
+	Status NginxGlobalConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NginxGlobalConfigList contains a list of NginxGlobalConfigs.
+type NginxGlobalConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []NginxGlobalConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NginxGlobalConfig{}, &NginxGlobalConfigList{})
+}
@@ -0,0 +1,89 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Stream protocols.
+const (
+	StreamProtocolTCP = "TCP"
+	StreamProtocolUDP = "UDP"
+)
+
+// Stream TLS modes.
+const (
+	StreamTLSPassthrough = "Passthrough"
+	StreamTLSTerminate   = "Terminate"
+)
+
+// StreamRouteSpec defines a TCP or UDP port proxied to an upstream.
+type StreamRouteSpec struct {
+	// Port is the port nginx listens on. Ports are shared by all namespaces: a port claimed
+	// by several StreamRoutes is served for the oldest one. TCP ports 80 and 443 are
+	// reserved for Websites.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Protocol is "TCP" or "UDP". It defaults to "TCP".
+	// +kubebuilder:validation:Enum=TCP;UDP
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// Upstream is the host:port connections are proxied to, e.g. "postgres.db.svc:5432".
+	Upstream string `json:"upstream"`
+
+	// TLS configures TLS for TCP routes.
+	// +optional
+	TLS *StreamTLSSpec `json:"tls,omitempty"`
+}
+
+// StreamTLSSpec configures TLS for a StreamRoute.
+type StreamTLSSpec struct {
+	// Mode "Passthrough" forwards TLS connections untouched to the upstream, which terminates
+	// them. Mode "Terminate" terminates TLS at the edge with the certificate of SecretRef and
+	// forwards plaintext. It defaults to "Passthrough".
+	// +kubebuilder:validation:Enum=Passthrough;Terminate
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// SecretRef reads the certificate and key of mode Terminate from a kubernetes.io/tls
+	// Secret in the StreamRoute's namespace.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+}
+
+// StreamRouteStatus defines the observed state of a StreamRoute.
+type StreamRouteStatus struct {
+	// Conditions describe whether the StreamRoute is served.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// StreamRoute exposes a non-HTTP service, such as a database or an MQTT broker, on a port of
+// the edge nginx. It is rendered into the nginx stream context.
+type StreamRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   StreamRouteSpec   `json:"spec,omitempty"`
+	Status StreamRouteStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// StreamRouteList contains a list of StreamRoutes.
+type StreamRouteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []StreamRoute `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&StreamRoute{}, &StreamRouteList{})
+}
@@ -0,0 +1,1070 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// WebsiteSpec defines the desired state of a Website.
+type WebsiteSpec struct {
+	// Hostname is the server_name the Website is served under.
+	Hostname string `json:"hostname"`
+
+	// ClassName selects the website controller serving the Website, like an Ingress's
+	// ingressClassName, when several run in one cluster, e.g. for internal and public nginx.
+	// A Website is served by the controller whose --class equals it; a Website without a
+	// class is served by the controller without one.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// PathPrefix serves the Website under a path of a hostname shared with other Websites,
+	// e.g. "/team/". The prefixes of Websites sharing a hostname must be disjoint, and none of
+	// them may configure TLS, routes, tracing, load balancing, host aliases, rate limits,
+	// real IP, protection, transforms, content types, body filters, redirect maps or reuseport, which
+	// apply to the whole server or http context.
+	// +kubebuilder:validation:Pattern=`^/[^\s;{}'"$\\]*/$`
+	// +optional
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// Type "Proxy" proxies requests to the upstreams. Type "Redirect" redirects every request
+	// as configured by Redirect, and has no upstream. It defaults to "Proxy".
+	// +kubebuilder:validation:Enum=Proxy;Redirect
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// Redirect configures the redirect of a Website of type Redirect.
+	// +optional
+	Redirect *RedirectSpec `json:"redirect,omitempty"`
+
+	// Upstream is the URL requests are proxied to. It is required unless the Website is of
+	// type Redirect or sets ServiceRef.
+	// +optional
+	Upstream string `json:"upstream,omitempty"`
+
+	// ServiceRef proxies to a Kubernetes Service instead of a URL. It is resolved to the
+	// Service's ClusterIP or, for a headless Service, to its ready endpoints, and the
+	// configuration is regenerated when they change. It cannot be combined with Upstream.
+	// +optional
+	ServiceRef *ServiceReference `json:"serviceRef,omitempty"`
+
+	// Upstreams are additional URLs requests are balanced across together with Upstream.
+	// They must have the same scheme as Upstream; their paths are ignored.
+	// +optional
+	Upstreams []string `json:"upstreams,omitempty"`
+
+	// UpstreamProtocol is the protocol the upstreams are spoken to with, replacing the scheme
+	// of their URLs: "http1", "h2c" for HTTP/2 over cleartext, e.g. for gRPC backends, or
+	// "https". "auto" probes the upstream once and records the detected protocol in the
+	// status. It defaults to the scheme of Upstream. h2c upstreams take no path, and are
+	// proxied to with grpc_pass, which ignores the proxy_* settings of e.g. spec.upstreamAuth
+	// and spec.cookies.
+	// +kubebuilder:validation:Enum=auto;http1;h2c;https
+	// +optional
+	UpstreamProtocol string `json:"upstreamProtocol,omitempty"`
+
+	// Routes proxy the requests below other paths than "/" to upstreams of their own, e.g.
+	// /api/ to an API service while Upstream serves the rest. The most specific path wins.
+	// Routes cannot be combined with Upstreams, LoadBalancing or HostAliases, which apply
+	// to Upstream only, and are not transformed.
+	// +optional
+	Routes []Route `json:"routes,omitempty"`
+
+	// LoadBalancing selects how requests are balanced across the upstreams.
+	// +optional
+	LoadBalancing *LoadBalancingSpec `json:"loadBalancing,omitempty"`
+
+	// HostAliases resolve upstream hostnames to fixed IP addresses instead of through DNS,
+	// e.g. to reach a backend that is not in public or cluster DNS during a migration.
+	// The upstreams still receive their hostname in the Host header and SNI.
+	// +optional
+	HostAliases []HostAlias `json:"hostAliases,omitempty"`
+
+	// Priority orders reconciliation when events queue up, e.g. after a controller restart.
+	// Websites with a higher priority are reconciled first. Priorities of 100 and above
+	// are reported in the "high" band, negative priorities in the "low" band.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// UpstreamAuth authenticates the requests proxied to the upstream.
+	// +optional
+	UpstreamAuth *UpstreamAuthSpec `json:"upstreamAuth,omitempty"`
+
+	// UpstreamTLS configures how HTTPS upstreams are connected to.
+	// +optional
+	UpstreamTLS *UpstreamTLSSpec `json:"upstreamTLS,omitempty"`
+
+	// Security configures the browser security headers of the Website.
+	// +optional
+	Security *SecuritySpec `json:"security,omitempty"`
+
+	// Cookies hardens the cookies set by the upstream, e.g. for legacy backends that set
+	// them without the Secure or HttpOnly flags.
+	// +optional
+	Cookies *CookiesSpec `json:"cookies,omitempty"`
+
+	// TLS configures HTTPS for the Website.
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// Listeners are the ports the Website is served on, e.g. 8080 with the PROXY protocol
+	// behind a cloud load balancer and 443 with TLS. It defaults to port 80, and 443 if the
+	// Website has TLS material.
+	// +optional
+	Listeners []Listener `json:"listeners,omitempty"`
+
+	// BasicAuth protects the Website with HTTP basic authentication.
+	// +optional
+	BasicAuth *BasicAuthSpec `json:"basicAuth,omitempty"`
+
+	// Tracing configures distributed tracing of proxied requests.
+	// +optional
+	Tracing *TracingSpec `json:"tracing,omitempty"`
+
+	// Tuning holds low-level socket options for performance-sensitive Websites.
+	// +optional
+	Tuning *TuningSpec `json:"tuning,omitempty"`
+
+	// DrainPolicy drains the traffic of the Website before its configuration is removed on deletion.
+	// +optional
+	DrainPolicy *DrainPolicy `json:"drainPolicy,omitempty"`
+
+	// TTL deletes the Website this long after it was created, e.g. "72h" for the preview site
+	// of a pull request, so hostnames and configurations do not leak when a pipeline forgets
+	// to clean up. Changing it moves the expiry.
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// RevisionHistoryLimit is how many rendered configurations are kept for rollbacks.
+	// It defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// Verification configures a probe through the local nginx that must pass before
+	// the Website is marked Ready.
+	// +optional
+	Verification *VerificationSpec `json:"verification,omitempty"`
+
+	// HealthEndpoint serves a synthetic health response at the edge, without involving
+	// the upstream, for external uptime monitors and load balancers.
+	// +optional
+	HealthEndpoint *HealthEndpointSpec `json:"healthEndpoint,omitempty"`
+
+	// HealthReport serves a JSON report of the Website's health at /.well-known/health: its
+	// Ready condition, certificate expiry and upstream reachability, for external systems
+	// without access to the Kubernetes API.
+	// +optional
+	HealthReport *HealthReportSpec `json:"healthReport,omitempty"`
+
+	// Middleware references WebsiteMiddlewares in the Website's namespace, applied in order.
+	// +optional
+	Middleware []corev1.LocalObjectReference `json:"middleware,omitempty"`
+
+	// RealIP derives the client address from the X-Forwarded-For header when the Website is
+	// behind proxies, e.g. a cloud load balancer. Rate limits apply to the derived address.
+	// +optional
+	RealIP *RealIPSpec `json:"realIP,omitempty"`
+
+	// Indexing set to "deny" keeps search engines from indexing the Website, e.g. for
+	// staging and preview hostnames. It defaults to "allow".
+	// +kubebuilder:validation:Enum=allow;deny
+	// +optional
+	Indexing string `json:"indexing,omitempty"`
+
+	// Protection hardens the Website against floods and slow clients with a vetted
+	// combination of per-client connection and request limits, slowloris timeouts and
+	// large-header rejection. "strict" is tighter than "basic"; requests over the limits are
+	// answered with 503.
+	// +kubebuilder:validation:Enum=basic;strict
+	// +optional
+	Protection string `json:"protection,omitempty"`
+
+	// Content corrects the Content-Type headers of the Website's responses, e.g. for static
+	// sites whose upstream does not know newer file extensions such as wasm or avif.
+	// +optional
+	Content *ContentSpec `json:"content,omitempty"`
+
+	// Assets sets the caching policy of the Website's static assets, e.g. a year for
+	// fingerprinted *.css and *.js files and a day for /favicon.ico. It overrides the
+	// upstream's Cache-Control and Expires headers for the matching paths.
+	// +optional
+	Assets *AssetsSpec `json:"assets,omitempty"`
+
+	// BodyFilters substitute strings in the Website's responses, e.g. to inject a compliance
+	// banner or rewrite the absolute URLs of a legacy backend. Responses are requested
+	// uncompressed from the upstream, so they can be filtered.
+	// +optional
+	BodyFilters []BodyFilter `json:"bodyFilters,omitempty"`
+
+	// Transform runs njs scripts on requests and responses at the edge.
+	// +optional
+	Transform *TransformSpec `json:"transform,omitempty"`
+
+	// RedirectMapRef selects a ConfigMap key holding the path redirects of a migrated site,
+	// one "old new" pair per line, where old is a path and new a path or an absolute http(s)
+	// URL. Blank lines and lines starting with # are ignored. Requests for an old path are
+	// redirected permanently; the pairs are compiled into an nginx map, so thousands of them
+	// cost a single hash lookup per request.
+	// +optional
+	RedirectMapRef *corev1.ConfigMapKeySelector `json:"redirectMapRef,omitempty"`
+
+	// TemplateRef is a key of a ConfigMap in the Website's namespace holding a Go template the
+	// server block is rendered with instead of the built-in one, e.g. to add proxy_read_timeout,
+	// headers or logging. It replaces the controller's --template-configmap. The template is
+	// executed with the Website as .Website and the compiled parts of the server block as
+	// .HTTP, .Listen, .ServerName, .Directives and .Locations, and can call serviceIP NAME,
+	// annotation KEY and secretBase64 NAME KEY; only Secrets annotated with
+	// website-operator.io/template-readable=true can be read. secretBase64 returns an nginx
+	// variable holding the value, for directives that take variables, so the value is kept out
+	// of the configuration. It does not apply to Websites of
+	// type Redirect or with a pathPrefix. A template can emit any directive, so it is refused
+	// unless the controller runs with the TenantTemplates feature gate.
+	// +optional
+	TemplateRef *corev1.ConfigMapKeySelector `json:"templateRef,omitempty"`
+
+	// SLO tracks the reliability of the Website at the edge against objectives.
+	// +optional
+	SLO *SLOSpec `json:"slo,omitempty"`
+
+	// Serving selects whether the Website is served by the controller's nginx or handed to
+	// an existing ingress controller.
+	// +optional
+	Serving *ServingSpec `json:"serving,omitempty"`
+
+	// Overlays vary the Website per environment, keyed by environment name, so one Website
+	// can carry e.g. its "prod" and "staging" variants. A controller started with an
+	// environment renders the spec with the overlay of its environment applied.
+	// +optional
+	Overlays map[string]WebsiteSpecPatch `json:"overlays,omitempty"`
+}
+
+// Protection profiles.
+const (
+	ProtectionBasic  = "basic"
+	ProtectionStrict = "strict"
+)
+
+// Upstream protocols.
+const (
+	UpstreamProtocolAuto  = "auto"
+	UpstreamProtocolHTTP1 = "http1"
+	UpstreamProtocolH2C   = "h2c"
+	UpstreamProtocolHTTPS = "https"
+)
+
+// Website types.
+const (
+	WebsiteTypeProxy    = "Proxy"
+	WebsiteTypeRedirect = "Redirect"
+)
+
+// RedirectSpec redirects every request of a Website, e.g. from an old domain to a new one.
+type RedirectSpec struct {
+	// Target is the URL requests are redirected to, e.g. "https://new.example.com". The path
+	// and query of the request are appended unless the target has a path of its own.
+	Target string `json:"target"`
+
+	// Code is the status code of the redirect. It defaults to 301.
+	// +kubebuilder:validation:Enum=301;302;307;308
+	// +optional
+	Code int32 `json:"code,omitempty"`
+}
+
+// SLOSpec defines the service level objectives of a Website. Objectives are percentages of
+// the requests within the window, e.g. "99.9".
+type SLOSpec struct {
+	// Availability is the percentage of requests that must not fail with a 5xx status.
+	// +kubebuilder:validation:Pattern=`^[0-9]{1,2}(\.[0-9]+)?$`
+	// +optional
+	Availability string `json:"availability,omitempty"`
+
+	// Latency is the objective for the request time at the edge.
+	// +optional
+	Latency *LatencyObjective `json:"latency,omitempty"`
+
+	// Window is the period the error budget is computed over. It defaults to 720h (30 days).
+	// +optional
+	Window *metav1.Duration `json:"window,omitempty"`
+}
+
+// LatencyObjective requires a percentage of the requests to complete within a threshold.
+type LatencyObjective struct {
+	// Threshold is the request time a request must complete within, e.g. "300ms".
+	Threshold metav1.Duration `json:"threshold"`
+
+	// Target is the percentage of requests that must complete within the threshold.
+	// +kubebuilder:validation:Pattern=`^[0-9]{1,2}(\.[0-9]+)?$`
+	Target string `json:"target"`
+}
+
+// Serving modes of a Website.
+const (
+	ServingModeNginx      = "Nginx"
+	ServingModeIngress    = "Ingress"
+	ServingModeDeployment = "Deployment"
+	ServingModeGatewayAPI = "GatewayAPI"
+)
+
+// ServingSpec selects how the Website is served.
+type ServingSpec struct {
+	// Mode "Nginx" serves the Website with the controller's nginx. Mode "Ingress" emits a
+	// networking.k8s.io/v1 Ingress, and an ExternalName Service for the upstream, instead.
+	// Only the hostname, pathPrefix, upstream, upstreamTLS and tls.secretRef fields can be
+	// expressed as an Ingress. Mode "Deployment" serves the Website with an nginx Deployment,
+	// ConfigMap and Service of its own, named website-<name>-nginx and owned by the Website;
+	// fields that need the controller's files, e.g. tls and basicAuth, cannot be served so; it
+	// requires the controller's DeploymentMode feature gate. Mode "GatewayAPI" emits a Gateway
+	// API HTTPRoute attached to ParentRefs, and to a Gateway of its own if GatewayClassName is
+	// set, instead; it can express what Ingress mode can, except https upstreams. It defaults
+	// to "Nginx".
+	// +kubebuilder:validation:Enum=Nginx;Ingress;Deployment;GatewayAPI
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// IngressClassName is the IngressClass of the emitted Ingress. The cluster's default
+	// IngressClass is used if it is empty.
+	// +optional
+	IngressClassName string `json:"ingressClassName,omitempty"`
+
+	// Annotations are added to the emitted Ingress, overriding the annotations derived from
+	// the spec for the ingress-nginx controller.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// AdoptConfigFile is a hand-written configuration file in /etc/nginx/conf.d serving the
+	// hostname, which the Website takes over in Nginx mode, e.g. during a migration. The file
+	// is only replaced if it has the same directives as the Website's configuration, ignoring
+	// comments and whitespace.
+	// +optional
+	AdoptConfigFile string `json:"adoptConfigFile,omitempty"`
+
+	// AdoptIngress is an Ingress in the Website's namespace the Website takes over in Ingress
+	// mode instead of emitting its own. The Ingress is only taken over if it routes the same
+	// hosts and paths and terminates TLS for the same hosts; its backend is replaced.
+	// +optional
+	AdoptIngress string `json:"adoptIngress,omitempty"`
+
+	// ParentRefs are the Gateways the HTTPRoute emitted in GatewayAPI mode attaches to.
+	// +optional
+	ParentRefs []GatewayReference `json:"parentRefs,omitempty"`
+
+	// GatewayClassName emits a Gateway of this GatewayClass in GatewayAPI mode, named
+	// website-<name> and owned by the Website, which the HTTPRoute attaches to besides
+	// ParentRefs. It listens for the hostname on port 443 with tls.secretRef, and on port 80
+	// unless the Website redirects HTTP to HTTPS.
+	// +optional
+	GatewayClassName string `json:"gatewayClassName,omitempty"`
+}
+
+// GatewayReference refers to a Gateway, or to one of its listeners.
+type GatewayReference struct {
+	// Name is the name of the Gateway.
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the Gateway. Defaults to the Website's namespace.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// SectionName is the name of the Gateway's listener to attach to. The route attaches to
+	// all listeners that accept it if it is empty.
+	// +optional
+	SectionName string `json:"sectionName,omitempty"`
+}
+
+// WebsiteSpecPatch is a JSON merge patch (RFC 7386) of a WebsiteSpec, e.g.
+// {"hostname": "staging.example.com", "indexing": "deny"}. Overlays cannot be patched.
+// +kubebuilder:validation:Type=object
+// +kubebuilder:pruning:PreserveUnknownFields
+type WebsiteSpecPatch struct {
+	runtime.RawExtension `json:",inline"`
+}
+
+// TLSSpec configures the certificate the Website is served with.
+type TLSSpec struct {
+	// SecretRef reads the certificate and key from a kubernetes.io/tls Secret in the
+	// Website's namespace. The certificate's SANs must cover the Website's hostname.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// VaultRef reads the certificate and key from the "tls.crt" and "tls.key"
+	// (or "certificate" and "private_key") keys of a Vault secret.
+	// +optional
+	VaultRef *VaultRef `json:"vaultRef,omitempty"`
+
+	// SPIFFE serves the Website with an X.509 SVID of the controller's SPIFFE workload, so no
+	// long-lived certificate is stored in the cluster. Rotated SVIDs are picked up automatically.
+	// +optional
+	SPIFFE *SPIFFESource `json:"spiffe,omitempty"`
+
+	// IssuerRef has cert-manager issue the certificate for the hostname into the Secret
+	// website-<name>-tls. The Website is served over plain HTTP until the Secret is issued,
+	// as reported by the CertificateReady condition.
+	// +optional
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+
+	// ACME has the controller's built-in ACME client issue the certificate for the hostname
+	// with an HTTP-01 challenge, for clusters without cert-manager. The certificate is kept in
+	// the Secret website-<name>-acme and renewed before it expires. It requires the ACME
+	// feature gate, and the hostname to resolve to the controller's nginx on port 80.
+	// +optional
+	ACME bool `json:"acme,omitempty"`
+
+	// HTTPRedirect redirects plain HTTP requests on port 80 to HTTPS. It defaults to true;
+	// set it to false to serve the Website on both ports.
+	// +optional
+	HTTPRedirect *bool `json:"httpRedirect,omitempty"`
+
+	// Certificates serves the Website under further hostnames on its HTTPS ports, each
+	// presenting the certificate listed for it by SNI. The hostname is still served with the
+	// certificate of secretRef, vaultRef or spiffe, one of which is required. A hostname
+	// served by an older Website is left out. Websites of type Redirect cannot set it.
+	// +optional
+	Certificates []TLSCertificate `json:"certificates,omitempty"`
+}
+
+// TLSCertificate is a certificate presented for some hostnames of a Website.
+type TLSCertificate struct {
+	// SecretRef reads the certificate and key from a kubernetes.io/tls Secret in the
+	// Website's namespace. The certificate's SANs must cover the hostnames.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// Hostnames are the server_names the certificate is presented for. They must not include
+	// the Website's hostname or the hostnames of another certificate.
+	// +kubebuilder:validation:MinItems=1
+	Hostnames []string `json:"hostnames"`
+}
+
+// SPIFFESource selects the X.509 SVID a Website is served with. The SVIDs are read from the
+// files written by spiffe-helper or a SPIFFE CSI driver into the controller's SPIFFE directory.
+type SPIFFESource struct {
+	// SVID is the subdirectory of the SPIFFE directory holding the SVID, for workloads that
+	// are issued several. The SPIFFE directory itself is used if it is empty.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +optional
+	SVID string `json:"svid,omitempty"`
+}
+
+// BasicAuthSpec configures HTTP basic authentication.
+type BasicAuthSpec struct {
+	// Realm is shown by browsers when prompting for credentials. It defaults to the hostname.
+	// +optional
+	Realm string `json:"realm,omitempty"`
+
+	// VaultRef reads the users from a Vault secret, either as username/password pairs
+	// or as a ready-made "htpasswd" key.
+	// +optional
+	VaultRef *VaultRef `json:"vaultRef,omitempty"`
+}
+
+// IssuerReference selects the cert-manager issuer of a Website's certificate.
+type IssuerReference struct {
+	// Name is the name of the issuer.
+	Name string `json:"name"`
+
+	// Kind is "Issuer", in the Website's namespace, or "ClusterIssuer". It defaults to "Issuer".
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group is the API group of the issuer. It defaults to "cert-manager.io".
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// VaultRef references a secret in Vault, read by logging in with the controller's
+// service account through the Kubernetes auth method.
+type VaultRef struct {
+	// Path is the path of the secret, e.g. "secret/data/websites/shop".
+	Path string `json:"path"`
+
+	// Role is the Vault role the controller logs in as. It must be granted to the Website's
+	// namespace by the vaultRoles of a WebsitePolicy.
+	Role string `json:"role"`
+}
+
+// TracingSpec configures distributed tracing.
+type TracingSpec struct {
+	// Otel exports spans of proxied requests through the nginx otel module.
+	// +optional
+	Otel *OtelTracingSpec `json:"otel,omitempty"`
+}
+
+// OtelTracingSpec configures the nginx otel module. Spans are tagged with the Website's
+// namespace and name.
+type OtelTracingSpec struct {
+	// Endpoint is the host:port of the OTLP/gRPC collector. nginx supports a single
+	// exporter, so all Websites must use the same endpoint.
+	Endpoint string `json:"endpoint"`
+
+	// SamplePercent is the percentage of traces that are sampled. It defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	SamplePercent *int32 `json:"samplePercent,omitempty"`
+}
+
+// TuningSpec holds low-level nginx socket options. Unset options keep the nginx defaults.
+type TuningSpec struct {
+	// TCPNoDelay sets tcp_nodelay.
+	// +optional
+	TCPNoDelay *bool `json:"tcpNodelay,omitempty"`
+
+	// TCPNoPush sets tcp_nopush. It requires sendfile.
+	// +optional
+	TCPNoPush *bool `json:"tcpNopush,omitempty"`
+
+	// Sendfile sets sendfile.
+	// +optional
+	Sendfile *bool `json:"sendfile,omitempty"`
+
+	// KeepaliveTimeout sets keepalive_timeout. It must be between 1s and 10m,
+	// so keep-alive cannot be disabled.
+	// +optional
+	KeepaliveTimeout *metav1.Duration `json:"keepaliveTimeout,omitempty"`
+
+	// ReusePort adds reuseport to the listen directive. nginx accepts it once per port,
+	// so only one Website at a time may enable it.
+	// +optional
+	ReusePort bool `json:"reuseport,omitempty"`
+}
+
+// DrainPolicy describes how a deleted Website's traffic is drained.
+type DrainPolicy struct {
+	// Duration is how long the Website keeps serving existing clients, with keep-alive
+	// disabled, after it was deleted.
+	Duration metav1.Duration `json:"duration"`
+
+	// Respond503AfterDrain answers requests with 503 for another drain duration after the
+	// drain window, instead of removing the server block right away.
+	// +optional
+	Respond503AfterDrain bool `json:"respond503AfterDrain,omitempty"`
+}
+
+// VerificationSpec describes the request used to verify that a Website is routed correctly.
+type VerificationSpec struct {
+	// Path is requested with the Website's hostname as Host header. It must start with /.
+	// +kubebuilder:default=/
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// ExpectedStatus is the status code the upstream is expected to respond with.
+	// +kubebuilder:default=200
+	// +optional
+	ExpectedStatus int `json:"expectedStatus,omitempty"`
+
+	// Timeout is how long the probe is retried before the Website is reported as not Ready.
+	// It defaults to 10s and is capped at 1m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Load balancing policies.
+const (
+	LoadBalancingRoundRobin     = "roundRobin"
+	LoadBalancingLeastConn      = "leastConn"
+	LoadBalancingIPHash         = "ipHash"
+	LoadBalancingConsistentHash = "consistentHash"
+	LoadBalancingLeastTime      = "leastTime"
+)
+
+// LoadBalancingSpec selects the nginx load balancing method of a Website's upstreams.
+type LoadBalancingSpec struct {
+	// Policy is the load balancing method. It defaults to roundRobin. leastTime prefers the
+	// upstreams with the lowest response time: nginx Plus measures it natively, otherwise the
+	// controller probes the upstreams and weights them by their latency.
+	// +kubebuilder:validation:Enum=roundRobin;leastConn;ipHash;consistentHash;leastTime
+	// +optional
+	Policy string `json:"policy,omitempty"`
+
+	// ConsistentHash configures the consistentHash policy, which keeps requests for the
+	// same key on the same upstream, e.g. for cache-sharded backends.
+	// +optional
+	ConsistentHash *ConsistentHashSpec `json:"consistentHash,omitempty"`
+}
+
+// ConsistentHashSpec configures ketama consistent hashing.
+type ConsistentHashSpec struct {
+	// Key is the value requests are hashed by, combining nginx variables, e.g. "$request_uri".
+	Key string `json:"key"`
+}
+
+// ContentSpec configures the Content-Type headers of a Website's responses.
+type ContentSpec struct {
+	// Types maps file extensions, without the dot, to the MIME type of responses for paths
+	// ending in them, e.g. {"wasm": "application/wasm"}. They override the upstream's type.
+	// +optional
+	Types map[string]string `json:"types,omitempty"`
+
+	// DefaultCharset is added to the Content-Type of text responses that do not declare a
+	// charset, e.g. "utf-8".
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_.:-]+$`
+	// +optional
+	DefaultCharset string `json:"defaultCharset,omitempty"`
+}
+
+// Listener protocols.
+const (
+	ListenerHTTP  = "HTTP"
+	ListenerHTTPS = "HTTPS"
+)
+
+// Listener is a port a Website is served on.
+type Listener struct {
+	// Port is the port nginx listens on.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Protocol is "HTTP", or "HTTPS", which requires spec.tls. It defaults to "HTTP".
+	// +kubebuilder:validation:Enum=HTTP;HTTPS
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+
+	// ProxyProtocol requires the PROXY protocol header a load balancer sends ahead of each
+	// connection on the port, so the client address is known although the connection is not
+	// from the client.
+	// +optional
+	ProxyProtocol bool `json:"proxyProtocol,omitempty"`
+}
+
+// AssetsSpec lists the caching policies of a Website's static assets.
+type AssetsSpec struct {
+	// Rules apply caching policies to paths; the first rule matching a path applies.
+	// +kubebuilder:validation:MinItems=1
+	Rules []AssetCacheRule `json:"rules"`
+}
+
+// AssetCacheRule is the caching policy of the paths matching any of its patterns.
+type AssetCacheRule struct {
+	// Patterns match request paths, where * matches any characters. Patterns starting with /
+	// match the whole path, e.g. "/static/*"; others match the last path segment, e.g. "*.css"
+	// or "favicon.ico".
+	// +kubebuilder:validation:MinItems=1
+	Patterns []string `json:"patterns"`
+
+	// MaxAge is how long clients and caches may reuse a response, e.g. "8760h". It is sent as
+	// Cache-Control max-age and as Expires.
+	MaxAge metav1.Duration `json:"maxAge"`
+
+	// Immutable marks the responses as never changing while fresh, e.g. for assets whose
+	// file name carries a content hash, so browsers do not revalidate them on reload.
+	// +optional
+	Immutable bool `json:"immutable,omitempty"`
+}
+
+// BodyFilter replaces a string in response bodies.
+type BodyFilter struct {
+	// Match is the string replaced, e.g. "</body>". It is matched case-insensitively.
+	// +kubebuilder:validation:MinLength=1
+	Match string `json:"match"`
+
+	// Replace replaces every occurrence of Match. It may reference nginx variables, e.g. "$host".
+	Replace string `json:"replace"`
+
+	// ContentTypes are the MIME types of the responses filtered. It defaults to ["text/html"].
+	// +optional
+	ContentTypes []string `json:"contentTypes,omitempty"`
+}
+
+// ServiceReference selects a port of a Service requests are proxied to.
+type ServiceReference struct {
+	// Name is the name of the Service.
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the Service. Defaults to the Website's namespace. Another
+	// namespace must grant the Website's namespace access with its
+	// website-operator.io/service-ref-namespaces annotation.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Port is the Service port requests are proxied to.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Scheme is the scheme the Service is spoken to with. Defaults to http.
+	// +kubebuilder:validation:Enum=http;https
+	// +optional
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// Route proxies the requests below a path to an upstream.
+type Route struct {
+	// Path is the path prefix the route matches, e.g. "/api/".
+	// +kubebuilder:validation:Pattern=`^/[^\s;{}'"$\\]+$`
+	Path string `json:"path"`
+
+	// Upstream is the http(s) URL requests are proxied to, without a path.
+	Upstream string `json:"upstream"`
+
+	// Rewrite replaces the matched path prefix before the request is proxied, e.g. "/" to
+	// strip it. The path is proxied unchanged if it is empty.
+	// +kubebuilder:validation:Pattern=`^/[^\s;{}'"$\\]*$`
+	// +optional
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+// HostAlias resolves an upstream hostname to an IP address.
+type HostAlias struct {
+	// Hostname is the host of spec.upstream or spec.upstreams that is resolved.
+	Hostname string `json:"hostname"`
+
+	// IP is the IPv4 or IPv6 address the hostname resolves to.
+	IP string `json:"ip"`
+}
+
+// RealIPSpec configures how the client address is derived from X-Forwarded-For.
+type RealIPSpec struct {
+	// TrustedHops is the number of proxies in front of nginx that append to X-Forwarded-For.
+	// The client address is the entry that many positions from the end of the header, as
+	// entries before it may be forged by the client. Requests with fewer entries fall back to
+	// the peer address.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=10
+	TrustedHops int32 `json:"trustedHops"`
+}
+
+// TransformSpec references njs modules in ConfigMaps of the Website's namespace. njs cannot be
+// sandboxed, so transforms are refused unless the controller runs with the TenantTransforms
+// feature gate.
+type TransformSpec struct {
+	// RequestScriptRef is a module whose default export has a request(r) function. It handles
+	// every request and passes it to the upstream with r.internalRedirect('@upstream').
+	// The upstream must not have a path.
+	// +optional
+	RequestScriptRef *corev1.ConfigMapKeySelector `json:"requestScriptRef,omitempty"`
+
+	// ResponseScriptRef is a module whose default export has a response(r) function, run as
+	// a header filter on the upstream's responses.
+	// +optional
+	ResponseScriptRef *corev1.ConfigMapKeySelector `json:"responseScriptRef,omitempty"`
+}
+
+// HealthReportSpec protects the health report of a Website.
+type HealthReportSpec struct {
+	// TokenSecretRef selects the key of a Secret in the Website's namespace holding the token
+	// clients send as "Authorization: Bearer <token>" to read the report.
+	TokenSecretRef corev1.SecretKeySelector `json:"tokenSecretRef"`
+}
+
+// HealthEndpointSpec describes the synthetic health response served by nginx.
+type HealthEndpointSpec struct {
+	// Path is the exact path the response is served at. It is exempt from basic authentication.
+	// +kubebuilder:validation:Pattern=`^/[^\s;{}'"$]*$`
+	Path string `json:"path"`
+
+	// ResponseBody is the plain text body of the response.
+	// +optional
+	ResponseBody string `json:"responseBody,omitempty"`
+
+	// StatusCode is the status code of the response. It defaults to 200.
+	// Redirect codes are not allowed.
+	// +kubebuilder:validation:Minimum=200
+	// +kubebuilder:validation:Maximum=599
+	// +optional
+	StatusCode int32 `json:"statusCode,omitempty"`
+}
+
+// UpstreamAuthSpec configures the credentials sent to a protected upstream.
+type UpstreamAuthSpec struct {
+	// HeaderFromSecret sends a header whose value is read from a Secret, e.g. an API key
+	// or "Bearer <token>". The value is only written to a root-readable include, and
+	// rotated when the Secret changes.
+	// +optional
+	HeaderFromSecret *HeaderFromSecret `json:"headerFromSecret,omitempty"`
+
+	// Signing signs every request proxied to the upstream at the edge, e.g. to serve a static
+	// site from a private object storage bucket. It requires the nginx njs module.
+	// +optional
+	Signing *UpstreamSigningSpec `json:"signing,omitempty"`
+}
+
+// Upstream signing types.
+const (
+	// UpstreamSigningSigV4 signs requests with AWS Signature Version 4 and an unsigned payload,
+	// as S3 and S3-compatible object storage accept. The Secret holds the keys accessKeyId,
+	// secretAccessKey and optionally sessionToken.
+	UpstreamSigningSigV4 = "sigv4"
+
+	// UpstreamSigningHMAC sends the hex HMAC-SHA256 of "<method>\n<request URI>\n<timestamp>"
+	// in X-Signature and the timestamp (e.g. 20060102T150405Z) in X-Signature-Timestamp.
+	// The Secret holds the key under key.
+	UpstreamSigningHMAC = "hmac"
+)
+
+// UpstreamSigningSpec configures how the requests proxied to the upstream are signed.
+type UpstreamSigningSpec struct {
+	// Type is the signature scheme: sigv4 or hmac.
+	// +kubebuilder:validation:Enum=sigv4;hmac
+	Type string `json:"type"`
+
+	// SecretRef selects the Secret in the Website's namespace holding the credentials.
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// Region is the AWS region requests are signed for, e.g. "eu-west-1". Required for sigv4.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9-]+$`
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Service is the AWS service requests are signed for. Defaults to "s3" for sigv4.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9-]+$`
+	// +optional
+	Service string `json:"service,omitempty"`
+}
+
+// HeaderFromSecret is a header whose value is read from a key of a Secret in the Website's namespace.
+type HeaderFromSecret struct {
+	// Name is the header name, e.g. "Authorization".
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9-]+$`
+	Name string `json:"name"`
+
+	// SecretRef selects the Secret key holding the header value.
+	SecretRef corev1.SecretKeySelector `json:"secretRef"`
+}
+
+// UpstreamTLSSpec configures the TLS connection to an https:// upstream.
+type UpstreamTLSSpec struct {
+	// SNI is the server name sent to the upstream and verified against its certificate.
+	// It defaults to the host of the upstream URL. Set it when the upstream is reached
+	// through a shared load balancer that routes by SNI.
+	// +optional
+	SNI string `json:"sni,omitempty"`
+
+	// VerifyHostname verifies the upstream certificate against the system trust store
+	// and the server name.
+	// +optional
+	VerifyHostname bool `json:"verifyHostname,omitempty"`
+}
+
+// SecuritySpec configures how the Website may be embedded by and embed other origins.
+// All fields are compiled together into one consistent set of response headers.
+type SecuritySpec struct {
+	// FrameAncestors lists the origins allowed to embed the Website in a frame.
+	// The keywords 'self' and 'none' are accepted; 'none' cannot be combined with other sources.
+	// An empty list leaves framing unrestricted.
+	// +optional
+	FrameAncestors []string `json:"frameAncestors,omitempty"`
+
+	// CrossOriginResourcePolicy restricts which origins may load the Website's resources.
+	// +kubebuilder:validation:Enum=same-origin;same-site;cross-origin
+	// +optional
+	CrossOriginResourcePolicy string `json:"crossOriginResourcePolicy,omitempty"`
+
+	// CrossOriginEmbedderPolicy controls which cross-origin resources the Website may embed.
+	// +kubebuilder:validation:Enum=unsafe-none;require-corp;credentialless
+	// +optional
+	CrossOriginEmbedderPolicy string `json:"crossOriginEmbedderPolicy,omitempty"`
+
+	// CrossOriginOpenerPolicy controls whether the Website shares a browsing context group with cross-origin documents.
+	// +kubebuilder:validation:Enum=unsafe-none;same-origin-allow-popups;same-origin
+	// +optional
+	CrossOriginOpenerPolicy string `json:"crossOriginOpenerPolicy,omitempty"`
+}
+
+// CookiesSpec sets flags on every cookie the upstream sets.
+type CookiesSpec struct {
+	// ForceSecure adds the Secure flag, so browsers only send the cookies over HTTPS.
+	// +optional
+	ForceSecure bool `json:"forceSecure,omitempty"`
+
+	// ForceHTTPOnly adds the HttpOnly flag, hiding the cookies from scripts.
+	// +optional
+	ForceHTTPOnly bool `json:"forceHttpOnly,omitempty"`
+
+	// SameSite sets the SameSite attribute. None requires forceSecure, as browsers reject
+	// SameSite=None cookies without the Secure flag.
+	// +kubebuilder:validation:Enum=Strict;Lax;None
+	// +optional
+	SameSite string `json:"sameSite,omitempty"`
+}
+
+// Condition types of a Website.
+const (
+	// ConditionReady indicates that the Website is served by nginx.
+	ConditionReady = "Ready"
+
+	// ConditionConfigWritten indicates that the Website's configuration was written for nginx.
+	ConditionConfigWritten = "ConfigWritten"
+
+	// ConditionReloadSucceeded indicates that nginx was reloaded with the Website's configuration.
+	ConditionReloadSucceeded = "ReloadSucceeded"
+
+	// ConditionCertificateHostnameMismatch indicates that the Website's certificate does not
+	// cover all of its hostnames.
+	ConditionCertificateHostnameMismatch = "CertificateHostnameMismatch"
+
+	// ConditionHostnameConflict indicates that an older Website serves the Website's hostname,
+	// so the Website is not served.
+	ConditionHostnameConflict = "HostnameConflict"
+
+	// ConditionRolledBack indicates that the Website's latest configuration failed validation
+	// or reload, and nginx serves its last known good configuration instead.
+	ConditionRolledBack = "RolledBack"
+
+	// ConditionSLOViolated indicates that the Website burns its error budget fast enough
+	// to exhaust it well before the end of the SLO window.
+	ConditionSLOViolated = "SLOViolated"
+
+	// ConditionCertificateReady indicates that cert-manager or the built-in ACME client issued
+	// the certificate of a Website with spec.tls.issuerRef or spec.tls.acme, so it is served
+	// over HTTPS.
+	ConditionCertificateReady = "CertificateReady"
+
+	// ConditionPaused indicates that the reconciliation of the Website is paused by the
+	// website-operator.io/paused annotation, so nginx serves its configuration as it is.
+	ConditionPaused = "Paused"
+
+	// ConditionQuarantined indicates that the configuration of the Website's generation failed
+	// nginx validation or reload repeatedly, so it is not served until its spec changes.
+	ConditionQuarantined = "Quarantined"
+)
+
+// WebsiteStatus defines the observed state of a Website.
+type WebsiteStatus struct {
+	// Conditions describe the current state of the Website.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the generation of the Website last reconciled.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// URL is the URL the Website is served under.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// LastReload describes the impact of the last nginx reload triggered by this Website.
+	// +optional
+	LastReload *ReloadStatus `json:"lastReload,omitempty"`
+
+	// Revisions lists the rendered configurations kept for rollbacks, oldest first.
+	// +optional
+	Revisions []RevisionStatus `json:"revisions,omitempty"`
+
+	// Rollback is set while a rolled back revision is served instead of the spec.
+	// +optional
+	Rollback *RollbackStatus `json:"rollback,omitempty"`
+
+	// ExpiresAt is when the Website is deleted for its spec.ttl.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// Upstreams lists the probed latency and current weight of each upstream of a Website
+	// balanced by controller-computed leastTime weights.
+	// +optional
+	Upstreams []UpstreamStatus `json:"upstreams,omitempty"`
+
+	// UpstreamProtocol is the protocol detected for an upstreamProtocol of "auto".
+	// +optional
+	UpstreamProtocol string `json:"upstreamProtocol,omitempty"`
+
+	// Remediations lists what to do about the conditions that report a failure, by the
+	// machine-readable reason of each condition.
+	// +optional
+	Remediations []ConditionRemediation `json:"remediations,omitempty"`
+}
+
+// ConditionRemediation is the runbook hint for a condition reporting a failure.
+type ConditionRemediation struct {
+	// Type is the type of the condition, e.g. "Ready".
+	Type string `json:"type"`
+
+	// Reason is the machine-readable reason of the condition, e.g. "ReloadFailed".
+	Reason string `json:"reason"`
+
+	// Remediation tells how to resolve the failure.
+	Remediation string `json:"remediation"`
+}
+
+// UpstreamStatus describes an upstream weighted by its probed latency.
+type UpstreamStatus struct {
+	// Server is the host:port of the upstream.
+	Server string `json:"server"`
+
+	// Healthy is false if the last probe failed. Unhealthy upstreams are marked down
+	// while any upstream is healthy.
+	Healthy bool `json:"healthy"`
+
+	// Latency is the time the last probe took to receive the response headers.
+	// +optional
+	Latency *metav1.Duration `json:"latency,omitempty"`
+
+	// Weight is the nginx weight of the upstream, from 1 for the slowest to 10 for the fastest.
+	// It is 0 while the upstream is marked down.
+	Weight int32 `json:"weight"`
+}
+
+// RevisionStatus describes a rendered configuration in the revision history.
+type RevisionStatus struct {
+	// Revision numbers the configurations of a Website in the order they were rendered.
+	Revision int64 `json:"revision"`
+
+	// SHA256 is the hash of the rendered configuration.
+	SHA256 string `json:"sha256"`
+
+	// Generation is the Website generation the configuration was rendered from.
+	Generation int64 `json:"generation"`
+
+	// Time is when the configuration was rendered.
+	Time metav1.Time `json:"time"`
+}
+
+// RollbackStatus describes a rollback to an earlier revision.
+type RollbackStatus struct {
+	// Revision is the revision being served.
+	Revision int64 `json:"revision"`
+
+	// Generation is the Website generation the rollback was requested at. The rollback
+	// ends once the spec changes and the generation moves past it.
+	Generation int64 `json:"generation"`
+
+	// Time is when the rollback was applied.
+	Time metav1.Time `json:"time"`
+}
+
+// ReloadStatus describes the customer impact of a single nginx reload.
+type ReloadStatus struct {
+	// Time is when the reload was triggered.
+	Time metav1.Time `json:"time"`
+
+	// Duration is the wall time of the reload command.
+	Duration metav1.Duration `json:"duration"`
+
+	// WorkerLinger is how long the old worker processes kept running after the reload.
+	WorkerLinger metav1.Duration `json:"workerLinger"`
+
+	// WorkersTimedOut is set when old workers were still running when observation stopped.
+	// +optional
+	WorkersTimedOut bool `json:"workersTimedOut,omitempty"`
+
+	// DroppedConnections is the number of connections nginx accepted but did not handle
+	// while the old workers were draining.
+	DroppedConnections int64 `json:"droppedConnections"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// Website is the Schema for the websites API.
+type Website struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebsiteSpec   `json:"spec,omitempty"`
+	Status WebsiteStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebsiteList contains a list of Websites.
+type WebsiteList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Website `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Website{}, &WebsiteList{})
+}
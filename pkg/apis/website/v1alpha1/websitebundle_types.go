@@ -0,0 +1,65 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebsiteBundleSpec defines the Websites of a bundle.
+type WebsiteBundleSpec struct {
+	// Websites are created or updated in the bundle's namespace. Websites removed from the
+	// bundle are deleted.
+	// +kubebuilder:validation:MinItems=1
+	Websites []WebsiteTemplate `json:"websites"`
+}
+
+// WebsiteTemplate is a Website of a bundle.
+type WebsiteTemplate struct {
+	// Name is the name of the Website.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Labels are added to the Website.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Spec is the spec of the Website.
+	Spec WebsiteSpec `json:"spec"`
+}
+
+// WebsiteBundleStatus defines the observed state of a WebsiteBundle.
+type WebsiteBundleStatus struct {
+	// ObservedGeneration is the generation last applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe whether the bundle was applied.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// WebsiteBundle manages many Websites as one object. The Websites are only applied if all of
+// them are valid, so a product line can be onboarded in one reviewed change.
+type WebsiteBundle struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebsiteBundleSpec   `json:"spec,omitempty"`
+	Status WebsiteBundleStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebsiteBundleList contains a list of WebsiteBundles.
+type WebsiteBundleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []WebsiteBundle `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebsiteBundle{}, &WebsiteBundleList{})
+}
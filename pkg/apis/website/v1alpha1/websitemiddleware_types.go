@@ -0,0 +1,102 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebsiteMiddlewareSpec defines a reusable building block of a Website's request handling.
+// All set parts of a middleware are applied.
+type WebsiteMiddlewareSpec struct {
+	// Headers sets request and response headers.
+	// +optional
+	Headers *MiddlewareHeaders `json:"headers,omitempty"`
+
+	// ForwardAuth authorizes every request with a subrequest to an external service.
+	// +optional
+	ForwardAuth *ForwardAuthSpec `json:"forwardAuth,omitempty"`
+
+	// RateLimit limits the request rate per client address.
+	// +optional
+	RateLimit *RateLimitSpec `json:"rateLimit,omitempty"`
+
+	// Rewrites rewrite the request URI before it is proxied, in order.
+	// +optional
+	Rewrites []RewriteRule `json:"rewrites,omitempty"`
+}
+
+// MiddlewareHeaders sets headers on requests and responses.
+type MiddlewareHeaders struct {
+	// Request headers are sent to the upstream.
+	// +optional
+	Request []HeaderValue `json:"request,omitempty"`
+
+	// Response headers are sent to the client, replacing headers of the same name from the upstream.
+	// +optional
+	Response []HeaderValue `json:"response,omitempty"`
+}
+
+// HeaderValue is a header and its value. Values may refer to nginx variables.
+type HeaderValue struct {
+	// Name is the header name.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9-]+$`
+	Name string `json:"name"`
+
+	// Value is the header value.
+	Value string `json:"value"`
+}
+
+// ForwardAuthSpec delegates authorization to an external service. Requests are allowed if
+// the service responds with 2xx, and denied with its 401 or 403 otherwise.
+type ForwardAuthSpec struct {
+	// URL is the http(s) URL of the authorization service. The original URI is sent in
+	// the X-Original-URI header.
+	URL string `json:"url"`
+}
+
+// RateLimitSpec limits the request rate per client address.
+type RateLimitSpec struct {
+	// RequestsPerSecond is the sustained request rate.
+	// +kubebuilder:validation:Minimum=1
+	RequestsPerSecond int32 `json:"requestsPerSecond"`
+
+	// Burst is the number of requests allowed above the rate before requests are rejected with 429.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// RewriteRule rewrites request URIs matching a regular expression.
+type RewriteRule struct {
+	// Regex is the PCRE the URI is matched against. It must also be valid RE2, which the
+	// controller checks it with, and cannot contain quotes, control characters or end with a
+	// backslash.
+	Regex string `json:"regex"`
+
+	// Replacement is the new URI, which may refer to capture groups as $1, $2, etc. It cannot
+	// contain quotes, control characters or end with a backslash.
+	Replacement string `json:"replacement"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebsiteMiddleware is a building block shared by Websites, which reference it in spec.middleware.
+type WebsiteMiddleware struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WebsiteMiddlewareSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebsiteMiddlewareList contains a list of WebsiteMiddlewares.
+type WebsiteMiddlewareList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []WebsiteMiddleware `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebsiteMiddleware{}, &WebsiteMiddlewareList{})
+}
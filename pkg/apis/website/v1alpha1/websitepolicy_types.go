@@ -0,0 +1,64 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebsitePolicySpec defines rules Websites must satisfy to be served.
+type WebsitePolicySpec struct {
+	// NamespaceSelector selects the namespaces whose Websites the policy applies to.
+	// The policy applies to all namespaces if it is empty.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Rules must all hold for a Website to be served.
+	// +optional
+	Rules []PolicyRule `json:"rules,omitempty"`
+
+	// VaultRoles are the Vault roles the Websites in the selected namespaces may name in
+	// spec.tls.vaultRef and spec.basicAuth.vaultRef. The controller logs in to Vault as any role
+	// a Website names, so a role no policy grants to the Website's namespace is refused.
+	// +optional
+	VaultRoles []string `json:"vaultRoles,omitempty"`
+}
+
+// PolicyRule is a CEL expression a Website must satisfy.
+type PolicyRule struct {
+	// Name identifies the rule in denials.
+	Name string `json:"name"`
+
+	// Expression is a CEL expression that must evaluate to true. The Website is available as
+	// "website" and its Namespace as "namespace", e.g.
+	// `!has(website.spec.transform) || namespace.metadata.name == "edge-team"`.
+	Expression string `json:"expression"`
+
+	// Message tells the Website's owner why it was denied and what to do instead.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// WebsitePolicy restricts which Website features may be used where. Platform admins express
+// rules such as "only namespace X may use transforms"; Websites breaking a rule are not served.
+type WebsitePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WebsitePolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebsitePolicyList contains a list of WebsitePolicies.
+type WebsitePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []WebsitePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebsitePolicy{}, &WebsitePolicyList{})
+}
@@ -0,0 +1,102 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WebsiteRollout phases.
+const (
+	RolloutProgressing = "Progressing"
+	RolloutPaused      = "Paused"
+	RolloutComplete    = "Complete"
+)
+
+// WebsiteRolloutSpec describes a re-render of all Websites under changed global settings.
+type WebsiteRolloutSpec struct {
+	// Settings is the fingerprint of the global settings the Websites are re-rendered under.
+	Settings string `json:"settings"`
+
+	// Reason tells which global setting changed.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Paused stops the rollout after the current batch until it is set to false again.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// BatchSize is how many Websites are re-rendered at once. It defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	BatchSize int32 `json:"batchSize,omitempty"`
+}
+
+// WebsiteRolloutFailure is a Website that failed to re-render under the new settings.
+type WebsiteRolloutFailure struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Message   string `json:"message"`
+}
+
+// WebsiteRolloutStatus is the progress of a rollout.
+type WebsiteRolloutStatus struct {
+	// Phase is Progressing, Paused or Complete.
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Total is the number of Websites to re-render.
+	// +optional
+	Total int32 `json:"total,omitempty"`
+
+	// Updated is the number of Websites re-rendered under the new settings.
+	// +optional
+	Updated int32 `json:"updated,omitempty"`
+
+	// Failed is the number of Websites that failed to re-render.
+	// +optional
+	Failed int32 `json:"failed,omitempty"`
+
+	// Failures lists the Websites that failed to re-render.
+	// +optional
+	Failures []WebsiteRolloutFailure `json:"failures,omitempty"`
+
+	// StartTime is when the rollout started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the last Website was re-rendered.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Updated",type=integer,JSONPath=`.status.updated`
+// +kubebuilder:printcolumn:name="Failed",type=integer,JSONPath=`.status.failed`
+// +kubebuilder:printcolumn:name="Total",type=integer,JSONPath=`.status.total`
+
+// WebsiteRollout tracks the re-render of all Websites after a global setting changed, e.g.
+// the TLS defaults or the controller's flags. It is created by the controller; setting
+// spec.paused pauses and resumes it.
+type WebsiteRollout struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebsiteRolloutSpec   `json:"spec,omitempty"`
+	Status WebsiteRolloutStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WebsiteRolloutList contains a list of WebsiteRollout.
+type WebsiteRolloutList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []WebsiteRollout `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WebsiteRollout{}, &WebsiteRolloutList{})
+}
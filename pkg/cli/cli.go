@@ -0,0 +1,73 @@
+// Package cli contains what the subcommands of website-controller and of the kubectl-website
+// plugin share: parsing their flags, printing their reports and waiting for Websites.
+package cli
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Output formats of the reports of the subcommands, selected with -o.
+const (
+	OutputJSON = "json"
+	OutputYAML = "yaml"
+)
+
+// ParseFlags parses the flags given before and after the positional arguments, which the flag
+// package stops at, e.g. `kubectl website wait <name> --timeout=5m`, and returns the
+// positional arguments. Everything after a -- terminator is positional.
+func ParseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		err := flags.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if parsed := len(args) - len(rest); parsed > 0 && args[parsed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// CheckOutputFormat rejects unknown output formats before a subcommand does any work.
+func CheckOutputFormat(format string) error {
+	if format != OutputJSON && format != OutputYAML {
+		return errors.Errorf("unknown output format %q: must be json or yaml", format)
+	}
+	return nil
+}
+
+// CheckOptionalOutputFormat accepts the human-readable output of a subcommand, selected by an
+// empty format, besides the structured ones.
+func CheckOptionalOutputFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	return CheckOutputFormat(format)
+}
+
+// PrintReport writes the report of a subcommand to stdout in the output format. Both formats
+// use the JSON field names of the report, so scripts can switch between them.
+func PrintReport(format string, report interface{}) error {
+	if format == OutputYAML {
+		out, err := yaml.Marshal(report)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode report")
+		}
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
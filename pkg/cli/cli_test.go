@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		wantPositional []string
+		wantTimeout    string
+	}{
+		{
+			name:           "flags before the name",
+			args:           []string{"--timeout=5m", "shop"},
+			wantPositional: []string{"shop"},
+			wantTimeout:    "5m",
+		},
+		{
+			name:           "flags after the name",
+			args:           []string{"shop", "--timeout", "5m"},
+			wantPositional: []string{"shop"},
+			wantTimeout:    "5m",
+		},
+		{
+			name:           "terminator",
+			args:           []string{"--timeout=5m", "--", "shop", "--timeout=1m"},
+			wantPositional: []string{"shop", "--timeout=1m"},
+			wantTimeout:    "5m",
+		},
+		{
+			name:           "terminator after the name",
+			args:           []string{"shop", "--", "-n"},
+			wantPositional: []string{"shop", "-n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("wait", flag.ContinueOnError)
+			timeout := flags.String("timeout", "", "")
+			positional, err := ParseFlags(flags, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(positional, tt.wantPositional) {
+				t.Errorf("positional = %q, want %q", positional, tt.wantPositional)
+			}
+			if *timeout != tt.wantTimeout {
+				t.Errorf("timeout = %q, want %q", *timeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestParseFlagsError(t *testing.T) {
+	flags := flag.NewFlagSet("wait", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.Duration("timeout", 0, "")
+	_, err := ParseFlags(flags, []string{"shop", "--timeout=soon"})
+	if err == nil {
+		t.Error("got no error for an invalid flag value")
+	}
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// WaitReport is the output of the wait commands once the condition is met.
+type WaitReport struct {
+	Namespace  string                 `json:"namespace"`
+	Name       string                 `json:"name"`
+	Generation int64                  `json:"generation"`
+	Condition  string                 `json:"condition"`
+	Status     metav1.ConditionStatus `json:"status"`
+	Reason     string                 `json:"reason,omitempty"`
+	Message    string                 `json:"message,omitempty"`
+}
+
+// ParseWaitCondition parses a Type or Type=Status condition; the status defaults to True.
+func ParseWaitCondition(condition string) (string, metav1.ConditionStatus, error) {
+	parts := strings.SplitN(condition, "=", 2)
+	conditionType, status := parts[0], metav1.ConditionTrue
+	if len(parts) == 2 {
+		status = metav1.ConditionStatus(parts[1])
+	}
+	if conditionType == "" {
+		return "", "", errors.Errorf("invalid condition %q", condition)
+	}
+	switch status {
+	case metav1.ConditionTrue, metav1.ConditionFalse, metav1.ConditionUnknown:
+	default:
+		return "", "", errors.Errorf("invalid status %q of condition %s: must be True, False or Unknown", status, conditionType)
+	}
+	return conditionType, status, nil
+}
+
+// Wait watches a Website until its condition has the expected status for its current
+// generation. It fails if the timeout expires or the Website is deleted. The watch is started
+// again from the latest state if the API server ends it.
+func Wait(c client.WithWatch, key types.NamespacedName, conditionType string, status metav1.ConditionStatus, timeout time.Duration) (*WaitReport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var last *metav1.Condition
+	met := func(website *v1alpha1.Website) *WaitReport {
+		last = meta.FindStatusCondition(website.Status.Conditions, conditionType)
+		if last == nil || last.Status != status || last.ObservedGeneration != website.Generation {
+			return nil
+		}
+		return &WaitReport{
+			Namespace:  key.Namespace,
+			Name:       key.Name,
+			Generation: website.Generation,
+			Condition:  conditionType,
+			Status:     status,
+			Reason:     last.Reason,
+			Message:    last.Message,
+		}
+	}
+	timedOut := func() error {
+		if last == nil {
+			return errors.Errorf("timed out after %s waiting for %s=%s, the condition is not reported", timeout, conditionType, status)
+		}
+		return errors.Errorf("timed out after %s waiting for %s=%s, it is %s for generation %d: %s: %s", timeout, conditionType, status, last.Status, last.ObservedGeneration, last.Reason, last.Message)
+	}
+
+	for {
+		// Check the latest state, which the watch starts from
+		website := &v1alpha1.Website{}
+		err := c.Get(ctx, key, website)
+		switch {
+		case apierrors.IsNotFound(err):
+			return nil, errors.Errorf("Website %s was not found", key)
+		case err != nil && ctx.Err() != nil:
+			return nil, timedOut()
+		case err != nil:
+			return nil, errors.Wrap(err, "failed to get Website")
+		}
+		if report := met(website); report != nil {
+			return report, nil
+		}
+
+		// Watch the Website for changes
+		watcher, err := c.Watch(ctx, &v1alpha1.WebsiteList{}, &client.ListOptions{
+			Namespace:     key.Namespace,
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", key.Name),
+			Raw:           &metav1.ListOptions{ResourceVersion: website.ResourceVersion},
+		})
+		if err != nil && ctx.Err() != nil {
+			return nil, timedOut()
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to watch Website")
+		}
+		report, err := waitForEvent(ctx, watcher, key, met)
+		watcher.Stop()
+		if report != nil || err != nil {
+			return report, err
+		}
+		if ctx.Err() != nil {
+			return nil, timedOut()
+		}
+	}
+}
+
+// waitForEvent reads the events of a watch until the condition is met, the Website is deleted
+// or the watch ends, in which case it returns neither a report nor an error.
+func waitForEvent(ctx context.Context, watcher watch.Interface, key types.NamespacedName, met func(*v1alpha1.Website) *WaitReport) (*WaitReport, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return nil, errors.Errorf("Website %s was deleted", key)
+			case watch.Error:
+				// The watch expired; it is started again from the latest state
+				return nil, nil
+			}
+			website, ok := event.Object.(*v1alpha1.Website)
+			if !ok {
+				continue
+			}
+			if report := met(website); report != nil {
+				return report, nil
+			}
+		}
+	}
+}
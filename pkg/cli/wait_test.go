@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// newWaitClient creates a fake cluster holding a Website with the given Ready status.
+func newWaitClient(t *testing.T, ready metav1.ConditionStatus) client.WithWatch {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	website := &v1alpha1.Website{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop", Generation: 2},
+		Status: v1alpha1.WebsiteStatus{Conditions: []metav1.Condition{{
+			Type:               v1alpha1.ConditionReady,
+			Status:             ready,
+			Reason:             "Pending",
+			ObservedGeneration: 2,
+		}}},
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(website).WithStatusSubresource(website).Build()
+}
+
+func TestWait(t *testing.T) {
+	key := types.NamespacedName{Namespace: "team", Name: "shop"}
+
+	t.Run("met", func(t *testing.T) {
+		c := newWaitClient(t, metav1.ConditionTrue)
+		report, err := Wait(c, key, v1alpha1.ConditionReady, metav1.ConditionTrue, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Generation != 2 || report.Status != metav1.ConditionTrue {
+			t.Errorf("got report %+v", report)
+		}
+	})
+
+	t.Run("met while watching", func(t *testing.T) {
+		c := newWaitClient(t, metav1.ConditionFalse)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			website := &v1alpha1.Website{}
+			if err := c.Get(context.Background(), key, website); err != nil {
+				return
+			}
+			website.Status.Conditions[0].Status = metav1.ConditionTrue
+			website.Status.Conditions[0].Reason = "Served"
+			c.Status().Update(context.Background(), website)
+		}()
+		report, err := Wait(c, key, v1alpha1.ConditionReady, metav1.ConditionTrue, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Reason != "Served" {
+			t.Errorf("got reason %q, want Served", report.Reason)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		c := newWaitClient(t, metav1.ConditionFalse)
+		_, err := Wait(c, key, v1alpha1.ConditionReady, metav1.ConditionTrue, 100*time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), "it is False for generation 2: Pending") {
+			t.Errorf("got error %v, want a timeout reporting the condition", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		c := newWaitClient(t, metav1.ConditionTrue)
+		_, err := Wait(c, types.NamespacedName{Namespace: "team", Name: "blog"}, v1alpha1.ConditionReady, metav1.ConditionTrue, time.Second)
+		if err == nil || !strings.Contains(err.Error(), "was not found") {
+			t.Errorf("got error %v, want not found", err)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// assetPatternPattern matches the path patterns of asset cache rules.
+var assetPatternPattern = regexp.MustCompile(`^[A-Za-z0-9_.~/*-]+$`)
+
+// assetPatternRegex compiles an asset path pattern into an anchored regular expression.
+func assetPatternRegex(pattern string) string {
+	regex := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `.*`)
+	if !strings.HasPrefix(pattern, "/") {
+		regex = "/" + regex
+	}
+	return regex + "$"
+}
+
+// assetsDirectives compiles a Website's asset cache rules into http-level maps from the path
+// to the expiry and Cache-Control extension, and server-level directives setting them. Maps
+// are used rather than regex locations, which nginx would match before the locations of
+// routes and transform scripts, so the assets would bypass them.
+func assetsDirectives(website *v1alpha1.Website) (string, string, error) {
+	assets := website.Spec.Assets
+	if assets == nil || len(assets.Rules) == 0 {
+		return "", "", nil
+	}
+
+	variable := "$website_assets_" + websiteIdentifier(website)
+	var expires, immutable strings.Builder
+	fmt.Fprintf(&expires, "map $uri %s_expires {\n", variable)
+	expires.WriteString("\tdefault off;\n")
+	fmt.Fprintf(&immutable, "map $uri %s_immutable {\n", variable)
+	immutable.WriteString("\tdefault \"\";\n")
+	for i, rule := range assets.Rules {
+		if len(rule.Patterns) == 0 {
+			return "", "", errors.Errorf("rule %d has no patterns", i)
+		}
+		seconds := int64(rule.MaxAge.Seconds())
+		if seconds < 1 {
+			return "", "", errors.Errorf("rule %d has a maxAge under a second", i)
+		}
+		for _, pattern := range rule.Patterns {
+			if !assetPatternPattern.MatchString(pattern) {
+				return "", "", errors.Errorf("invalid asset pattern %q", pattern)
+			}
+			regex := assetPatternRegex(pattern)
+			fmt.Fprintf(&expires, "\t\"~%s\" %ds;\n", regex, seconds)
+			if rule.Immutable {
+				fmt.Fprintf(&immutable, "\t\"~%s\" \"immutable\";\n", regex)
+			} else {
+				fmt.Fprintf(&immutable, "\t\"~%s\" \"\";\n", regex)
+			}
+		}
+	}
+	expires.WriteString("}\n")
+	immutable.WriteString("}\n")
+
+	// expires replaces the upstream's Expires and Cache-Control; immutable is added beside it
+	server := fmt.Sprintf("\texpires %s_expires;\n", variable) +
+		fmt.Sprintf("\tadd_header Cache-Control %s_immutable;\n", variable)
+	return expires.String() + immutable.String(), server, nil
+}
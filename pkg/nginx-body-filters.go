@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// bodyFilterTypePattern matches the MIME types body filters apply to.
+var bodyFilterTypePattern = regexp.MustCompile(`^[a-z]+/[A-Za-z0-9.+-]+$`)
+
+// bodyFilterDirectives compiles the body filters of a Website into sub_filter directives.
+// nginx applies all substitutions of a server to every filtered type, so each replacement is
+// chosen by a map on the response type, replacing the match with itself for other types.
+func bodyFilterDirectives(website *v1alpha1.Website) (string, string, error) {
+	filters := website.Spec.BodyFilters
+	if len(filters) == 0 {
+		return "", "", nil
+	}
+
+	var http, server strings.Builder
+	types := map[string]bool{}
+	for i, filter := range filters {
+		// The strings are rendered quoted, and the match must not expand variables
+		if filter.Match == "" || strings.ContainsAny(filter.Match, "\"\\$\n") {
+			return "", "", errors.Errorf("invalid body filter match %q", filter.Match)
+		}
+		if strings.ContainsAny(filter.Replace, "\"\\\n") {
+			return "", "", errors.Errorf("invalid body filter replacement %q", filter.Replace)
+		}
+		contentTypes := filter.ContentTypes
+		if len(contentTypes) == 0 {
+			contentTypes = []string{"text/html"}
+		}
+		for _, contentType := range contentTypes {
+			if !bodyFilterTypePattern.MatchString(contentType) {
+				return "", "", errors.Errorf("invalid body filter content type %q", contentType)
+			}
+			types[contentType] = true
+		}
+
+		variable := fmt.Sprintf("$website_body_filter_%s_%d", websiteIdentifier(website), i)
+		fmt.Fprintf(&http, "map $sent_http_content_type %s {\n", variable)
+		fmt.Fprintf(&http, "\tdefault \"%s\";\n", filter.Match)
+		fmt.Fprintf(&http, "\t\"~*^(%s)(;|$)\" \"%s\";\n", strings.Join(quoteRegexps(contentTypes), "|"), filter.Replace)
+		http.WriteString("}\n")
+		fmt.Fprintf(&server, "\tsub_filter \"%s\" %s;\n", filter.Match, variable)
+	}
+
+	// Filter every type any filter applies to; text/html is always filtered
+	delete(types, "text/html")
+	names := make([]string, 0, len(types))
+	for contentType := range types {
+		names = append(names, contentType)
+	}
+	sort.Strings(names)
+	server.WriteString("\tsub_filter_once off;\n")
+	if len(names) > 0 {
+		fmt.Fprintf(&server, "\tsub_filter_types %s;\n", strings.Join(names, " "))
+	}
+	server.WriteString("\tproxy_set_header Accept-Encoding \"\";\n")
+	return http.String(), server.String(), nil
+}
+
+// quoteRegexps quotes strings for use in a regular expression.
+func quoteRegexps(values []string) []string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, regexp.QuoteMeta(value))
+	}
+	return quoted
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// nginxModulesDir holds the dynamic modules of nginx.
+const nginxModulesDir = "/usr/lib/nginx/modules"
+
+// nginxVersionPattern matches the version line of nginx -V.
+var nginxVersionPattern = regexp.MustCompile(`nginx version: nginx/(\S+)`)
+
+// capabilityProbes detect capabilities from the configure arguments of nginx -V, which list
+// static modules, or from the dynamic modules installed next to it.
+var capabilityProbes = map[string]struct {
+	configure string
+	module    string
+}{
+	v1alpha1.CapabilityHTTP2:  {configure: "--with-http_v2_module"},
+	v1alpha1.CapabilityBrotli: {configure: "brotli", module: "ngx_http_brotli_filter_module.so"},
+	v1alpha1.CapabilityGeoIP2: {configure: "geoip2", module: "ngx_http_geoip2_module.so"},
+	v1alpha1.CapabilityNJS:    {configure: "njs", module: filepath.Base(njsModulePath)},
+	v1alpha1.CapabilityOtel:   {configure: "otel", module: filepath.Base(otelModulePath)},
+
+	v1alpha1.CapabilityLeastTime: {configure: "nginx-plus"},
+}
+
+// nginxCapabilities is the detected version and capability set of nginx.
+type nginxCapabilities struct {
+	version      string
+	capabilities map[string]bool
+}
+
+// detectCapabilities probes nginx -V for the version and compiled modules of nginx.
+func detectCapabilities() (*nginxCapabilities, error) {
+	// nginx -V prints to stderr
+	var out bytes.Buffer
+	cmd := exec.Command("nginx", "-V")
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to run nginx -V")
+	}
+
+	detected := &nginxCapabilities{capabilities: map[string]bool{}}
+	if match := nginxVersionPattern.FindStringSubmatch(out.String()); match != nil {
+		detected.version = match[1]
+	}
+	for capability, probe := range capabilityProbes {
+		supported := strings.Contains(out.String(), probe.configure)
+		if !supported && probe.module != "" {
+			_, err := os.Stat(filepath.Join(nginxModulesDir, probe.module))
+			supported = err == nil
+		}
+		detected.capabilities[capability] = supported
+	}
+
+	return detected, nil
+}
+
+// list returns the supported capabilities, sorted.
+func (n *nginxCapabilities) list() []string {
+	var list []string
+	for capability, supported := range n.capabilities {
+		if supported {
+			list = append(list, capability)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// initCapabilities detects the capabilities of nginx and publishes them in the metrics and the
+// NginxCapabilities object of this host. If detection fails, all features are assumed supported.
+func (c *WebsiteController) initCapabilities(ctx context.Context) {
+	detected, err := detectCapabilities()
+	if err != nil {
+		c.log.Error(err, "failed to detect nginx capabilities, assuming all features are supported")
+		return
+	}
+	c.capabilities = detected
+	c.log.Info("detected nginx capabilities", "version", detected.version, "capabilities", detected.list())
+
+	// Publish the capabilities in the metrics
+	nginxInfo.WithLabelValues(detected.version).Set(1)
+	for capability, supported := range detected.capabilities {
+		value := 0.0
+		if supported {
+			value = 1
+		}
+		nginxCapability.WithLabelValues(capability).Set(value)
+	}
+
+	// Publish the capabilities in the cluster
+	err = c.publishCapabilities(ctx, detected)
+	if err != nil {
+		c.log.Error(err, "failed to publish nginx capabilities")
+	}
+}
+
+// publishCapabilities creates or updates the NginxCapabilities object of this host.
+func (c *WebsiteController) publishCapabilities(ctx context.Context, detected *nginxCapabilities) error {
+	name, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "failed to get hostname")
+	}
+
+	object := &v1alpha1.NginxCapabilities{}
+	err = c.client.Get(ctx, types.NamespacedName{Name: name}, object)
+	if apierrors.IsNotFound(err) {
+		object = &v1alpha1.NginxCapabilities{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		err = c.client.Create(ctx, object)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to get NginxCapabilities")
+	}
+
+	object.Status = v1alpha1.NginxCapabilitiesStatus{
+		Version:      detected.version,
+		Capabilities: detected.list(),
+		Time:         metav1.NewTime(time.Now()),
+	}
+	err = c.client.Status().Update(ctx, object)
+	if err != nil {
+		return errors.Wrap(err, "failed to update NginxCapabilities status")
+	}
+	return nil
+}
+
+// requiredCapabilities lists the capabilities the features of a Website require, with the
+// spec field that requires them.
+func requiredCapabilities(website *v1alpha1.Website) map[string]string {
+	required := map[string]string{}
+	if otelSpec(website) != nil {
+		required[v1alpha1.CapabilityOtel] = "spec.tracing.otel"
+	}
+	if transform := website.Spec.Transform; transform != nil && (transform.RequestScriptRef != nil || transform.ResponseScriptRef != nil) {
+		required[v1alpha1.CapabilityNJS] = "spec.transform"
+	}
+	return required
+}
+
+// checkCapabilities refuses to serve a Website using features the nginx data plane does not
+// support, and says so in its Ready condition.
+func (c *WebsiteController) checkCapabilities(ctx context.Context, website *v1alpha1.Website) error {
+	if c.capabilities == nil {
+		return nil
+	}
+
+	var unsupported []string
+	for capability, field := range requiredCapabilities(website) {
+		if !c.capabilities.capabilities[capability] {
+			unsupported = append(unsupported, field+" requires the nginx "+capability+" module")
+		}
+	}
+	if len(unsupported) == 0 {
+		return nil
+	}
+	sort.Strings(unsupported)
+	message := "The data plane does not support the Website: " + strings.Join(unsupported, "; ")
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "UnsupportedFeature",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return errors.New(message)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// update rewrites the golden files with the rendered configurations instead of comparing them.
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// configMapKey selects a key of a ConfigMap.
+func configMapKey(name, key string) *corev1.ConfigMapKeySelector {
+	return &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key}
+}
+
+func TestCompileNginxConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       v1alpha1.WebsiteSpec
+		listen     string
+		middleware []v1alpha1.WebsiteMiddlewareSpec
+		template   string
+	}{
+		{
+			name: "basic",
+			spec: v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://shop.team.svc:8080"},
+		},
+		{
+			name:   "listen-options",
+			spec:   v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://shop.team.svc:8080"},
+			listen: " reuseport",
+		},
+		{
+			name: "listeners",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				TLS:      &v1alpha1.TLSSpec{SecretRef: &corev1.LocalObjectReference{Name: "shop-tls"}},
+				Listeners: []v1alpha1.Listener{
+					{Port: 8080, ProxyProtocol: true},
+					{Port: 8443, Protocol: v1alpha1.ListenerHTTPS},
+				},
+			},
+		},
+		{
+			name: "sni-certificates",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				TLS: &v1alpha1.TLSSpec{
+					SecretRef: &corev1.LocalObjectReference{Name: "shop-tls"},
+					Certificates: []v1alpha1.TLSCertificate{
+						{SecretRef: corev1.LocalObjectReference{Name: "shop-de-tls"}, Hostnames: []string{"shop.example.de", "www.shop.example.de"}},
+						{SecretRef: corev1.LocalObjectReference{Name: "shop-fr-tls"}, Hostnames: []string{"*.shop.example.fr"}},
+					},
+				},
+			},
+		},
+		{
+			name: "middleware",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:   "shop.example.com",
+				Upstream:   "http://shop.team.svc:8080",
+				Middleware: []corev1.LocalObjectReference{{Name: "headers"}, {Name: "limits"}},
+			},
+			middleware: []v1alpha1.WebsiteMiddlewareSpec{
+				{
+					Headers: &v1alpha1.MiddlewareHeaders{
+						Request:  []v1alpha1.HeaderValue{{Name: "X-Team", Value: "shop"}},
+						Response: []v1alpha1.HeaderValue{{Name: "X-Frame-Options", Value: "DENY"}},
+					},
+					Rewrites: []v1alpha1.RewriteRule{{Regex: "^/old/(.*)$", Replacement: "/new/$1"}},
+				},
+				{RateLimit: &v1alpha1.RateLimitSpec{RequestsPerSecond: 10, Burst: 20}},
+			},
+		},
+		{
+			name: "transform",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				Transform: &v1alpha1.TransformSpec{
+					RequestScriptRef:  configMapKey("scripts", "request.js"),
+					ResponseScriptRef: configMapKey("scripts", "response.js"),
+				},
+			},
+		},
+		{
+			name: "transform-response",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:  "shop.example.com",
+				Upstream:  "http://shop.team.svc:8080/app",
+				Transform: &v1alpha1.TransformSpec{ResponseScriptRef: configMapKey("scripts", "response.js")},
+			},
+		},
+		{
+			name: "redirect-map",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:       "shop.example.com",
+				Upstream:       "http://shop.team.svc:8080",
+				RedirectMapRef: configMapKey("redirects", "map"),
+			},
+		},
+		{
+			name:     "template",
+			spec:     v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://shop.team.svc:8080"},
+			template: "{{.HTTP}}server {\n{{.Listen}}\tserver_name {{.ServerName}} www.{{.ServerName}};\n\tproxy_read_timeout 300s;\n{{.Directives}}{{.Locations}}}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			website := &v1alpha1.Website{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop"}, Spec: tt.spec}
+			c := newRenderer()
+			if tt.middleware != nil {
+				c.middleware[websiteKey(website)] = tt.middleware
+			}
+			var tmpl *serverTemplate
+			if tt.template != "" {
+				parsed, err := template.New(tt.name).Option("missingkey=error").Parse(tt.template)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tmpl = &serverTemplate{version: "test", template: parsed}
+			}
+
+			got, err := c.compileNginxConfig(context.Background(), website, tt.listen, tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("testdata", "compile", tt.name+".conf"), got)
+		})
+	}
+}
+
+func TestCompileNginxConfigDeterministic(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       v1alpha1.WebsiteSpec
+		middleware []v1alpha1.WebsiteMiddlewareSpec
+		// permuted is the same Website with its lists in another order
+		permuted           v1alpha1.WebsiteSpec
+		permutedMiddleware []v1alpha1.WebsiteMiddlewareSpec
+	}{
+		{
+			name: "upstreams",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:  "shop.example.com",
+				Upstream:  "http://shop-a.team.svc:8080",
+				Upstreams: []string{"http://shop-b.team.svc:8080", "http://shop-c.team.svc:8080", "http://shop-d.team.svc:8080"},
+			},
+			permuted: v1alpha1.WebsiteSpec{
+				Hostname:  "shop.example.com",
+				Upstream:  "http://shop-a.team.svc:8080",
+				Upstreams: []string{"http://shop-d.team.svc:8080", "http://shop-b.team.svc:8080", "http://shop-c.team.svc:8080"},
+			},
+		},
+		{
+			name: "routes",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				Routes: []v1alpha1.Route{
+					{Path: "/api/", Upstream: "http://api.team.svc:8080"},
+					{Path: "/api/v2/", Upstream: "http://api-v2.team.svc:8080", Rewrite: "/"},
+					{Path: "/assets/", Upstream: "http://assets.team.svc:8080"},
+				},
+			},
+			permuted: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				Routes: []v1alpha1.Route{
+					{Path: "/assets/", Upstream: "http://assets.team.svc:8080"},
+					{Path: "/api/", Upstream: "http://api.team.svc:8080"},
+					{Path: "/api/v2/", Upstream: "http://api-v2.team.svc:8080", Rewrite: "/"},
+				},
+			},
+		},
+		{
+			name: "headers",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:   "shop.example.com",
+				Upstream:   "http://shop.team.svc:8080",
+				Middleware: []corev1.LocalObjectReference{{Name: "headers"}},
+			},
+			middleware: []v1alpha1.WebsiteMiddlewareSpec{{
+				Headers: &v1alpha1.MiddlewareHeaders{
+					Request: []v1alpha1.HeaderValue{{Name: "X-Region", Value: "eu"}, {Name: "X-Team", Value: "shop"}},
+					Response: []v1alpha1.HeaderValue{
+						{Name: "X-Content-Type-Options", Value: "nosniff"},
+						{Name: "X-Frame-Options", Value: "DENY"},
+					},
+				},
+			}},
+			permuted: v1alpha1.WebsiteSpec{
+				Hostname:   "shop.example.com",
+				Upstream:   "http://shop.team.svc:8080",
+				Middleware: []corev1.LocalObjectReference{{Name: "headers"}},
+			},
+			permutedMiddleware: []v1alpha1.WebsiteMiddlewareSpec{{
+				Headers: &v1alpha1.MiddlewareHeaders{
+					Request: []v1alpha1.HeaderValue{{Name: "X-Team", Value: "shop"}, {Name: "X-Region", Value: "eu"}},
+					Response: []v1alpha1.HeaderValue{
+						{Name: "X-Frame-Options", Value: "DENY"},
+						{Name: "X-Content-Type-Options", Value: "nosniff"},
+					},
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			render := func(spec v1alpha1.WebsiteSpec, middleware []v1alpha1.WebsiteMiddlewareSpec) string {
+				website := &v1alpha1.Website{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop"}, Spec: spec}
+				c := newRenderer()
+				if middleware != nil {
+					c.middleware[websiteKey(website)] = middleware
+				}
+				got, err := c.compileNginxConfig(context.Background(), website, "", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return got
+			}
+
+			// The golden file is compared byte for byte, so every rendering must match it
+			path := filepath.Join("testdata", "compile", tt.name+".conf")
+			assertGolden(t, path, render(tt.spec, tt.middleware))
+			if *update {
+				return
+			}
+			assertGolden(t, path, render(tt.spec, tt.middleware))
+			assertGolden(t, path, render(tt.permuted, tt.permutedMiddleware))
+		})
+	}
+}
+
+func TestCompileNginxConfigErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       v1alpha1.WebsiteSpec
+		middleware []v1alpha1.WebsiteMiddlewareSpec
+		want       string
+	}{
+		{
+			name: "no upstream",
+			spec: v1alpha1.WebsiteSpec{Hostname: "shop.example.com"},
+			want: "spec.upstream or spec.serviceRef is required",
+		},
+		{
+			name: "upstream with nginx directives",
+			spec: v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://a.com/x; return 200 pwned"},
+			want: `invalid upstream "http://a.com/x; return 200 pwned": must be an http(s) URL`,
+		},
+		{
+			name: "duplicate listener",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:  "shop.example.com",
+				Upstream:  "http://shop.team.svc:8080",
+				Listeners: []v1alpha1.Listener{{Port: 8080}, {Port: 8080}},
+			},
+			want: "invalid listeners: port 8080 is listed twice",
+		},
+		{
+			name: "HTTPS listener without TLS",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:  "shop.example.com",
+				Upstream:  "http://shop.team.svc:8080",
+				Listeners: []v1alpha1.Listener{{Port: 8443, Protocol: v1alpha1.ListenerHTTPS}},
+			},
+			want: "invalid listeners: listener on port 8443 is HTTPS but spec.tls is not set",
+		},
+		{
+			name: "SNI certificates without a certificate for the hostname",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				TLS: &v1alpha1.TLSSpec{Certificates: []v1alpha1.TLSCertificate{
+					{SecretRef: corev1.LocalObjectReference{Name: "shop-de-tls"}, Hostnames: []string{"shop.example.de"}},
+				}},
+			},
+			want: "invalid TLS configuration: tls.certificates requires tls.secretRef, tls.vaultRef or tls.spiffe for the hostname",
+		},
+		{
+			name: "SNI hostname with a semicolon",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname: "shop.example.com",
+				Upstream: "http://shop.team.svc:8080",
+				TLS: &v1alpha1.TLSSpec{
+					SecretRef: &corev1.LocalObjectReference{Name: "shop-tls"},
+					Certificates: []v1alpha1.TLSCertificate{
+						{SecretRef: corev1.LocalObjectReference{Name: "shop-de-tls"}, Hostnames: []string{"shop.example.de;return"}},
+					},
+				},
+			},
+			want: `invalid TLS configuration: tls.certificates hostname "shop.example.de;return" is invalid`,
+		},
+		{
+			name: "unresolved middleware",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:   "shop.example.com",
+				Upstream:   "http://shop.team.svc:8080",
+				Middleware: []corev1.LocalObjectReference{{Name: "headers"}},
+			},
+			want: "invalid middleware configuration: middleware is not resolved",
+		},
+		{
+			name: "rewrite with a quote",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:   "shop.example.com",
+				Upstream:   "http://shop.team.svc:8080",
+				Middleware: []corev1.LocalObjectReference{{Name: "rewrites"}},
+			},
+			middleware: []v1alpha1.WebsiteMiddlewareSpec{
+				{Rewrites: []v1alpha1.RewriteRule{{Regex: "^/a$", Replacement: `/b";return 200 "x`}}},
+			},
+			want: "invalid middleware configuration",
+		},
+		{
+			name: "request script with an upstream path",
+			spec: v1alpha1.WebsiteSpec{
+				Hostname:  "shop.example.com",
+				Upstream:  "http://shop.team.svc:8080/app",
+				Transform: &v1alpha1.TransformSpec{RequestScriptRef: configMapKey("scripts", "request.js")},
+			},
+			want: "invalid transform configuration: a request script requires an upstream without a path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			website := &v1alpha1.Website{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop"}, Spec: tt.spec}
+			c := newRenderer()
+			if tt.middleware != nil {
+				c.middleware[websiteKey(website)] = tt.middleware
+			}
+			_, err := c.compileNginxConfig(context.Background(), website, "", nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// assertGolden compares a rendered configuration with a golden file, or rewrites the golden
+// file with -update.
+func assertGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *update {
+		err := ioutil.WriteFile(path, []byte(got), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run with -update to create it: %s", err)
+	}
+	if got != string(want) {
+		t.Errorf("configuration differs from %s:\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
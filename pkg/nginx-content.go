@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+var (
+	// contentExtensionPattern matches the file extensions of content types.
+	contentExtensionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_+-]*$`)
+
+	// contentTypePattern matches MIME types, optionally with parameters.
+	contentTypePattern = regexp.MustCompile(`^[a-z]+/[A-Za-z0-9.+-]+(; ?[A-Za-z0-9_-]+=[A-Za-z0-9_.:-]+)*$`)
+
+	// contentCharsetPattern matches charset names.
+	contentCharsetPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+)
+
+// contentDirectives compiles a Website's content types into http-level maps and server-level
+// directives. A default charset alone is added by nginx's charset module. Types replace the
+// upstream's Content-Type, which is then set by the maps, charset included.
+func contentDirectives(website *v1alpha1.Website) (string, string, error) {
+	content := website.Spec.Content
+	if content == nil {
+		return "", "", nil
+	}
+	if content.DefaultCharset != "" && !contentCharsetPattern.MatchString(content.DefaultCharset) {
+		return "", "", errors.Errorf("invalid default charset %q", content.DefaultCharset)
+	}
+	if len(content.Types) == 0 {
+		if content.DefaultCharset == "" {
+			return "", "", nil
+		}
+		return "", fmt.Sprintf("\tcharset %s;\n", content.DefaultCharset), nil
+	}
+
+	// Map the path extensions to their types, in a stable order
+	extensions := make([]string, 0, len(content.Types))
+	for extension, contentType := range content.Types {
+		if !contentExtensionPattern.MatchString(extension) {
+			return "", "", errors.Errorf("invalid file extension %q", extension)
+		}
+		if !contentTypePattern.MatchString(contentType) {
+			return "", "", errors.Errorf("invalid content type %q for extension %q", contentType, extension)
+		}
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+
+	variable := "$website_content_type_" + websiteIdentifier(website)
+	var http strings.Builder
+	fmt.Fprintf(&http, "map $uri %s {\n", variable+"_base")
+	fmt.Fprintf(&http, "\tdefault $upstream_http_content_type;\n")
+	for _, extension := range extensions {
+		fmt.Fprintf(&http, "\t\"~*\\.%s$\" \"%s\";\n", extension, content.Types[extension])
+	}
+	http.WriteString("}\n")
+
+	// Add the charset to text types without one, as the charset module sees no type anymore
+	fmt.Fprintf(&http, "map %s %s {\n", variable+"_base", variable)
+	fmt.Fprintf(&http, "\tdefault %s;\n", variable+"_base")
+	if content.DefaultCharset != "" {
+		fmt.Fprintf(&http, "\t\"~*^((text/[^;]+|application/(javascript|json|xml|rss\\+xml))\\s*)$\" \"$1; charset=%s\";\n", content.DefaultCharset)
+	}
+	http.WriteString("}\n")
+
+	server := "\tproxy_hide_header Content-Type;\n" +
+		fmt.Sprintf("\tadd_header Content-Type %s always;\n", variable)
+	return http.String(), server, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// cookieDirectives sets the flags of a CookiesSpec on every cookie the upstream sets, with
+// proxy_cookie_flags, which needs nginx 1.19.3 or later.
+func cookieDirectives(cookies *v1alpha1.CookiesSpec) (string, error) {
+	if cookies == nil {
+		return "", nil
+	}
+
+	var flags []string
+	if cookies.ForceSecure {
+		flags = append(flags, "secure")
+	}
+	if cookies.ForceHTTPOnly {
+		flags = append(flags, "httponly")
+	}
+	switch cookies.SameSite {
+	case "":
+	case "Strict", "Lax":
+		flags = append(flags, "samesite="+strings.ToLower(cookies.SameSite))
+	case "None":
+		if !cookies.ForceSecure {
+			return "", errors.New("sameSite None requires forceSecure")
+		}
+		flags = append(flags, "samesite=none")
+	default:
+		return "", errors.Errorf("unknown sameSite value %q", cookies.SameSite)
+	}
+	if len(flags) == 0 {
+		return "", nil
+	}
+
+	// The ~ pattern matches every cookie
+	return "\tproxy_cookie_flags ~ " + strings.Join(flags, " ") + ";\n", nil
+}
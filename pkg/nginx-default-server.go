@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultServerConfigPath is the managed server block answering unknown hostnames.
+	defaultServerConfigPath = "/etc/nginx/conf.d/00-website-controller-default.conf"
+
+	// defaultServerRoot holds the pages of the default server.
+	defaultServerRoot = "/etc/nginx/website-controller/default-server"
+
+	// unknownHostLogPath logs the hostname of every request answered by the default server.
+	unknownHostLogPath = "/var/log/nginx/website-unknown-hosts.log"
+
+	// unknownHostInterval is how often the unknown hostnames are counted.
+	unknownHostInterval = 15 * time.Second
+
+	// unknownHostLimit bounds the hostnames exported as metric labels, as the Host header is
+	// chosen by clients. Further hostnames are counted as "other".
+	unknownHostLimit = 1000
+)
+
+// defaultServerConfig answers requests for unknown hostnames. HTTPS handshakes for unknown
+// hostnames are rejected, as no certificate can match; requests over a connection established
+// for another hostname are misdirected.
+const defaultServerConfig = `log_format website_unknown_host '$host';
+
+server {
+	listen 80 default_server;
+	listen 443 ssl default_server;
+	ssl_reject_handshake on;
+	server_name _;
+	access_log ` + unknownHostLogPath + ` website_unknown_host;
+	root ` + defaultServerRoot + `;
+	error_page 404 /404.html;
+	error_page 421 /421.html;
+	location = /404.html {
+		internal;
+	}
+	location = /421.html {
+		internal;
+	}
+	location / {
+		if ($scheme = https) {
+			return 421;
+		}
+		return 404;
+	}
+}
+`
+
+// defaultNotFoundPage and defaultMisdirectedPage are served unless the NginxGlobalConfig brands them.
+const (
+	defaultNotFoundPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Not found</title></head>
+<body><h1>Not found</h1><p>No website is served under this hostname.</p></body>
+</html>
+`
+	defaultMisdirectedPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Misdirected request</title></head>
+<body><h1>Misdirected request</h1><p>This connection cannot serve this hostname. Please reload the page.</p></body>
+</html>
+`
+)
+
+// unknownHostPattern matches hostnames worth exporting, keeping IP literals and garbage out of
+// the metric labels.
+var unknownHostPattern = regexp.MustCompile(`^([a-z0-9]([-a-z0-9]*[a-z0-9])?\.)+[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// renderDefaultServer renders the files of the default server, keyed by path.
+func renderDefaultServer(spec *v1alpha1.DefaultServerSpec) map[string]string {
+	notFound, misdirected := defaultNotFoundPage, defaultMisdirectedPage
+	if spec != nil && spec.NotFoundPage != "" {
+		notFound = spec.NotFoundPage
+	}
+	if spec != nil && spec.MisdirectedPage != "" {
+		misdirected = spec.MisdirectedPage
+	}
+	return map[string]string{
+		defaultServerConfigPath:                      defaultServerConfig,
+		filepath.Join(defaultServerRoot, "404.html"): notFound,
+		filepath.Join(defaultServerRoot, "421.html"): misdirected,
+	}
+}
+
+// runUnknownHosts counts the requests answered by the default server per hostname until
+// the context is cancelled, so DNS records still pointing at the edge can be spotted.
+func (c *WebsiteController) runUnknownHosts(ctx context.Context) {
+	ticker := time.NewTicker(unknownHostInterval)
+	defer ticker.Stop()
+
+	tail := &logTail{path: unknownHostLogPath}
+	tail.read(func(string) {})
+	seen := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := tail.read(func(line string) {
+			hostname := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(line)), ".")
+			if !unknownHostPattern.MatchString(hostname) {
+				hostname = "other"
+			} else if !seen[hostname] {
+				if len(seen) >= unknownHostLimit {
+					hostname = "other"
+				} else {
+					seen[hostname] = true
+				}
+			}
+			unknownHostRequests.WithLabelValues(hostname).Inc()
+		})
+		if err != nil {
+			c.log.Error(err, "failed to read unknown host log")
+		}
+	}
+}
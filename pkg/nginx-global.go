@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/controller/util"
+)
+
+const (
+	// globalConfigDir holds the managed includes of the main and events contexts. nginx.conf
+	// must include main.conf at the top level and events.conf inside its events block.
+	globalConfigDir = "/etc/nginx/website-controller"
+
+	// globalHTTPConfigPath is the managed include of the http context.
+	globalHTTPConfigPath = "/etc/nginx/conf.d/00-website-controller-global.conf"
+)
+
+// watchGlobalConfig watches for NginxGlobalConfig objects and applies the default one.
+func (c *WebsiteController) watchGlobalConfig(ctx context.Context) error {
+	// Write the defaults, including the default server, if there is no configuration
+	err := c.client.Get(ctx, types.NamespacedName{Name: v1alpha1.NginxGlobalConfigName}, &v1alpha1.NginxGlobalConfig{})
+	if apierrors.IsNotFound(err) {
+		err = c.applyGlobalConfig(ctx, &v1alpha1.NginxGlobalConfig{})
+		if err != nil {
+			c.log.Error(err, "failed to apply default global nginx configuration")
+		}
+		c.rolloutGlobalConfig(ctx, &v1alpha1.NginxGlobalConfig{})
+	}
+
+	w := util.NewWatch(ctx, &v1alpha1.NginxGlobalConfig{})
+
+	err = w.Watch(func(event watch.Event) error {
+		config, ok := event.Object.(*v1alpha1.NginxGlobalConfig)
+		if !ok {
+			return errors.Errorf("object is not an NginxGlobalConfig: %T", event.Object)
+		}
+		if config.Name != v1alpha1.NginxGlobalConfigName {
+			return nil
+		}
+
+		// A deleted configuration falls back to the nginx defaults
+		if event.Type == watch.Deleted {
+			config = &v1alpha1.NginxGlobalConfig{}
+		}
+		err := c.applyGlobalConfig(ctx, config)
+		if err != nil {
+			c.log.Error(err, "failed to apply global nginx configuration")
+		}
+
+		// Re-render the Websites if the settings they are rendered under changed
+		c.rolloutGlobalConfig(ctx, config)
+
+		// Resume deletions held by the deletion guard once acknowledged
+		err = c.acknowledgeDeletions(ctx, config)
+		if err != nil {
+			c.log.Error(err, "failed to acknowledge held deletions")
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch for NginxGlobalConfig objects")
+	}
+
+	return nil
+}
+
+// applyGlobalConfig renders the managed includes of an NginxGlobalConfig, reloads nginx if any
+// of them changed, and records the outcome in the object's status.
+func (c *WebsiteController) applyGlobalConfig(ctx context.Context, config *v1alpha1.NginxGlobalConfig) error {
+	files, renderErr := renderGlobalConfig(&config.Spec)
+	changed := false
+	if renderErr == nil {
+		changed, renderErr = c.writeGlobalConfig(files)
+	}
+	if renderErr == nil && changed {
+		renderErr = c.reloadNginx(nil)
+	}
+
+	// Deleted configurations have no status to update
+	if config.Name == "" {
+		return renderErr
+	}
+
+	condition := metav1.Condition{
+		Type:               "Applied",
+		Status:             metav1.ConditionTrue,
+		Reason:             "Applied",
+		Message:            "The global configuration is applied to nginx",
+		ObservedGeneration: config.Generation,
+	}
+	if renderErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ApplyFailed"
+		condition.Message = renderErr.Error()
+	}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &v1alpha1.NginxGlobalConfig{}
+		err := c.client.Get(ctx, types.NamespacedName{Name: config.Name}, latest)
+		if err != nil {
+			return err
+		}
+		latest.Status.ObservedGeneration = config.Generation
+		meta.SetStatusCondition(&latest.Status.Conditions, condition)
+		return c.client.Status().Update(ctx, latest)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update NginxGlobalConfig status")
+	}
+
+	return renderErr
+}
+
+// renderGlobalConfig renders the managed includes, keyed by path. Includes are written
+// even when empty, so the include directives in nginx.conf always resolve.
+func renderGlobalConfig(spec *v1alpha1.NginxGlobalConfigSpec) (map[string]string, error) {
+	var top, events, http strings.Builder
+
+	// Render the main and events contexts
+	if spec.WorkerProcesses != "" {
+		if !isHeaderToken(spec.WorkerProcesses) {
+			return nil, errors.Errorf("invalid workerProcesses %q", spec.WorkerProcesses)
+		}
+		fmt.Fprintf(&top, "worker_processes %s;\n", spec.WorkerProcesses)
+	}
+	if spec.WorkerConnections != nil {
+		fmt.Fprintf(&events, "worker_connections %d;\n", *spec.WorkerConnections)
+	}
+
+	// Render the http context
+	if len(spec.Resolvers) > 0 {
+		for _, resolver := range spec.Resolvers {
+			if strings.ContainsAny(resolver, " \t;{}\"'") {
+				return nil, errors.Errorf("invalid resolver %q", resolver)
+			}
+		}
+		fmt.Fprintf(&http, "resolver %s", strings.Join(spec.Resolvers, " "))
+		if spec.ResolverValid != nil {
+			fmt.Fprintf(&http, " valid=%ds", int(spec.ResolverValid.Seconds()))
+		}
+		http.WriteString(";\n")
+	}
+	for _, format := range spec.LogFormats {
+		if nginxIdentifier(format.Name) != format.Name || format.Name == "" {
+			return nil, errors.Errorf("invalid log format name %q", format.Name)
+		}
+		http.WriteString("log_format " + format.Name)
+		if format.Escape != "" {
+			if !isHeaderToken(format.Escape) {
+				return nil, errors.Errorf("invalid log format escape %q", format.Escape)
+			}
+			http.WriteString(" escape=" + format.Escape)
+		}
+		fmt.Fprintf(&http, " '%s';\n", escapeSingleQuoted(format.Format))
+	}
+	if ssl := spec.SSL; ssl != nil {
+		if len(ssl.Protocols) > 0 {
+			for _, protocol := range ssl.Protocols {
+				if !strings.HasPrefix(protocol, "TLSv") || strings.ContainsAny(protocol, " ;") {
+					return nil, errors.Errorf("invalid TLS protocol %q", protocol)
+				}
+			}
+			fmt.Fprintf(&http, "ssl_protocols %s;\n", strings.Join(ssl.Protocols, " "))
+		}
+		if ssl.Ciphers != "" {
+			if strings.ContainsAny(ssl.Ciphers, " \t;{}\"'") {
+				return nil, errors.Errorf("invalid ciphers %q", ssl.Ciphers)
+			}
+			fmt.Fprintf(&http, "ssl_ciphers %s;\n", ssl.Ciphers)
+		}
+		if ssl.PreferServerCiphers != nil {
+			if *ssl.PreferServerCiphers {
+				http.WriteString("ssl_prefer_server_ciphers on;\n")
+			} else {
+				http.WriteString("ssl_prefer_server_ciphers off;\n")
+			}
+		}
+		if ssl.SessionTimeout != nil {
+			fmt.Fprintf(&http, "ssl_session_timeout %ds;\n", int(ssl.SessionTimeout.Seconds()))
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(globalConfigDir, "main.conf"):   top.String(),
+		filepath.Join(globalConfigDir, "events.conf"): events.String(),
+		globalHTTPConfigPath:                          http.String(),
+	}
+
+	// Answer unknown hostnames with the managed default server
+	for path, content := range renderDefaultServer(spec.DefaultServer) {
+		files[path] = content
+	}
+
+	return files, nil
+}
+
+// writeGlobalConfig writes the managed includes and reports whether any of them changed.
+func (c *WebsiteController) writeGlobalConfig(files map[string]string) (bool, error) {
+	changed := false
+	for path, content := range files {
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && string(existing) == content {
+			continue
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to create global configuration directory")
+		}
+		err = writeFileAtomic(path, []byte(content), 0644)
+		c.audit(auditConfigWrite, nil, path, []byte(content), err)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to write global configuration")
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// escapeSingleQuoted escapes a string for use inside single quotes in the nginx configuration.
+func escapeSingleQuoted(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return strings.Replace(s, `'`, `\'`, -1)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// healthEndpointDirectives compiles a HealthEndpointSpec into a location answered by nginx
+// itself, so the health response does not depend on the upstream. Forward auth is disabled
+// for the location if the Website uses it.
+func healthEndpointDirectives(health *v1alpha1.HealthEndpointSpec, forwardAuth bool) (string, error) {
+	if health == nil {
+		return "", nil
+	}
+
+	// Validate the path; it ends up unquoted in the location directive
+	if !strings.HasPrefix(health.Path, "/") || strings.ContainsAny(health.Path, " \t\n;{}'\"$\\") {
+		return "", errors.Errorf("invalid health endpoint path %q", health.Path)
+	}
+	if health.Path == "/" {
+		return "", errors.New("health endpoint path cannot be the root path")
+	}
+
+	// Validate the status code; nginx treats the text of a redirect as its location
+	status := health.StatusCode
+	if status == 0 {
+		status = 200
+	}
+	if status < 200 || status > 599 || (status >= 300 && status < 400) {
+		return "", errors.Errorf("invalid health endpoint status code %d", status)
+	}
+
+	// nginx expands variables in the response text, and has no way to escape them
+	if strings.Contains(health.ResponseBody, "$") {
+		return "", errors.New("health endpoint response body cannot contain '$'")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\tlocation = %s {\n", health.Path)
+	b.WriteString("\t\tauth_basic off;\n")
+	if forwardAuth {
+		b.WriteString("\t\tauth_request off;\n")
+	}
+	b.WriteString("\t\taccess_log off;\n")
+	b.WriteString("\t\tdefault_type text/plain;\n")
+	fmt.Fprintf(&b, "\t\treturn %d '%s';\n", status, escapeSingleQuoted(health.ResponseBody))
+	b.WriteString("\t}\n")
+
+	return b.String(), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// Indexing modes of a Website.
+const (
+	indexingAllow = "allow"
+	indexingDeny  = "deny"
+)
+
+// indexingDirectives keeps search engines off a Website whose indexing is denied, with an
+// X-Robots-Tag header on every response and a disallow-all robots.txt. Websites sharing a
+// hostname cannot serve robots.txt, which is outside their path prefix, and rely on the header.
+func indexingDirectives(website *v1alpha1.Website) (string, error) {
+	switch website.Spec.Indexing {
+	case "", indexingAllow:
+		return "", nil
+	case indexingDeny:
+	default:
+		return "", errors.Errorf("unknown indexing mode %q", website.Spec.Indexing)
+	}
+
+	directives := "\tproxy_hide_header X-Robots-Tag;\n" +
+		"\tadd_header X-Robots-Tag \"noindex, nofollow\" always;\n"
+	if website.Spec.PathPrefix == "" {
+		directives += "\tlocation = /robots.txt {\n" +
+			"\t\tadd_header X-Robots-Tag \"noindex, nofollow\" always;\n" +
+			"\t\tdefault_type text/plain;\n" +
+			"\t\treturn 200 \"User-agent: *\\nDisallow: /\\n\";\n" +
+			"\t}\n"
+	}
+	return directives, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// latencyProbeInterval is how often the upstreams of leastTime Websites are probed.
+	latencyProbeInterval = 30 * time.Second
+
+	// latencyProbeTimeout is how long a probe waits for the response headers.
+	latencyProbeTimeout = 2 * time.Second
+
+	// latencyMaxWeight is the weight of the fastest upstream.
+	latencyMaxWeight = 10
+)
+
+// nativeLeastTime reports whether nginx balances by response time itself.
+func (c *WebsiteController) nativeLeastTime() bool {
+	return c.capabilities != nil && c.capabilities.capabilities[v1alpha1.CapabilityLeastTime]
+}
+
+// upstreamWeights returns the latency weights of a Website's upstreams by host:port, if probed.
+func (c *WebsiteController) upstreamWeights(website *v1alpha1.Website) map[string]int32 {
+	if !c.featureEnabled(featureDynamicUpstreams) {
+		return nil
+	}
+
+	c.latencyMu.Lock()
+	defer c.latencyMu.Unlock()
+
+	return c.latencyWeights[websiteKey(website)]
+}
+
+// usesLatencyWeights reports whether a Website is balanced by controller-computed weights.
+func (c *WebsiteController) usesLatencyWeights(website *v1alpha1.Website) bool {
+	lb := website.Spec.LoadBalancing
+	return lb != nil && lb.Policy == v1alpha1.LoadBalancingLeastTime && !c.nativeLeastTime() &&
+		servingMode(website) == v1alpha1.ServingModeNginx && c.featureEnabled(featureDynamicUpstreams)
+}
+
+// runLatencyProbes probes the upstreams of leastTime Websites every interval until the context
+// is cancelled. Websites whose weights change are re-rendered and their status updated.
+func (c *WebsiteController) runLatencyProbes(ctx context.Context) {
+	ticker := time.NewTicker(latencyProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		list := &v1alpha1.WebsiteList{}
+		err := c.client.List(ctx, list)
+		if err != nil {
+			c.log.Error(err, "failed to list Websites")
+			continue
+		}
+		probed := map[string]bool{}
+		for i := range list.Items {
+			website, err := applyOverlay(&list.Items[i], c.environment)
+			if err != nil || !hasClass(website, c.class) || !c.usesLatencyWeights(website) {
+				continue
+			}
+			probed[websiteKey(website)] = true
+
+			err = c.probeWebsite(ctx, website)
+			if err != nil {
+				c.log.Error(err, "failed to probe upstreams", "namespace", website.Namespace, "name", website.Name)
+			}
+		}
+
+		// Forget the weights of Websites no longer balanced by latency
+		c.latencyMu.Lock()
+		for key := range c.latencyWeights {
+			if !probed[key] {
+				delete(c.latencyWeights, key)
+			}
+		}
+		c.latencyMu.Unlock()
+	}
+}
+
+// probeWebsite probes the upstreams of a Website concurrently and applies changed weights.
+func (c *WebsiteController) probeWebsite(ctx context.Context, website *v1alpha1.Website) error {
+	primary, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return err
+	}
+	aliases, err := hostAliases(website)
+	if err != nil {
+		return err
+	}
+	upstreams := append([]string{website.Spec.Upstream}, website.Spec.Upstreams...)
+	statuses := make([]v1alpha1.UpstreamStatus, len(upstreams))
+	var wg sync.WaitGroup
+	for i, upstream := range upstreams {
+		server, err := upstreamServer(upstream, primary.Scheme)
+		if err != nil {
+			return err
+		}
+		statuses[i].Server = server
+		wg.Add(1)
+		go func(status *v1alpha1.UpstreamStatus) {
+			defer wg.Done()
+			latency, err := probeUpstream(ctx, website, primary, aliasedServer(status.Server, aliases))
+			if err == nil {
+				status.Healthy = true
+				status.Latency = &metav1.Duration{Duration: latency}
+			}
+		}(&statuses[i])
+	}
+	wg.Wait()
+
+	// Apply the weights if they changed
+	weights := latencyWeights(statuses)
+	c.latencyMu.Lock()
+	changed := !reflect.DeepEqual(c.latencyWeights[websiteKey(website)], weights)
+	c.latencyWeights[websiteKey(website)] = weights
+	c.latencyMu.Unlock()
+	if !changed {
+		return nil
+	}
+	for i := range statuses {
+		statuses[i].Weight = weights[statuses[i].Server]
+	}
+	c.log.Info("upstream weights changed", "namespace", website.Namespace, "name", website.Name, "weights", weights)
+	c.queue.Add(watch.Event{Type: watch.Modified, Object: website})
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	return c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		status.Upstreams = statuses
+	})
+}
+
+// probeUpstream measures how long an upstream takes to send the response headers of a HEAD
+// request for the upstream URL, sent with the Host and SNI nginx would use.
+func probeUpstream(ctx context.Context, website *v1alpha1.Website, primary *url.URL, server string) (time.Duration, error) {
+	sni := primary.Hostname()
+	verify := false
+	if upstreamTLS := website.Spec.UpstreamTLS; upstreamTLS != nil {
+		if upstreamTLS.SNI != "" {
+			sni = upstreamTLS.SNI
+		}
+		verify = upstreamTLS.VerifyHostname
+	}
+	client := &http.Client{
+		Timeout: latencyProbeTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{ServerName: sni, InsecureSkipVerify: !verify},
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	target := *primary
+	target.Host = server
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Host = primary.Host
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return 0, errors.Errorf("upstream returned %s", resp.Status)
+	}
+	return time.Since(start), nil
+}
+
+// latencyWeights weights healthy upstreams inversely to their latency, from latencyMaxWeight
+// for the fastest down to 1. Unhealthy upstreams get weight 0 unless no upstream is healthy.
+func latencyWeights(statuses []v1alpha1.UpstreamStatus) map[string]int32 {
+	var fastest time.Duration
+	for _, status := range statuses {
+		if status.Healthy && (fastest == 0 || status.Latency.Duration < fastest) {
+			fastest = status.Latency.Duration
+		}
+	}
+
+	weights := map[string]int32{}
+	for _, status := range statuses {
+		switch {
+		case fastest == 0:
+			weights[status.Server] = 1
+		case !status.Healthy:
+			weights[status.Server] = 0
+		default:
+			weight := math.Round(latencyMaxWeight * float64(fastest) / float64(status.Latency.Duration))
+			weights[status.Server] = int32(math.Max(1, weight))
+		}
+	}
+	return weights
+}
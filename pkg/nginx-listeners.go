@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// listenDirectives renders the listen directives of a Website's server block, each with the
+// given options. Without listeners, a Website listens on port 80, and on 443 if it has TLS
+// material.
+func listenDirectives(website *v1alpha1.Website, options string) (string, error) {
+	var b strings.Builder
+	if len(website.Spec.Listeners) == 0 {
+		fmt.Fprintf(&b, "\tlisten 80%s;\n", options)
+		if hasTLSMaterial(website) {
+			b.WriteString("\tlisten 443 ssl;\n")
+		}
+		return b.String(), nil
+	}
+
+	ports := map[int32]bool{}
+	for _, listener := range website.Spec.Listeners {
+		if listener.Port < 1 || listener.Port > 65535 {
+			return "", errors.Errorf("invalid listener port %d", listener.Port)
+		}
+		if ports[listener.Port] {
+			return "", errors.Errorf("port %d is listed twice", listener.Port)
+		}
+		ports[listener.Port] = true
+
+		fmt.Fprintf(&b, "\tlisten %d", listener.Port)
+		switch listener.Protocol {
+		case "", v1alpha1.ListenerHTTP:
+		case v1alpha1.ListenerHTTPS:
+			if !hasTLSMaterial(website) {
+				return "", errors.Errorf("listener on port %d is HTTPS but spec.tls is not set", listener.Port)
+			}
+			b.WriteString(" ssl")
+		default:
+			return "", errors.Errorf("invalid protocol %q of listener on port %d", listener.Protocol, listener.Port)
+		}
+		if listener.ProxyProtocol {
+			b.WriteString(" proxy_protocol")
+		}
+		fmt.Fprintf(&b, "%s;\n", options)
+	}
+	return b.String(), nil
+}
+
+// tlsListenDirectives renders the listen directives of a Website's HTTPS ports only: port 443
+// without listeners.
+func tlsListenDirectives(website *v1alpha1.Website) (string, error) {
+	if len(website.Spec.Listeners) == 0 {
+		return "\tlisten 443 ssl;\n", nil
+	}
+	https := *website
+	https.Spec.Listeners = nil
+	for _, listener := range website.Spec.Listeners {
+		if listener.Protocol == v1alpha1.ListenerHTTPS {
+			https.Spec.Listeners = append(https.Spec.Listeners, listener)
+		}
+	}
+	if len(https.Spec.Listeners) == 0 {
+		return "", errors.New("tls.certificates requires an HTTPS listener")
+	}
+	return listenDirectives(&https, "")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// loadBalancingDirectives compiles the upstreams of a Website into an upstream block for the
+// http context, and returns the URL requests are proxied to and the server-level directives
+// that keep the original upstream's Host and SNI. Websites with a single upstream and no
+// load balancing policy are proxied to their upstream directly. The leastTime policy uses
+// nginx's least_time if native is set, and weights the servers by weights otherwise. Upstreams
+// resolved by host aliases are listed by IP address.
+func loadBalancingDirectives(website *v1alpha1.Website, native bool, weights map[string]int32) (string, string, string, error) {
+	// Validate the upstreams, as they are rendered into the configuration verbatim
+	for _, upstream := range append([]string{website.Spec.Upstream}, website.Spec.Upstreams...) {
+		u, err := url.Parse(upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" ||
+			strings.ContainsAny(upstream, " \t\n;{}'\"\\$") {
+			return "", "", "", errors.Errorf("invalid upstream %q: must be an http(s) URL", upstream)
+		}
+	}
+
+	lb := website.Spec.LoadBalancing
+	if len(website.Spec.Upstreams) == 0 && lb == nil && len(website.Spec.HostAliases) == 0 {
+		return "", website.Spec.Upstream, "", nil
+	}
+	aliases, err := hostAliases(website)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	primary, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return "", "", "", errors.Wrap(err, "invalid upstream")
+	}
+
+	var b strings.Builder
+	name := "website_" + websiteIdentifier(website)
+	fmt.Fprintf(&b, "upstream %s {\n", name)
+
+	// Select the balancing method
+	policy := v1alpha1.LoadBalancingRoundRobin
+	if lb != nil && lb.Policy != "" {
+		policy = lb.Policy
+	}
+	switch policy {
+	case v1alpha1.LoadBalancingRoundRobin:
+	case v1alpha1.LoadBalancingLeastConn:
+		b.WriteString("\tleast_conn;\n")
+	case v1alpha1.LoadBalancingIPHash:
+		b.WriteString("\tip_hash;\n")
+	case v1alpha1.LoadBalancingConsistentHash:
+		if lb.ConsistentHash == nil || lb.ConsistentHash.Key == "" {
+			return "", "", "", errors.New("consistentHash requires a key")
+		}
+		key := lb.ConsistentHash.Key
+		if strings.ContainsAny(key, " \t\n;{}'\"\\") {
+			return "", "", "", errors.Errorf("invalid consistent hash key %q", key)
+		}
+		fmt.Fprintf(&b, "\thash %s consistent;\n", key)
+	case v1alpha1.LoadBalancingLeastTime:
+		if native {
+			b.WriteString("\tleast_time header;\n")
+		}
+	default:
+		return "", "", "", errors.Errorf("unknown load balancing policy %q", policy)
+	}
+	if policy != v1alpha1.LoadBalancingConsistentHash && lb != nil && lb.ConsistentHash != nil {
+		return "", "", "", errors.Errorf("consistentHash cannot be set for the %s policy", policy)
+	}
+
+	// List the servers, the additional ones sorted so their order in the spec does not matter
+	upstreams := append([]string(nil), website.Spec.Upstreams...)
+	sort.Strings(upstreams)
+	for _, upstream := range append([]string{website.Spec.Upstream}, upstreams...) {
+		server, err := upstreamServer(upstream, primary.Scheme)
+		if err != nil {
+			return "", "", "", err
+		}
+		weight, weighted := weights[server]
+		address := aliasedServer(server, aliases)
+		switch {
+		case policy != v1alpha1.LoadBalancingLeastTime || native || !weighted:
+			fmt.Fprintf(&b, "\tserver %s;\n", address)
+		case weight == 0:
+			fmt.Fprintf(&b, "\tserver %s down;\n", address)
+		default:
+			fmt.Fprintf(&b, "\tserver %s weight=%d;\n", address, weight)
+		}
+	}
+	b.WriteString("}\n")
+
+	// The upstream name replaces the host in proxy_pass, so the Host header and the SNI
+	// of the primary upstream are set explicitly
+	server := fmt.Sprintf("\tproxy_set_header Host %s;\n", primary.Host)
+	if primary.Scheme == "https" && (website.Spec.UpstreamTLS == nil || website.Spec.UpstreamTLS.SNI == "") {
+		server += fmt.Sprintf("\tproxy_ssl_name %s;\n", primary.Hostname())
+	}
+
+	proxyPass := primary.Scheme + "://" + name + primary.EscapedPath()
+	return b.String(), proxyPass, server, nil
+}
+
+// upstreamServer returns the host:port of an upstream URL for an upstream block.
+func upstreamServer(upstream, scheme string) (string, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid upstream %q", upstream)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.Errorf("invalid upstream %q: scheme must be http or https", upstream)
+	}
+	if u.Scheme != scheme {
+		return "", errors.Errorf("invalid upstream %q: all upstreams must use %s", upstream, scheme)
+	}
+	if u.Hostname() == "" || strings.ContainsAny(u.Host, " \t;{}'\"") {
+		return "", errors.Errorf("invalid upstream %q: missing or invalid host", upstream)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// hostAliases returns the IP addresses of a Website's host aliases by hostname. Every alias
+// must resolve the host of one of the upstreams.
+func hostAliases(website *v1alpha1.Website) (map[string]string, error) {
+	hosts := map[string]bool{}
+	for _, upstream := range append([]string{website.Spec.Upstream}, website.Spec.Upstreams...) {
+		u, err := url.Parse(upstream)
+		if err == nil {
+			hosts[strings.ToLower(u.Hostname())] = true
+		}
+	}
+
+	aliases := map[string]string{}
+	for _, alias := range website.Spec.HostAliases {
+		hostname := strings.ToLower(alias.Hostname)
+		ip := net.ParseIP(alias.IP)
+		switch {
+		case ip == nil:
+			return nil, errors.Errorf("invalid host alias %q: invalid IP %q", alias.Hostname, alias.IP)
+		case !hosts[hostname]:
+			return nil, errors.Errorf("invalid host alias %q: no upstream has that host", alias.Hostname)
+		case aliases[hostname] != "":
+			return nil, errors.Errorf("invalid host alias %q: duplicate hostname", alias.Hostname)
+		}
+		aliases[hostname] = ip.String()
+	}
+	return aliases, nil
+}
+
+// aliasedServer returns the host:port of an upstream server with its host resolved by the
+// host aliases, if one matches.
+func aliasedServer(server string, aliases map[string]string) string {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		return server
+	}
+	ip, ok := aliases[strings.ToLower(host)]
+	if !ok {
+		return server
+	}
+	return net.JoinHostPort(ip, port)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/controller/util"
+)
+
+// syncMiddleware reads the WebsiteMiddlewares referenced by a Website, so they can be rendered.
+func (c *WebsiteController) syncMiddleware(ctx context.Context, website *v1alpha1.Website) error {
+	var middleware []v1alpha1.WebsiteMiddlewareSpec
+	for _, ref := range website.Spec.Middleware {
+		m := &v1alpha1.WebsiteMiddleware{}
+		err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: ref.Name}, m)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get WebsiteMiddleware %s", ref.Name)
+		}
+		middleware = append(middleware, m.Spec)
+	}
+
+	c.middlewareMu.Lock()
+	defer c.middlewareMu.Unlock()
+	if len(middleware) == 0 {
+		delete(c.middleware, websiteKey(website))
+	} else {
+		c.middleware[websiteKey(website)] = middleware
+	}
+	return nil
+}
+
+// releaseMiddleware forgets the WebsiteMiddlewares of a deleted Website.
+func (c *WebsiteController) releaseMiddleware(website *v1alpha1.Website) {
+	c.middlewareMu.Lock()
+	defer c.middlewareMu.Unlock()
+
+	delete(c.middleware, websiteKey(website))
+}
+
+// watchMiddleware reconciles the Websites referencing a WebsiteMiddleware whenever it changes.
+func (c *WebsiteController) watchMiddleware(ctx context.Context) error {
+	w := util.NewWatch(ctx, &v1alpha1.WebsiteMiddleware{})
+
+	err := w.Watch(func(event watch.Event) error {
+		m, ok := event.Object.(*v1alpha1.WebsiteMiddleware)
+		if !ok {
+			return errors.Errorf("object is not a WebsiteMiddleware: %T", event.Object)
+		}
+
+		list := &v1alpha1.WebsiteList{}
+		err := c.client.List(ctx, list, client.InNamespace(m.Namespace))
+		if err != nil {
+			c.log.Error(err, "failed to list Websites", "namespace", m.Namespace)
+			return nil
+		}
+		for i := range list.Items {
+			for _, ref := range list.Items[i].Spec.Middleware {
+				if ref.Name == m.Name {
+					c.queue.Add(watch.Event{Type: watch.Modified, Object: &list.Items[i]})
+					break
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch for WebsiteMiddleware objects")
+	}
+
+	return nil
+}
+
+// middlewareDirectives compiles the WebsiteMiddlewares of a Website, in order, into
+// http-level and server-level nginx directives. Rate limits apply per client address, the
+// variable holding it. It reports whether requests are authorized by a forward auth subrequest.
+func (c *WebsiteController) middlewareDirectives(website *v1alpha1.Website, address string) (string, string, bool, error) {
+	if len(website.Spec.Middleware) == 0 {
+		return "", "", false, nil
+	}
+
+	c.middlewareMu.Lock()
+	middleware, ok := c.middleware[websiteKey(website)]
+	c.middlewareMu.Unlock()
+	if !ok || len(middleware) != len(website.Spec.Middleware) {
+		return "", "", false, errors.New("middleware is not resolved")
+	}
+
+	var http, server strings.Builder
+	forwardAuth := false
+	rateLimited := false
+	zones := map[string]bool{}
+	for i, m := range middleware {
+		name := website.Spec.Middleware[i].Name
+
+		// Set the headers, sorted by name so their order in the spec does not matter
+		if m.Headers != nil {
+			for _, header := range sortedHeaders(m.Headers.Request) {
+				if err := validateMiddlewareHeader(header); err != nil {
+					return "", "", false, errors.Wrapf(err, "middleware %s", name)
+				}
+				fmt.Fprintf(&server, "\tproxy_set_header %s \"%s\";\n", header.Name, header.Value)
+			}
+			for _, header := range sortedHeaders(m.Headers.Response) {
+				if err := validateMiddlewareHeader(header); err != nil {
+					return "", "", false, errors.Wrapf(err, "middleware %s", name)
+				}
+				fmt.Fprintf(&server, "\tproxy_hide_header %s;\n", header.Name)
+				fmt.Fprintf(&server, "\tadd_header %s \"%s\" always;\n", header.Name, header.Value)
+			}
+		}
+
+		// Authorize the requests; nginx accepts a single auth_request per context
+		if m.ForwardAuth != nil {
+			if forwardAuth {
+				return "", "", false, errors.Errorf("middleware %s: only one middleware may use forwardAuth", name)
+			}
+			forwardAuth = true
+			u, err := url.Parse(m.ForwardAuth.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(m.ForwardAuth.URL, " \t\n;{}'\"") {
+				return "", "", false, errors.Errorf("middleware %s: invalid forwardAuth url %q", name, m.ForwardAuth.URL)
+			}
+			// Websites sharing a hostname nest the location in their path prefix
+			authPath := "/_website_forward_auth"
+			if website.Spec.PathPrefix != "" {
+				authPath = website.Spec.PathPrefix + "_website_forward_auth"
+			}
+			fmt.Fprintf(&server, "\tauth_request %s;\n", authPath)
+			fmt.Fprintf(&server, "\tlocation = %s {\n", authPath)
+			server.WriteString("\t\tinternal;\n")
+			server.WriteString("\t\tauth_request off;\n")
+			fmt.Fprintf(&server, "\t\tproxy_pass %s;\n", m.ForwardAuth.URL)
+			server.WriteString("\t\tproxy_pass_request_body off;\n")
+			server.WriteString("\t\tproxy_set_header Content-Length \"\";\n")
+			server.WriteString("\t\tproxy_set_header X-Original-URI $request_uri;\n")
+			server.WriteString("\t}\n")
+		}
+
+		// Limit the request rate per client address
+		if m.RateLimit != nil {
+			if m.RateLimit.RequestsPerSecond < 1 || m.RateLimit.Burst < 0 {
+				return "", "", false, errors.Errorf("middleware %s: invalid rate limit", name)
+			}
+			zone := rateLimitZone(website, name)
+			if !zones[zone] {
+				fmt.Fprintf(&http, "limit_req_zone %s zone=%s:10m rate=%dr/s;\n", address, zone, m.RateLimit.RequestsPerSecond)
+				zones[zone] = true
+			}
+			fmt.Fprintf(&server, "\tlimit_req zone=%s burst=%d nodelay;\n", zone, m.RateLimit.Burst)
+			if !rateLimited {
+				server.WriteString("\tlimit_req_status 429;\n")
+				rateLimited = true
+			}
+		}
+
+		// Rewrite the URI
+		for _, rewrite := range m.Rewrites {
+			if _, err := regexp.Compile(rewrite.Regex); rewrite.Regex == "" || err != nil {
+				return "", "", false, errors.Errorf("middleware %s: invalid rewrite regex %q", name, rewrite.Regex)
+			}
+			regex, err := quoteNginxString(rewrite.Regex)
+			if err != nil {
+				return "", "", false, errors.Wrapf(err, "middleware %s: invalid rewrite regex", name)
+			}
+			replacement, err := quoteNginxString(rewrite.Replacement)
+			if err != nil {
+				return "", "", false, errors.Wrapf(err, "middleware %s: invalid rewrite replacement", name)
+			}
+			fmt.Fprintf(&server, "\trewrite %s %s break;\n", regex, replacement)
+		}
+	}
+
+	return http.String(), server.String(), forwardAuth, nil
+}
+
+// validateMiddlewareHeader validates a header set by a middleware. Values are written
+// in double quotes, so they cannot contain quotes, backslashes or newlines.
+func validateMiddlewareHeader(header v1alpha1.HeaderValue) error {
+	if !isHeaderToken(header.Name) {
+		return errors.Errorf("invalid header name %q", header.Name)
+	}
+	if strings.ContainsAny(header.Value, "\"\\\n\r") {
+		return errors.Errorf("invalid value of header %s", header.Name)
+	}
+	return nil
+}
+
+// quoteNginxString returns a value as a double-quoted nginx string. Backslashes are passed
+// through, so regular expressions keep their escapes, which is why values with quotes, a
+// trailing backslash escaping the closing quote, or control characters are refused.
+func quoteNginxString(value string) (string, error) {
+	if strings.Contains(value, "\"") {
+		return "", errors.Errorf("%q contains a quote", value)
+	}
+	if strings.HasSuffix(value, "\\") {
+		return "", errors.Errorf("%q ends with a backslash", value)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) {
+			return "", errors.Errorf("%q contains a control character", value)
+		}
+	}
+	return "\"" + value + "\"", nil
+}
+
+// sortedHeaders returns headers sorted by name. Headers of the same name keep their order.
+func sortedHeaders(headers []v1alpha1.HeaderValue) []v1alpha1.HeaderValue {
+	sorted := append([]v1alpha1.HeaderValue(nil), headers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// rateLimitZone names the limit_req zone of a Website's middleware. The name is derived from
+// the middleware name rather than its position, so reordering middleware keeps the zones.
+func rateLimitZone(website *v1alpha1.Website, middleware string) string {
+	sum := sha1.Sum([]byte(middleware))
+	return fmt.Sprintf("website_%s_mw_%s", websiteIdentifier(website), hex.EncodeToString(sum[:4]))
+}
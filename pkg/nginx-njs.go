@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// njsModulePath is the dynamic njs module, which nginx.conf must load for transforms to work.
+	njsModulePath = "/usr/lib/nginx/modules/ngx_http_js_module.so"
+
+	// njsDir holds the transform scripts, in a directory per Website.
+	njsDir = "/etc/nginx/website-controller/njs"
+
+	// njsMaxScriptSize bounds the size of a transform script.
+	njsMaxScriptSize = 64 * 1024
+)
+
+// syncTransform writes the transform scripts of a Website from their ConfigMaps. njs cannot
+// be sandboxed: a script can read the filesystem of the edge, which holds the secret material
+// of all Websites, and make outgoing requests. Transforms are therefore refused unless the
+// controller runs with the TenantTransforms feature gate.
+func (c *WebsiteController) syncTransform(ctx context.Context, website *v1alpha1.Website) error {
+	transform := website.Spec.Transform
+	if transform == nil || (transform.RequestScriptRef == nil && transform.ResponseScriptRef == nil) {
+		return c.removeTransform(website)
+	}
+	if !c.featureEnabled(featureTenantTransforms) {
+		return errors.Errorf("spec.transform requires the %s feature gate", featureTenantTransforms)
+	}
+	if _, err := os.Stat(njsModulePath); err != nil {
+		return errors.Errorf("transforms require the nginx njs module at %s", njsModulePath)
+	}
+
+	dir := filepath.Join(njsDir, websiteIdentifier(website))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create transform directory")
+	}
+	scripts := map[string]*corev1.ConfigMapKeySelector{
+		"request.js":  transform.RequestScriptRef,
+		"response.js": transform.ResponseScriptRef,
+	}
+	for file, ref := range scripts {
+		path := filepath.Join(dir, file)
+		if ref == nil {
+			os.Remove(path)
+			continue
+		}
+
+		// Read the script
+		script, err := c.readTransformScript(ctx, website.Namespace, ref)
+		if err != nil {
+			return err
+		}
+
+		// Write the script if it changed
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && string(existing) == script {
+			continue
+		}
+		err = writeFileAtomic(path, []byte(script), 0644)
+		c.audit(auditConfigWrite, website, path, []byte(script), err)
+		if err != nil {
+			return errors.Wrap(err, "failed to write transform script")
+		}
+	}
+
+	return nil
+}
+
+// configMapNames returns the names of the ConfigMaps a Website reads transform scripts and
+// its redirect map from.
+func configMapNames(website *v1alpha1.Website) []string {
+	var names []string
+	if transform := website.Spec.Transform; transform != nil {
+		for _, ref := range []*corev1.ConfigMapKeySelector{transform.RequestScriptRef, transform.ResponseScriptRef} {
+			if ref != nil {
+				names = append(names, ref.Name)
+			}
+		}
+	}
+	if ref := website.Spec.RedirectMapRef; ref != nil {
+		names = append(names, ref.Name)
+	}
+	if ref := website.Spec.TemplateRef; ref != nil {
+		names = append(names, ref.Name)
+	}
+	return names
+}
+
+// readTransformScript reads a transform script from a ConfigMap.
+func (c *WebsiteController) readTransformScript(ctx context.Context, namespace string, ref *corev1.ConfigMapKeySelector) (string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, configMap)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get ConfigMap %s", ref.Name)
+	}
+	script, ok := configMap.Data[ref.Key]
+	if !ok {
+		return "", errors.Errorf("ConfigMap %s has no key %s", ref.Name, ref.Key)
+	}
+
+	if len(script) > njsMaxScriptSize {
+		return "", errors.Errorf("script %s/%s exceeds %d bytes", ref.Name, ref.Key, njsMaxScriptSize)
+	}
+	return script, nil
+}
+
+// removeTransform deletes the transform scripts of a Website.
+func (c *WebsiteController) removeTransform(website *v1alpha1.Website) error {
+	dir := filepath.Join(njsDir, websiteIdentifier(website))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	err := os.RemoveAll(dir)
+	c.audit(auditConfigDelete, website, dir, nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete transform scripts")
+	}
+	return nil
+}
+
+// transformDirectives renders the http-level imports of a Website's transform scripts and the
+// locations proxying to its upstream. The request script's request function handles requests
+// with js_content and passes them on with r.internalRedirect('@upstream'); the response
+// script's response function filters the upstream's response headers.
+func transformDirectives(website *v1alpha1.Website, proxyPass string) (string, string, error) {
+	transform := website.Spec.Transform
+	if transform == nil || (transform.RequestScriptRef == nil && transform.ResponseScriptRef == nil) {
+		return "", fmt.Sprintf("\tlocation / {\n\t\t%s;\n\t}\n", upstreamPass(website, proxyPass)), nil
+	}
+
+	var http, proxy strings.Builder
+	dir := filepath.Join(njsDir, websiteIdentifier(website))
+	module := "website_" + websiteIdentifier(website)
+	fmt.Fprintf(&proxy, "\t\t%s;\n", upstreamPass(website, proxyPass))
+	if transform.ResponseScriptRef != nil {
+		fmt.Fprintf(&http, "js_import %s_response from %s;\n", module, filepath.Join(dir, "response.js"))
+		fmt.Fprintf(&proxy, "\t\tjs_header_filter %s_response.response;\n", module)
+	}
+	if transform.RequestScriptRef == nil {
+		return http.String(), "\tlocation / {\n" + proxy.String() + "\t}\n", nil
+	}
+
+	// Named locations cannot proxy to a URI, so the upstream path is not supported
+	if strings.Count(strings.TrimPrefix(strings.TrimPrefix(proxyPass, "http://"), "https://"), "/") > 0 {
+		return "", "", errors.New("a request script requires an upstream without a path")
+	}
+	fmt.Fprintf(&http, "js_import %s_request from %s;\n", module, filepath.Join(dir, "request.js"))
+	locations := fmt.Sprintf("\tlocation / {\n\t\tjs_content %s_request.request;\n\t}\n", module) +
+		"\tlocation @upstream {\n" + proxy.String() + "\t}\n"
+	return http.String(), locations, nil
+}
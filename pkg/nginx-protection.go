@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// protectionProfile is a vetted combination of limits against floods and slow clients.
+type protectionProfile struct {
+	// connections is the number of concurrent connections per client address.
+	connections int
+	// rate and burst limit the requests per second of a client address.
+	rate  int
+	burst int
+	// timeout bounds reading the request headers and body and sending the response, in
+	// seconds, against slowloris-style attacks.
+	timeout int
+	// headerBuffers is the large_client_header_buffers setting; requests with larger request
+	// lines or headers are rejected.
+	headerBuffers string
+}
+
+// protectionProfiles are the profiles of spec.protection.
+var protectionProfiles = map[string]protectionProfile{
+	v1alpha1.ProtectionBasic:  {connections: 50, rate: 20, burst: 40, timeout: 10, headerBuffers: "4 8k"},
+	v1alpha1.ProtectionStrict: {connections: 20, rate: 5, burst: 10, timeout: 5, headerBuffers: "2 4k"},
+}
+
+// protectionDirectives expands a Website's protection profile into the http-level zones and
+// the server-level limits, keyed by the client address.
+func protectionDirectives(website *v1alpha1.Website, address string) (string, string, error) {
+	if website.Spec.Protection == "" {
+		return "", "", nil
+	}
+	profile, ok := protectionProfiles[website.Spec.Protection]
+	if !ok {
+		return "", "", errors.Errorf("unknown protection profile %q", website.Spec.Protection)
+	}
+
+	zone := "website_" + websiteIdentifier(website) + "_protection"
+	var http, server strings.Builder
+	fmt.Fprintf(&http, "limit_conn_zone %s zone=%s_conn:10m;\n", address, zone)
+	fmt.Fprintf(&http, "limit_req_zone %s zone=%s_req:10m rate=%dr/s;\n", address, zone, profile.rate)
+
+	fmt.Fprintf(&server, "\tlimit_conn %s_conn %d;\n", zone, profile.connections)
+	fmt.Fprintf(&server, "\tlimit_req zone=%s_req burst=%d nodelay;\n", zone, profile.burst)
+	fmt.Fprintf(&server, "\tclient_header_timeout %ds;\n", profile.timeout)
+	fmt.Fprintf(&server, "\tclient_body_timeout %ds;\n", profile.timeout)
+	fmt.Fprintf(&server, "\tsend_timeout %ds;\n", profile.timeout)
+	fmt.Fprintf(&server, "\tlarge_client_header_buffers %s;\n", profile.headerBuffers)
+	server.WriteString("\treset_timedout_connection on;\n")
+	return http.String(), server.String(), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// realIPMaxHops bounds the number of trusted proxies, which is also bounded by the CRD.
+const realIPMaxHops = 10
+
+// clientAddress returns the variable holding the client address of a Website's requests and
+// the http-level map deriving it from X-Forwarded-For. Without spec.realIP the peer address
+// is the client address, as trusting the whole header would let clients forge it.
+func clientAddress(website *v1alpha1.Website) (string, string, error) {
+	realIP := website.Spec.RealIP
+	if realIP == nil {
+		return "$binary_remote_addr", "", nil
+	}
+	if realIP.TrustedHops < 1 || realIP.TrustedHops > realIPMaxHops {
+		return "", "", errors.Errorf("trustedHops must be between 1 and %d", realIPMaxHops)
+	}
+
+	// Each trusted proxy appends the address it received the request from, so the client
+	// address is the entry trustedHops positions from the end
+	variable := fmt.Sprintf("$website_%s_client_addr", websiteIdentifier(website))
+	pattern := `(?:^|,)\s*([^,\s]+)\s*`
+	if realIP.TrustedHops > 1 {
+		pattern += fmt.Sprintf(`(?:,[^,]*){%d}`, realIP.TrustedHops-1)
+	}
+	http := fmt.Sprintf("map $http_x_forwarded_for %s {\n\tdefault $remote_addr;\n\t\"~%s$\" $1;\n}\n", variable, pattern)
+	return variable, http, nil
+}
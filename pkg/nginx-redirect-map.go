@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// redirectMapDir holds the compiled redirect maps, in a file per Website.
+const redirectMapDir = "/etc/nginx/website-controller/redirects"
+
+// redirectMapPath is the path of the compiled redirect map of a Website.
+func redirectMapPath(website *v1alpha1.Website) string {
+	return filepath.Join(redirectMapDir, websiteIdentifier(website)+".map")
+}
+
+// syncRedirectMap compiles the redirect map of a Website from its ConfigMap into the entries
+// of an nginx map, which the Website's configuration includes.
+func (c *WebsiteController) syncRedirectMap(ctx context.Context, website *v1alpha1.Website) error {
+	ref := website.Spec.RedirectMapRef
+	if ref == nil {
+		return c.removeRedirectMap(website)
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: ref.Name}, configMap)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get ConfigMap %s", ref.Name)
+	}
+	data, ok := configMap.Data[ref.Key]
+	if !ok {
+		return errors.Errorf("ConfigMap %s has no key %s", ref.Name, ref.Key)
+	}
+	entries, err := compileRedirectMap(data)
+	if err != nil {
+		return errors.Wrapf(err, "invalid redirect map %s/%s", ref.Name, ref.Key)
+	}
+
+	// Write the map if it changed
+	err = os.MkdirAll(redirectMapDir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create redirect map directory")
+	}
+	path := redirectMapPath(website)
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && string(existing) == entries {
+		return nil
+	}
+	err = writeFileAtomic(path, []byte(entries), 0644)
+	c.audit(auditConfigWrite, website, path, []byte(entries), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write redirect map")
+	}
+	return nil
+}
+
+// removeRedirectMap deletes the compiled redirect map of a Website.
+func (c *WebsiteController) removeRedirectMap(website *v1alpha1.Website) error {
+	path := redirectMapPath(website)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	err := os.Remove(path)
+	c.audit(auditConfigDelete, website, path, nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete redirect map")
+	}
+	return nil
+}
+
+// compileRedirectMap turns the "old new" lines of a redirect map into nginx map entries. The
+// paths and targets are rendered into the configuration verbatim, so they are validated.
+func compileRedirectMap(data string) (string, error) {
+	var b strings.Builder
+	seen := map[string]int{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return "", errors.Errorf("line %d: expected an old path and a new location", n)
+		}
+		from, to := fields[0], fields[1]
+		if !strings.HasPrefix(from, "/") || strings.ContainsAny(from, ";{}'\"\\$?#") {
+			return "", errors.Errorf("line %d: invalid path %q", n, from)
+		}
+		if strings.ContainsAny(to, ";{}'\"\\$") {
+			return "", errors.Errorf("line %d: invalid location %q", n, to)
+		}
+		if !strings.HasPrefix(to, "/") {
+			target, err := url.Parse(to)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				return "", errors.Errorf("line %d: invalid location %q: must be a path or an absolute http(s) URL", n, to)
+			}
+		}
+		if first, ok := seen[from]; ok {
+			return "", errors.Errorf("line %d: path %s is already redirected on line %d", n, from, first)
+		}
+		seen[from] = n
+
+		fmt.Fprintf(&b, "\"%s\" \"%s\";\n", from, to)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// redirectMapDirectives renders the http-level map of a Website's redirect map and the server
+// directive answering the mapped paths with a permanent redirect.
+func redirectMapDirectives(website *v1alpha1.Website) (string, string) {
+	if website.Spec.RedirectMapRef == nil {
+		return "", ""
+	}
+	variable := fmt.Sprintf("$website_%s_redirect", websiteIdentifier(website))
+	http := fmt.Sprintf("map $uri %s {\n\tinclude %s;\n}\n", variable, redirectMapPath(website))
+	server := fmt.Sprintf("\tif (%s) {\n\t\treturn 301 %s;\n\t}\n", variable, variable)
+	return http, server
+}
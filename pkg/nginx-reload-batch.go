@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// reloadBatcher batches the nginx reloads requested within a debounce window into one. The
+// first request opens the window; the reload is signalled when it closes, on behalf of every
+// Website that requested it, and the outcome for each Website is handed to its requesters.
+type reloadBatcher struct {
+	window time.Duration
+	flush  func([]*v1alpha1.Website) reloadOutcome
+
+	mu       sync.Mutex
+	pending  bool
+	websites map[string]*v1alpha1.Website
+	done     []batchedDone
+}
+
+// batchedDone is a callback waiting for the outcome of a batched reload for the Website with the
+// given key, or for the controller if key is empty.
+type batchedDone struct {
+	key  string
+	done func(error)
+}
+
+// newReloadBatcher creates a reloadBatcher calling flush once per window with reloads.
+func newReloadBatcher(window time.Duration, flush func([]*v1alpha1.Website) reloadOutcome) *reloadBatcher {
+	return &reloadBatcher{window: window, flush: flush, websites: map[string]*v1alpha1.Website{}}
+}
+
+// request adds a reload on behalf of a Website, or of the controller if website is nil, to the
+// current window, opening a window if none is open. done, if not nil, is called with the
+// outcome of the reload once the window closed.
+func (b *reloadBatcher) request(website *v1alpha1.Website, done func(error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var key string
+	if website != nil {
+		key = websiteKey(website)
+		b.websites[key] = website.DeepCopy()
+	}
+	if done != nil {
+		b.done = append(b.done, batchedDone{key: key, done: done})
+	}
+	if b.pending {
+		return
+	}
+	b.pending = true
+	time.AfterFunc(b.window, b.close)
+}
+
+// close ends the current window, flushes its reloads and hands their outcome to the requesters.
+func (b *reloadBatcher) close() {
+	b.mu.Lock()
+	websites := make([]*v1alpha1.Website, 0, len(b.websites))
+	for _, website := range b.websites {
+		websites = append(websites, website)
+	}
+	done := b.done
+	b.websites = map[string]*v1alpha1.Website{}
+	b.done = nil
+	b.pending = false
+	b.mu.Unlock()
+
+	outcome := b.flush(websites)
+	for _, d := range done {
+		d.done(outcome.of(d.key))
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultNginxPidFile is where the nginx master process records its pid, unless nginx runs
+	// unprivileged and records it elsewhere.
+	defaultNginxPidFile = "/var/run/nginx.pid"
+
+	// nginxStubStatusURL is the stub_status endpoint of the local nginx.
+	nginxStubStatusURL = "http://127.0.0.1/nginx_status"
+
+	// reloadObservationTimeout bounds how long old workers are waited for after a reload.
+	reloadObservationTimeout = 5 * time.Minute
+
+	// workerPollInterval is how often old workers are checked for while they drain.
+	workerPollInterval = 100 * time.Millisecond
+
+	// reloadConfirmTimeout bounds how long nginx is given to start the workers of a reloaded
+	// configuration before the reload is considered rejected.
+	reloadConfirmTimeout = 10 * time.Second
+)
+
+// nginxSample is a snapshot of the nginx worker processes and connection counters.
+type nginxSample struct {
+	workers []int
+
+	// accepts and handled are only valid if hasCounters is set.
+	accepts     int64
+	handled     int64
+	hasCounters bool
+}
+
+// observeReload measures the impact of a reload and records it in the metrics and the status of
+// the Websites it was triggered by. Reloads not triggered by exactly one Website, such as batched
+// reloads, are recorded in the metrics with empty labels.
+func (c *WebsiteController) observeReload(websites []*v1alpha1.Website, before nginxSample, start time.Time, duration time.Duration) {
+	labels := &v1alpha1.Website{}
+	if len(websites) == 1 {
+		labels = websites[0]
+	}
+
+	// Wait for the old workers to exit
+	linger, timedOut := waitForWorkers(before.workers, reloadObservationTimeout)
+
+	// Count the connections dropped while the old workers were draining
+	after := sampleNginx(c.nginxPidFile)
+	var dropped int64
+	if before.hasCounters && after.hasCounters {
+		dropped = (after.accepts - after.handled) - (before.accepts - before.handled)
+	}
+
+	reload := v1alpha1.ReloadStatus{
+		Time:               metav1.NewTime(start),
+		Duration:           metav1.Duration{Duration: duration},
+		WorkerLinger:       metav1.Duration{Duration: linger},
+		WorkersTimedOut:    timedOut,
+		DroppedConnections: dropped,
+	}
+	recordReloadMetrics(labels, reload)
+	c.log.Info("nginx reload observed", "namespace", labels.Namespace, "name", labels.Name, "websites", len(websites),
+		"duration", duration, "workerLinger", linger, "workersTimedOut", timedOut, "droppedConnections", dropped)
+
+	// Record the reload in the Website status
+	for _, website := range websites {
+		key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+		err := c.updateStatus(context.Background(), key, func(status *v1alpha1.WebsiteStatus) {
+			status.LastReload = &reload
+		})
+		if err != nil {
+			c.log.Error(err, "failed to record reload", "namespace", website.Namespace, "name", website.Name)
+		}
+	}
+}
+
+// sampleNginx takes a snapshot of the running nginx. Missing information is left empty,
+// so reload impact measurement never blocks a reload.
+func sampleNginx(pidFile string) nginxSample {
+	var sample nginxSample
+
+	workers, err := nginxWorkers(pidFile)
+	if err == nil {
+		sample.workers = workers
+	}
+
+	accepts, handled, err := readStubStatus(nginxStubStatusURL)
+	if err == nil {
+		sample.accepts = accepts
+		sample.handled = handled
+		sample.hasCounters = true
+	}
+
+	return sample
+}
+
+// waitForWorkers waits until none of the given processes are running anymore.
+// It returns how long that took and whether the timeout expired first.
+func waitForWorkers(workers []int, timeout time.Duration) (time.Duration, bool) {
+	start := time.Now()
+	for {
+		running := false
+		for _, pid := range workers {
+			if processRunning(pid) {
+				running = true
+				break
+			}
+		}
+		if !running {
+			return time.Since(start), false
+		}
+		if time.Since(start) >= timeout {
+			return time.Since(start), true
+		}
+		time.Sleep(workerPollInterval)
+	}
+}
+
+// nginxMasterPid reads the pid of the nginx master process from its pid file.
+func nginxMasterPid(pidFile string) (int, error) {
+	pidBytes, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read nginx pid file")
+	}
+	master, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse nginx pid file")
+	}
+	return master, nil
+}
+
+// signalNginx sends a signal to the nginx master process. The controller must share the
+// process namespace of nginx, with shareProcessNamespace in its Pod, and run as the same user,
+// e.g. an unprivileged nginx with runAsUser 65532; a root nginx master cannot be signalled.
+func signalNginx(pidFile string, sig syscall.Signal) error {
+	master, err := nginxMasterPid(pidFile)
+	if err != nil {
+		return err
+	}
+	err = syscall.Kill(master, sig)
+	if err != nil {
+		return errors.Wrapf(err, "failed to signal nginx master process %d", master)
+	}
+	return nil
+}
+
+// confirmReload waits for the nginx master process to fork workers other than the ones running
+// before a reload. nginx keeps its old workers if it rejects the reloaded configuration and
+// only logs why, so a reload without new workers failed; the reason is taken from nginx -t if
+// the binary is available. A reload is not confirmed if the workers could not be listed.
+func (c *WebsiteController) confirmReload(before []int) error {
+	if len(before) == 0 {
+		return nil
+	}
+	old := map[int]bool{}
+	for _, pid := range before {
+		old[pid] = true
+	}
+
+	deadline := time.Now().Add(reloadConfirmTimeout)
+	for time.Now().Before(deadline) {
+		workers, err := nginxWorkers(c.nginxPidFile)
+		if err == nil {
+			for _, pid := range workers {
+				if !old[pid] {
+					return nil
+				}
+			}
+		}
+		time.Sleep(workerPollInterval)
+	}
+
+	reason := "see the nginx error log"
+	if err := c.testNginx(); err != nil {
+		reason = err.Error()
+	}
+	return errors.Errorf("nginx kept its previous configuration: %s", reason)
+}
+
+// nginxWorkers lists the pids of the processes forked by the nginx master process.
+func nginxWorkers(pidFile string) ([]int, error) {
+	// Read the pid of the master process
+	master, err := nginxMasterPid(pidFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Find the children of the master process
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list processes")
+	}
+	var workers []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		ppid, err := parentPid(pid)
+		if err != nil {
+			continue
+		}
+		if ppid == master {
+			workers = append(workers, pid)
+		}
+	}
+
+	return workers, nil
+}
+
+// parentPid returns the parent pid of a process.
+func parentPid(pid int) (int, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// The command name may contain spaces, so the fields are parsed after its closing parenthesis
+	end := strings.LastIndexByte(string(stat), ')')
+	if end < 0 {
+		return 0, errors.Errorf("malformed stat for process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 2 {
+		return 0, errors.Errorf("malformed stat for process %d", pid)
+	}
+
+	return strconv.Atoi(fields[1])
+}
+
+// processRunning reports whether a process exists.
+func processRunning(pid int) bool {
+	_, err := os.Stat(fmt.Sprintf("/proc/%d", pid))
+	return err == nil
+}
+
+// readStubStatus reads the accepted and handled connection counters from nginx's stub_status page.
+func readStubStatus(url string) (int64, int64, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get nginx stub status")
+	}
+	defer resp.Body.Close()
+
+	// The counters are on the line following "server accepts handled requests"
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "server accepts handled requests" {
+			continue
+		}
+		if !scanner.Scan() {
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			break
+		}
+		accepts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to parse accepted connections")
+		}
+		handled, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to parse handled connections")
+		}
+		return accepts, handled, nil
+	}
+
+	return 0, 0, errors.New("malformed nginx stub status")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// routeDirectives renders a location per route of a Website, proxying to the route's upstream.
+// The locations are ordered from the most to the least specific path, which is also the order
+// nginx matches prefix locations in.
+func routeDirectives(website *v1alpha1.Website) (string, error) {
+	spec := website.Spec
+	if len(spec.Routes) == 0 {
+		return "", nil
+	}
+	if len(spec.Upstreams) > 0 || spec.LoadBalancing != nil || len(spec.HostAliases) > 0 {
+		return "", errors.New("routes cannot be combined with upstreams, load balancing or host aliases")
+	}
+
+	routes := append([]v1alpha1.Route(nil), spec.Routes...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if len(routes[i].Path) != len(routes[j].Path) {
+			return len(routes[i].Path) > len(routes[j].Path)
+		}
+		return routes[i].Path < routes[j].Path
+	})
+
+	var b strings.Builder
+	for i, route := range routes {
+		// Validate the route, as it is rendered into the configuration verbatim
+		if !strings.HasPrefix(route.Path, "/") || strings.ContainsAny(route.Path, " \t\n;{}'\"\\$") {
+			return "", errors.Errorf("invalid route path %q", route.Path)
+		}
+		if route.Path == "/" {
+			return "", errors.New("route path / is served by spec.upstream")
+		}
+		if i > 0 && routes[i-1].Path == route.Path {
+			return "", errors.Errorf("duplicate route path %s", route.Path)
+		}
+		if route.Rewrite != "" && (!strings.HasPrefix(route.Rewrite, "/") || strings.ContainsAny(route.Rewrite, " \t\n;{}'\"\\$")) {
+			return "", errors.Errorf("invalid rewrite %q of route %s", route.Rewrite, route.Path)
+		}
+		upstream, err := url.Parse(route.Upstream)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid upstream of route %s", route.Path)
+		}
+		if (upstream.Scheme != "http" && upstream.Scheme != "https") || upstream.Hostname() == "" ||
+			strings.ContainsAny(route.Upstream, " \t\n;{}'\"\\$") {
+			return "", errors.Errorf("invalid upstream %q of route %s: must be an http(s) URL", route.Upstream, route.Path)
+		}
+		if upstream.Path != "" || upstream.RawQuery != "" {
+			return "", errors.Errorf("invalid upstream %q of route %s: use rewrite to change the path", route.Upstream, route.Path)
+		}
+
+		fmt.Fprintf(&b, "\tlocation %s {\n", route.Path)
+		if route.Rewrite != "" {
+			fmt.Fprintf(&b, "\t\trewrite ^%s(.*)$ %s$1 break;\n", regexp.QuoteMeta(route.Path), route.Rewrite)
+		}
+		fmt.Fprintf(&b, "\t\tproxy_pass %s;\n\t}\n", route.Upstream)
+	}
+	return b.String(), nil
+}
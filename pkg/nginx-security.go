@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// securityDirectives compiles a SecuritySpec into server-level nginx directives.
+// Headers of the same name sent by the upstream are hidden, so clients only ever
+// see the policy derived from the Website spec.
+func securityDirectives(security *v1alpha1.SecuritySpec) (string, error) {
+	if security == nil {
+		return "", nil
+	}
+
+	var b strings.Builder
+
+	// Compile the frame ancestors into CSP and the matching X-Frame-Options
+	if len(security.FrameAncestors) > 0 {
+		sources, err := frameAncestorSources(security.FrameAncestors)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString("\tproxy_hide_header X-Frame-Options;\n")
+		fmt.Fprintf(&b, "\tadd_header Content-Security-Policy \"frame-ancestors %s\" always;\n", strings.Join(sources, " "))
+
+		// X-Frame-Options can only express 'none' and 'self'; for any other origin list
+		// it is omitted, because ALLOW-FROM is ignored by browsers and would contradict the CSP
+		switch {
+		case len(sources) == 1 && sources[0] == "'none'":
+			b.WriteString("\tadd_header X-Frame-Options \"DENY\" always;\n")
+		case len(sources) == 1 && sources[0] == "'self'":
+			b.WriteString("\tadd_header X-Frame-Options \"SAMEORIGIN\" always;\n")
+		}
+	}
+
+	// Compile the cross-origin policies
+	policies := []struct {
+		header string
+		value  string
+	}{
+		{"Cross-Origin-Resource-Policy", security.CrossOriginResourcePolicy},
+		{"Cross-Origin-Embedder-Policy", security.CrossOriginEmbedderPolicy},
+		{"Cross-Origin-Opener-Policy", security.CrossOriginOpenerPolicy},
+	}
+	for _, policy := range policies {
+		if policy.value == "" {
+			continue
+		}
+		if !isHeaderToken(policy.value) {
+			return "", errors.Errorf("invalid %s %q", policy.header, policy.value)
+		}
+		fmt.Fprintf(&b, "\tproxy_hide_header %s;\n", policy.header)
+		fmt.Fprintf(&b, "\tadd_header %s \"%s\" always;\n", policy.header, policy.value)
+	}
+
+	return b.String(), nil
+}
+
+// frameAncestorSources normalizes and validates the CSP sources of a frame-ancestors list.
+func frameAncestorSources(ancestors []string) ([]string, error) {
+	var sources []string
+	for _, ancestor := range ancestors {
+		source := strings.TrimSpace(ancestor)
+
+		// Keywords may be given with or without their quotes
+		switch strings.Trim(source, "'") {
+		case "none":
+			if len(ancestors) > 1 {
+				return nil, errors.New("frame ancestor 'none' cannot be combined with other sources")
+			}
+			source = "'none'"
+		case "self":
+			source = "'self'"
+		default:
+			if source == "" || strings.ContainsAny(source, " \t\"';,\\{}$") {
+				return nil, errors.Errorf("invalid frame ancestor %q", ancestor)
+			}
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// isHeaderToken reports whether a value can be used verbatim as a header value in the nginx configuration.
+func isHeaderToken(value string) bool {
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return value != ""
+}
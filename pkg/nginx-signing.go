@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// signingScriptPath is the njs script signing proxied requests, shared by all Websites.
+var signingScriptPath = filepath.Join(njsDir, "signing.js")
+
+// signingNamePattern matches the regions and services requests are signed for.
+var signingNamePattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// signingScript computes the signatures from the variables the signing directives set. The
+// signature is evaluated first, as it records the timestamp sent along with it in
+// $signing_date.
+const signingScript = `// Signs the requests proxied to an upstream. Written by the website controller.
+var crypto = require('crypto');
+
+function hmac(key, data) {
+    return crypto.createHmac('sha256', key).update(data).digest();
+}
+
+function sha256(data) {
+    return crypto.createHash('sha256').update(data).digest('hex');
+}
+
+function decode(s) {
+    try {
+        return decodeURIComponent(s);
+    } catch (e) {
+        return s;
+    }
+}
+
+function encode(s) {
+    return encodeURIComponent(s).replace(/[!'()*]/g, function (c) {
+        return '%' + c.charCodeAt(0).toString(16).toUpperCase();
+    });
+}
+
+function canonicalQuery(args) {
+    if (!args) {
+        return '';
+    }
+    return args.split('&').filter(function (p) {
+        return p !== '';
+    }).map(function (p) {
+        var i = p.indexOf('=');
+        var name = i < 0 ? p : p.substring(0, i);
+        var value = i < 0 ? '' : p.substring(i + 1);
+        return encode(decode(name)) + '=' + encode(decode(value));
+    }).sort().join('&');
+}
+
+function stamp(r) {
+    var date = new Date().toISOString().replace(/[-:]/g, '').replace(/\.\d+/, '');
+    r.variables.signing_date = date;
+    return date;
+}
+
+function sigv4(r) {
+    var v = r.variables;
+    var date = stamp(r);
+    var day = date.substring(0, 8);
+    var path = v.request_uri.split('?')[0].split('/').map(function (s) {
+        return encode(decode(s));
+    }).join('/');
+
+    var headers = 'host:' + v.signing_host + '\n' +
+        'x-amz-content-sha256:UNSIGNED-PAYLOAD\n' +
+        'x-amz-date:' + date + '\n';
+    var signed = 'host;x-amz-content-sha256;x-amz-date';
+    if (v.signing_session_token) {
+        headers += 'x-amz-security-token:' + v.signing_session_token + '\n';
+        signed += ';x-amz-security-token';
+    }
+    var request = [r.method, path, canonicalQuery(v.args), headers, signed, 'UNSIGNED-PAYLOAD'].join('\n');
+
+    var scope = [day, v.signing_region, v.signing_service, 'aws4_request'].join('/');
+    var toSign = ['AWS4-HMAC-SHA256', date, scope, sha256(request)].join('\n');
+    var key = hmac(hmac(hmac(hmac('AWS4' + v.signing_secret_key, day), v.signing_region), v.signing_service), 'aws4_request');
+    var signature = crypto.createHmac('sha256', key).update(toSign).digest('hex');
+    return 'AWS4-HMAC-SHA256 Credential=' + v.signing_access_key + '/' + scope +
+        ', SignedHeaders=' + signed + ', Signature=' + signature;
+}
+
+function hmacSignature(r) {
+    var date = stamp(r);
+    return crypto.createHmac('sha256', r.variables.signing_key)
+        .update(r.method + '\n' + r.variables.request_uri + '\n' + date).digest('hex');
+}
+
+export default {sigv4: sigv4, hmac: hmacSignature};
+`
+
+// upstreamSigning returns how a Website signs the requests to its upstream, if at all.
+func upstreamSigning(website *v1alpha1.Website) *v1alpha1.UpstreamSigningSpec {
+	if website.Spec.UpstreamAuth == nil {
+		return nil
+	}
+	return website.Spec.UpstreamAuth.Signing
+}
+
+// syncSigningScript writes the signing script if a Website signs its requests.
+func (c *WebsiteController) syncSigningScript(website *v1alpha1.Website) error {
+	if upstreamSigning(website) == nil {
+		return nil
+	}
+	if _, err := os.Stat(njsModulePath); err != nil {
+		return errors.Errorf("upstream signing requires the nginx njs module at %s", njsModulePath)
+	}
+
+	existing, err := ioutil.ReadFile(signingScriptPath)
+	if err == nil && string(existing) == signingScript {
+		return nil
+	}
+	err = os.MkdirAll(njsDir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create njs directory")
+	}
+	err = writeFileAtomic(signingScriptPath, []byte(signingScript), 0644)
+	c.audit(auditConfigWrite, website, signingScriptPath, []byte(signingScript), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write signing script")
+	}
+	return nil
+}
+
+// renderSigningCredentials reads the signing credentials of a Website from their Secret and
+// renders the include setting them for the signing script.
+func (c *WebsiteController) renderSigningCredentials(ctx context.Context, namespace string, signing *v1alpha1.UpstreamSigningSpec) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: signing.SecretRef.Name}, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Secret %s", signing.SecretRef.Name)
+	}
+
+	keys := map[string]string{"key": "signing_key"}
+	optional := map[string]bool{}
+	if signing.Type == v1alpha1.UpstreamSigningSigV4 {
+		keys = map[string]string{
+			"accessKeyId":     "signing_access_key",
+			"secretAccessKey": "signing_secret_key",
+			"sessionToken":    "signing_session_token",
+		}
+		optional["sessionToken"] = true
+	}
+
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, key := range names {
+		value, ok := secret.Data[key]
+		switch {
+		case !ok && optional[key]:
+			continue
+		case !ok:
+			return nil, errors.Errorf("Secret %s has no key %s", signing.SecretRef.Name, key)
+		}
+		// The values are rendered into the include verbatim, without echoing them in errors
+		if bytes.ContainsAny(value, "\"\\$;{}\r\n") {
+			return nil, errors.Errorf("Secret %s holds an invalid %s", signing.SecretRef.Name, key)
+		}
+		fmt.Fprintf(&b, "set $%s \"%s\";\n", keys[key], value)
+	}
+	return b.Bytes(), nil
+}
+
+// signingDirectives renders the server directives signing the requests proxied to a Website's
+// upstream with the signing script and the credentials include.
+func (c *WebsiteController) signingDirectives(website *v1alpha1.Website) (string, error) {
+	signing := upstreamSigning(website)
+	if signing == nil {
+		return "", nil
+	}
+	if len(website.Spec.Routes) > 0 {
+		return "", errors.New("signed upstreams cannot be combined with routes")
+	}
+	upstream, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid upstream")
+	}
+	if upstream.EscapedPath() != "" && upstream.EscapedPath() != "/" {
+		return "", errors.New("signed upstreams cannot have a path, which would change the signed URI")
+	}
+
+	module := "signing_" + websiteIdentifier(website)
+	variable := fmt.Sprintf("$website_%s_signature", websiteIdentifier(website))
+	var b strings.Builder
+	fmt.Fprintf(&b, "\tjs_import %s from %s;\n", module, signingScriptPath)
+	b.WriteString("\tset $signing_date \"\";\n")
+	switch signing.Type {
+	case v1alpha1.UpstreamSigningSigV4:
+		service := signing.Service
+		if service == "" {
+			service = "s3"
+		}
+		if !signingNamePattern.MatchString(signing.Region) {
+			return "", errors.Errorf("invalid region %q: sigv4 requires a region", signing.Region)
+		}
+		if !signingNamePattern.MatchString(service) {
+			return "", errors.Errorf("invalid service %q", service)
+		}
+		fmt.Fprintf(&b, "\tjs_set %s %s.sigv4;\n", variable, module)
+		fmt.Fprintf(&b, "\tset $signing_region %s;\n", signing.Region)
+		fmt.Fprintf(&b, "\tset $signing_service %s;\n", service)
+		fmt.Fprintf(&b, "\tset $signing_host %s;\n", upstream.Host)
+		b.WriteString("\tset $signing_session_token \"\";\n")
+		fmt.Fprintf(&b, "\tinclude %s;\n", c.secretFile(website, "upstream-signing.conf"))
+		fmt.Fprintf(&b, "\tproxy_set_header Authorization %s;\n", variable)
+		b.WriteString("\tproxy_set_header X-Amz-Date $signing_date;\n")
+		b.WriteString("\tproxy_set_header X-Amz-Content-Sha256 UNSIGNED-PAYLOAD;\n")
+		b.WriteString("\tproxy_set_header X-Amz-Security-Token $signing_session_token;\n")
+	case v1alpha1.UpstreamSigningHMAC:
+		fmt.Fprintf(&b, "\tjs_set %s %s.hmac;\n", variable, module)
+		fmt.Fprintf(&b, "\tinclude %s;\n", c.secretFile(website, "upstream-signing.conf"))
+		fmt.Fprintf(&b, "\tproxy_set_header X-Signature %s;\n", variable)
+		b.WriteString("\tproxy_set_header X-Signature-Timestamp $signing_date;\n")
+	default:
+		return "", errors.Errorf("unknown signing type %q", signing.Type)
+	}
+	return b.String(), nil
+}
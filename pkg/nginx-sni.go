@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// sniServers renders a server block for each certificate a Website presents for further
+// hostnames. The server blocks serve the hostnames on the Website's HTTPS ports like the
+// Website's own server block with the given directives and locations, but present the
+// certificate listed for them. Hostnames another Website serves are left out.
+func (c *WebsiteController) sniServers(website *v1alpha1.Website, directives, locations string) (string, error) {
+	certificates := sniCertificates(website)
+	if len(certificates) == 0 {
+		return "", nil
+	}
+	if !hasTLSMaterial(website) {
+		return "", errors.New("tls.certificates requires tls.secretRef, tls.vaultRef or tls.spiffe for the hostname")
+	}
+	if problems := sniHostnameProblems(website); len(problems) > 0 {
+		return "", errors.New(strings.Join(problems, ", "))
+	}
+	// Options such as reuseport are set once per port, by the Website's own server block
+	listeners, err := tlsListenDirectives(website)
+	if err != nil {
+		return "", err
+	}
+
+	// The certificate directives lead the directives of the Website's server block
+	directives = strings.TrimPrefix(directives, c.certificateDirectives(website, "tls"))
+
+	lost := c.hostnames.lostSNIHostnames(website)
+	var b strings.Builder
+	for i, certificate := range certificates {
+		var hostnames []string
+		for _, hostname := range certificate.Hostnames {
+			if lost[hostname] == "" {
+				hostnames = append(hostnames, hostname)
+			}
+		}
+		if len(hostnames) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `
+server {
+%s	server_name %s;
+%s%s%s}
+`, listeners, strings.Join(hostnames, " "), c.certificateDirectives(website, sniCertificateName(i)), directives, locations)
+	}
+	return b.String(), nil
+}
+
+// sniHostnameProblems checks the hostnames of a Website's SNI certificates, which are rendered
+// into the configuration verbatim. Each must be a valid, possibly wildcard, DNS name that is
+// served by one server block only.
+func sniHostnameProblems(website *v1alpha1.Website) []string {
+	var problems []string
+	seen := map[string]bool{website.Spec.Hostname: true}
+	for _, certificate := range sniCertificates(website) {
+		if len(certificate.Hostnames) == 0 {
+			problems = append(problems, fmt.Sprintf("tls.certificates entry of Secret %s has no hostnames", certificate.SecretRef.Name))
+		}
+		for _, hostname := range certificate.Hostnames {
+			errs := validation.IsDNS1123Subdomain(hostname)
+			if strings.HasPrefix(hostname, "*.") {
+				errs = validation.IsWildcardDNS1123Subdomain(hostname)
+			}
+			switch {
+			case len(errs) > 0:
+				problems = append(problems, fmt.Sprintf("tls.certificates hostname %q is invalid: %s", hostname, strings.Join(errs, ", ")))
+			case seen[hostname]:
+				problems = append(problems, fmt.Sprintf("tls.certificates hostname %s is served twice", hostname))
+			}
+			seen[hostname] = true
+		}
+	}
+	return problems
+}
+
+// sharedSNIHostname returns a hostname of a Website's SNI certificates that another Website
+// serves, or a hostname of the other Website's SNI certificates that the Website serves.
+func sharedSNIHostname(website, other *v1alpha1.Website) (string, bool) {
+	served := map[string]bool{other.Spec.Hostname: true}
+	for _, hostname := range sniHostnames(other) {
+		served[hostname] = true
+	}
+	for _, hostname := range sniHostnames(website) {
+		if served[hostname] {
+			return hostname, true
+		}
+	}
+	for _, hostname := range sniHostnames(other) {
+		if hostname == website.Spec.Hostname {
+			return hostname, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/controller/util"
+)
+
+const (
+	// templateConfigMapKey is the key of the --template-configmap ConfigMap holding the template.
+	templateConfigMapKey = "server.tmpl"
+
+	// templateReadableAnnotation on a Secret lets server block templates read it with
+	// secretBase64. Other Secrets cannot be read, so a template cannot leak credentials their
+	// owner did not hand out.
+	templateReadableAnnotation = "website-operator.io/template-readable"
+
+	// templateSecretsFile is the include in a Website's secret directory holding the Secret
+	// values its template looked up.
+	templateSecretsFile = "template-secrets.conf"
+)
+
+// templateLookups are the template functions reading objects from the cluster. Configurations
+// rendered with a template calling them are not cached, as the objects may change while the
+// Website does not.
+var templateLookups = map[string]bool{"serviceIP": true, "secretBase64": true}
+
+// serverTemplate is a parsed template of the server block and the version of the ConfigMap
+// key it was parsed from. lookups reports whether it calls one of the templateLookups.
+type serverTemplate struct {
+	version  string
+	template *template.Template
+	lookups  bool
+}
+
+// serverTemplateData is what a server block template is executed with. The built-in template
+// is equivalent to:
+//
+//	{{.HTTP}}
+//	server {
+//	{{.Listen}}	server_name {{.ServerName}};
+//	{{.Directives}}{{.Locations}}}
+//
+// Templates can also call the functions of templateFuncs, e.g. {{annotation "team"}}, or
+// proxy_set_header Authorization "Basic {{secretBase64 "upstream" "credentials"}}"; in a
+// directive that takes variables.
+type serverTemplateData struct {
+	Website    *v1alpha1.Website
+	HTTP       string
+	Listen     string
+	ServerName string
+	Directives string
+	Locations  string
+}
+
+// templateSource returns the ConfigMap and key of the template a Website's server block is
+// rendered with, and false if it is rendered with the built-in one. A Website's templateRef is
+// only honoured with the TenantTemplates feature gate.
+func (c *WebsiteController) templateSource(website *v1alpha1.Website) (types.NamespacedName, string, bool) {
+	if ref := website.Spec.TemplateRef; ref != nil && c.featureEnabled(featureTenantTemplates) {
+		return types.NamespacedName{Namespace: website.Namespace, Name: ref.Name}, ref.Key, true
+	}
+	if c.templateConfigMap.Name != "" {
+		return c.templateConfigMap, templateConfigMapKey, true
+	}
+	return types.NamespacedName{}, "", false
+}
+
+// loadTemplate reads and parses the template a Website's server block is rendered with, unless
+// the ConfigMap is unchanged since it was last parsed.
+func (c *WebsiteController) loadTemplate(ctx context.Context, website *v1alpha1.Website) error {
+	if website.Spec.TemplateRef != nil && !c.featureEnabled(featureTenantTemplates) {
+		return errors.Errorf("spec.templateRef requires the %s feature gate", featureTenantTemplates)
+	}
+	key, field, ok := c.templateSource(website)
+	if !ok {
+		return nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err := c.client.Get(ctx, key, configMap)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get template ConfigMap %s", key)
+	}
+	text, ok := configMap.Data[field]
+	if !ok {
+		return errors.Errorf("template ConfigMap %s has no key %s", key, field)
+	}
+	id := key.String() + "/" + field
+	version := id + "@" + configMap.ResourceVersion
+
+	c.templateMu.Lock()
+	defer c.templateMu.Unlock()
+
+	if cached, ok := c.templates[id]; ok && cached.version == version {
+		return nil
+	}
+	parsed, err := template.New(id).Option("missingkey=error").Funcs(c.templateFuncs(ctx, website, newTemplateRender())).Parse(text)
+	if err != nil {
+		return errors.Wrapf(err, "invalid template in ConfigMap %s", key)
+	}
+	c.templates[id] = &serverTemplate{version: version, template: parsed, lookups: templateCallsLookup(parsed)}
+	return nil
+}
+
+// templateFuncs returns the functions a Website's server block template can call, recording
+// what they look up in render:
+//
+//	serviceIP NAME      the cluster IP of a Service in the Website's namespace
+//	annotation KEY      the value of an annotation of the Website, empty if it is not set
+//	secretBase64 NAME KEY  a variable holding the base64 encoded value of a key of a Secret
+//	                    in the Website's namespace, which must be annotated with
+//	                    templateReadableAnnotation. The value itself is rendered into an
+//	                    include in the secrets directory, so it never appears in the
+//	                    configuration, its revisions or the logs.
+func (c *WebsiteController) templateFuncs(ctx context.Context, website *v1alpha1.Website, render *templateRender) template.FuncMap {
+	return template.FuncMap{
+		"serviceIP": func(name string) (string, error) {
+			render.objects["Service/"+name] = true
+			service := &corev1.Service{}
+			err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: name}, service)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to get Service %s", name)
+			}
+			if service.Spec.ClusterIP == "" || service.Spec.ClusterIP == corev1.ClusterIPNone {
+				return "", errors.Errorf("Service %s has no cluster IP", name)
+			}
+			return service.Spec.ClusterIP, nil
+		},
+		"annotation": func(key string) string {
+			return website.Annotations[key]
+		},
+		"secretBase64": func(name, key string) (string, error) {
+			render.objects["Secret/"+name] = true
+			secret := &corev1.Secret{}
+			err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: name}, secret)
+			if err != nil {
+				return "", errors.Wrapf(err, "failed to get Secret %s", name)
+			}
+			if secret.Annotations[templateReadableAnnotation] != "true" {
+				return "", errors.Errorf("Secret %s is not annotated with %s=true", name, templateReadableAnnotation)
+			}
+			value, ok := secret.Data[key]
+			if !ok {
+				return "", errors.Errorf("Secret %s has no key %s", name, key)
+			}
+			return "$" + render.secret(website, name+"/"+key, base64.StdEncoding.EncodeToString(value)), nil
+		},
+	}
+}
+
+// templateRender is what executing a server block template for a Website looked up: the
+// objects it read, so the Website is rendered again when they change, and the Secret values,
+// by the variable nginx reads them from.
+type templateRender struct {
+	website   *v1alpha1.Website
+	objects   map[string]bool
+	variables map[string]string
+	secrets   map[string]string
+}
+
+// newTemplateRender creates an empty templateRender.
+func newTemplateRender() *templateRender {
+	return &templateRender{objects: map[string]bool{}, variables: map[string]string{}, secrets: map[string]string{}}
+}
+
+// secret returns the variable holding a Secret value, identified by the Secret name and key.
+func (r *templateRender) secret(website *v1alpha1.Website, id, value string) string {
+	variable, ok := r.variables[id]
+	if !ok {
+		variable = fmt.Sprintf("website_%s_secret%d", websiteIdentifier(website), len(r.variables))
+		r.variables[id] = variable
+	}
+	r.secrets[variable] = value
+	return variable
+}
+
+// include renders the Secret values into maps of the http context setting their variables.
+func (r *templateRender) include() []byte {
+	variables := make([]string, 0, len(r.secrets))
+	for variable := range r.secrets {
+		variables = append(variables, variable)
+	}
+	sort.Strings(variables)
+
+	var b bytes.Buffer
+	for _, variable := range variables {
+		fmt.Fprintf(&b, "map $host $%s {\n\tdefault \"%s\";\n}\n", variable, r.secrets[variable])
+	}
+	return b.Bytes()
+}
+
+// recordTemplateRender remembers what rendering a Website with its template looked up.
+func (c *WebsiteController) recordTemplateRender(website *v1alpha1.Website, render *templateRender) {
+	c.templateMu.Lock()
+	defer c.templateMu.Unlock()
+
+	if render == nil || len(render.objects) == 0 {
+		delete(c.templateRenders, websiteKey(website))
+		return
+	}
+	render.website = website.DeepCopy()
+	c.templateRenders[websiteKey(website)] = render
+}
+
+// releaseTemplateRender forgets what rendering a deleted Website looked up.
+func (c *WebsiteController) releaseTemplateRender(website *v1alpha1.Website) {
+	c.recordTemplateRender(website, nil)
+}
+
+// writeTemplateSecrets writes the Secret values a Website's template looked up to the include
+// its configuration references, next to the Website's other secret material, or removes the
+// include if the template looked up none.
+func (c *WebsiteController) writeTemplateSecrets(website *v1alpha1.Website) error {
+	c.templateMu.Lock()
+	render := c.templateRenders[websiteKey(website)]
+	c.templateMu.Unlock()
+
+	path := c.secretFile(website, templateSecretsFile)
+	if render == nil || len(render.secrets) == 0 {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to delete template secrets")
+		}
+		return nil
+	}
+
+	// Refuse to write secret material anywhere but tmpfs
+	err := requireTmpfs(c.secretsDir)
+	if err != nil {
+		return err
+	}
+	err = c.makeSecretDir(c.secretDir(website))
+	if err != nil {
+		return err
+	}
+	content := render.include()
+	if secretFileCurrent(path, content) {
+		return nil
+	}
+	err = writeFileAtomic(path, content, secretFileMode)
+	c.audit(auditConfigWrite, website, path, content, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write template secrets")
+	}
+	return nil
+}
+
+// watchTemplateLookups reconciles the Websites whose template looked up a Service or Secret
+// whenever it changes, as their configuration is not rendered again otherwise.
+func (c *WebsiteController) watchTemplateLookups(ctx context.Context) error {
+	errs := make(chan error, 2)
+	for _, object := range []client.Object{&corev1.Service{}, &corev1.Secret{}} {
+		go func(w *util.Watch) {
+			errs <- w.Watch(func(event watch.Event) error {
+				var ref, namespace string
+				switch o := event.Object.(type) {
+				case *corev1.Service:
+					ref, namespace = "Service/"+o.Name, o.Namespace
+				case *corev1.Secret:
+					ref, namespace = "Secret/"+o.Name, o.Namespace
+				default:
+					return errors.Errorf("object is not a Service or Secret: %T", event.Object)
+				}
+
+				c.templateMu.Lock()
+				var websites []*v1alpha1.Website
+				for _, render := range c.templateRenders {
+					if render.website.Namespace == namespace && render.objects[ref] {
+						websites = append(websites, render.website)
+					}
+				}
+				c.templateMu.Unlock()
+				for _, website := range websites {
+					c.queue.Add(watch.Event{Type: watch.Modified, Object: website})
+				}
+				return nil
+			})
+		}(util.NewWatch(ctx, object))
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			return errors.Wrap(err, "failed to watch for objects looked up by templates")
+		}
+	}
+	return nil
+}
+
+// templateCallsLookup reports whether a template, or a template it defines, calls one of the
+// templateLookups.
+func templateCallsLookup(t *template.Template) bool {
+	for _, defined := range t.Templates() {
+		if defined.Tree != nil && callsLookup(defined.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+// callsLookup reports whether a node of a parsed template calls one of the templateLookups.
+func callsLookup(node parse.Node) bool {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return false
+		}
+		for _, child := range node.Nodes {
+			if callsLookup(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return callsLookup(node.Pipe)
+	case *parse.IfNode:
+		return callsLookup(node.Pipe) || callsLookup(node.List) || callsLookup(node.ElseList)
+	case *parse.RangeNode:
+		return callsLookup(node.Pipe) || callsLookup(node.List) || callsLookup(node.ElseList)
+	case *parse.WithNode:
+		return callsLookup(node.Pipe) || callsLookup(node.List) || callsLookup(node.ElseList)
+	case *parse.TemplateNode:
+		return callsLookup(node.Pipe)
+	case *parse.PipeNode:
+		if node == nil {
+			return false
+		}
+		for _, cmd := range node.Cmds {
+			if callsLookup(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			if callsLookup(arg) {
+				return true
+			}
+		}
+	case *parse.IdentifierNode:
+		return templateLookups[node.Ident]
+	}
+	return false
+}
+
+// templateFor returns the loaded template a Website's server block is rendered with, or nil
+// for the built-in one.
+func (c *WebsiteController) templateFor(website *v1alpha1.Website) *serverTemplate {
+	key, field, ok := c.templateSource(website)
+	if !ok {
+		return nil
+	}
+
+	c.templateMu.Lock()
+	defer c.templateMu.Unlock()
+
+	return c.templates[key.String()+"/"+field]
+}
+
+// execute renders a server block with the template, its functions bound to a Website.
+func (t *serverTemplate) execute(funcs template.FuncMap, data serverTemplateData) (string, error) {
+	bound, err := t.template.Clone()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to clone server template")
+	}
+	var b strings.Builder
+	err = bound.Funcs(funcs).Execute(&b, data)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to execute server template")
+	}
+	return b.String(), nil
+}
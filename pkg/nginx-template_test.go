@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// templatedWebsite is a Website with an annotation for the template tests.
+func templatedWebsite() *v1alpha1.Website {
+	return &v1alpha1.Website{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop", Annotations: map[string]string{"team": "checkout"}},
+		Spec:       v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://shop.team.svc:8080"},
+	}
+}
+
+// templateSecret is a Secret of the team namespace holding a token.
+func templateSecret(name string, annotations map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: name, Annotations: annotations},
+		Data:       map[string][]byte{"token": []byte("s3cret")},
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		objects []client.Object
+		want    string
+		wantErr string
+	}{
+		{
+			name: "annotation",
+			text: `{{annotation "team"}}/{{annotation "missing"}}`,
+			want: "checkout/",
+		},
+		{
+			name: "service IP",
+			text: `{{serviceIP "cache"}}`,
+			objects: []client.Object{&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "cache"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.12"},
+			}},
+			want: "10.96.0.12",
+		},
+		{
+			name: "headless Service",
+			text: `{{serviceIP "cache"}}`,
+			objects: []client.Object{&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "cache"},
+				Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+			}},
+			wantErr: "Service cache has no cluster IP",
+		},
+		{
+			name: "Service of another namespace",
+			text: `{{serviceIP "cache"}}`,
+			objects: []client.Object{&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cache"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.12"},
+			}},
+			wantErr: "failed to get Service cache",
+		},
+		{
+			name:    "readable Secret",
+			text:    `{{secretBase64 "upstream" "token"}}`,
+			objects: []client.Object{templateSecret("upstream", map[string]string{templateReadableAnnotation: "true"})},
+			want:    "$website_team_shop_688d23fe_secret0",
+		},
+		{
+			name:    "Secret not readable by templates",
+			text:    `{{secretBase64 "upstream" "token"}}`,
+			objects: []client.Object{templateSecret("upstream", nil)},
+			wantErr: "Secret upstream is not annotated with website-operator.io/template-readable=true",
+		},
+		{
+			name:    "missing Secret key",
+			text:    `{{secretBase64 "upstream" "password"}}`,
+			objects: []client.Object{templateSecret("upstream", map[string]string{templateReadableAnnotation: "true"})},
+			wantErr: "Secret upstream has no key password",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, tt.objects...)
+			website := templatedWebsite()
+			parsed, err := template.New(tt.name).Funcs(c.templateFuncs(context.Background(), website, newTemplateRender())).Parse(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tmpl := &serverTemplate{version: "test", template: parsed}
+
+			got, err := tmpl.execute(c.templateFuncs(context.Background(), website, newTemplateRender()), serverTemplateData{Website: website})
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("got error %v", err)
+			case got != tt.want:
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateFuncsBoundPerWebsite(t *testing.T) {
+	c := newTestController(t)
+	shop := templatedWebsite()
+	blog := templatedWebsite()
+	blog.Name = "blog"
+	blog.Annotations = map[string]string{"team": "content"}
+
+	parsed, err := template.New("shared").Funcs(c.templateFuncs(context.Background(), shop, newTemplateRender())).Parse(`{{annotation "team"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &serverTemplate{version: "test", template: parsed}
+	for _, website := range []*v1alpha1.Website{shop, blog, shop} {
+		got, err := tmpl.execute(c.templateFuncs(context.Background(), website, newTemplateRender()), serverTemplateData{Website: website})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != website.Annotations["team"] {
+			t.Errorf("Website %s rendered %q, want %q", website.Name, got, website.Annotations["team"])
+		}
+	}
+}
+
+func TestTemplateSecretsStayOutOfTheConfiguration(t *testing.T) {
+	secret := templateSecret("upstream", map[string]string{templateReadableAnnotation: "true"})
+	c := newTestController(t, secret)
+	website := templatedWebsite()
+	parsed, err := template.New("auth").Option("missingkey=error").Funcs(c.templateFuncs(context.Background(), website, newTemplateRender())).Parse(
+		"{{.HTTP}}server {\n{{.Listen}}\tserver_name {{.ServerName}};\n\tproxy_set_header Authorization \"Bearer {{secretBase64 \"upstream\" \"token\"}}\";\n{{.Directives}}{{.Locations}}}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &serverTemplate{version: "test", template: parsed, lookups: true}
+
+	config, err := c.compileNginxConfig(context.Background(), website, "", tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(config, "czNjcmV0") {
+		t.Errorf("configuration contains the Secret value:\n%s", config)
+	}
+	include := "include " + c.secretFile(website, templateSecretsFile) + ";\n"
+	if !strings.HasPrefix(config, include) {
+		t.Errorf("configuration does not start with %q:\n%s", include, config)
+	}
+
+	c.templateMu.Lock()
+	render := c.templateRenders[websiteKey(website)]
+	c.templateMu.Unlock()
+	if render == nil || !render.objects["Secret/upstream"] {
+		t.Fatalf("the Secret lookup was not recorded: %+v", render)
+	}
+	want := "map $host $website_team_shop_688d23fe_secret0 {\n\tdefault \"czNjcmV0\";\n}\n"
+	if got := string(render.include()); got != want {
+		t.Errorf("include is %q, want %q", got, want)
+	}
+}
+
+func TestTemplateCallsLookup(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{text: `{{.HTTP}}server { {{.Directives}} }`},
+		{text: `{{annotation "team"}}`},
+		{text: `set $cache {{serviceIP "cache"}};`, want: true},
+		{text: `{{if .Website}}{{else}}{{secretBase64 "a" "b"}}{{end}}`, want: true},
+		{text: `{{range .Website.Spec.Upstreams}}{{end}}{{with .Website}}{{serviceIP "x" | printf "%s"}}{{end}}`, want: true},
+		{text: `{{define "auth"}}{{secretBase64 "a" "b"}}{{end}}{{template "auth"}}`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			parsed, err := template.New("t").Funcs(newRenderer().templateFuncs(context.Background(), templatedWebsite(), newTemplateRender())).Parse(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := templateCallsLookup(parsed); got != tt.want {
+				t.Errorf("got %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
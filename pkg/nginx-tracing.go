@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// otelModulePath is the dynamic otel module, which nginx.conf must load for tracing to work.
+	otelModulePath = "/usr/lib/nginx/modules/ngx_otel_module.so"
+
+	// otelExporterConfigPath is the shared http-level exporter configuration managed by the controller.
+	otelExporterConfigPath = "/etc/nginx/conf.d/00-website-controller-otel.conf"
+)
+
+// otelEndpointPattern matches the host:port endpoints the otel module exports to.
+var otelEndpointPattern = regexp.MustCompile(`^[A-Za-z0-9.-]+:[0-9]{1,5}$`)
+
+// tracingDirectives renders the http-level sampling map and the server-level tracing
+// directives of a Website. Both are empty if the Website does not enable tracing.
+func tracingDirectives(website *v1alpha1.Website) (string, string, error) {
+	otel := otelSpec(website)
+	if otel == nil {
+		return "", "", nil
+	}
+
+	// Validate the sample rate
+	percent := int32(100)
+	if otel.SamplePercent != nil {
+		percent = *otel.SamplePercent
+	}
+	if percent < 0 || percent > 100 {
+		return "", "", errors.Errorf("samplePercent must be between 0 and 100, got %d", percent)
+	}
+
+	// Sample requests by trace id, so all spans of a trace share one decision
+	variable := "$website_otel_" + websiteIdentifier(website)
+	var http strings.Builder
+	fmt.Fprintf(&http, "split_clients \"$otel_trace_id\" %s {\n", variable)
+	if percent > 0 {
+		fmt.Fprintf(&http, "\t%d%% on;\n", percent)
+	}
+	http.WriteString("\t* off;\n}\n")
+
+	var server strings.Builder
+	fmt.Fprintf(&server, "\totel_trace %s;\n", variable)
+	server.WriteString("\totel_trace_context propagate;\n")
+	fmt.Fprintf(&server, "\totel_span_attr website.namespace %q;\n", website.Namespace)
+	fmt.Fprintf(&server, "\totel_span_attr website.name %q;\n", website.Name)
+
+	return http.String(), server.String(), nil
+}
+
+// syncOtelExporter claims the shared otel exporter for a Website's endpoint and keeps the
+// exporter configuration in line with the Websites that enable tracing. nginx supports a
+// single exporter, so all Websites must agree on the endpoint; the first one wins.
+func (c *WebsiteController) syncOtelExporter(website *v1alpha1.Website) error {
+	c.otelMu.Lock()
+	defer c.otelMu.Unlock()
+
+	key := websiteKey(website)
+	otel := otelSpec(website)
+	if otel == nil {
+		delete(c.otelEndpoints, key)
+		return c.writeOtelExporter()
+	}
+
+	// Check that the module is installed and the endpoint is usable
+	if _, err := os.Stat(otelModulePath); err != nil {
+		return errors.Errorf("tracing requires the nginx otel module at %s", otelModulePath)
+	}
+	if !otelEndpointPattern.MatchString(otel.Endpoint) {
+		return errors.Errorf("invalid otel endpoint %q, expected host:port", otel.Endpoint)
+	}
+	for owner, endpoint := range c.otelEndpoints {
+		if owner != key && endpoint != otel.Endpoint {
+			return errors.Errorf("otel endpoint %s conflicts with endpoint %s used by Website %s", otel.Endpoint, endpoint, owner)
+		}
+	}
+
+	c.otelEndpoints[key] = otel.Endpoint
+	return c.writeOtelExporter()
+}
+
+// releaseOtelExporter gives up a deleted Website's use of the otel exporter.
+func (c *WebsiteController) releaseOtelExporter(website *v1alpha1.Website) error {
+	c.otelMu.Lock()
+	defer c.otelMu.Unlock()
+
+	delete(c.otelEndpoints, websiteKey(website))
+	return c.writeOtelExporter()
+}
+
+// writeOtelExporter writes the exporter configuration while any Website enables tracing
+// and removes it otherwise. It must be called with otelMu held.
+func (c *WebsiteController) writeOtelExporter() error {
+	if len(c.otelEndpoints) == 0 {
+		err := os.Remove(otelExporterConfigPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		c.audit(auditConfigDelete, nil, otelExporterConfigPath, nil, err)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete otel exporter configuration")
+		}
+		return nil
+	}
+
+	var endpoint string
+	for _, endpoint = range c.otelEndpoints {
+		break
+	}
+	config := fmt.Sprintf(`otel_exporter {
+	endpoint %s;
+}
+otel_service_name website-controller-nginx;
+`, endpoint)
+
+	existing, err := ioutil.ReadFile(otelExporterConfigPath)
+	if err == nil && string(existing) == config {
+		return nil
+	}
+	err = writeFileAtomic(otelExporterConfigPath, []byte(config), 0644)
+	c.audit(auditConfigWrite, nil, otelExporterConfigPath, []byte(config), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write otel exporter configuration")
+	}
+
+	return nil
+}
+
+// otelSpec returns a Website's otel tracing configuration, if any.
+func otelSpec(website *v1alpha1.Website) *v1alpha1.OtelTracingSpec {
+	if website.Spec.Tracing == nil {
+		return nil
+	}
+	return website.Spec.Tracing.Otel
+}
+
+// websiteIdentifier returns a unique identifier of a Website that can be used in nginx
+// variable and zone names. The hash keeps it unique after sanitizing.
+func websiteIdentifier(website *v1alpha1.Website) string {
+	sum := sha1.Sum([]byte(websiteKey(website)))
+	return nginxIdentifier(website.Namespace+"_"+website.Name) + "_" + hex.EncodeToString(sum[:4])
+}
+
+// nginxIdentifier turns a string into a valid nginx variable or zone name.
+func nginxIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// minKeepaliveTimeout prevents keep-alive from being disabled or made useless.
+	minKeepaliveTimeout = time.Second
+
+	// maxKeepaliveTimeout prevents idle connections from exhausting the shared workers.
+	maxKeepaliveTimeout = 10 * time.Minute
+)
+
+// tuningDirectives compiles a TuningSpec into server-level nginx directives.
+func tuningDirectives(tuning *v1alpha1.TuningSpec) (string, error) {
+	if tuning == nil {
+		return "", nil
+	}
+
+	// Validate the combination of options
+	if tuning.TCPNoPush != nil && *tuning.TCPNoPush && tuning.Sendfile != nil && !*tuning.Sendfile {
+		return "", errors.New("tcpNopush requires sendfile")
+	}
+	if tuning.KeepaliveTimeout != nil {
+		timeout := tuning.KeepaliveTimeout.Duration
+		if timeout < minKeepaliveTimeout || timeout > maxKeepaliveTimeout {
+			return "", errors.Errorf("keepaliveTimeout must be between %s and %s", minKeepaliveTimeout, maxKeepaliveTimeout)
+		}
+	}
+
+	var b strings.Builder
+	writeSwitch(&b, "tcp_nodelay", tuning.TCPNoDelay)
+	writeSwitch(&b, "tcp_nopush", tuning.TCPNoPush)
+	writeSwitch(&b, "sendfile", tuning.Sendfile)
+	if tuning.KeepaliveTimeout != nil {
+		fmt.Fprintf(&b, "\tkeepalive_timeout %ds;\n", int(tuning.KeepaliveTimeout.Seconds()))
+	}
+
+	return b.String(), nil
+}
+
+// writeSwitch writes an on/off directive if the option is set.
+func writeSwitch(b *strings.Builder, directive string, value *bool) {
+	if value == nil {
+		return
+	}
+	if *value {
+		fmt.Fprintf(b, "\t%s on;\n", directive)
+	} else {
+		fmt.Fprintf(b, "\t%s off;\n", directive)
+	}
+}
+
+// listenOptions returns the options of a Website's listen directive. nginx accepts
+// reuseport only once per address, so the first Website requesting it owns it until
+// it is deleted, and requests from other Websites are rejected.
+func (c *WebsiteController) listenOptions(website *v1alpha1.Website) (string, error) {
+	if website.Spec.Tuning == nil || !website.Spec.Tuning.ReusePort {
+		c.releaseReusePort(website)
+		return "", nil
+	}
+
+	c.reusePortMu.Lock()
+	defer c.reusePortMu.Unlock()
+
+	owner := websiteKey(website)
+	if c.reusePortOwner != "" && c.reusePortOwner != owner {
+		return "", errors.Errorf("reuseport is already enabled by Website %s", c.reusePortOwner)
+	}
+	c.reusePortOwner = owner
+
+	return " reuseport", nil
+}
+
+// releaseReusePort gives up a Website's claim on reuseport.
+func (c *WebsiteController) releaseReusePort(website *v1alpha1.Website) {
+	c.reusePortMu.Lock()
+	defer c.reusePortMu.Unlock()
+
+	if c.reusePortOwner == websiteKey(website) {
+		c.reusePortOwner = ""
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// upstreamProbeTimeout bounds the connection and the answer of an upstream protocol probe.
+const upstreamProbeTimeout = 5 * time.Second
+
+// h2cPreface is the HTTP/2 connection preface followed by an empty SETTINGS frame, which an
+// HTTP/2 server answers with a SETTINGS frame of its own.
+var h2cPreface = []byte("PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n\x00\x00\x00\x04\x00\x00\x00\x00\x00")
+
+// upstreamProtocol returns the protocol the upstreams of a Website are spoken to with: the set
+// one, the detected one for auto, or else the one of the upstream's scheme.
+func upstreamProtocol(website *v1alpha1.Website) string {
+	protocol := website.Spec.UpstreamProtocol
+	if protocol == v1alpha1.UpstreamProtocolAuto {
+		protocol = website.Status.UpstreamProtocol
+	}
+	if protocol != "" {
+		return protocol
+	}
+	if strings.HasPrefix(website.Spec.Upstream, "https://") {
+		return v1alpha1.UpstreamProtocolHTTPS
+	}
+	return v1alpha1.UpstreamProtocolHTTP1
+}
+
+// upstreamPass renders the directive proxying a location to an upstream: grpc_pass for h2c
+// upstreams, which is how nginx speaks HTTP/2 over cleartext, and proxy_pass otherwise.
+func upstreamPass(website *v1alpha1.Website, proxyPass string) string {
+	if upstreamProtocol(website) == v1alpha1.UpstreamProtocolH2C {
+		return "grpc_pass grpc://" + strings.TrimPrefix(proxyPass, "http://")
+	}
+	return "proxy_pass " + proxyPass
+}
+
+// resolveUpstreamProtocol detects the protocol of a Website's upstream for an upstreamProtocol
+// of auto, recording it in the status, and replaces the scheme of the upstreams with the one
+// of the protocol. An upstream is probed once; one that cannot be probed is spoken to with the
+// protocol of its scheme, and probed again with the next event.
+func (c *WebsiteController) resolveUpstreamProtocol(ctx context.Context, website *v1alpha1.Website) (*v1alpha1.Website, error) {
+	protocol := website.Spec.UpstreamProtocol
+	if protocol == "" || website.Spec.Upstream == "" {
+		return website, nil
+	}
+	upstream, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upstream")
+	}
+	if upstream.Scheme != "http" && upstream.Scheme != "https" {
+		return nil, errors.Errorf("upstreamProtocol requires an http or https upstream, got %q", website.Spec.Upstream)
+	}
+
+	resolved := website.DeepCopy()
+	if protocol == v1alpha1.UpstreamProtocolAuto {
+		detected, err := c.detectUpstreamProtocol(ctx, upstream)
+		if err != nil {
+			c.websiteLog(website).Info("failed to detect upstream protocol, using http1", "error", err.Error())
+			detected = v1alpha1.UpstreamProtocolHTTP1
+		}
+		if website.Status.UpstreamProtocol != detected {
+			key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+			err = c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+				status.UpstreamProtocol = detected
+			})
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to record detected upstream protocol")
+			}
+		}
+		resolved.Status.UpstreamProtocol = detected
+		protocol = detected
+	}
+	if protocol == v1alpha1.UpstreamProtocolH2C && upstreamPath(website.Spec.Upstream) != "" {
+		return nil, errors.New("an h2c upstream cannot have a path")
+	}
+
+	// Speak the protocol's scheme to all upstreams
+	scheme := "http://"
+	if protocol == v1alpha1.UpstreamProtocolHTTPS {
+		scheme = "https://"
+	}
+	replaceScheme := func(u string) string {
+		return scheme + strings.TrimPrefix(strings.TrimPrefix(u, "http://"), "https://")
+	}
+	resolved.Spec.Upstream = replaceScheme(resolved.Spec.Upstream)
+	for i := range resolved.Spec.Upstreams {
+		resolved.Spec.Upstreams[i] = replaceScheme(resolved.Spec.Upstreams[i])
+	}
+	return resolved, nil
+}
+
+// detectUpstreamProtocol probes the protocol of an upstream once and remembers it. An https
+// upstream is spoken to over TLS. An http upstream is sent the HTTP/2 connection preface, which
+// only an h2c server answers with a SETTINGS frame.
+func (c *WebsiteController) detectUpstreamProtocol(ctx context.Context, upstream *url.URL) (string, error) {
+	if upstream.Scheme == "https" {
+		return v1alpha1.UpstreamProtocolHTTPS, nil
+	}
+	address := upstream.Host
+	if upstream.Port() == "" {
+		address = net.JoinHostPort(upstream.Hostname(), "80")
+	}
+
+	c.upstreamProtocolMu.Lock()
+	detected, ok := c.upstreamProtocols[address]
+	c.upstreamProtocolMu.Unlock()
+	if ok {
+		return detected, nil
+	}
+
+	// Send the preface and read the header of the first frame
+	dialer := &net.Dialer{Timeout: upstreamProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to connect to upstream %s", address)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(upstreamProbeTimeout))
+	_, err = conn.Write(h2cPreface)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to probe upstream %s", address)
+	}
+	header := make([]byte, 9)
+	_, err = io.ReadFull(conn, header)
+	timeout, _ := err.(net.Error)
+	switch {
+	case err == nil && header[3] == 0x4 && !bytes.HasPrefix(header, []byte("HTTP/")):
+		detected = v1alpha1.UpstreamProtocolH2C
+	case err == nil || err == io.EOF || err == io.ErrUnexpectedEOF || (timeout != nil && timeout.Timeout()):
+		// HTTP/1 servers answer the preface with an error response, close the connection or
+		// wait for the rest of a request
+		detected = v1alpha1.UpstreamProtocolHTTP1
+	default:
+		return "", errors.Wrapf(err, "failed to probe upstream %s", address)
+	}
+
+	c.upstreamProtocolMu.Lock()
+	c.upstreamProtocols[address] = detected
+	c.upstreamProtocolMu.Unlock()
+	return detected, nil
+}
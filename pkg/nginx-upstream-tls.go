@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// trustedCAFile is the system trust store upstream certificates are verified against.
+const trustedCAFile = "/etc/ssl/certs/ca-certificates.crt"
+
+// upstreamTLSDirectives compiles an UpstreamTLSSpec into server-level nginx directives.
+func upstreamTLSDirectives(upstream string, upstreamTLS *v1alpha1.UpstreamTLSSpec) (string, error) {
+	if upstreamTLS == nil {
+		return "", nil
+	}
+
+	// The options only make sense for HTTPS upstreams
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid upstream")
+	}
+	if u.Scheme != "https" {
+		return "", errors.Errorf("upstream TLS options require an https upstream, got %q", upstream)
+	}
+
+	var b strings.Builder
+
+	// Send the server name, so shared load balancers can route the connection
+	b.WriteString("\tproxy_ssl_server_name on;\n")
+	if upstreamTLS.SNI != "" {
+		if errs := validation.IsDNS1123Subdomain(upstreamTLS.SNI); len(errs) > 0 {
+			return "", errors.Errorf("invalid sni %q: %s", upstreamTLS.SNI, strings.Join(errs, ", "))
+		}
+		fmt.Fprintf(&b, "\tproxy_ssl_name %s;\n", upstreamTLS.SNI)
+	}
+
+	// Verify the certificate against the server name; the default depth of 1 rejects
+	// certificates issued by intermediate CAs
+	if upstreamTLS.VerifyHostname {
+		b.WriteString("\tproxy_ssl_verify on;\n")
+		b.WriteString("\tproxy_ssl_verify_depth 3;\n")
+		fmt.Fprintf(&b, "\tproxy_ssl_trusted_certificate %s;\n", trustedCAFile)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// nginxRoot is the directory of the nginx configuration, which nginx.conf is read from.
+const nginxRoot = "/etc/nginx"
+
+// maxIncludeDepth bounds the nesting of the includes followed to validate a candidate.
+const maxIncludeDepth = 8
+
+// initValidation looks up the nginx binary configurations are validated with before every
+// reload. Without it, e.g. when nginx runs in another container, configurations are reloaded
+// unvalidated.
+func (c *WebsiteController) initValidation() {
+	binary, err := exec.LookPath("nginx")
+	if err != nil {
+		c.log.Info("nginx binary not found, configurations are reloaded without validation")
+		return
+	}
+	c.nginxBinary = binary
+}
+
+// testNginx runs nginx -t against the configuration on disk.
+func (c *WebsiteController) testNginx() error {
+	if c.nginxBinary == "" {
+		return nil
+	}
+	return runNginxTest(exec.Command(c.nginxBinary, "-t", "-q"))
+}
+
+// testCandidate runs nginx -t against a copy of the configuration in which the file at path
+// holds a candidate configuration, so the live configuration is only replaced once the
+// candidate is known to be valid.
+func (c *WebsiteController) testCandidate(path string, candidate []byte) error {
+	return c.testConfigs(map[string][]byte{path: candidate})
+}
+
+// testConfigs runs nginx -t against the live configuration with the files at the given paths
+// holding the given configurations, or left out if it is nil. Rather than copying the live
+// configuration, a generated nginx.conf includes the live files except the replaced ones and
+// the files including them, which are written to a temporary directory with their includes
+// pointing at the candidates.
+func (c *WebsiteController) testConfigs(configs map[string][]byte) error {
+	if c.nginxBinary == "" {
+		return nil
+	}
+	for path := range configs {
+		relative, err := filepath.Rel(nginxRoot, path)
+		if err != nil || strings.HasPrefix(relative, "..") {
+			return errors.Errorf("configuration %s is outside %s", path, nginxRoot)
+		}
+	}
+	dir, err := ioutil.TempDir(c.stateDir, "validate-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create validation directory")
+	}
+	defer os.RemoveAll(dir)
+
+	main, err := newCandidateTree(nginxRoot, dir, configs).write()
+	if err != nil {
+		return errors.Wrap(err, "failed to write candidate configuration")
+	}
+	return runNginxTest(exec.Command(c.nginxBinary, "-t", "-q", "-p", filepath.Dir(main)+"/", "-c", main))
+}
+
+// includeDirective matches the include directives of an nginx configuration, one per line.
+var includeDirective = regexp.MustCompile(`(?m)^([ \t]*)include[ \t]+([^\s;#]+)[ \t]*;`)
+
+// candidateTree writes the files nginx -t reads a candidate configuration from: nginx.conf
+// next to links to the other entries of the live configuration directory, which relative paths
+// resolve against, and the candidates and the files including them in a directory of their
+// own. Their includes of the other files point at the live configuration.
+type candidateTree struct {
+	root    string
+	dir     string
+	configs map[string][]byte
+
+	// includes records whether a file includes a candidate, directly or through other files
+	includes map[string]bool
+	written  map[string]string
+}
+
+// newCandidateTree creates a candidateTree in dir for the configuration in root.
+func newCandidateTree(root, dir string, configs map[string][]byte) *candidateTree {
+	return &candidateTree{root: root, dir: dir, configs: configs, includes: map[string]bool{}, written: map[string]string{}}
+}
+
+// write writes the tree and returns the path of its nginx.conf.
+func (t *candidateTree) write() (string, error) {
+	// Link the entries of the configuration directory, e.g. mime.types
+	root := filepath.Join(t.dir, "root")
+	err := os.MkdirAll(root, 0755)
+	if err != nil {
+		return "", err
+	}
+	entries, err := ioutil.ReadDir(t.root)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.Name() == "nginx.conf" {
+			continue
+		}
+		err = os.Symlink(filepath.Join(t.root, entry.Name()), filepath.Join(root, entry.Name()))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	main := filepath.Join(t.root, "nginx.conf")
+	content, err := ioutil.ReadFile(main)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(root, "nginx.conf")
+	t.written[main] = path
+	return path, t.writeFile(path, content, 0)
+}
+
+// writeFile writes a configuration to path with the includes of candidates pointing at the
+// tree.
+func (t *candidateTree) writeFile(path string, content []byte, depth int) error {
+	if depth > maxIncludeDepth {
+		return errors.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+	}
+	var err error
+	content = includeDirective.ReplaceAllFunc(content, func(directive []byte) []byte {
+		match := includeDirective.FindSubmatch(directive)
+		indent, paths := string(match[1]), t.included(string(match[2]))
+		affected := false
+		for _, included := range paths {
+			affected = affected || t.includesCandidate(included, depth+1)
+		}
+		if !affected || err != nil {
+			return directive
+		}
+
+		// Include the files one by one, the candidates and their includers from the tree
+		var b bytes.Buffer
+		for _, included := range paths {
+			if config, ok := t.configs[included]; ok && config == nil {
+				continue
+			}
+			target := included
+			if t.includesCandidate(included, depth+1) {
+				target, err = t.writeIncluded(included, depth+1)
+				if err != nil {
+					return directive
+				}
+			}
+			fmt.Fprintf(&b, "%sinclude %s;\n", indent, target)
+		}
+		return bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+	})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// writeIncluded writes a candidate, or a file including one, to the tree once and returns its
+// path in the tree.
+func (t *candidateTree) writeIncluded(path string, depth int) (string, error) {
+	if written, ok := t.written[path]; ok {
+		return written, nil
+	}
+	content, err := t.content(path)
+	if err != nil {
+		return "", err
+	}
+	relative, err := filepath.Rel(t.root, path)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(t.dir, "candidates", relative)
+	t.written[path] = target
+	return target, t.writeFile(target, content, depth)
+}
+
+// included returns the files an include directive's pattern matches, including candidates
+// that are not on disk yet, in the order nginx includes them.
+func (t *candidateTree) included(pattern string) []string {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(t.root, pattern)
+	}
+	paths, _ := filepath.Glob(pattern)
+	for path := range t.configs {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	unique := paths[:0]
+	for i, path := range paths {
+		if i == 0 || paths[i-1] != path {
+			unique = append(unique, path)
+		}
+	}
+	return unique
+}
+
+// includesCandidate reports whether a file is a candidate or includes one.
+func (t *candidateTree) includesCandidate(path string, depth int) bool {
+	if _, ok := t.configs[path]; ok {
+		return true
+	}
+	if includes, ok := t.includes[path]; ok || depth > maxIncludeDepth {
+		return includes
+	}
+	t.includes[path] = false
+	content, err := t.content(path)
+	if err != nil {
+		return false
+	}
+	for _, match := range includeDirective.FindAllSubmatch(content, -1) {
+		for _, included := range t.included(string(match[2])) {
+			if t.includesCandidate(included, depth+1) {
+				t.includes[path] = true
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// content returns the configuration nginx -t reads from a path: the candidate, if there is
+// one, or the live file.
+func (t *candidateTree) content(path string) ([]byte, error) {
+	if config, ok := t.configs[path]; ok {
+		return config, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// runNginxTest runs an nginx -t command, returning its output as the error if it fails.
+func runNginxTest(cmd *exec.Cmd) error {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err != nil {
+		return errors.Errorf("nginx -t failed: %s", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// readPreviousConfig reads the configuration a Website is served with before it is replaced,
+// so it can be restored if the new one is invalid. It returns nil if there is none.
+func readPreviousConfig(path string) []byte {
+	previous, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return previous
+}
+
+// validateConfig validates the configuration about to be written for a Website with nginx -t,
+// in isolation from the live configuration. If it is invalid, the file keeps the last known
+// good configuration, or is removed if there is none, so the next reload of any Website does
+// not take down the shared nginx. The Website is marked Invalid, and RolledBack if a previous
+// configuration was restored, and a Warning event is emitted.
+func (c *WebsiteController) validateConfig(ctx context.Context, website *v1alpha1.Website, path string, config, previous []byte) error {
+	testErr := c.testCandidate(path, config)
+	if testErr == nil {
+		return nil
+	}
+
+	// Restore the last known good configuration
+	var err error
+	if previous != nil {
+		err = writeFileAtomic(path, previous, 0644)
+		c.audit(auditConfigWrite, website, path, previous, err)
+	} else if _, statErr := os.Stat(path); statErr == nil {
+		err = os.Remove(path)
+		c.audit(auditConfigDelete, website, path, nil, err)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to restore Nginx configuration")
+	}
+
+	// Record the outcome
+	defer c.countNginxFailure(ctx, website, testErr)
+	message := "The configuration was rejected by nginx and not loaded: " + testErr.Error()
+	c.event(website, corev1.EventTypeWarning, "InvalidConfiguration", message)
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err = c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Invalid",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+		if previous != nil {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionRolledBack,
+				Status:             metav1.ConditionTrue,
+				Reason:             "LastKnownGood",
+				Message:            "The last known good configuration was restored: " + testErr.Error(),
+				ObservedGeneration: website.Generation,
+			})
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return errors.New(message)
+}
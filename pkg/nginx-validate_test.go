@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+func TestRunNginxTest(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{name: "valid", script: "exit 0"},
+		{
+			name:    "invalid",
+			script:  `echo 'nginx: [emerg] unknown directive "proxy_pas" in /etc/nginx/conf.d/shop.conf:4' >&2; exit 1`,
+			wantErr: `nginx -t failed: nginx: [emerg] unknown directive "proxy_pas" in /etc/nginx/conf.d/shop.conf:4`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := runNginxTest(exec.Command("sh", "-c", tt.script))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTestCandidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		binary  string
+		path    string
+		wantErr string
+	}{
+		{name: "without nginx", path: "/etc/nginx/conf.d/shop.conf"},
+		{
+			name:    "outside the nginx configuration",
+			binary:  "/bin/true",
+			path:    "/tmp/shop.conf",
+			wantErr: "configuration /tmp/shop.conf is outside /etc/nginx",
+		},
+		{
+			name:    "escaping the nginx configuration",
+			binary:  "/bin/true",
+			path:    "/etc/nginx/../shop.conf",
+			wantErr: "configuration /etc/nginx/../shop.conf is outside /etc/nginx",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRenderer()
+			c.nginxBinary = tt.binary
+			c.stateDir = t.TempDir()
+			err := c.testCandidate(tt.path, []byte("server {}\n"))
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %v", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCandidateTree(t *testing.T) {
+	root, dir := t.TempDir(), t.TempDir()
+	live := map[string]string{
+		"nginx.conf":         "events {}\nhttp {\n\tinclude mime.types;\n\tinclude " + root + "/conf.d/*.conf; # Websites\n}\n",
+		"mime.types":         "types {}\n",
+		"conf.d/a.conf":      "server { server_name a; }\n",
+		"conf.d/b.conf":      "server {\n\tserver_name b;\n\tinclude " + root + "/subpaths/b/*.conf;\n}\n",
+		"conf.d/d.conf":      "server { server_name d; }\n",
+		"subpaths/b/x.conf":  "location /x {}\n",
+		"subpaths/d/ok.conf": "location /ok {}\n",
+	}
+	for name, content := range live {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Remove a, add c and add a location to b
+	configs := map[string][]byte{
+		filepath.Join(root, "conf.d/a.conf"):     nil,
+		filepath.Join(root, "conf.d/c.conf"):     []byte("server { server_name c; }\n"),
+		filepath.Join(root, "subpaths/b/y.conf"): []byte("location /y {}\n"),
+	}
+	main, err := newCandidateTree(root, dir, configs).write()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := filepath.Join(dir, "candidates")
+	want := map[string]string{
+		main: "events {}\nhttp {\n\tinclude mime.types;\n" +
+			"\tinclude " + candidates + "/conf.d/b.conf;\n" +
+			"\tinclude " + candidates + "/conf.d/c.conf;\n" +
+			"\tinclude " + root + "/conf.d/d.conf; # Websites\n}\n",
+		filepath.Join(candidates, "conf.d/b.conf"): "server {\n\tserver_name b;\n" +
+			"\tinclude " + root + "/subpaths/b/x.conf;\n" +
+			"\tinclude " + candidates + "/subpaths/b/y.conf;\n}\n",
+		filepath.Join(candidates, "conf.d/c.conf"):     "server { server_name c; }\n",
+		filepath.Join(candidates, "subpaths/b/y.conf"): "location /y {}\n",
+	}
+	for path, content := range want {
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Errorf("%s:\n--- got\n%s\n--- want\n%s", path, got, content)
+		}
+	}
+
+	// Only the candidates and their includers are written, the rest is linked
+	written := 0
+	filepath.Walk(candidates, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			written++
+		}
+		return nil
+	})
+	if written != 3 {
+		t.Errorf("wrote %d files, want 3", written)
+	}
+	if target, err := os.Readlink(filepath.Join(filepath.Dir(main), "mime.types")); err != nil || target != filepath.Join(root, "mime.types") {
+		t.Errorf("mime.types links to %q, %v", target, err)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		binary         string
+		existing       string
+		previous       string
+		wantErr        bool
+		wantFile       string
+		wantRolledBack bool
+	}{
+		{
+			name:     "unvalidated without nginx",
+			existing: "old\n",
+			previous: "old\n",
+			wantFile: "old\n",
+		},
+		{
+			name:           "rejected with a last known good configuration",
+			binary:         "/bin/false",
+			existing:       "half written\n",
+			previous:       "old\n",
+			wantErr:        true,
+			wantFile:       "old\n",
+			wantRolledBack: true,
+		},
+		{
+			name:     "rejected without a last known good configuration",
+			binary:   "/bin/false",
+			existing: "half written\n",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			website := &v1alpha1.Website{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop", Generation: 3},
+				Spec:       v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://203.0.113.10"},
+			}
+			c := newTestController(t, website.DeepCopy())
+			c.nginxBinary = tt.binary
+			c.stateDir = t.TempDir()
+
+			// A path outside the nginx configuration is rejected like an invalid candidate
+			path := filepath.Join(t.TempDir(), "shop.conf")
+			err := ioutil.WriteFile(path, []byte(tt.existing), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var previous []byte
+			if tt.previous != "" {
+				previous = []byte(tt.previous)
+			}
+			err = c.validateConfig(context.Background(), website, path, []byte("server {}\n"), previous)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %t", err, tt.wantErr)
+			}
+
+			// Check the file holds the last known good configuration, if any
+			content, err := ioutil.ReadFile(path)
+			switch {
+			case tt.wantFile == "" && !os.IsNotExist(err):
+				t.Errorf("file was not removed: %v", err)
+			case tt.wantFile != "" && string(content) != tt.wantFile:
+				t.Errorf("file holds %q, want %q", content, tt.wantFile)
+			}
+			if !tt.wantErr {
+				return
+			}
+
+			// Check the Website is marked Invalid
+			updated := &v1alpha1.Website{}
+			err = c.client.Get(context.Background(), types.NamespacedName{Namespace: "team", Name: "shop"}, updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ready := meta.FindStatusCondition(updated.Status.Conditions, v1alpha1.ConditionReady)
+			if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "Invalid" || ready.ObservedGeneration != 3 {
+				t.Errorf("got Ready condition %+v, want False for Invalid", ready)
+			}
+			if ready != nil && !strings.HasPrefix(ready.Message, "The configuration was rejected by nginx and not loaded: ") {
+				t.Errorf("got Ready message %q", ready.Message)
+			}
+			rolledBack := meta.IsStatusConditionTrue(updated.Status.Conditions, v1alpha1.ConditionRolledBack)
+			if rolledBack != tt.wantRolledBack {
+				t.Errorf("RolledBack = %t, want %t", rolledBack, tt.wantRolledBack)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultACMEWebroot is where the HTTP-01 challenge responses are written for nginx to serve.
+	defaultACMEWebroot = "/var/lib/nginx/acme"
+
+	// acmeChallengePath is the path the CA requests the HTTP-01 challenge responses under.
+	acmeChallengePath = "/.well-known/acme-challenge/"
+
+	// acmeAccountKeyFile is the private key of the controller's ACME account, within the state
+	// directory, so restarts keep the account.
+	acmeAccountKeyFile = "acme-account.key"
+
+	// acmeRenewBefore is how long before it expires a certificate is renewed. Let's Encrypt
+	// issues certificates for 90 days and recommends renewing them after 60.
+	acmeRenewBefore = 30 * 24 * time.Hour
+
+	// acmeOrderTimeout bounds an order, from its creation to the issued certificate.
+	acmeOrderTimeout = 5 * time.Minute
+
+	// acmeRetryInterval is how long after a failed order another is placed, to stay clear of
+	// the CA's rate limits.
+	acmeRetryInterval = 15 * time.Minute
+)
+
+// acmeOrder is the state of the orders of a Website's certificate.
+type acmeOrder struct {
+	// next is when another order may be placed, while one is in flight or after one failed.
+	next time.Time
+
+	// err is why the last order failed, if it did.
+	err error
+}
+
+// usesACME reports whether a Website's certificate is issued by the built-in ACME client.
+func usesACME(website *v1alpha1.Website) bool {
+	return website.Spec.TLS != nil && website.Spec.TLS.ACME
+}
+
+// acmeSecretName is the name of the Secret the ACME certificate of a Website is kept in.
+func acmeSecretName(website *v1alpha1.Website) string {
+	return "website-" + website.Name + "-acme"
+}
+
+// acmeDirectives serves the HTTP-01 challenge responses of a Website using ACME from the
+// webroot, regardless of its authentication. A Website redirecting HTTP to HTTPS redirects the
+// challenges too, which the CA follows, so renewals pass once it is served over HTTPS.
+func (c *WebsiteController) acmeDirectives(website *v1alpha1.Website) string {
+	if !usesACME(website) {
+		return ""
+	}
+	return fmt.Sprintf("\tlocation ^~ %s {\n\t\talias %s/;\n\t\tdefault_type text/plain;\n\t\tauth_basic off;\n\t\tallow all;\n\t}\n", acmeChallengePath, c.acmeWebroot)
+}
+
+// resolveACME orders the certificate of a Website using ACME when it has none, or it is due
+// for renewal, and returns a copy of the Website served with the certificate through
+// spec.tls.secretRef while it is valid. A Website without a valid certificate is served over
+// plain HTTP until it is issued, as reported by the CertificateReady condition.
+func (c *WebsiteController) resolveACME(ctx context.Context, website *v1alpha1.Website) (*v1alpha1.Website, error) {
+	if !usesACME(website) {
+		return website, nil
+	}
+	tls := website.Spec.TLS
+	if tls.SecretRef != nil || tls.VaultRef != nil || tls.SPIFFE != nil || tls.IssuerRef != nil {
+		return nil, errors.New("tls.acme is mutually exclusive with tls.secretRef, tls.vaultRef, tls.spiffe and tls.issuerRef")
+	}
+	if !c.featureEnabled(featureACME) {
+		return nil, errors.Errorf("tls.acme requires the %s feature gate", featureACME)
+	}
+
+	// Read the current certificate, which must still cover the hostname
+	var cert *x509.Certificate
+	secret := &corev1.Secret{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: acmeSecretName(website)}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to get ACME certificate Secret")
+	}
+	if err == nil && len(secret.Data[corev1.TLSPrivateKeyKey]) > 0 {
+		cert, err = leafCertificate(secret.Data[corev1.TLSCertKey])
+		if err != nil || cert.VerifyHostname(website.Spec.Hostname) != nil {
+			cert = nil
+		}
+	}
+	now := time.Now()
+	valid := cert != nil && now.Before(cert.NotAfter)
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionCertificateReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Issued",
+		Message:            "The certificate was issued into the Secret " + acmeSecretName(website),
+		ObservedGeneration: website.Generation,
+	}
+	if valid && now.Before(cert.NotAfter.Add(-acmeRenewBefore)) {
+		// Renew the certificate when it is due
+		c.pollCertificate(website, cert.NotAfter.Add(-acmeRenewBefore).Sub(now))
+	} else {
+		// Order a certificate, serving the current one meanwhile
+		err = c.orderCertificate(website)
+		switch {
+		case err != nil:
+			condition.Reason = "IssuanceFailed"
+			condition.Message = "Failed to issue the certificate, retrying: " + err.Error()
+		case valid:
+			condition.Reason = "Renewing"
+			condition.Message = "Renewing the certificate in the Secret " + acmeSecretName(website) + ", which expires at " + cert.NotAfter.UTC().Format(time.RFC3339)
+		default:
+			condition.Reason = "Issuing"
+			condition.Message = "Ordering the certificate with ACME, serving over HTTP meanwhile"
+		}
+		if !valid {
+			condition.Status = metav1.ConditionFalse
+		}
+	}
+
+	// Record the state of the certificate
+	err = c.recordCertificate(ctx, website, condition)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return website, nil
+	}
+
+	resolved := website.DeepCopy()
+	resolved.Spec.TLS.SecretRef = &corev1.LocalObjectReference{Name: acmeSecretName(website)}
+	return resolved, nil
+}
+
+// orderCertificate places an order for the certificate of a Website in the background, unless
+// one is in flight or the last one failed within the retry interval, in which case it returns
+// why it failed.
+func (c *WebsiteController) orderCertificate(website *v1alpha1.Website) error {
+	key := websiteKey(website)
+
+	c.acmeMu.Lock()
+	defer c.acmeMu.Unlock()
+
+	if order, ok := c.acmeOrders[key]; ok && time.Now().Before(order.next) {
+		return order.err
+	}
+	c.acmeOrders[key] = &acmeOrder{next: time.Now().Add(acmeOrderTimeout)}
+	go c.issueCertificate(website.DeepCopy())
+	return nil
+}
+
+// issueCertificate issues the certificate of a Website and queues it again to be served with
+// it. A failed order is reported in the CertificateReady condition and retried after the retry
+// interval.
+func (c *WebsiteController) issueCertificate(website *v1alpha1.Website) {
+	ctx, cancel := context.WithTimeout(context.Background(), acmeOrderTimeout)
+	defer cancel()
+
+	err := c.obtainCertificate(ctx, website)
+
+	c.acmeMu.Lock()
+	if err != nil {
+		c.acmeOrders[websiteKey(website)] = &acmeOrder{next: time.Now().Add(acmeRetryInterval), err: err}
+	} else {
+		delete(c.acmeOrders, websiteKey(website))
+	}
+	c.acmeMu.Unlock()
+
+	if err != nil {
+		c.log.Error(err, "failed to issue ACME certificate", "namespace", website.Namespace, "name", website.Name)
+		c.event(website, corev1.EventTypeWarning, "CertificateFailed", err.Error())
+		condition := metav1.Condition{
+			Type:               v1alpha1.ConditionCertificateReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "IssuanceFailed",
+			Message:            "Failed to issue the certificate, retrying: " + err.Error(),
+			ObservedGeneration: website.Generation,
+		}
+		if meta.IsStatusConditionTrue(website.Status.Conditions, v1alpha1.ConditionCertificateReady) {
+			condition.Status = metav1.ConditionTrue
+		}
+		err = c.recordCertificate(context.Background(), website, condition)
+		if err != nil {
+			c.log.Error(err, "failed to report ACME certificate failure", "namespace", website.Namespace, "name", website.Name)
+		}
+		c.pollCertificate(website, acmeRetryInterval)
+	} else {
+		c.log.Info("issued ACME certificate", "namespace", website.Namespace, "name", website.Name, "hostname", website.Spec.Hostname)
+		c.event(website, corev1.EventTypeNormal, "CertificateIssued", "The certificate was issued into the Secret "+acmeSecretName(website))
+		c.pollCertificate(website, 0)
+	}
+}
+
+// obtainCertificate orders a certificate for the hostname of a Website, answers its HTTP-01
+// challenges and writes the issued certificate into the Website's ACME Secret.
+func (c *WebsiteController) obtainCertificate(ctx context.Context, website *v1alpha1.Website) error {
+	client, err := c.acmeAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Prove control of the hostname
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(website.Spec.Hostname))
+	if err != nil {
+		return errors.Wrap(err, "failed to create ACME order")
+	}
+	for _, url := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			return errors.Wrap(err, "failed to get ACME authorization")
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		err = c.answerChallenge(ctx, client, website, authz)
+		if err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return errors.Wrap(err, "ACME order failed")
+	}
+
+	// Have the certificate issued for a new key
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate certificate key")
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{website.Spec.Hostname}}, key)
+	if err != nil {
+		return errors.Wrap(err, "failed to create certificate request")
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to finalize ACME order")
+	}
+	var certPEM bytes.Buffer
+	for _, der := range chain {
+		pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode certificate key")
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	// Keep the certificate in a Secret owned by the Website
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: website.Namespace, Name: acmeSecretName(website)}}
+	return c.applyOwned(ctx, website, "secrets", secret, func() {
+		secret.Type = corev1.SecretTypeTLS
+		secret.Data = map[string][]byte{
+			corev1.TLSCertKey:       certPEM.Bytes(),
+			corev1.TLSPrivateKeyKey: keyPEM,
+		}
+	})
+}
+
+// answerChallenge answers the HTTP-01 challenge of an authorization through the Website's
+// nginx server and waits for the CA to validate it.
+func (c *WebsiteController) answerChallenge(ctx context.Context, client *acme.Client, website *v1alpha1.Website, authz *acme.Authorization) error {
+	var challenge *acme.Challenge
+	for _, offered := range authz.Challenges {
+		if offered.Type == "http-01" {
+			challenge = offered
+			break
+		}
+	}
+	if challenge == nil {
+		return errors.Errorf("the ACME CA offers no http-01 challenge for %s", authz.Identifier.Value)
+	}
+
+	// Write the response into the webroot
+	response, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return errors.Wrap(err, "failed to compute ACME challenge response")
+	}
+	err = os.MkdirAll(c.acmeWebroot, 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create ACME webroot")
+	}
+	path := filepath.Join(c.acmeWebroot, filepath.Base(challenge.Token))
+	err = ioutil.WriteFile(path, []byte(response), 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to write ACME challenge response")
+	}
+	defer os.Remove(path)
+
+	// The CA must not request the response before nginx serves the challenge location
+	err = c.waitForChallengeLocation(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Accept(ctx, challenge)
+	if err != nil {
+		return errors.Wrap(err, "failed to accept ACME challenge")
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	if err != nil {
+		return errors.Wrapf(err, "ACME challenge for %s failed", authz.Identifier.Value)
+	}
+	return nil
+}
+
+// waitForChallengeLocation waits until nginx was reloaded with a configuration of the Website
+// at least as recent as the one the order was placed for, which serves the challenges.
+func (c *WebsiteController) waitForChallengeLocation(ctx context.Context, website *v1alpha1.Website) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		c.appliedMu.Lock()
+		applied, ok := c.applied[websiteKey(website)]
+		c.appliedMu.Unlock()
+		if ok && applied.Generation >= website.Generation {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("nginx was not reloaded with the ACME challenge location in time")
+		case <-ticker.C:
+		}
+	}
+}
+
+// acmeAccount returns the client of the controller's ACME account, registering the account
+// with the CA on first use.
+func (c *WebsiteController) acmeAccount(ctx context.Context) (*acme.Client, error) {
+	c.acmeAccountMu.Lock()
+	defer c.acmeAccountMu.Unlock()
+
+	if c.acmeClient != nil {
+		return c.acmeClient, nil
+	}
+
+	// Load the account key, or generate it
+	path := filepath.Join(c.stateDir, acmeAccountKeyFile)
+	var key *ecdsa.PrivateKey
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.Errorf("ACME account key %s is not PEM encoded", path)
+		}
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse ACME account key")
+		}
+	case os.IsNotExist(err):
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate ACME account key")
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode ACME account key")
+		}
+		err = writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to write ACME account key")
+		}
+	default:
+		return nil, errors.Wrap(err, "failed to read ACME account key")
+	}
+
+	// Register the account, which is a no-op for a known key
+	client := &acme.Client{Key: key, DirectoryURL: c.acmeDirectoryURL, UserAgent: "website-controller"}
+	account := &acme.Account{}
+	if c.acmeEmail != "" {
+		account.Contact = []string{"mailto:" + c.acmeEmail}
+	}
+	_, err = client.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, errors.Wrap(err, "failed to register ACME account")
+	}
+	c.acmeClient = client
+	return client, nil
+}
+
+// leafCertificate parses the first certificate of a PEM-encoded chain.
+func leafCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse certificate")
+	}
+	return cert, nil
+}
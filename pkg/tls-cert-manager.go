@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// certificatePollInterval is how often a Website waiting for its certificate checks whether
+// cert-manager issued it.
+const certificatePollInterval = 15 * time.Second
+
+// certificateGVK is the kind of cert-manager's Certificates. They are handled as unstructured
+// objects, so the controller does not depend on cert-manager's API packages.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// certificateName is the name of the cert-manager Certificate of a Website, and of the Secret
+// it is issued into.
+func certificateName(website *v1alpha1.Website) string {
+	return "website-" + website.Name + "-tls"
+}
+
+// issuerRef returns the cert-manager issuer of a Website's certificate, if any.
+func issuerRef(website *v1alpha1.Website) *v1alpha1.IssuerReference {
+	if website.Spec.TLS == nil {
+		return nil
+	}
+	return website.Spec.TLS.IssuerRef
+}
+
+// resolveIssuerRef has cert-manager issue the certificate of a Website with an issuer and,
+// once the Secret is issued, returns a copy of the Website served with it through
+// spec.tls.secretRef. Until then the Website is served over plain HTTP and checked again
+// every poll interval.
+func (c *WebsiteController) resolveIssuerRef(ctx context.Context, website *v1alpha1.Website) (*v1alpha1.Website, error) {
+	issuer := issuerRef(website)
+	if issuer == nil || website.Spec.TLS.SecretRef != nil {
+		return website, nil
+	}
+
+	// Apply the Certificate
+	kind, group := issuer.Kind, issuer.Group
+	if kind == "" {
+		kind = "Issuer"
+	}
+	if group == "" {
+		group = certificateGVK.Group
+	}
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetNamespace(website.Namespace)
+	certificate.SetName(certificateName(website))
+	err := c.applyOwned(ctx, website, "certificates", certificate, func() {
+		certificate.Object["spec"] = map[string]interface{}{
+			"secretName": certificateName(website),
+			"dnsNames":   []interface{}{website.Spec.Hostname},
+			"issuerRef": map[string]interface{}{
+				"name":  issuer.Name,
+				"kind":  kind,
+				"group": group,
+			},
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Serve the Website with the certificate once it is issued
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionCertificateReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Issued",
+		Message:            "The certificate was issued into the Secret " + certificateName(website),
+		ObservedGeneration: website.Generation,
+	}
+	secret := &corev1.Secret{}
+	err = c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: certificateName(website)}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to get certificate Secret")
+	}
+	issued := err == nil && len(secret.Data[corev1.TLSCertKey]) > 0 && len(secret.Data[corev1.TLSPrivateKeyKey]) > 0
+	if !issued {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Issuing"
+		condition.Message = "Waiting for cert-manager to issue the certificate into the Secret " + certificateName(website) + ", serving over HTTP meanwhile"
+		c.pollCertificate(website, certificatePollInterval)
+	}
+
+	// Record the state of the certificate
+	err = c.recordCertificate(ctx, website, condition)
+	if err != nil {
+		return nil, err
+	}
+	if !issued {
+		return website, nil
+	}
+	c.stopCertificatePoll(websiteKey(website))
+
+	resolved := website.DeepCopy()
+	resolved.Spec.TLS.SecretRef = &corev1.LocalObjectReference{Name: certificateName(website)}
+	return resolved, nil
+}
+
+// recordCertificate sets the CertificateReady condition of a Website, unless it already has
+// the condition's status and reason.
+func (c *WebsiteController) recordCertificate(ctx context.Context, website *v1alpha1.Website, condition metav1.Condition) error {
+	current := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionCertificateReady)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update CertificateReady condition")
+	}
+	return nil
+}
+
+// pollCertificate queues a Website waiting for its certificate again after a delay, replacing
+// an earlier poll.
+func (c *WebsiteController) pollCertificate(website *v1alpha1.Website, delay time.Duration) {
+	key := websiteKey(website)
+
+	c.certificateMu.Lock()
+	defer c.certificateMu.Unlock()
+
+	if timer, ok := c.certificatePolls[key]; ok {
+		timer.Stop()
+	}
+	c.certificatePolls[key] = c.requeueAfter(website, delay)
+}
+
+// stopCertificatePoll stops checking whether the certificate of a Website was issued.
+func (c *WebsiteController) stopCertificatePoll(key string) {
+	c.certificateMu.Lock()
+	defer c.certificateMu.Unlock()
+
+	if timer, ok := c.certificatePolls[key]; ok {
+		timer.Stop()
+		delete(c.certificatePolls, key)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// readTLSSecret reads the certificate and key of a kubernetes.io/tls Secret.
+func (c *WebsiteController) readTLSSecret(ctx context.Context, namespace, name string) ([]byte, []byte, error) {
+	secret := &corev1.Secret{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get TLS Secret %s", name)
+	}
+
+	cert := secret.Data[corev1.TLSCertKey]
+	key := secret.Data[corev1.TLSPrivateKeyKey]
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, nil, errors.Errorf("Secret %s contains no %s and %s", name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+	}
+
+	return cert, key, nil
+}
+
+// checkCertificateHostnames verifies that the certificates a Website is served with cover their
+// hostnames and records the outcome in the CertificateHostnameMismatch condition. A mismatch
+// does not stop the Website from being served, but is surfaced instead of failing silently
+// in browsers.
+func (c *WebsiteController) checkCertificateHostnames(ctx context.Context, website *v1alpha1.Website) error {
+	if !hasTLSMaterial(website) {
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionCertificateHostnameMismatch,
+		Status:             metav1.ConditionFalse,
+		Reason:             "HostnamesCovered",
+		Message:            "The certificate covers all hostnames of the Website",
+		ObservedGeneration: website.Generation,
+	}
+	err := verifyCertificateHostnames(c.secretFile(website, "tls.crt"), []string{website.Spec.Hostname})
+	for i, certificate := range sniCertificates(website) {
+		if err == nil {
+			err = errors.Wrapf(verifyCertificateHostnames(c.secretFile(website, sniCertificateName(i)+".crt"), certificate.Hostnames), "Secret %s", certificate.SecretRef.Name)
+		}
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "HostnameNotCovered"
+		condition.Message = err.Error()
+		c.log.Info("certificate does not cover the Website's hostnames", "namespace", website.Namespace, "name", website.Name, "reason", err.Error())
+	}
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	return c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+}
+
+// verifyCertificateHostnames checks that the leaf certificate of a PEM file is valid for all hostnames.
+func verifyCertificateHostnames(path string, hostnames []string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read certificate")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return errors.New("certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse certificate")
+	}
+
+	var uncovered []string
+	for _, hostname := range hostnames {
+		if cert.VerifyHostname(hostname) != nil {
+			uncovered = append(uncovered, hostname)
+		}
+	}
+	if len(uncovered) > 0 {
+		return errors.Errorf("certificate with SANs %s does not cover %s", strings.Join(cert.DNSNames, ", "), strings.Join(uncovered, ", "))
+	}
+
+	return nil
+}
+
+// tlsSecretRef returns the Secret reference of a Website's certificate, if any.
+func tlsSecretRef(website *v1alpha1.Website) *corev1.LocalObjectReference {
+	if website.Spec.TLS == nil {
+		return nil
+	}
+	return website.Spec.TLS.SecretRef
+}
+
+// redirectsHTTP reports whether a Website served with a certificate redirects plain HTTP
+// requests to HTTPS.
+func redirectsHTTP(website *v1alpha1.Website) bool {
+	tls := website.Spec.TLS
+	return hasTLSMaterial(website) && (tls.HTTPRedirect == nil || *tls.HTTPRedirect)
+}
+
+// hasTLSMaterial reports whether a Website is served with a certificate from any source.
+func hasTLSMaterial(website *v1alpha1.Website) bool {
+	return vaultTLSRef(website) != nil || tlsSecretRef(website) != nil || spiffeSource(website) != nil
+}
+
+// sniCertificates returns the certificates a Website presents for further hostnames, if any.
+func sniCertificates(website *v1alpha1.Website) []v1alpha1.TLSCertificate {
+	if website.Spec.TLS == nil {
+		return nil
+	}
+	return website.Spec.TLS.Certificates
+}
+
+// sniCertificateName is the name of the files of a Website's i-th SNI certificate in its
+// secret directory, without the .crt and .key extensions.
+func sniCertificateName(i int) string {
+	return fmt.Sprintf("sni-%d", i)
+}
+
+// sniHostnames returns the hostnames a Website serves in addition to its hostname.
+func sniHostnames(website *v1alpha1.Website) []string {
+	var hostnames []string
+	for _, certificate := range sniCertificates(website) {
+		hostnames = append(hostnames, certificate.Hostnames...)
+	}
+	return hostnames
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultSPIFFEDir is where spiffe-helper or the SPIFFE CSI driver writes the X.509 SVIDs.
+	defaultSPIFFEDir = "/run/spiffe/certs"
+
+	// svidCertFile and svidKeyFile are the file names of an X.509 SVID and its private key.
+	svidCertFile = "svid.pem"
+	svidKeyFile  = "svid_key.pem"
+
+	// spiffeRefreshInterval is how often the SVIDs are checked for rotation. SVIDs are short-lived,
+	// so they are checked far more often than Vault secrets.
+	spiffeRefreshInterval = 10 * time.Second
+)
+
+// spiffeSource returns the SVID a Website is served with, if any.
+func spiffeSource(website *v1alpha1.Website) *v1alpha1.SPIFFESource {
+	if website.Spec.TLS == nil {
+		return nil
+	}
+	return website.Spec.TLS.SPIFFE
+}
+
+// readSVID reads the certificate chain and private key of an SVID.
+func (c *WebsiteController) readSVID(source *v1alpha1.SPIFFESource) ([]byte, []byte, error) {
+	dir := filepath.Join(c.spiffeDir, source.SVID)
+	cert, err := ioutil.ReadFile(filepath.Join(dir, svidCertFile))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read SVID")
+	}
+	key, err := ioutil.ReadFile(filepath.Join(dir, svidKeyFile))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read SVID key")
+	}
+	if len(cert) == 0 || len(key) == 0 {
+		return nil, nil, errors.Errorf("SVID in %s is incomplete", dir)
+	}
+	return cert, key, nil
+}
+
+// refreshSPIFFE copies rotated SVIDs to the Websites served with them until the context is
+// cancelled. All Websites sharing an SVID rotate together, so they are reloaded at once.
+func (c *WebsiteController) refreshSPIFFE(ctx context.Context) {
+	ticker := time.NewTicker(spiffeRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rotated := 0
+		for _, website := range c.vaultWebsites() {
+			if spiffeSource(website) == nil {
+				continue
+			}
+			changed, err := c.syncSecretMaterial(ctx, website)
+			if err != nil {
+				c.log.Error(err, "failed to refresh SVID", "namespace", website.Namespace, "name", website.Name)
+				continue
+			}
+			if changed {
+				rotated++
+			}
+		}
+		if rotated == 0 {
+			continue
+		}
+
+		// Reload once for all rotated Websites
+		err := c.reloadNginx(nil)
+		if err != nil {
+			c.log.Error(err, "failed to reload rotated SVIDs", "websites", rotated)
+			continue
+		}
+		c.log.Info("reloaded rotated SVIDs", "websites", rotated)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// upstreamAuthHeader returns the header a Website sends to its upstream from a Secret, if any.
+func upstreamAuthHeader(website *v1alpha1.Website) *v1alpha1.HeaderFromSecret {
+	if website.Spec.UpstreamAuth == nil {
+		return nil
+	}
+	return website.Spec.UpstreamAuth.HeaderFromSecret
+}
+
+// renderUpstreamAuth reads an upstream credential from its Secret and renders the include
+// setting it on proxied requests.
+func (c *WebsiteController) renderUpstreamAuth(ctx context.Context, namespace string, header *v1alpha1.HeaderFromSecret) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: header.SecretRef.Name}, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Secret %s", header.SecretRef.Name)
+	}
+	value, ok := secret.Data[header.SecretRef.Key]
+	if !ok {
+		return nil, errors.Errorf("Secret %s has no key %s", header.SecretRef.Name, header.SecretRef.Key)
+	}
+
+	// The value is validated like any other header, without echoing it in the error
+	err = validateMiddlewareHeader(v1alpha1.HeaderValue{Name: header.Name, Value: string(value)})
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upstream auth header")
+	}
+	return []byte(fmt.Sprintf("proxy_set_header %s \"%s\";\n", header.Name, value)), nil
+}
+
+// secretNames returns the names of the Kubernetes Secrets a Website reads secret material from.
+func secretNames(website *v1alpha1.Website) []string {
+	var names []string
+	if ref := tlsSecretRef(website); ref != nil {
+		names = append(names, ref.Name)
+	}
+	if header := upstreamAuthHeader(website); header != nil {
+		names = append(names, header.SecretRef.Name)
+	}
+	if signing := upstreamSigning(website); signing != nil {
+		names = append(names, signing.SecretRef.Name)
+	}
+	if ref := healthReportToken(website); ref != nil {
+		names = append(names, ref.Name)
+	}
+	return names
+}
+
+// containsString reports whether a slice contains a string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultSecretsDir is where secret material is written. It must be a tmpfs mount.
+	defaultSecretsDir = "/run/website-controller/secrets"
+
+	// tmpfsMagic is the filesystem type reported by statfs for tmpfs.
+	tmpfsMagic = 0x01021994
+
+	// serviceAccountTokenPath is the token used to log in to Vault's Kubernetes auth method.
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// secretDirMode and secretFileMode let the secrets group, which nginx reads the material
+	// as, read the secret material.
+	secretDirMode  = 0750
+	secretFileMode = 0640
+
+	// vaultRefreshInterval is how often secret material is re-read from Vault.
+	vaultRefreshInterval = 5 * time.Minute
+)
+
+// vaultClient reads secrets from Vault, logging in with the controller's service account
+// once per role and reusing the token until it expires.
+type vaultClient struct {
+	address string
+
+	mu     sync.Mutex
+	tokens map[string]vaultToken
+}
+
+// vaultToken is a client token of a Vault role.
+type vaultToken struct {
+	token   string
+	expires time.Time
+}
+
+// newVaultClient creates a vaultClient for a Vault address.
+func newVaultClient(address string) *vaultClient {
+	return &vaultClient{address: address, tokens: map[string]vaultToken{}}
+}
+
+// Read reads the key/value data of a secret. Both KV version 1 and 2 layouts are supported.
+func (v *vaultClient) Read(ref *v1alpha1.VaultRef) (map[string]string, error) {
+	client, err := v.client(ref.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := client.Logical().Read(ref.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read Vault secret %s", ref.Path)
+	}
+	if secret == nil {
+		return nil, errors.Errorf("Vault secret %s not found", ref.Path)
+	}
+
+	// KV version 2 nests the key/value data
+	raw := secret.Data
+	if nested, ok := raw["data"].(map[string]interface{}); ok {
+		raw = nested
+	}
+	data := map[string]string{}
+	for key, value := range raw {
+		if s, ok := value.(string); ok {
+			data[key] = s
+		}
+	}
+
+	return data, nil
+}
+
+// client returns a Vault client logged in as a role.
+func (v *vaultClient) client(role string) (*vaultapi.Client, error) {
+	config := vaultapi.DefaultConfig()
+	if v.address != "" {
+		config.Address = v.address
+	}
+	client, err := vaultapi.NewClient(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create Vault client")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Reuse the token of the role until shortly before it expires
+	token, ok := v.tokens[role]
+	if !ok || time.Now().After(token.expires) {
+		jwt, err := ioutil.ReadFile(serviceAccountTokenPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read service account token")
+		}
+		login, err := client.Logical().Write("auth/kubernetes/login", map[string]interface{}{
+			"role": role,
+			"jwt":  string(jwt),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to log in to Vault as role %s", role)
+		}
+		if login == nil || login.Auth == nil {
+			return nil, errors.Errorf("Vault login as role %s returned no token", role)
+		}
+		lease := time.Duration(login.Auth.LeaseDuration) * time.Second
+		token = vaultToken{token: login.Auth.ClientToken, expires: time.Now().Add(lease * 3 / 4)}
+		v.tokens[role] = token
+	}
+	client.SetToken(token.token)
+
+	return client, nil
+}
+
+// syncSecretMaterial reads a Website's TLS and basic auth material from Vault or a Kubernetes
+// Secret and writes it to the secrets directory. It reports whether any file changed.
+func (c *WebsiteController) syncSecretMaterial(ctx context.Context, website *v1alpha1.Website) (bool, error) {
+	tlsRef := vaultTLSRef(website)
+	authRef := vaultBasicAuthRef(website)
+	secretRef := tlsSecretRef(website)
+	spiffe := spiffeSource(website)
+	upstreamAuth := upstreamAuthHeader(website)
+	signing := upstreamSigning(website)
+	healthToken := healthReportToken(website)
+	if tlsRef == nil && authRef == nil && secretRef == nil && spiffe == nil && upstreamAuth == nil && signing == nil && healthToken == nil {
+		return false, nil
+	}
+	if (tlsRef != nil && secretRef != nil) || (tlsRef != nil && spiffe != nil) || (secretRef != nil && spiffe != nil) {
+		return false, errors.New("tls.vaultRef, tls.secretRef and tls.spiffe are mutually exclusive")
+	}
+	if len(sniCertificates(website)) > 0 && !hasTLSMaterial(website) {
+		return false, errors.New("tls.certificates requires tls.secretRef, tls.vaultRef or tls.spiffe for the hostname")
+	}
+	if (tlsRef != nil || authRef != nil) && c.vault == nil {
+		return false, errors.New("Website references Vault, but no Vault address is configured")
+	}
+	err := c.checkVaultRoles(ctx, website, tlsRef, authRef)
+	if err != nil {
+		return false, err
+	}
+
+	// Refuse to write secret material anywhere but tmpfs
+	err = requireTmpfs(c.secretsDir)
+	if err != nil {
+		return false, err
+	}
+
+	files := map[string][]byte{}
+
+	// Read the certificate and key
+	if tlsRef != nil {
+		data, err := c.vault.Read(tlsRef)
+		if err != nil {
+			return false, err
+		}
+		cert := firstValue(data, "tls.crt", "certificate")
+		key := firstValue(data, "tls.key", "private_key")
+		if cert == "" || key == "" {
+			return false, errors.Errorf("Vault secret %s contains no certificate and key", tlsRef.Path)
+		}
+		files["tls.crt"] = []byte(cert)
+		files["tls.key"] = []byte(key)
+	}
+	if secretRef != nil {
+		cert, key, err := c.readTLSSecret(ctx, website.Namespace, secretRef.Name)
+		if err != nil {
+			return false, err
+		}
+		files["tls.crt"] = cert
+		files["tls.key"] = key
+	}
+	if spiffe != nil {
+		cert, key, err := c.readSVID(spiffe)
+		if err != nil {
+			return false, err
+		}
+		files["tls.crt"] = cert
+		files["tls.key"] = key
+	}
+	for i, certificate := range sniCertificates(website) {
+		cert, key, err := c.readTLSSecret(ctx, website.Namespace, certificate.SecretRef.Name)
+		if err != nil {
+			return false, err
+		}
+		files[sniCertificateName(i)+".crt"] = cert
+		files[sniCertificateName(i)+".key"] = key
+	}
+
+	// Read the basic auth users
+	if authRef != nil {
+		data, err := c.vault.Read(authRef)
+		if err != nil {
+			return false, err
+		}
+		htpasswd, err := renderHtpasswd(data, c.secretFile(website, "htpasswd"))
+		if err != nil {
+			return false, err
+		}
+		files["htpasswd"] = htpasswd
+	}
+
+	// Read the upstream credentials into an include
+	if upstreamAuth != nil {
+		include, err := c.renderUpstreamAuth(ctx, website.Namespace, upstreamAuth)
+		if err != nil {
+			return false, err
+		}
+		files["upstream-auth.conf"] = include
+	}
+	if signing != nil {
+		include, err := c.renderSigningCredentials(ctx, website.Namespace, signing)
+		if err != nil {
+			return false, err
+		}
+		files["upstream-signing.conf"] = include
+	}
+
+	// Read the health report token into an include
+	if healthToken != nil {
+		include, err := c.renderHealthReportAuth(ctx, website.Namespace, healthToken)
+		if err != nil {
+			return false, err
+		}
+		files["health-auth.conf"] = include
+	}
+
+	// Write the material, readable only by the controller and nginx
+	dir := c.secretDir(website)
+	err = c.makeSecretDir(dir)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if secretFileCurrent(path, content) {
+			continue
+		}
+		err = writeFileAtomic(path, content, secretFileMode)
+		c.audit(auditConfigWrite, website, path, content, err)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to write secret material")
+		}
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// checkVaultRoles refuses Vault references whose role no WebsitePolicy grants to the Website's
+// namespace. The controller logs in to Vault as whatever role is named, so without the grant
+// any tenant could read the secrets of another tenant's role.
+func (c *WebsiteController) checkVaultRoles(ctx context.Context, website *v1alpha1.Website, refs ...*v1alpha1.VaultRef) error {
+	var roles map[string]bool
+	for _, ref := range refs {
+		if ref == nil {
+			continue
+		}
+		if roles == nil {
+			var err error
+			roles, err = c.grantedVaultRoles(ctx, website.Namespace)
+			if err != nil {
+				return err
+			}
+		}
+		if !roles[ref.Role] {
+			return errors.Errorf("Vault role %q is not granted to namespace %s by a WebsitePolicy", ref.Role, website.Namespace)
+		}
+	}
+	return nil
+}
+
+// makeSecretDir creates a directory of secret material readable by the secrets group and
+// nobody else. The directory is setgid, so the files written to it take on its group.
+func (c *WebsiteController) makeSecretDir(dir string) error {
+	err := os.MkdirAll(dir, secretDirMode)
+	if err != nil {
+		return errors.Wrap(err, "failed to create secret directory")
+	}
+	for _, path := range []string{c.secretsDir, dir} {
+		if c.secretsGroup != 0 {
+			err = os.Chown(path, -1, c.secretsGroup)
+			if err != nil {
+				return errors.Wrap(err, "failed to change group of secret directory")
+			}
+		}
+		err = os.Chmod(path, secretDirMode|os.ModeSetgid)
+		if err != nil {
+			return errors.Wrap(err, "failed to change mode of secret directory")
+		}
+	}
+	return nil
+}
+
+// secretFileCurrent reports whether a secret file already has the content and mode it would
+// be written with, so unchanged material written with an older mode is still rewritten.
+func secretFileCurrent(path string, content []byte) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != secretFileMode {
+		return false
+	}
+	existing, err := ioutil.ReadFile(path)
+	return err == nil && bytes.Equal(existing, content)
+}
+
+// removeSecretMaterial deletes a Website's secret material.
+func (c *WebsiteController) removeSecretMaterial(website *v1alpha1.Website) error {
+	dir := c.secretDir(website)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	err := os.RemoveAll(dir)
+	c.audit(auditConfigDelete, website, dir, nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete secret material")
+	}
+
+	return nil
+}
+
+// refreshSecretMaterial periodically re-reads the secret material of all Websites and
+// reloads nginx if any of it was rotated.
+func (c *WebsiteController) refreshSecretMaterial(ctx context.Context) {
+	ticker := time.NewTicker(vaultRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, website := range c.vaultWebsites() {
+			changed, err := c.syncSecretMaterial(ctx, website)
+			if err != nil {
+				c.log.Error(err, "failed to refresh secret material", "namespace", website.Namespace, "name", website.Name)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			err = c.reloadNginx(website)
+			if err != nil {
+				c.log.Error(err, "failed to reload rotated secret material", "namespace", website.Namespace, "name", website.Name)
+			}
+		}
+	}
+}
+
+// trackVaultWebsite remembers a Website for periodic refreshes while it references secret material.
+func (c *WebsiteController) trackVaultWebsite(website *v1alpha1.Website) {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+
+	key := websiteKey(website)
+	if !hasTLSMaterial(website) && vaultBasicAuthRef(website) == nil && upstreamAuthHeader(website) == nil && upstreamSigning(website) == nil {
+		delete(c.vaultTracked, key)
+		return
+	}
+	c.vaultTracked[key] = website.DeepCopy()
+}
+
+// untrackVaultWebsite stops periodic refreshes of a Website.
+func (c *WebsiteController) untrackVaultWebsite(website *v1alpha1.Website) {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+
+	delete(c.vaultTracked, websiteKey(website))
+}
+
+// vaultWebsites returns the Websites that reference secret material.
+func (c *WebsiteController) vaultWebsites() []*v1alpha1.Website {
+	c.vaultMu.Lock()
+	defer c.vaultMu.Unlock()
+
+	websites := make([]*v1alpha1.Website, 0, len(c.vaultTracked))
+	for _, website := range c.vaultTracked {
+		websites = append(websites, website)
+	}
+	return websites
+}
+
+// secretDir is the directory holding a Website's secret material.
+func (c *WebsiteController) secretDir(website *v1alpha1.Website) string {
+	return filepath.Join(c.secretsDir, website.Namespace+"_"+website.Name)
+}
+
+// secretFile is the path of one of a Website's secret files.
+func (c *WebsiteController) secretFile(website *v1alpha1.Website, name string) string {
+	return filepath.Join(c.secretDir(website), name)
+}
+
+// vaultTLSRef returns the Vault reference of a Website's certificate, if any.
+func vaultTLSRef(website *v1alpha1.Website) *v1alpha1.VaultRef {
+	if website.Spec.TLS == nil {
+		return nil
+	}
+	return website.Spec.TLS.VaultRef
+}
+
+// vaultBasicAuthRef returns the Vault reference of a Website's basic auth users, if any.
+func vaultBasicAuthRef(website *v1alpha1.Website) *v1alpha1.VaultRef {
+	if website.Spec.BasicAuth == nil {
+		return nil
+	}
+	return website.Spec.BasicAuth.VaultRef
+}
+
+// secretDirectives renders the TLS and basic auth directives of a Website's secret material.
+func (c *WebsiteController) secretDirectives(website *v1alpha1.Website) string {
+	var b strings.Builder
+	if hasTLSMaterial(website) {
+		b.WriteString(c.certificateDirectives(website, "tls"))
+	}
+	if redirectsHTTP(website) {
+		b.WriteString("\tif ($scheme = http) {\n\t\treturn 308 https://$host$request_uri;\n\t}\n")
+	}
+	if auth := website.Spec.BasicAuth; auth != nil && auth.VaultRef != nil {
+		realm := auth.Realm
+		if realm == "" {
+			realm = website.Spec.Hostname
+		}
+		fmt.Fprintf(&b, "\tauth_basic %q;\n", realm)
+		fmt.Fprintf(&b, "\tauth_basic_user_file %s;\n", c.secretFile(website, "htpasswd"))
+	}
+	if upstreamAuthHeader(website) != nil {
+		fmt.Fprintf(&b, "\tinclude %s;\n", c.secretFile(website, "upstream-auth.conf"))
+	}
+	return b.String()
+}
+
+// certificateDirectives renders the directives presenting one of a Website's certificates,
+// named by its files in the secret directory without the extensions.
+func (c *WebsiteController) certificateDirectives(website *v1alpha1.Website, name string) string {
+	return fmt.Sprintf("\tssl_certificate %s;\n\tssl_certificate_key %s;\n", c.secretFile(website, name+".crt"), c.secretFile(website, name+".key"))
+}
+
+// renderHtpasswd renders username/password pairs into an htpasswd file with salted SHA-1
+// hashes, which nginx verifies as {SSHA}. A secret holding a ready-made "htpasswd" key is used verbatim.
+func renderHtpasswd(users map[string]string, path string) ([]byte, error) {
+	if htpasswd, ok := users["htpasswd"]; ok {
+		return []byte(htpasswd), nil
+	}
+	if len(users) == 0 {
+		return nil, errors.Errorf("no basic auth users for %s", path)
+	}
+
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		if strings.ContainsAny(name, ":\n") {
+			return nil, errors.Errorf("invalid basic auth user %q", name)
+		}
+		// The salt is derived from the file and user, so unchanged users render identically
+		// and refreshes do not cause spurious reloads
+		seed := sha1.Sum([]byte(path + "\x00" + name))
+		salt := seed[:8]
+		sum := sha1.Sum(append([]byte(users[name]), salt...))
+		fmt.Fprintf(&b, "%s:{SSHA}%s\n", name, base64.StdEncoding.EncodeToString(append(sum[:], salt...)))
+	}
+
+	return b.Bytes(), nil
+}
+
+// requireTmpfs fails unless a directory is on tmpfs, so secret material never touches disk.
+func requireTmpfs(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create secrets directory")
+	}
+
+	var stat syscall.Statfs_t
+	err = syscall.Statfs(dir, &stat)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat secrets directory")
+	}
+	if int64(stat.Type) != tmpfsMagic {
+		return errors.Errorf("secrets directory %s is not on tmpfs", dir)
+	}
+
+	return nil
+}
+
+// firstValue returns the value of the first of the keys present in data.
+func firstValue(data map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := data[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
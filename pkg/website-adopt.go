@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// adoptConfigFile takes over the hand-written configuration file a Website adopts, if it is
+// still there. The file is removed before the Website's configuration is written, so nginx
+// never loads both; it is only removed if it is equivalent to the rendered configuration.
+// A mismatch is recorded in the Ready condition and the Website is not served.
+func (c *WebsiteController) adoptConfigFile(ctx context.Context, website *v1alpha1.Website, config string) error {
+	if website.Spec.Serving == nil || website.Spec.Serving.AdoptConfigFile == "" {
+		return nil
+	}
+	path := website.Spec.Serving.AdoptConfigFile
+	if filepath.Dir(path) != "/etc/nginx/conf.d" || filepath.Ext(path) != ".conf" {
+		return errors.Errorf("adopted configuration file %s is not a .conf file in /etc/nginx/conf.d", path)
+	}
+	if path == nginxConfigPath(website) {
+		return nil
+	}
+	legacy, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read adopted configuration file")
+	}
+
+	// Refuse to take over a file that serves the hostname differently
+	mismatch := configMismatch(string(legacy), config)
+	if mismatch != "" {
+		message := fmt.Sprintf("The configuration file %s cannot be adopted: %s", path, mismatch)
+		c.event(website, corev1.EventTypeWarning, "AdoptionMismatch", message)
+		key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+		err = c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionReady,
+				Status:             metav1.ConditionFalse,
+				Reason:             "AdoptionMismatch",
+				Message:            message,
+				ObservedGeneration: website.Generation,
+			})
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to update Ready condition")
+		}
+		return errors.New(message)
+	}
+
+	err = os.Remove(path)
+	c.audit(auditConfigDelete, website, path, nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to remove adopted configuration file")
+	}
+	c.event(website, corev1.EventTypeNormal, "Adopted", "The configuration file "+path+" was taken over")
+	return nil
+}
+
+// configMismatch compares two nginx configurations token by token, ignoring comments and
+// whitespace, and describes the first difference. It returns "" if they are equivalent.
+func configMismatch(actual, expected string) string {
+	a, e := configTokens(actual), configTokens(expected)
+	for i := 0; i < len(a) || i < len(e); i++ {
+		switch {
+		case i >= len(a):
+			return fmt.Sprintf("it ends where %q is expected", e[i])
+		case i >= len(e):
+			return fmt.Sprintf("it has %q where the configuration ends", a[i])
+		case a[i] != e[i]:
+			return fmt.Sprintf("it has %q where %q is expected", a[i], e[i])
+		}
+	}
+	return ""
+}
+
+// configTokens splits an nginx configuration into its words and ; { } separators, dropping
+// comments.
+func configTokens(config string) []string {
+	var tokens []string
+	for _, line := range strings.Split(config, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.NewReplacer(";", " ; ", "{", " { ", "}", " } ").Replace(line)
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	return tokens
+}
+
+// ingressRoutesMismatch compares the routes of an Ingress a Website adopts with the routes of
+// the Website's Ingress, ignoring the backends, and describes the first difference. It
+// returns "" if they route the same hosts and paths and terminate TLS for the same hosts.
+func ingressRoutesMismatch(actual, expected *networkingv1.Ingress) string {
+	routes := func(ingress *networkingv1.Ingress) []string {
+		var routes []string
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				pathType := ""
+				if path.PathType != nil {
+					pathType = string(*path.PathType)
+				}
+				routes = append(routes, fmt.Sprintf("%s%s (%s)", rule.Host, path.Path, pathType))
+			}
+		}
+		return routes
+	}
+	tlsHosts := func(ingress *networkingv1.Ingress) []string {
+		var hosts []string
+		for _, tls := range ingress.Spec.TLS {
+			hosts = append(hosts, tls.Hosts...)
+		}
+		return hosts
+	}
+
+	if a, e := routes(actual), routes(expected); !reflect.DeepEqual(a, e) {
+		return fmt.Sprintf("it routes %s where %s is expected", strings.Join(a, ", "), strings.Join(e, ", "))
+	}
+	if a, e := tlsHosts(actual), tlsHosts(expected); !reflect.DeepEqual(a, e) {
+		return fmt.Sprintf("it terminates TLS for %v where %v is expected", a, e)
+	}
+	return ""
+}
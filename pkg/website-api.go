@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// AppliedConfig describes the configuration of a Website currently loaded in nginx.
+type AppliedConfig struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Generation int64     `json:"generation"`
+	SHA256     string    `json:"sha256"`
+	Time       time.Time `json:"time"`
+
+	// includes is the digest of the files the configuration includes when nginx was reloaded.
+	includes string
+}
+
+// recordApplied remembers the configuration of a Website nginx was reloaded with.
+func (c *WebsiteController) recordApplied(website *v1alpha1.Website, config []byte) {
+	sum := sha256.Sum256(config)
+
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	c.applied[websiteKey(website)] = AppliedConfig{
+		Namespace:  website.Namespace,
+		Name:       website.Name,
+		Generation: website.Generation,
+		SHA256:     hex.EncodeToString(sum[:]),
+		Time:       time.Now().UTC(),
+		includes:   c.includesDigest(website),
+	}
+	managedWebsites.Set(float64(len(c.applied)))
+}
+
+// configServed reports whether nginx was reloaded with a Website's configuration and the file
+// on disk still holds it, and the files it includes are unchanged since, so writing it again
+// and reloading would change nothing.
+func (c *WebsiteController) configServed(website *v1alpha1.Website, config string) bool {
+	existing, err := ioutil.ReadFile(nginxConfigPath(website))
+	if err != nil || string(existing) != config {
+		return false
+	}
+	sum := sha256.Sum256([]byte(config))
+	includes := c.includesDigest(website)
+
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	applied, ok := c.applied[websiteKey(website)]
+	return ok && applied.SHA256 == hex.EncodeToString(sum[:]) && applied.includes == includes
+}
+
+// includesDigest hashes the files a Website's configuration includes: its secret material,
+// transform scripts, redirect map and the signing script. nginx only reads them on reload, so
+// a change to any of them needs one even if the configuration itself is unchanged.
+func (c *WebsiteController) includesDigest(website *v1alpha1.Website) string {
+	var paths []string
+	for _, dir := range []string{c.secretDir(website), filepath.Join(njsDir, websiteIdentifier(website))} {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	paths = append(paths, redirectMapPath(website))
+	if upstreamSigning(website) != nil {
+		paths = append(paths, signingScriptPath)
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", path, len(content))
+		hash.Write(content)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// forgetApplied forgets the configuration of a deleted Website.
+func (c *WebsiteController) forgetApplied(website *v1alpha1.Website) {
+	c.appliedMu.Lock()
+	defer c.appliedMu.Unlock()
+
+	delete(c.applied, websiteKey(website))
+	managedWebsites.Set(float64(len(c.applied)))
+}
+
+// serveAPI serves the controller API until the context is cancelled:
+//
+//	GET /api/websites/<namespace>/<name>/applied  returns the AppliedConfig of a Website
+//	GET /api/websites/<namespace>/<name>/tail     streams the access log of a Website
+//
+// Requests must carry a Kubernetes bearer token allowed to get the Website.
+func (c *WebsiteController) serveAPI(ctx context.Context, address string) error {
+	// Log requests for the tail endpoint
+	err := c.enableTailLog()
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/websites/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/websites/"), "/")
+		if len(parts) != 3 || (parts[2] != "applied" && parts[2] != "tail") {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		namespace, name := parts[0], parts[1]
+
+		// Only callers allowed to get the Website may see what is applied or its traffic
+		status, err := c.authorizeAPI(r, "get", namespace, name)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if parts[2] == "tail" {
+			c.serveTail(w, r, namespace, name)
+			return
+		}
+
+		c.appliedMu.Lock()
+		applied, ok := c.applied[namespace+"/"+name]
+		c.appliedMu.Unlock()
+		if !ok {
+			http.Error(w, "no configuration of the Website is applied", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(applied)
+	})
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	err = server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// authorizeAPI authenticates the bearer token of a request with a TokenReview and checks with
+// a SubjectAccessReview that its user may perform a verb on a Website. It returns the HTTP
+// status to respond with if the request is not allowed.
+func (c *WebsiteController) authorizeAPI(r *http.Request, verb, namespace, name string) (int, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+
+	// Authenticate the token
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	err := c.client.Create(r.Context(), review)
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "failed to review token")
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, errors.New("invalid bearer token")
+	}
+
+	// Authorize the user
+	user := review.Status.User
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	access := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		Extra:  extra,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      verb,
+			Group:     v1alpha1.GroupVersion.Group,
+			Resource:  "websites",
+			Name:      name,
+		},
+	}}
+	err = c.client.Create(r.Context(), access)
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "failed to review access")
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, errors.Errorf("%s may not %s Website %s/%s", user.Username, verb, namespace, name)
+	}
+
+	return http.StatusOK, nil
+}
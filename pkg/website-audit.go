@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// Audit actions recorded by the controller.
+const (
+	auditConfigWrite  = "config-write"
+	auditConfigDelete = "config-delete"
+	auditNginxReload  = "nginx-reload"
+	auditStatusUpdate = "status-update"
+	auditObjectApply  = "object-apply"
+)
+
+// AuditRecord is a single entry of the audit log.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Namespace string    `json:"namespace,omitempty"`
+	Website   string    `json:"website,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditSink persists audit records.
+type auditSink interface {
+	Write(record AuditRecord) error
+}
+
+// newAuditSink creates the sink for an audit log destination: an http(s) URL records are
+// posted to, or a file path records are appended to. An empty destination disables auditing.
+func newAuditSink(destination string) (auditSink, error) {
+	switch {
+	case destination == "":
+		return nil, nil
+	case strings.HasPrefix(destination, "http://") || strings.HasPrefix(destination, "https://"):
+		return &webhookAuditSink{url: destination, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	default:
+		file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open audit log")
+		}
+		return &fileAuditSink{file: file}, nil
+	}
+}
+
+// fileAuditSink appends audit records to a file as JSON lines.
+type fileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Write appends a record and syncs it to disk.
+func (s *fileAuditSink) Write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal audit record")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.file.Write(append(line, '\n'))
+	if err != nil {
+		return errors.Wrap(err, "failed to write audit record")
+	}
+
+	return s.file.Sync()
+}
+
+// webhookAuditSink posts audit records to a URL as JSON.
+type webhookAuditSink struct {
+	url    string
+	client *http.Client
+}
+
+// Write posts a record.
+func (s *webhookAuditSink) Write(record AuditRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal audit record")
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to post audit record")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("audit webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// audit records a mutation performed on behalf of a Website. Failing to record it is logged,
+// but does not fail the mutation, which has already happened.
+func (c *WebsiteController) audit(action string, website *v1alpha1.Website, path string, content []byte, cause error) {
+	if c.auditSink == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:   time.Now().UTC(),
+		Actor:  c.actor,
+		Action: action,
+		Path:   path,
+	}
+	if website != nil {
+		record.Namespace = website.Namespace
+		record.Website = website.Name
+	}
+	if content != nil {
+		sum := sha256.Sum256(content)
+		record.SHA256 = hex.EncodeToString(sum[:])
+	}
+	if cause != nil {
+		record.Error = cause.Error()
+	}
+
+	err := c.auditSink.Write(record)
+	if err != nil {
+		c.log.Error(err, "failed to write audit record", "action", action, "path", path)
+	}
+}
+
+// controllerActor identifies this controller instance in audit records.
+func controllerActor() string {
+	name := os.Getenv("POD_NAME")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return "website-controller/" + name
+}
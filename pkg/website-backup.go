@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sort"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// BackupReport is the output of the backup command, a List the objects are restored from with
+// kubectl apply.
+type BackupReport struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Items      []client.Object `json:"items"`
+}
+
+// backupCommand prints the Websites, WebsiteMiddlewares and StreamRoutes of a namespace, or all
+// namespaces, without their status and the metadata the API server sets, so they can be
+// restored into another cluster.
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	namespace := flags.String("namespace", "", "namespace of the objects, all namespaces if empty")
+	output := flags.String("o", cli.OutputYAML, "output format of the backup: json or yaml")
+	_, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCommandClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	ctx := context.Background()
+	report := BackupReport{APIVersion: "v1", Kind: "List", Items: []client.Object{}}
+
+	// Collect the objects, each kind sorted by key
+	websites := &v1alpha1.WebsiteList{}
+	err = c.List(ctx, websites, client.InNamespace(*namespace))
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+	sort.Slice(websites.Items, func(i, j int) bool {
+		return objectKey(&websites.Items[i]) < objectKey(&websites.Items[j])
+	})
+	for i := range websites.Items {
+		website := &websites.Items[i]
+		website.Status = v1alpha1.WebsiteStatus{}
+		report.Items = append(report.Items, backupObject(website, "Website"))
+	}
+	middleware := &v1alpha1.WebsiteMiddlewareList{}
+	err = c.List(ctx, middleware, client.InNamespace(*namespace))
+	if err != nil {
+		return errors.Wrap(err, "failed to list WebsiteMiddlewares")
+	}
+	sort.Slice(middleware.Items, func(i, j int) bool {
+		return objectKey(&middleware.Items[i]) < objectKey(&middleware.Items[j])
+	})
+	for i := range middleware.Items {
+		report.Items = append(report.Items, backupObject(&middleware.Items[i], "WebsiteMiddleware"))
+	}
+	routes := &v1alpha1.StreamRouteList{}
+	err = c.List(ctx, routes, client.InNamespace(*namespace))
+	if err != nil {
+		return errors.Wrap(err, "failed to list StreamRoutes")
+	}
+	sort.Slice(routes.Items, func(i, j int) bool {
+		return objectKey(&routes.Items[i]) < objectKey(&routes.Items[j])
+	})
+	for i := range routes.Items {
+		route := &routes.Items[i]
+		route.Status = v1alpha1.StreamRouteStatus{}
+		report.Items = append(report.Items, backupObject(route, "StreamRoute"))
+	}
+
+	return cli.PrintReport(*output, report)
+}
+
+// backupObject sets the kind of an object, which lists leave out, and clears the metadata the
+// API server and the controller set.
+func backupObject(obj client.Object, kind string) client.Object {
+	obj.GetObjectKind().SetGroupVersionKind(v1alpha1.GroupVersion.WithKind(kind))
+	obj.SetUID("")
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetManagedFields(nil)
+	obj.SetFinalizers(nil)
+	return obj
+}
+
+// objectKey returns the namespace/name key of an object.
+func objectKey(obj client.Object) string {
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
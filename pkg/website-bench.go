@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+const (
+	// benchLabel marks the Websites of a benchmark run, with the run's id as value.
+	benchLabel = "website-operator.io/bench"
+
+	// benchReloadMetric and benchRequestMetric are scraped from the controller's metrics
+	// endpoint to count nginx reloads and API server requests.
+	benchReloadMetric  = "website_controller_nginx_reload_duration_seconds_count"
+	benchRequestMetric = "rest_client_requests_total"
+)
+
+// BenchReport is the outcome of a benchmark run.
+type BenchReport struct {
+	Run       string `json:"run"`
+	Websites  int    `json:"websites"`
+	Converged int    `json:"converged"`
+	TimedOut  int    `json:"timedOut"`
+
+	// CreateDuration is how long creating all Websites took.
+	CreateDuration string `json:"createDuration"`
+
+	// Convergence are the percentiles of the time from creating a Website until it was
+	// observed Ready for its generation.
+	Convergence BenchPercentiles `json:"convergence"`
+
+	// Reloads counts the distinct reloads recorded in the status of the Websites, or the
+	// reloads reported by the controller's metrics if they are scraped.
+	Reloads int64 `json:"reloads"`
+
+	// APIRequests counts the API server requests the controller made during the run. It is
+	// only reported if the controller's metrics are scraped.
+	APIRequests *int64 `json:"apiRequests,omitempty"`
+}
+
+// BenchPercentiles summarizes a distribution of durations.
+type BenchPercentiles struct {
+	P50 string `json:"p50"`
+	P90 string `json:"p90"`
+	P99 string `json:"p99"`
+	Max string `json:"max"`
+}
+
+// benchCommand creates synthetic Websites, waits for the controller to converge on them and
+// reports how long that took, so queueing and reload changes can be validated at scale.
+func benchCommand(args []string) error {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	count := flags.Int("websites", 100, "number of synthetic Websites to create")
+	namespace := flags.String("namespace", "website-bench", "existing namespace the Websites are created in")
+	upstream := flags.String("upstream", "http://127.0.0.1:8080", "upstream of the synthetic Websites")
+	concurrency := flags.Int("concurrency", 20, "number of Websites created in parallel")
+	timeout := flags.Duration("timeout", 10*time.Minute, "how long to wait for the Websites to converge")
+	poll := flags.Duration("poll", 250*time.Millisecond, "how often the Websites are checked for convergence")
+	metricsURL := flags.String("metrics-url", "", "metrics endpoint of the controller, to count its reloads and API requests")
+	cleanup := flags.Bool("cleanup", true, "delete the Websites after the run")
+	output := flags.String("o", cli.OutputJSON, "output format of the report: json or yaml")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCommandClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	ctx := context.Background()
+	run := strconv.FormatInt(time.Now().Unix(), 36)
+	report := BenchReport{Run: run, Websites: *count}
+
+	// Sample the controller before the run
+	var before map[string]float64
+	if *metricsURL != "" {
+		before, err = scrapeBenchMetrics(*metricsURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create the Websites
+	created, createDuration, err := createBenchWebsites(ctx, c, run, *namespace, *upstream, *count, *concurrency)
+	if *cleanup {
+		defer deleteBenchWebsites(ctx, c, run, *namespace)
+	}
+	if err != nil {
+		return err
+	}
+	report.CreateDuration = createDuration.String()
+
+	// Wait for the Websites to converge
+	converged, reloads, err := waitForBenchWebsites(ctx, c, run, *namespace, created, *timeout, *poll)
+	if err != nil {
+		return err
+	}
+	report.Converged = len(converged)
+	report.TimedOut = *count - len(converged)
+	report.Convergence = benchPercentiles(converged)
+	report.Reloads = reloads
+
+	// Sample the controller after the run
+	if *metricsURL != "" {
+		after, err := scrapeBenchMetrics(*metricsURL)
+		if err != nil {
+			return err
+		}
+		report.Reloads = int64(after[benchReloadMetric] - before[benchReloadMetric])
+		requests := int64(after[benchRequestMetric] - before[benchRequestMetric])
+		report.APIRequests = &requests
+	}
+
+	err = cli.PrintReport(*output, report)
+	if err != nil {
+		return err
+	}
+	if report.TimedOut > 0 {
+		return errors.Errorf("%d Websites did not converge within %s", report.TimedOut, *timeout)
+	}
+	return nil
+}
+
+// createBenchWebsites creates the synthetic Websites of a run and returns when each of them
+// was created, by name.
+func createBenchWebsites(ctx context.Context, c client.Client, run, namespace, upstream string, count, concurrency int) (map[string]time.Time, time.Duration, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	created := map[string]time.Time{}
+	names := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range names {
+				name := fmt.Sprintf("bench-%s-%d", run, i)
+				website := &v1alpha1.Website{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: namespace,
+						Name:      name,
+						Labels:    map[string]string{benchLabel: run},
+					},
+					Spec: v1alpha1.WebsiteSpec{
+						Hostname: fmt.Sprintf("%s.bench.invalid", name),
+						Upstream: upstream,
+					},
+				}
+				err := c.Create(ctx, website)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = errors.Wrapf(err, "failed to create Website %s", name)
+				} else if err == nil {
+					created[name] = time.Now()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		names <- i
+	}
+	close(names)
+	wg.Wait()
+
+	return created, time.Since(start), firstErr
+}
+
+// waitForBenchWebsites polls the Websites of a run until all of them are Ready for their
+// generation or the timeout expires. It returns the convergence time of every converged
+// Website and the number of distinct reloads recorded in their status.
+func waitForBenchWebsites(ctx context.Context, c client.Client, run, namespace string, created map[string]time.Time, timeout, poll time.Duration) ([]time.Duration, int64, error) {
+	converged := map[string]time.Duration{}
+	reloads := map[time.Time]bool{}
+	deadline := time.Now().Add(timeout)
+	for len(converged) < len(created) && time.Now().Before(deadline) {
+		list := &v1alpha1.WebsiteList{}
+		err := c.List(ctx, list, client.InNamespace(namespace), client.MatchingLabels{benchLabel: run})
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to list Websites")
+		}
+		now := time.Now()
+		for i := range list.Items {
+			website := &list.Items[i]
+			if reload := website.Status.LastReload; reload != nil {
+				reloads[reload.Time.Time] = true
+			}
+			createdAt, ok := created[website.Name]
+			if _, done := converged[website.Name]; !ok || done {
+				continue
+			}
+			ready := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionReady)
+			if ready != nil && ready.Status == metav1.ConditionTrue && ready.ObservedGeneration == website.Generation {
+				converged[website.Name] = now.Sub(createdAt)
+			}
+		}
+		time.Sleep(poll)
+	}
+
+	durations := make([]time.Duration, 0, len(converged))
+	for _, d := range converged {
+		durations = append(durations, d)
+	}
+	return durations, int64(len(reloads)), nil
+}
+
+// deleteBenchWebsites deletes the Websites of a run.
+func deleteBenchWebsites(ctx context.Context, c client.Client, run, namespace string) {
+	err := c.DeleteAllOf(ctx, &v1alpha1.Website{}, client.InNamespace(namespace), client.MatchingLabels{benchLabel: run})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to delete benchmark Websites:", err)
+	}
+}
+
+// benchPercentiles computes the percentiles of durations with the nearest-rank method.
+func benchPercentiles(durations []time.Duration) BenchPercentiles {
+	if len(durations) == 0 {
+		return BenchPercentiles{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	rank := func(p float64) string {
+		i := int(math.Ceil(p*float64(len(durations)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return durations[i].String()
+	}
+	return BenchPercentiles{P50: rank(0.5), P90: rank(0.9), P99: rank(0.99), Max: rank(1)}
+}
+
+// scrapeBenchMetrics reads the counters the benchmark reports from a Prometheus text
+// endpoint, summed over their labels.
+func scrapeBenchMetrics(url string) (map[string]float64, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scrape controller metrics")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("failed to scrape controller metrics: %s", resp.Status)
+	}
+
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Samples are "name{labels} value [timestamp]", and label values may contain spaces
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ "); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		if name != benchReloadMetric && name != benchRequestMetric {
+			continue
+		}
+		if i := strings.LastIndexByte(rest, '}'); i >= 0 {
+			rest = rest[i+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		metrics[name] += value
+	}
+	return metrics, scanner.Err()
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/controller/util"
+)
+
+// watchBundles watches for WebsiteBundle objects and applies their Websites. The Websites of a
+// deleted bundle are garbage collected through their owner references.
+func (c *WebsiteController) watchBundles(ctx context.Context) error {
+	w := util.NewWatch(ctx, &v1alpha1.WebsiteBundle{})
+
+	err := w.Watch(func(event watch.Event) error {
+		bundle, ok := event.Object.(*v1alpha1.WebsiteBundle)
+		if !ok {
+			return errors.Errorf("object is not a WebsiteBundle: %T", event.Object)
+		}
+		if event.Type == watch.Deleted {
+			return nil
+		}
+
+		err := c.applyBundle(ctx, bundle)
+		if err != nil {
+			c.log.Error(err, "failed to apply WebsiteBundle", "namespace", bundle.Namespace, "name", bundle.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch for WebsiteBundle objects")
+	}
+
+	return nil
+}
+
+// applyBundle validates all Websites of a bundle and applies them only if every one of them is
+// valid. Websites removed from the bundle are deleted. The outcome is recorded in the bundle's
+// Applied condition.
+func (c *WebsiteController) applyBundle(ctx context.Context, bundle *v1alpha1.WebsiteBundle) error {
+	condition := metav1.Condition{
+		Type:               "Applied",
+		Status:             metav1.ConditionTrue,
+		Reason:             "Applied",
+		Message:            fmt.Sprintf("%d Websites are applied", len(bundle.Spec.Websites)),
+		ObservedGeneration: bundle.Generation,
+	}
+
+	// Apply nothing unless the whole bundle is valid
+	websites := bundleWebsites(bundle)
+	problems := c.validateBundle(ctx, bundle, websites)
+	var applyErr error
+	if len(problems) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = "No Website is applied: " + strings.Join(problems, "; ")
+	} else {
+		applyErr = c.applyBundleWebsites(ctx, bundle, websites)
+		if applyErr != nil {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "ApplyFailed"
+			condition.Message = applyErr.Error()
+		}
+	}
+
+	// Record the outcome
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &v1alpha1.WebsiteBundle{}
+		err := c.client.Get(ctx, types.NamespacedName{Namespace: bundle.Namespace, Name: bundle.Name}, latest)
+		if err != nil {
+			return err
+		}
+		latest.Status.ObservedGeneration = bundle.Generation
+		meta.SetStatusCondition(&latest.Status.Conditions, condition)
+		return c.client.Status().Update(ctx, latest)
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to update WebsiteBundle status")
+	}
+
+	return applyErr
+}
+
+// bundleWebsites returns the Websites of a bundle, owned by the bundle.
+func bundleWebsites(bundle *v1alpha1.WebsiteBundle) []*v1alpha1.Website {
+	owner := []metav1.OwnerReference{*metav1.NewControllerRef(bundle, v1alpha1.GroupVersion.WithKind("WebsiteBundle"))}
+	websites := make([]*v1alpha1.Website, 0, len(bundle.Spec.Websites))
+	for _, template := range bundle.Spec.Websites {
+		websites = append(websites, &v1alpha1.Website{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       bundle.Namespace,
+				Name:            template.Name,
+				Labels:          template.Labels,
+				OwnerReferences: owner,
+			},
+			Spec: *template.Spec.DeepCopy(),
+		})
+	}
+	return websites
+}
+
+// validateBundle returns the problems of a bundle's Websites: duplicate names, overlapping
+// routes, Websites that do not render, and names taken by Websites the bundle does not own.
+func (c *WebsiteController) validateBundle(ctx context.Context, bundle *v1alpha1.WebsiteBundle, websites []*v1alpha1.Website) []string {
+	var problems []string
+	names := map[string]bool{}
+	routes := map[string]string{}
+	for _, website := range websites {
+		if names[website.Name] {
+			problems = append(problems, fmt.Sprintf("%s: duplicate name", website.Name))
+			continue
+		}
+		names[website.Name] = true
+
+		// Render the Website as this controller would
+		rendered, err := applyOverlay(website, c.environment)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", website.Name, err))
+			continue
+		}
+		for route, name := range routes {
+			if routesOverlap(route, websiteRoute(rendered)) {
+				problems = append(problems, fmt.Sprintf("%s: route %s overlaps Website %s", website.Name, websiteRoute(rendered), name))
+			}
+		}
+		routes[websiteRoute(rendered)] = website.Name
+		if servingMode(rendered) == v1alpha1.ServingModeNginx {
+			_, err = renderForDiff(ctx, c.client, rendered)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", website.Name, err))
+			}
+		}
+
+		// Refuse to take over Websites managed elsewhere
+		existing := &v1alpha1.Website{}
+		err = c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: website.Name}, existing)
+		if err == nil && !metav1.IsControlledBy(existing, bundle) {
+			problems = append(problems, fmt.Sprintf("%s: a Website of that name exists and is not managed by the bundle", website.Name))
+		}
+	}
+	return problems
+}
+
+// applyBundleWebsites creates or updates the Websites of a bundle and deletes the Websites
+// the bundle owns but no longer lists.
+func (c *WebsiteController) applyBundleWebsites(ctx context.Context, bundle *v1alpha1.WebsiteBundle, websites []*v1alpha1.Website) error {
+	names := map[string]bool{}
+	for _, website := range websites {
+		names[website.Name] = true
+
+		existing := &v1alpha1.Website{}
+		err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: website.Name}, existing)
+		switch {
+		case apierrors.IsNotFound(err):
+			err = c.client.Create(ctx, website)
+		case err == nil:
+			existing.Labels = website.Labels
+			existing.OwnerReferences = website.OwnerReferences
+			existing.Spec = website.Spec
+			err = c.client.Update(ctx, existing)
+		}
+		c.audit(auditObjectApply, website, "websites/"+websiteKey(website), nil, err)
+		if err != nil {
+			return errors.Wrapf(err, "failed to apply Website %s", website.Name)
+		}
+	}
+
+	// Delete the Websites removed from the bundle
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list, client.InNamespace(bundle.Namespace))
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+	for i := range list.Items {
+		website := &list.Items[i]
+		if names[website.Name] || !metav1.IsControlledBy(website, bundle) {
+			continue
+		}
+		err = c.client.Delete(ctx, website)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete Website %s", website.Name)
+		}
+	}
+
+	return nil
+}
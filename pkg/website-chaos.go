@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// chaosFaults holds the faults injected through the chaos API. A nil chaosFaults injects nothing.
+type chaosFaults struct {
+	mu               sync.Mutex
+	failReloads      int
+	renderDelay      time.Duration
+	secretCacheDrops int
+}
+
+// chaosState is the JSON representation of the injected faults.
+type chaosState struct {
+	FailReloads      int    `json:"failReloads"`
+	RenderDelay      string `json:"renderDelay"`
+	SecretCacheDrops int    `json:"secretCacheDrops"`
+}
+
+// failReload reports whether the next reload should fail, consuming one injected failure.
+func (f *chaosFaults) failReload() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failReloads == 0 {
+		return false
+	}
+	f.failReloads--
+	return true
+}
+
+// delayRender sleeps for the injected render delay.
+func (f *chaosFaults) delayRender() {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	delay := f.renderDelay
+	f.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// state returns the injected faults.
+func (f *chaosFaults) state() chaosState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return chaosState{
+		FailReloads:      f.failReloads,
+		RenderDelay:      f.renderDelay.String(),
+		SecretCacheDrops: f.secretCacheDrops,
+	}
+}
+
+// serveChaos serves the chaos API until the context is cancelled. It is meant for chaos tests
+// in staging and must never be enabled in production:
+//
+//	GET    /chaos                          returns the injected faults
+//	POST   /chaos/fail-next-reload?count=N fails the next N nginx reloads
+//	POST   /chaos/render-delay?duration=D  delays every configuration render by D
+//	POST   /chaos/drop-secret-cache        drops the cached Vault tokens
+//	DELETE /chaos                          removes all faults
+func (c *WebsiteController) serveChaos(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chaos", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			c.chaos.mu.Lock()
+			c.chaos.failReloads = 0
+			c.chaos.renderDelay = 0
+			c.chaos.mu.Unlock()
+			c.log.Info("chaos faults removed")
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.writeChaosState(w)
+	})
+	mux.HandleFunc("/chaos/fail-next-reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		count := 1
+		if value := r.URL.Query().Get("count"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid count", http.StatusBadRequest)
+				return
+			}
+			count = n
+		}
+		c.chaos.mu.Lock()
+		c.chaos.failReloads = count
+		c.chaos.mu.Unlock()
+		c.log.Info("chaos: failing next reloads", "count", count)
+		c.writeChaosState(w)
+	})
+	mux.HandleFunc("/chaos/render-delay", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		delay, err := time.ParseDuration(r.URL.Query().Get("duration"))
+		if err != nil || delay < 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		c.chaos.mu.Lock()
+		c.chaos.renderDelay = delay
+		c.chaos.mu.Unlock()
+		c.log.Info("chaos: delaying renders", "delay", delay)
+		c.writeChaosState(w)
+	})
+	mux.HandleFunc("/chaos/drop-secret-cache", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if c.vault != nil {
+			c.vault.mu.Lock()
+			c.vault.tokens = map[string]vaultToken{}
+			c.vault.mu.Unlock()
+		}
+		c.chaos.mu.Lock()
+		c.chaos.secretCacheDrops++
+		c.chaos.mu.Unlock()
+		c.log.Info("chaos: dropped secret cache")
+		c.writeChaosState(w)
+	})
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	c.log.Info("chaos API enabled, do not use in production", "address", address)
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// writeChaosState writes the injected faults as JSON.
+func (c *WebsiteController) writeChaosState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.chaos.state())
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// command is a subcommand of the website-controller binary.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+// commands are the subcommands of the website-controller binary, by name.
+var commands = map[string]command{
+	"backup":   {"backup [--namespace=NS] [-o json|yaml]       print the Websites, WebsiteMiddlewares and StreamRoutes to restore them", backupCommand},
+	"bench":    {"bench [--websites=N] [--metrics-url=URL] [-o json|yaml]  measure how fast the controller converges on synthetic Websites", benchCommand},
+	"diff":     {"diff --ref=REF [--repo=DIR] [--path=DIR] [-o json|yaml]  diff Websites in a Git revision against the cluster", diffCommand},
+	"migrate":  {"migrate [--dir=DIR] [--namespace=NS] [-o json|yaml]  turn hand-written nginx configuration files into Websites adopting them", migrateCommand},
+	"render":   {"render -f FILE [-o json|yaml]                render the nginx configuration of the Websites of a manifest", renderCommand},
+	"serve":    {"serve [--source=cluster|dir:PATH]            run the controller", serveCommand},
+	"status":   {"status [--namespace=NS] [-o json|yaml]       list Websites and their readiness", statusCommand},
+	"validate": {"validate -f FILE [-o json|yaml]              test the configuration of the Websites of a manifest with nginx -t", validateCommand},
+	"wait":     {"wait <namespace>/<name> [--for=Ready] [--timeout=2m] [-o json|yaml]  wait for a condition of a Website for its generation", waitCommand},
+}
+
+// runCommand runs a subcommand and returns the exit code of the process.
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage()
+		return 2
+	}
+
+	err := cmd.run(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+// printUsage lists the subcommands.
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: website-controller <command> [flags]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[name].usage)
+	}
+}
+
+// newCommandClient creates a client for the cluster of the current kubeconfig context.
+func newCommandClient() (client.WithWatch, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := runtime.NewScheme()
+	err = clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	err = v1alpha1.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.NewWithWatch(cfg, client.Options{Scheme: scheme})
+}
+
+// newRenderer creates a WebsiteController that is only used to render configurations.
+// It does not touch nginx, the filesystem or the cluster.
+func newRenderer() *WebsiteController {
+	return &WebsiteController{
+		log:             logr.Discard(),
+		secretsDir:      defaultSecretsDir,
+		vaultTracked:    map[string]*v1alpha1.Website{},
+		otelEndpoints:   map[string]string{},
+		middleware:      map[string][]v1alpha1.WebsiteMiddlewareSpec{},
+		hostnames:       newHostnameRegistry(),
+		renders:         &renderCache{entries: map[string]renderEntry{}},
+		templates:       map[string]*serverTemplate{},
+		templateRenders: map[string]*templateRender{},
+	}
+}
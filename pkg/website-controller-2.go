@@ -4,191 +4,1588 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.k8s.io/apimachinery/pkg/api/errors"
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/website-operator/pkg/apis/website/v1alpha1"
-	"github.com/website-operator/pkg/controller/util"
 )
 
 // WebsiteController watches for Website objects and creates Nginx servers for each of them.
 type WebsiteController struct {
-	log logr.Logger
+	log       logr.Logger
+	client    client.Client
+	queue     *websiteQueue
+	auditSink auditSink
+	actor     string
+
+	reusePortMu    sync.Mutex
+	reusePortOwner string
+
+	vault        *vaultClient
+	secretsDir   string
+	secretsGroup int
+	vaultMu      sync.Mutex
+	vaultTracked map[string]*v1alpha1.Website
+
+	otelMu        sync.Mutex
+	otelEndpoints map[string]string
+
+	middlewareMu sync.Mutex
+	middleware   map[string][]v1alpha1.WebsiteMiddlewareSpec
+
+	hostnames     *hostnameRegistry
+	deletionGuard *deletionGuard
+
+	rollouts      *rolloutTracker
+	rolloutMu     sync.Mutex
+	rolloutName   string
+	rolloutCancel context.CancelFunc
+	globalSSL     string
+
+	statusPageDomain string
+
+	usageEndpoint string
+	usageInterval time.Duration
+
+	elected   <-chan struct{}
+	standbyMu sync.Mutex
+	standby   map[string]*standbyEntry
+
+	chaosAddress string
+	chaos        *chaosFaults
+
+	metricsAddress string
+
+	healthAddress string
+	healthMu      sync.Mutex
+	listed        bool
+	watchStalled  error
+
+	webhookAddress  string
+	webhookCertFile string
+	webhookKeyFile  string
+
+	deniedUpstreamCIDRs []*net.IPNet
+
+	deploymentImage string
+
+	remediations map[string]string
+
+	resyncInterval time.Duration
+
+	features map[string]bool
+
+	tenantRoot      string
+	tenantDiskQuota int64
+
+	acmeDirectoryURL string
+	acmeEmail        string
+	acmeWebroot      string
+	acmeMu           sync.Mutex
+	acmeOrders       map[string]*acmeOrder
+	acmeAccountMu    sync.Mutex
+	acmeClient       *acme.Client
+
+	failoverPeerURL     string
+	failoverLocalTarget string
+	failoverPeerTarget  string
+	failoverDNS         dnsProvider
+
+	class string
+
+	quarantineThreshold int
+	quarantineMu        sync.Mutex
+	nginxFailures       map[string]*nginxFailures
+
+	templateConfigMap types.NamespacedName
+	templateMu        sync.Mutex
+	templates         map[string]*serverTemplate
+	templateRenders   map[string]*templateRender
+
+	capabilities *nginxCapabilities
+
+	sourceDir string
+
+	apiAddress string
+	appliedMu  sync.Mutex
+	applied    map[string]AppliedConfig
+
+	environment string
+
+	spiffeDir string
+
+	latencyMu      sync.Mutex
+	latencyWeights map[string]map[string]int32
+
+	expiryMu     sync.Mutex
+	expiryTimers map[string]*time.Timer
+
+	certificateMu    sync.Mutex
+	certificatePolls map[string]*time.Timer
+
+	upstreamProtocolMu sync.Mutex
+	upstreamProtocols  map[string]string
+
+	renders *renderCache
+
+	stateDir     string
+	nginxPidFile string
+	nginxBinary  string
+
+	streamMu sync.Mutex
+
+	restConfig *rest.Config
+	recorder   record.EventRecorder
+
+	reloads *reloadBatcher
+}
+
+// WebsiteControllerOptions configures a WebsiteController.
+type WebsiteControllerOptions struct {
+	// AuditLog is a file path or http(s) URL every mutation performed by the controller
+	// is recorded to. Auditing is disabled if it is empty.
+	AuditLog string
+
+	// VaultAddress is the address of the Vault server TLS and basic auth material is read from.
+	// Websites referencing Vault fail to reconcile if it is empty.
+	VaultAddress string
+
+	// SecretsDir is the tmpfs directory secret material is written to.
+	SecretsDir string
+
+	// SecretsGroup is the group the secret material is readable by, which nginx must run as or
+	// be a member of, e.g. through the Pod's fsGroup; nginx workers read the basic auth users at
+	// request time. The controller's own group is used if it is 0.
+	SecretsGroup int
+
+	// StatusPageDomain enables a generated status page per namespace, served under
+	// status-<namespace>.<StatusPageDomain>. Status pages are disabled if it is empty.
+	StatusPageDomain string
+
+	// MassDeletionPercent holds back Website deletions once more than this percentage of the
+	// Websites was deleted within MassDeletionWindow, until the deletions are acknowledged.
+	// The guard is disabled if it is 0.
+	MassDeletionPercent int
+
+	// MassDeletionWindow is the window deletions are counted in. It defaults to 5 minutes.
+	MassDeletionWindow time.Duration
+
+	// UsageEndpoint is an http(s) URL the requests and bytes served per Website are posted
+	// to once per UsageInterval, e.g. for chargeback. Usage accounting is disabled if it is empty.
+	UsageEndpoint string
+
+	// UsageInterval is the period of a usage report. It defaults to 1 hour.
+	UsageInterval time.Duration
+
+	// Elected is closed once the controller leads. Until then it runs as a warm standby,
+	// which renders the desired state of every Website without touching nginx, so it takes
+	// over without a full re-render. The controller leads right away if it is nil.
+	Elected <-chan struct{}
+
+	// ChaosAddress is the address the fault injection API for chaos tests is served on.
+	// It is a feature gate for staging environments: the API is disabled if it is empty.
+	ChaosAddress string
+
+	// MetricsAddress is the address the Prometheus metrics are served on at /metrics. They
+	// are not served if it is empty.
+	MetricsAddress string
+
+	// HealthAddress is the address the liveness and readiness probes are served on at /healthz
+	// and /readyz. They are not served if it is empty.
+	HealthAddress string
+
+	// WebhookAddress is the address the validating admission webhook for Websites is served on
+	// over TLS, with the certificate and key in WebhookCertFile and WebhookKeyFile. The webhook
+	// is disabled if it is empty.
+	WebhookAddress  string
+	WebhookCertFile string
+	WebhookKeyFile  string
+
+	// DeniedUpstreamCIDRs are the address ranges Websites may not proxy to, unless their
+	// Namespace allows them in the website-operator.io/allowed-upstream-cidrs annotation, e.g.
+	// the pod and service networks and the cloud metadata endpoints. No range is denied if it
+	// is empty.
+	DeniedUpstreamCIDRs []string
+
+	// DeploymentImage is the nginx image of the Deployments of Websites in Deployment mode. It
+	// defaults to nginx:alpine.
+	DeploymentImage string
+
+	// RemediationHintsFile is a YAML map of condition reasons to the runbook hints reported
+	// with failing conditions in status.remediations. It adds to and overrides the built-in hints.
+	RemediationHintsFile string
+
+	// SourceDir is a directory of Website manifests the controller runs from instead of the
+	// API server, e.g. on standalone edge VMs. The client must then be an in-memory client.
+	SourceDir string
+
+	// APIAddress is the address the controller API is served on, which tells deployment
+	// pipelines which configuration of a Website nginx has loaded. It is disabled if empty.
+	APIAddress string
+
+	// Environment selects the overlay of spec.overlays the Websites are rendered with.
+	// Websites are rendered without overlays if it is empty.
+	Environment string
+
+	// SPIFFEDir is the directory the X.509 SVIDs of spec.tls.spiffe are read from.
+	SPIFFEDir string
+
+	// StateDir is the writable directory the revision history and status pages are kept in,
+	// so the controller can run with a read-only root filesystem.
+	StateDir string
+
+	// NginxPidFile is the pid file of the nginx master process, which is signalled to reload.
+	NginxPidFile string
+
+	// RESTConfig connects the controller-runtime manager Websites are reconciled through.
+	// It is not needed when Websites are read from a directory.
+	RESTConfig *rest.Config
+
+	// EventRecorder emits events for the Websites. Events are not emitted if it is nil.
+	EventRecorder record.EventRecorder
+
+	// ReloadDebounce batches the nginx reloads requested within this window into one, e.g.
+	// while all Websites are reconciled after a restart. Every reload is immediate if it is 0.
+	ReloadDebounce time.Duration
+
+	// ResyncInterval is how often the configuration files of all Websites are checked for
+	// drift from what nginx was reloaded with, e.g. after a hand edit, and repaired. Drift is
+	// not checked if it is 0.
+	ResyncInterval time.Duration
+
+	// FeatureGates enables and disables the gated subsystems of the controller, as a
+	// comma-separated list of gate=bool pairs, e.g. "DeploymentMode=true". Gates not listed
+	// keep their default.
+	FeatureGates string
+
+	// TenantDir partitions the temporary files and error logs of the Websites into a directory
+	// per namespace below it, which nginx workers must be able to write to. The directories of
+	// deleted namespaces are removed. nginx's shared directories are used if it is empty.
+	TenantDir string
+
+	// TenantDiskQuota is the disk space in bytes a namespace's directory may use before its
+	// error logs are truncated. The usage is not limited if it is 0.
+	TenantDiskQuota int64
+
+	// ACMEDirectoryURL is the directory of the ACME CA the built-in ACME client issues the
+	// certificates of spec.tls.acme with. It defaults to Let's Encrypt.
+	ACMEDirectoryURL string
+
+	// ACMEEmail is the contact address of the controller's ACME account, which the CA sends
+	// expiry notices to.
+	ACMEEmail string
+
+	// ACMEWebroot is the directory the HTTP-01 challenge responses are written to and served
+	// from, which nginx workers must be able to read.
+	ACMEWebroot string
+
+	// FailoverPeerURL is the health check URL of the peer edge the Websites fail over to while
+	// the local edge is unhealthy. Failover is disabled if it is empty.
+	FailoverPeerURL string
+
+	// FailoverLocalTarget and FailoverPeerTarget are the addresses, IPs or hostnames, the DNS
+	// records of the Websites point at for the local and peer edge.
+	FailoverLocalTarget string
+	FailoverPeerTarget  string
+
+	// FailoverDNSProvider updates the weighted DNS records: "external-dns" for a DNSEndpoint
+	// per Website, or "webhook:URL" to post them to an endpoint.
+	FailoverDNSProvider string
+
+	// Class is the class of the Websites the controller serves, matched against their
+	// spec.className, so several controllers can run in one cluster. The controller serves the
+	// Websites without a class if it is empty.
+	Class string
+
+	// QuarantineThreshold is how many times in a row the configuration of a Website may fail
+	// nginx validation or reload before the Website is quarantined. Websites are never
+	// quarantined if it is negative.
+	QuarantineThreshold int
+
+	// TemplateConfigMap is the ConfigMap, as namespace/name, whose server.tmpl key holds the
+	// Go template the server blocks are rendered with instead of the built-in one. A Website's
+	// spec.templateRef replaces it with the TenantTemplates feature gate.
+	TemplateConfigMap string
 }
 
 // NewWebsiteController creates a new WebsiteController.
-func NewWebsiteController(log logr.Logger) *WebsiteController {
-	return &WebsiteController{log: log}
+func NewWebsiteController(log logr.Logger, client client.Client, options WebsiteControllerOptions) (*WebsiteController, error) {
+	// Open the audit log
+	sink, err := newAuditSink(options.AuditLog)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create audit sink")
+	}
+
+	// Parse the denied upstream ranges
+	deniedUpstreamCIDRs, err := parseCIDRs(options.DeniedUpstreamCIDRs)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid denied upstream CIDRs")
+	}
+
+	// Enable the gated subsystems
+	features, err := parseFeatureGates(options.FeatureGates)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid feature gates")
+	}
+	recordFeatureGates(features)
+
+	// Load the runbook hints of failing conditions
+	remediations, err := loadRemediations(options.RemediationHintsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &WebsiteController{
+		log:          log,
+		client:       client,
+		queue:        newWebsiteQueue(),
+		auditSink:    sink,
+		actor:        controllerActor(),
+		secretsDir:   options.SecretsDir,
+		secretsGroup: options.SecretsGroup,
+		vaultTracked: map[string]*v1alpha1.Website{},
+
+		otelEndpoints: map[string]string{},
+		middleware:    map[string][]v1alpha1.WebsiteMiddlewareSpec{},
+
+		hostnames: newHostnameRegistry(),
+		rollouts:  newRolloutTracker(),
+
+		statusPageDomain: options.StatusPageDomain,
+
+		usageEndpoint: options.UsageEndpoint,
+		usageInterval: options.UsageInterval,
+
+		elected: options.Elected,
+
+		chaosAddress: options.ChaosAddress,
+
+		metricsAddress: options.MetricsAddress,
+
+		healthAddress: options.HealthAddress,
+
+		webhookAddress:  options.WebhookAddress,
+		webhookCertFile: options.WebhookCertFile,
+		webhookKeyFile:  options.WebhookKeyFile,
+
+		deniedUpstreamCIDRs: deniedUpstreamCIDRs,
+
+		deploymentImage: options.DeploymentImage,
+
+		remediations: remediations,
+
+		resyncInterval: options.ResyncInterval,
+
+		features: features,
+
+		tenantRoot:      options.TenantDir,
+		tenantDiskQuota: options.TenantDiskQuota,
+
+		acmeDirectoryURL: options.ACMEDirectoryURL,
+		acmeEmail:        options.ACMEEmail,
+		acmeWebroot:      options.ACMEWebroot,
+		acmeOrders:       map[string]*acmeOrder{},
+
+		failoverPeerURL:     options.FailoverPeerURL,
+		failoverLocalTarget: options.FailoverLocalTarget,
+		failoverPeerTarget:  options.FailoverPeerTarget,
+
+		class: options.Class,
+
+		quarantineThreshold: options.QuarantineThreshold,
+		nginxFailures:       map[string]*nginxFailures{},
+
+		templates:       map[string]*serverTemplate{},
+		templateRenders: map[string]*templateRender{},
+
+		sourceDir: options.SourceDir,
+
+		apiAddress: options.APIAddress,
+		applied:    map[string]AppliedConfig{},
+
+		environment: options.Environment,
+
+		spiffeDir: options.SPIFFEDir,
+
+		latencyWeights:   map[string]map[string]int32{},
+		expiryTimers:     map[string]*time.Timer{},
+		certificatePolls: map[string]*time.Timer{},
+
+		upstreamProtocols: map[string]string{},
+
+		renders: &renderCache{entries: map[string]renderEntry{}},
+
+		stateDir:     options.StateDir,
+		nginxPidFile: options.NginxPidFile,
+
+		restConfig: options.RESTConfig,
+		recorder:   options.EventRecorder,
+	}
+	if c.chaosAddress != "" {
+		c.chaos = &chaosFaults{}
+	}
+	if c.elected != nil {
+		c.standby = map[string]*standbyEntry{}
+	}
+	window := options.MassDeletionWindow
+	if window == 0 {
+		window = 5 * time.Minute
+	}
+	c.deletionGuard = newDeletionGuard(options.MassDeletionPercent, window)
+	if c.usageInterval == 0 {
+		c.usageInterval = time.Hour
+	}
+	if c.secretsDir == "" {
+		c.secretsDir = defaultSecretsDir
+	}
+	if c.spiffeDir == "" {
+		c.spiffeDir = defaultSPIFFEDir
+	}
+	if c.stateDir == "" {
+		c.stateDir = defaultStateDir
+	}
+	if c.nginxPidFile == "" {
+		c.nginxPidFile = defaultNginxPidFile
+	}
+	if c.deploymentImage == "" {
+		c.deploymentImage = defaultDeploymentImage
+	}
+	if c.acmeDirectoryURL == "" {
+		c.acmeDirectoryURL = acme.LetsEncryptURL
+	}
+	if c.quarantineThreshold == 0 {
+		c.quarantineThreshold = defaultQuarantineThreshold
+	}
+	if c.acmeWebroot == "" {
+		c.acmeWebroot = defaultACMEWebroot
+	}
+	if options.TemplateConfigMap != "" {
+		parts := strings.SplitN(options.TemplateConfigMap, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid template ConfigMap %q, want namespace/name", options.TemplateConfigMap)
+		}
+		c.templateConfigMap = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+	if options.VaultAddress != "" {
+		c.vault = newVaultClient(options.VaultAddress)
+	}
+	if options.ReloadDebounce > 0 {
+		c.reloads = newReloadBatcher(options.ReloadDebounce, c.reloadWithRollback)
+	}
+	if c.failoverPeerURL != "" {
+		if c.failoverLocalTarget == "" || c.failoverPeerTarget == "" {
+			return nil, errors.New("failover requires the DNS targets of the local and peer edges")
+		}
+		c.failoverDNS, err = c.newDNSProvider(options.FailoverDNSProvider)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid failover DNS provider")
+		}
+	}
+
+	return c, nil
 }
 
 // Run starts the WebsiteController.
 func (c *WebsiteController) Run(ctx context.Context) error {
-	// Watch for Website objects
-	err := c.watch(ctx)
+	// Fail early if the state directory is not writable, e.g. not mounted on a read-only root filesystem
+	err := os.MkdirAll(c.stateDir, 0755)
 	if err != nil {
-		return errors.Wrap(err, "failed to watch for Website objects")
+		return errors.Wrap(err, "failed to create state directory")
 	}
 
-	return nil
-}
+	// Detect what nginx supports before any Website is handled
+	c.initCapabilities(ctx)
+	c.initValidation()
 
-// watch watches for Website objects.
-func (c *WebsiteController) watch(ctx context.Context) error {
-	// Create a new Website object
-	watch := util.NewWatch(ctx, &v1alpha1.Website{})
+	// Handle queued events in priority order
+	go c.processQueue(ctx)
 
-	// Watch for Website objects
-	err := watch.Watch(func(event watch.Event) error {
-		// Handle the event
-		return c.handleEvent(event)
-	})
+	// Serve the controller API
+	if c.apiAddress != "" {
+		go func() {
+			err := c.serveAPI(ctx, c.apiAddress)
+			if err != nil {
+				c.log.Error(err, "failed to serve API")
+			}
+		}()
+	}
+
+	// Serve the metrics
+	if c.metricsAddress != "" {
+		go func() {
+			err := c.serveMetrics(ctx, c.metricsAddress)
+			if err != nil {
+				c.log.Error(err, "failed to serve metrics")
+			}
+		}()
+	}
+
+	// Serve the liveness and readiness probes
+	if c.healthAddress != "" {
+		go func() {
+			err := c.serveHealth(ctx, c.healthAddress)
+			if err != nil {
+				c.log.Error(err, "failed to serve health probes")
+			}
+		}()
+	}
+
+	// Serve the validating admission webhook
+	if c.webhookAddress != "" {
+		go func() {
+			err := c.serveWebhook(ctx, c.webhookAddress, c.webhookCertFile, c.webhookKeyFile)
+			if err != nil {
+				c.log.Error(err, "failed to serve admission webhook")
+			}
+		}()
+	}
+
+	// Serve the fault injection API for chaos tests
+	if c.chaosAddress != "" {
+		go func() {
+			err := c.serveChaos(ctx, c.chaosAddress)
+			if err != nil {
+				c.log.Error(err, "failed to serve chaos API")
+			}
+		}()
+	}
+
+	// Start the background work once the controller leads
+	go func() {
+		if c.elected != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.elected:
+			}
+			c.takeover(ctx)
+		}
+		c.lead(ctx)
+	}()
+
+	// Read the Websites and the objects they reference from a directory
+	if c.sourceDir != "" {
+		return c.watchDir(ctx, c.sourceDir)
+	}
+
+	// Serve the cached desired state until the API server is reachable
+	c.waitForAPIServer(ctx)
+
+	// Reconcile Websites when their middleware changes
+	go func() {
+		err := c.watchMiddleware(ctx)
+		if err != nil {
+			c.log.Error(err, "failed to watch for Website middleware")
+		}
+	}()
+
+	// Reconcile Websites when the objects their templates look up change
+	if c.templateConfigMap.Name != "" || c.featureEnabled(featureTenantTemplates) {
+		go func() {
+			err := c.watchTemplateLookups(ctx)
+			if err != nil {
+				c.log.Error(err, "failed to watch for objects looked up by templates")
+			}
+		}()
+	}
+
+	// Reconcile Website objects
+	err = c.runManager(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to watch for Website objects")
+		return errors.Wrap(err, "failed to reconcile Website objects")
 	}
 
 	return nil
 }
 
+// lead starts the background work that only the leading controller performs.
+func (c *WebsiteController) lead(ctx context.Context) {
+	// Keep secret material read from Vault and Secrets up to date
+	go c.refreshSecretMaterial(ctx)
+
+	// Serve rotated SPIFFE SVIDs
+	go c.refreshSPIFFE(ctx)
+
+	// Generate the status pages of the namespaces
+	if c.statusPageDomain != "" {
+		go c.runStatusPages(ctx)
+	}
+
+	// Account the usage of the Websites
+	if c.usageEndpoint != "" {
+		go c.runUsageAccounting(ctx)
+	}
+
+	// Delete the configurations of Websites deleted while the controller was down
+	if c.sourceDir == "" && c.featureEnabled(featureOrphanCollection) {
+		go func() {
+			err := c.collectOrphanedConfigs(ctx)
+			if err != nil && ctx.Err() == nil {
+				c.log.Error(err, "failed to collect orphaned Nginx configurations")
+			}
+		}()
+	}
+
+	// Track the SLOs of the Websites
+	go c.runSLOs(ctx)
+
+	// Keep the tenant directories within their quota
+	if c.tenantRoot != "" {
+		go c.runTenantSweep(ctx)
+	}
+
+	// Repair configuration files edited behind the controller's back
+	if c.resyncInterval > 0 && c.featureEnabled(featureDriftRepair) {
+		go c.runDriftDetection(ctx)
+	}
+
+	// Shift the traffic to the peer edge while the local edge is unhealthy
+	if c.failoverDNS != nil && c.featureEnabled(featureDNSFailover) {
+		go c.runFailover(ctx)
+	}
+
+	// Count the requests for hostnames no Website serves
+	go c.runUnknownHosts(ctx)
+
+	// Weight the upstreams of leastTime Websites by their latency
+	go c.runLatencyProbes(ctx)
+
+	// Generate the health reports of the Websites
+	go c.runHealthReports(ctx)
+
+	// Apply the global nginx configuration; a manifest directory applies it as it is read
+	if c.sourceDir == "" {
+		go func() {
+			err := c.watchGlobalConfig(ctx)
+			if err != nil {
+				c.log.Error(err, "failed to watch for global nginx configuration")
+			}
+		}()
+	}
+
+	// Apply the Websites of bundles, and re-check Websites against changed policies
+	if c.sourceDir == "" {
+		go func() {
+			err := c.watchBundles(ctx)
+			if err != nil {
+				c.log.Error(err, "failed to watch for WebsiteBundle objects")
+			}
+		}()
+		go func() {
+			err := c.watchPolicies(ctx)
+			if err != nil {
+				c.log.Error(err, "failed to watch for WebsitePolicy objects")
+			}
+		}()
+	}
+
+	// Proxy the TCP and UDP ports of StreamRoutes
+	if c.sourceDir == "" {
+		go func() {
+			err := c.watchStreamRoutes(ctx)
+			if err != nil {
+				c.log.Error(err, "failed to watch for StreamRoute objects")
+			}
+		}()
+		go c.refreshStreamRoutes(ctx)
+	}
+}
+
 // handleEvent handles a watch event.
-func (c *WebsiteController) handleEvent(event watch.Event) error {
+func (c *WebsiteController) handleEvent(ctx context.Context, event watch.Event) error {
 	// Get the Website object
 	website, ok := event.Object.(*v1alpha1.Website)
 	if !ok {
 		return errors.Errorf("object is not a Website: %T", event.Object)
 	}
 
+	// Leave the Websites of other classes to their controllers; a Website moved to another
+	// class is deleted with its last state of this class
+	if !hasClass(website, c.class) {
+		return nil
+	}
+	c.websiteLog(website).V(1).Info("handling event", "type", event.Type)
+
+	// Render the Website for the controller's environment
+	website, err := c.applyEnvironment(ctx, event.Type, website)
+	if err != nil {
+		return err
+	}
+
+	// Keep track of the existing Websites for the deletion guard
+	if event.Type != watch.Deleted {
+		c.deletionGuard.observe(website)
+	}
+
+	// Only keep the desired state in sync while on standby
+	if c.observeStandby(ctx, event.Type, website) {
+		return nil
+	}
+
+	// Leave the configuration of a paused Website as it is
+	paused, err := c.checkPaused(ctx, event.Type, website)
+	if err != nil || paused {
+		return err
+	}
+
+	// Stop serving a Website whose configuration fails repeatedly
+	quarantined, err := c.checkQuarantine(ctx, event.Type, website)
+	if err != nil || quarantined {
+		return err
+	}
+
+	// Delete the Website once its TTL expired
+	expired, err := c.checkExpiry(ctx, event.Type, website)
+	if err != nil || expired {
+		return err
+	}
+
 	// Handle the event type
 	switch event.Type {
 	case watch.Added:
-		return c.handleAdded(website)
+		err = c.handleAdded(ctx, website)
 	case watch.Modified:
-		return c.handleModified(website)
+		err = c.handleModified(ctx, website)
 	case watch.Deleted:
-		return c.handleDeleted(website)
+		err = c.handleDeleted(ctx, website)
+	}
+	if err != nil {
+		return err
 	}
 
+	// Keep the desired state for restarts while the API server is unreachable
+	c.cacheDesiredState(event.Type, website)
+
 	return nil
 }
 
 // handleAdded handles an added Website object.
-func (c *WebsiteController) handleAdded(website *v1alpha1.Website) error {
+func (c *WebsiteController) handleAdded(ctx context.Context, website *v1alpha1.Website) error {
+	// Refuse features the data plane does not support
+	err := c.checkCapabilities(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Refuse features the platform's policies deny
+	err = c.checkPolicies(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Refuse upstreams in internal address ranges
+	err = c.checkUpstreams(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Serve a rolled back revision until the spec changes
+	rolledBack, err := c.handleRollback(ctx, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to roll back Website")
+	}
+	if rolledBack {
+		return nil
+	}
+
+	// Hand the Website to the ingress controller in Ingress mode
+	if servingMode(website) == v1alpha1.ServingModeIngress {
+		return c.serveIngress(ctx, website)
+	}
+	err = c.removeIngress(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Serve the Website with an nginx Deployment of its own in Deployment mode
+	if servingMode(website) == v1alpha1.ServingModeDeployment {
+		return c.serveDeployment(ctx, website)
+	}
+	err = c.removeDeployment(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Hand the Website to the Gateway implementation in GatewayAPI mode
+	if servingMode(website) == v1alpha1.ServingModeGatewayAPI {
+		return c.serveGatewayAPI(ctx, website)
+	}
+	err = c.removeHTTPRoute(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Hold back the deletion of the Website until its configuration is removed
+	err = c.addFinalizer(ctx, website)
+	if err != nil {
+		return err
+	}
+
 	// Create the Nginx server
-	err := c.createNginxServer(website)
+	reloading, err := c.createNginxServer(ctx, website)
 	if err != nil {
 		return errors.Wrap(err, "failed to create Nginx server")
 	}
 
-	return nil
+	// Keep the rendered configuration in the revision history
+	err = c.recordRevision(ctx, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to record revision")
+	}
+
+	// Surface certificates that do not cover the hostname
+	err = c.checkCertificateHostnames(ctx, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to check certificate hostnames")
+	}
+
+	// Mark the Website as Ready once it is verified to be served; a batched reload marks it
+	// once nginx was reloaded
+	if reloading {
+		return nil
+	}
+	return c.markReady(ctx, website)
 }
 
 // handleModified handles a modified Website object.
-func (c *WebsiteController) handleModified(website *v1alpha1.Website) error {
+func (c *WebsiteController) handleModified(ctx context.Context, website *v1alpha1.Website) error {
+	// Refuse features the data plane does not support
+	err := c.checkCapabilities(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Refuse features the platform's policies deny
+	err = c.checkPolicies(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Refuse upstreams in internal address ranges
+	err = c.checkUpstreams(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Serve a rolled back revision until the spec changes
+	rolledBack, err := c.handleRollback(ctx, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to roll back Website")
+	}
+	if rolledBack {
+		return nil
+	}
+
+	// Hand the Website to the ingress controller in Ingress mode
+	if servingMode(website) == v1alpha1.ServingModeIngress {
+		return c.serveIngress(ctx, website)
+	}
+	err = c.removeIngress(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Serve the Website with an nginx Deployment of its own in Deployment mode
+	if servingMode(website) == v1alpha1.ServingModeDeployment {
+		return c.serveDeployment(ctx, website)
+	}
+	err = c.removeDeployment(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Hand the Website to the Gateway implementation in GatewayAPI mode
+	if servingMode(website) == v1alpha1.ServingModeGatewayAPI {
+		return c.serveGatewayAPI(ctx, website)
+	}
+	err = c.removeHTTPRoute(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Hold back the deletion of the Website until its configuration is removed
+	err = c.addFinalizer(ctx, website)
+	if err != nil {
+		return err
+	}
+
 	// Update the Nginx server
-	err := c.updateNginxServer(website)
+	reloading, err := c.updateNginxServer(ctx, website)
 	if err != nil {
 		return errors.Wrap(err, "failed to update Nginx server")
 	}
 
-	return nil
+	// Keep the rendered configuration in the revision history
+	err = c.recordRevision(ctx, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to record revision")
+	}
+
+	// Surface certificates that do not cover the hostname
+	err = c.checkCertificateHostnames(ctx, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to check certificate hostnames")
+	}
+
+	// Mark the Website as Ready once it is verified to be served; a batched reload marks it
+	// once nginx was reloaded
+	if reloading {
+		return nil
+	}
+	return c.markReady(ctx, website)
 }
 
 // handleDeleted handles a deleted Website object.
-func (c *WebsiteController) handleDeleted(website *v1alpha1.Website) error {
+func (c *WebsiteController) handleDeleted(ctx context.Context, website *v1alpha1.Website) error {
+	// Hold the deletion if it is part of a mass deletion
+	if !c.deletionGuard.admit(website) {
+		return c.holdDeletion(ctx, website)
+	}
+
+	// The objects of a Website in Ingress, Deployment or GatewayAPI mode are garbage collected with the Website
+	if mode := servingMode(website); mode == v1alpha1.ServingModeIngress || mode == v1alpha1.ServingModeDeployment || mode == v1alpha1.ServingModeGatewayAPI {
+		c.event(website, corev1.EventTypeNormal, "Deleted", "The Website is no longer served")
+		return c.removeFinalizer(ctx, website)
+	}
+
+	// Drain the traffic before the Nginx server is deleted
+	if website.Spec.DrainPolicy != nil {
+		return c.startDrain(ctx, website)
+	}
+
 	// Delete the Nginx server
 	err := c.deleteNginxServer(website)
 	if err != nil {
 		return errors.Wrap(err, "failed to delete Nginx server")
 	}
 
-	return nil
+	// Drop the revision history
+	err = c.removeRevisions(website)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete revision history")
+	}
+
+	// Let the API server delete the Website now that it is cleaned up
+	c.event(website, corev1.EventTypeNormal, "Deleted", "The nginx configuration of the Website was removed")
+	return c.removeFinalizer(ctx, website)
 }
 
-// createNginxServer creates an Nginx server for a Website object.
-func (c *WebsiteController) createNginxServer(website *v1alpha1.Website) error {
+// createNginxServer creates an Nginx server for a Website object. It reports whether the
+// reload was batched, in which case the Website is marked Ready once nginx was reloaded.
+func (c *WebsiteController) createNginxServer(ctx context.Context, website *v1alpha1.Website) (bool, error) {
+	// Reserve the hostname; a hostname moving from another Website is only
+	// taken over once that Website no longer serves it, unless this one is older
+	err := c.claimHostname(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim hostname")
+	}
+
+	// Write the secret material
+	_, err = c.syncSecretMaterial(ctx, website)
+	if err != nil {
+		return false, c.markSecretMaterialUnavailable(ctx, website, err)
+	}
+	c.trackVaultWebsite(website)
+
+	// Create the directories of the Website's namespace
+	err = c.prepareTenantDir(website)
+	if err != nil {
+		return false, err
+	}
+
+	// Configure the shared trace exporter
+	err = c.syncOtelExporter(website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to configure otel exporter")
+	}
+
+	// Resolve the middleware chain
+	err = c.syncMiddleware(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to resolve middleware")
+	}
+
+	// Write the transform scripts
+	err = c.syncTransform(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to write transform scripts")
+	}
+
+	// Compile the redirect map
+	err = c.syncRedirectMap(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to compile redirect map")
+	}
+
+	// Write the upstream signing script
+	err = c.syncSigningScript(website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to write signing script")
+	}
+
 	// Create the Nginx configuration
-	config := c.createNginxConfig(website)
+	config, err := c.createNginxConfig(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create Nginx configuration")
+	}
+	err = c.writeTemplateSecrets(website)
+	if err != nil {
+		return false, err
+	}
+
+	// Take over the hand-written configuration file the Website adopts
+	err = c.adoptConfigFile(ctx, website, config)
+	if err != nil {
+		return false, err
+	}
+
+	// Move the configuration of a previous hostname or layout out of the way
+	err = c.placeConfig(website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to place Nginx configuration")
+	}
+
+	// Validate the configuration before it is written, keeping the last known good one if
+	// nginx rejects it
+	configPath := nginxConfigPath(website)
+	previous := c.lastKnownGood(website, configPath)
+	err = c.validateConfig(ctx, website, configPath, []byte(config), previous)
+	if err != nil {
+		return false, err
+	}
 
 	// Write the Nginx configuration to a file
-	configPath := filepath.Join("/etc/nginx/conf.d", fmt.Sprintf("%s.conf", website.Name))
-	err := os.WriteFile(configPath, []byte(config), 0644)
+	err = writeFileAtomic(configPath, []byte(config), 0644)
+	c.audit(auditConfigWrite, website, configPath, []byte(config), err)
 	if err != nil {
-		return errors.Wrap(err, "failed to write Nginx configuration")
+		c.recordSync(ctx, website, err, nil)
+		return false, errors.Wrap(err, "failed to write Nginx configuration")
 	}
 
-	// Reload the Nginx configuration
-	err = c.reloadNginx()
+	// Reload the Nginx configuration, replacing the server block of a previous hostname, and
+	// record the outcome once nginx is reloaded
+	err = c.reloadNginxThen(website, func(err error) {
+		c.recordReload(ctx, website, []byte(config), err)
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to reload Nginx configuration")
+		return false, errors.Wrap(err, "failed to reload Nginx configuration")
 	}
 
-	return nil
+	// Hand a previous hostname over to Websites waiting for it
+	c.requeueWaiters(c.hostnames.settle(website))
+
+	return c.reloads != nil, nil
 }
 
-// updateNginxServer updates an Nginx server for a Website object.
-func (c *WebsiteController) updateNginxServer(website *v1alpha1.Website) error {
+// updateNginxServer updates an Nginx server for a Website object. It reports whether the
+// reload was batched, in which case the Website is marked Ready once nginx was reloaded.
+func (c *WebsiteController) updateNginxServer(ctx context.Context, website *v1alpha1.Website) (bool, error) {
+	// Reserve the hostname; a hostname moving from another Website is only
+	// taken over once that Website no longer serves it, unless this one is older
+	err := c.claimHostname(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim hostname")
+	}
+
+	// Write the secret material
+	secretsChanged, err := c.syncSecretMaterial(ctx, website)
+	if err != nil {
+		return false, c.markSecretMaterialUnavailable(ctx, website, err)
+	}
+	c.trackVaultWebsite(website)
+
+	// Create the directories of the Website's namespace
+	err = c.prepareTenantDir(website)
+	if err != nil {
+		return false, err
+	}
+
+	// Configure the shared trace exporter
+	err = c.syncOtelExporter(website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to configure otel exporter")
+	}
+
+	// Resolve the middleware chain
+	err = c.syncMiddleware(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to resolve middleware")
+	}
+
+	// Write the transform scripts
+	err = c.syncTransform(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to write transform scripts")
+	}
+
+	// Compile the redirect map
+	err = c.syncRedirectMap(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to compile redirect map")
+	}
+
+	// Write the upstream signing script
+	err = c.syncSigningScript(website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to write signing script")
+	}
+
 	// Create the Nginx configuration
-	config := c.createNginxConfig(website)
+	config, err := c.createNginxConfig(ctx, website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create Nginx configuration")
+	}
+	err = c.writeTemplateSecrets(website)
+	if err != nil {
+		return false, err
+	}
+
+	// Take over the hand-written configuration file the Website adopts
+	err = c.adoptConfigFile(ctx, website, config)
+	if err != nil {
+		return false, err
+	}
+
+	// Move the configuration of a previous hostname or layout out of the way
+	err = c.placeConfig(website)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to place Nginx configuration")
+	}
+
+	// Leave nginx alone if the change affects neither the configuration nor the files it
+	// includes, e.g. a label edit
+	if !secretsChanged && c.configServed(website, config) {
+		c.websiteLog(website).V(1).Info("configuration unchanged, skipping reload")
+		c.requeueWaiters(c.hostnames.settle(website))
+		return false, nil
+	}
+
+	// Validate the configuration before it is written, keeping the last known good one if
+	// nginx rejects it
+	configPath := nginxConfigPath(website)
+	previous := c.lastKnownGood(website, configPath)
+	err = c.validateConfig(ctx, website, configPath, []byte(config), previous)
+	if err != nil {
+		return false, err
+	}
 
 	// Write the Nginx configuration to a file
-	configPath := filepath.Join("/etc/nginx/conf.d", fmt.Sprintf("%s.conf", website.Name))
-	err := os.WriteFile(configPath, []byte(config), 0644)
+	err = writeFileAtomic(configPath, []byte(config), 0644)
+	c.audit(auditConfigWrite, website, configPath, []byte(config), err)
 	if err != nil {
-		return errors.Wrap(err, "failed to write Nginx configuration")
+		c.recordSync(ctx, website, err, nil)
+		return false, errors.Wrap(err, "failed to write Nginx configuration")
 	}
 
-	// Reload the Nginx configuration
-	err = c.reloadNginx()
+	// Reload the Nginx configuration, replacing the server block of a previous hostname, and
+	// record the outcome once nginx is reloaded
+	err = c.reloadNginxThen(website, func(err error) {
+		c.recordReload(ctx, website, []byte(config), err)
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed to reload Nginx configuration")
+		return false, errors.Wrap(err, "failed to reload Nginx configuration")
 	}
 
-	return nil
+	// Hand a previous hostname over to Websites waiting for it
+	c.requeueWaiters(c.hostnames.settle(website))
+
+	return c.reloads != nil, nil
 }
 
 // deleteNginxServer deletes an Nginx server for a Website object.
 func (c *WebsiteController) deleteNginxServer(website *v1alpha1.Website) error {
-	// Delete the Nginx configuration file
-	configPath := filepath.Join("/etc/nginx/conf.d", fmt.Sprintf("%s.conf", website.Name))
+	// Delete the Nginx configuration file. It is missing if the Website was never served or
+	// an earlier attempt to finalize the Website failed after removing it.
+	configPath := nginxConfigPath(website)
 	err := os.Remove(configPath)
+	if !os.IsNotExist(err) {
+		c.audit(auditConfigDelete, website, configPath, nil, err)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete Nginx configuration")
+		}
+	}
+	if website.Spec.PathPrefix != "" {
+		err = c.removeSharedServer(website.Spec.Hostname)
+		if err != nil {
+			return err
+		}
+	}
+	c.releaseReusePort(website)
+
+	// Delete the secret material
+	c.untrackVaultWebsite(website)
+	err = c.removeSecretMaterial(website)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete secret material")
+	}
+
+	// Stop exporting traces for the Website
+	err = c.releaseOtelExporter(website)
+	if err != nil {
+		return errors.Wrap(err, "failed to update otel exporter")
+	}
+
+	// Drop the middleware, template lookups, transform scripts and redirect map
+	c.releaseMiddleware(website)
+	c.releaseTemplateRender(website)
+	err = c.removeTransform(website)
+	if err != nil {
+		return err
+	}
+	err = c.removeRedirectMap(website)
+	if err != nil {
+		return err
+	}
+	err = c.forgetLastKnownGood(website)
 	if err != nil {
-		return errors.Wrap(err, "failed to delete Nginx configuration")
+		return err
 	}
 
 	// Reload the Nginx configuration
-	err = c.reloadNginx()
+	err = c.reloadNginx(website)
 	if err != nil {
 		return errors.Wrap(err, "failed to reload Nginx configuration")
 	}
+	c.forgetApplied(website)
+	c.renders.forget(website)
+
+	// Hand the hostname over to Websites waiting for it
+	c.requeueWaiters(c.hostnames.release(website))
 
 	return nil
 }
 
-// createNginxConfig creates an Nginx configuration for a Website object.
-func (c *WebsiteController) createNginxConfig(website *v1alpha1.Website) string {
-	return fmt.Sprintf(`
-server {
-	listen 80;
-	server_name %s;
-	location / {
-		proxy_pass %s;
+// nginxConfigPath returns the path of the Nginx configuration file of a Website object.
+// Websites sharing a hostname are configured by a location file.
+func nginxConfigPath(website *v1alpha1.Website) string {
+	if website.Spec.PathPrefix != "" {
+		return subpathConfigPath(website)
 	}
+	return filepath.Join("/etc/nginx/conf.d", fmt.Sprintf("%s.conf", website.Name))
 }
-`, website.Spec.Hostname, website.Spec.Upstream)
+
+// createNginxConfig creates an Nginx configuration for a Website object, marked with the
+// Website it belongs to.
+func (c *WebsiteController) createNginxConfig(ctx context.Context, website *v1alpha1.Website) (string, error) {
+	config, err := c.renderNginxConfig(ctx, website)
+	if err != nil {
+		return "", err
+	}
+	config = markConfig(website, config)
+	c.websiteLog(website).V(2).Info("rendered configuration", "config", config)
+	return config, nil
 }
 
-// reloadNginx reloads the Nginx configuration.
-func (c *WebsiteController) reloadNginx() error {
-	// Reload the Nginx configuration
-	cmd := exec.Command("nginx", "-s", "reload")
-	err := cmd.Run()
+// renderNginxConfig renders the Nginx configuration of a Website object, or returns the
+// cached one if the Website is unchanged since it was last rendered.
+func (c *WebsiteController) renderNginxConfig(ctx context.Context, website *v1alpha1.Website) (string, error) {
+	// Never render a server block for a route another Website serves
+	if owner, ok := c.hostnames.conflict(website); ok {
+		return "", errors.Errorf("hostname %s is served by Website %s", websiteRoute(website), owner)
+	}
+
+	// Claim the listen options, which depend on the other Websites
+	listen, err := c.listenOptions(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid tuning configuration")
+	}
+
+	// Render with the Website's template, if it has one
+	templateVersion := builtinTemplateVersion
+	tmpl := c.templateFor(website)
+	if tmpl != nil {
+		templateVersion = tmpl.version
+	} else if key, _, ok := c.templateSource(website); ok {
+		return "", errors.Errorf("template ConfigMap %s is not loaded", key)
+	}
+
+	// Skip rendering if the inputs are unchanged. The objects a template looks up are not
+	// among the inputs, so such configurations are always rendered.
+	if tmpl != nil && tmpl.lookups {
+		return c.compileNginxConfig(ctx, website, listen, tmpl)
+	}
+	key, err := c.renderKey(website, templateVersion, listen)
+	if err != nil {
+		return "", err
+	}
+	if config, ok := c.renders.get(website, key); ok {
+		return config, nil
+	}
+	config, err := c.compileNginxConfig(ctx, website, listen, tmpl)
+	if err != nil {
+		return "", err
+	}
+	c.renders.put(website, key, config)
+	return config, nil
+}
+
+// compileNginxConfig compiles the Nginx configuration of a Website object with the options of
+// its listen directives, and the template of its server block if it is not nil.
+func (c *WebsiteController) compileNginxConfig(ctx context.Context, website *v1alpha1.Website, listen string, tmpl *serverTemplate) (string, error) {
+	// Slow down rendering if a chaos test asks for it
+	c.chaos.delayRender()
+
+	// Compile the security headers
+	security, err := securityDirectives(website.Spec.Security)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid security configuration")
+	}
+
+	// Compile the cookie flags
+	cookies, err := cookieDirectives(website.Spec.Cookies)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid cookies configuration")
+	}
+
+	// Compile the socket tuning options
+	tuning, err := tuningDirectives(website.Spec.Tuning)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid tuning configuration")
+	}
+
+	// Listen on the Website's ports
+	listeners, err := listenDirectives(website, listen)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid listeners")
+	}
+
+	// Reference the secret material
+	secrets := c.secretDirectives(website)
+
+	// Answer the ACME challenges of the Website's certificate
+	challenges := c.acmeDirectives(website)
+
+	// Redirect Websites have no upstream to proxy to
+	if websiteType(website) == v1alpha1.WebsiteTypeRedirect {
+		return redirectConfig(website, listeners, secrets+challenges+security+tuning)
+	}
+	if website.Spec.Upstream == "" {
+		return "", errors.New("spec.upstream or spec.serviceRef is required unless the Website is of type Redirect")
+	}
+
+	// Compile the tracing configuration
+	tracingHTTP, tracingServer, err := tracingDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid tracing configuration")
+	}
+
+	// Compile the upstream TLS options
+	upstreamTLS, err := upstreamTLSDirectives(website.Spec.Upstream, website.Spec.UpstreamTLS)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid upstream TLS configuration")
+	}
+
+	// Sign the requests to the upstream
+	signing, err := c.signingDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid upstream signing configuration")
+	}
+
+	// Derive the client address from the trusted proxies
+	address, addressHTTP, err := clientAddress(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid real IP configuration")
+	}
+
+	// Expand the protection profile
+	protectionHTTP, protection, err := protectionDirectives(website, address)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid protection configuration")
+	}
+
+	// Compile the middleware chain
+	middlewareHTTP, middleware, forwardAuth, err := c.middlewareDirectives(website, address)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid middleware configuration")
+	}
+
+	// Keep search engines off non-production Websites
+	indexing, err := indexingDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid indexing configuration")
+	}
+
+	// Correct the content types of the responses
+	contentHTTP, content, err := contentDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid content configuration")
+	}
+
+	// Apply the caching policy of the static assets
+	assetsHTTP, assets, err := assetsDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid assets configuration")
+	}
+
+	// Compile the substitutions in the responses
+	bodyFiltersHTTP, bodyFilters, err := bodyFilterDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid body filter configuration")
+	}
+
+	// Compile the synthetic health endpoint
+	health, err := healthEndpointDirectives(website.Spec.HealthEndpoint, forwardAuth)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid health endpoint configuration")
+	}
+
+	// Serve the health report
+	healthReport, err := c.healthReportDirectives(website, forwardAuth)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid health report configuration")
+	}
+
+	// Redirect the paths of the redirect map
+	redirectsHTTP, redirects := redirectMapDirectives(website)
+
+	// Compile the load balancing across the upstreams
+	upstreams, proxyPass, balancing, err := loadBalancingDirectives(website, c.nativeLeastTime(), c.upstreamWeights(website))
+	if err != nil {
+		return "", errors.Wrap(err, "invalid load balancing configuration")
+	}
+
+	directives := secrets + challenges + c.tenantDirectives(website) + redirects + security + cookies + tuning + tracingServer + upstreamTLS + signing + balancing + protection + middleware + indexing + content + assets + bodyFilters + health + healthReport
+	if website.DeletionTimestamp != nil {
+		directives += drainDirectives
+	}
+	// Compile the transform scripts into the proxying locations
+	transformHTTP, locations, err := transformDirectives(website, proxyPass)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid transform configuration")
+	}
+
+	// Route the paths proxied to upstreams of their own
+	routes, err := routeDirectives(website)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid routes")
+	}
+
+	http := tracingHTTP + upstreams + addressHTTP + protectionHTTP + middlewareHTTP + transformHTTP + contentHTTP + assetsHTTP + bodyFiltersHTTP + redirectsHTTP
+	if website.Spec.PathPrefix != "" {
+		return subpathLocation(website, http, listen, directives, proxyPass)
+	}
+
+	// Serve the hostnames of further certificates in server blocks of their own
+	sni, err := c.sniServers(website, directives, routes+locations)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid TLS configuration")
+	}
+	if tmpl != nil {
+		render := newTemplateRender()
+		config, err := tmpl.execute(c.templateFuncs(ctx, website, render), serverTemplateData{
+			Website:    website,
+			HTTP:       http,
+			Listen:     listeners,
+			ServerName: website.Spec.Hostname,
+			Directives: directives,
+			Locations:  routes + locations,
+		})
+		if err != nil {
+			return "", err
+		}
+		c.recordTemplateRender(website, render)
+		if len(render.secrets) > 0 {
+			config = fmt.Sprintf("include %s;\n", c.secretFile(website, templateSecretsFile)) + config
+		}
+		return config + sni, nil
+	}
+	c.recordTemplateRender(website, nil)
+	return fmt.Sprintf(`%s
+server {
+%s	server_name %s;
+%s%s}
+%s`, http, listeners, website.Spec.Hostname, directives, routes+locations, sni), nil
+}
+
+// reloadNginx reloads the Nginx configuration on behalf of a Website, or of the controller if
+// website is nil. With a reload debounce, the reload is batched with the others requested
+// within the window and reloadNginx returns right away.
+func (c *WebsiteController) reloadNginx(website *v1alpha1.Website) error {
+	return c.reloadNginxThen(website, nil)
+}
+
+// reloadNginxThen reloads the Nginx configuration like reloadNginx and calls done, if not nil,
+// with the outcome once nginx was reloaded. With a reload debounce, that is after the window
+// closed, so done records whether the configuration is served rather than the caller.
+func (c *WebsiteController) reloadNginxThen(website *v1alpha1.Website, done func(error)) error {
+	if c.reloads != nil {
+		c.reloads.request(website, done)
+		return nil
+	}
+	var websites []*v1alpha1.Website
+	var key string
+	if website != nil {
+		websites = append(websites, website)
+		key = websiteKey(website)
+	}
+	err := c.reloadWithRollback(websites).of(key)
+	if done != nil {
+		done(err)
+	}
+	return err
+}
+
+// signalReload reloads the Nginx configuration once on behalf of the given Websites.
+func (c *WebsiteController) signalReload(websites []*v1alpha1.Website) error {
+	// Sample nginx before the reload so its impact can be measured
+	before := sampleNginx(c.nginxPidFile)
+
+	// Signal the nginx master process to reload its configuration. Unlike `nginx -s reload`,
+	// this needs neither the nginx binary nor write access to anything but the configuration.
+	start := time.Now()
+	var err error
+	if c.chaos.failReload() {
+		err = errors.New("reload failure injected by chaos test")
+	} else {
+		err = signalNginx(c.nginxPidFile, syscall.SIGHUP)
+	}
+
+	// Wait for nginx to start workers with the new configuration
+	if err == nil {
+		err = c.confirmReload(before.workers)
+	}
+	duration := time.Since(start)
+	nginxReloads.Inc()
+	if err != nil {
+		nginxReloadFailures.Inc()
+	}
+	if len(websites) == 0 {
+		c.audit(auditNginxReload, nil, "", nil, err)
+	}
+	observed := make([]*v1alpha1.Website, 0, len(websites))
+	for _, website := range websites {
+		c.audit(auditNginxReload, website, "", nil, err)
+		observed = append(observed, website.DeepCopy())
+	}
 	if err != nil {
 		return errors.Wrap(err, "failed to reload Nginx configuration")
 	}
 
+	// Keep the configurations nginx was reloaded with to roll back to
+	for _, website := range websites {
+		if website.DeletionTimestamp == nil {
+			c.saveLastKnownGood(website)
+		}
+	}
+
+	// Measure the impact of the reload in the background
+	go c.observeReload(observed, before, start, duration)
+
 	return nil
 }
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// acknowledgeDeletionAnnotation on the default NginxGlobalConfig releases held deletions.
+	// The controller removes it once the deletions are queued again.
+	acknowledgeDeletionAnnotation = "website-operator.io/acknowledge-mass-deletion"
+
+	// conditionDeletionsPaused is set on the default NginxGlobalConfig while deletions are held.
+	conditionDeletionsPaused = "DeletionsPaused"
+
+	// massDeletionMinimum is the number of deletions within the window below which the guard
+	// never trips, so deleting one of a handful of Websites is not a mass deletion.
+	massDeletionMinimum = 3
+)
+
+// deletionGuard holds back Website deletions once more than a percentage of the Websites
+// was deleted within a window, until an operator acknowledges them.
+type deletionGuard struct {
+	mu      sync.Mutex
+	percent int
+	window  time.Duration
+
+	known  map[string]bool
+	recent []time.Time
+	paused bool
+	held   map[string]*v1alpha1.Website
+}
+
+// newDeletionGuard creates a deletionGuard. A percent of 0 disables the guard.
+func newDeletionGuard(percent int, window time.Duration) *deletionGuard {
+	return &deletionGuard{
+		percent: percent,
+		window:  window,
+		known:   map[string]bool{},
+		held:    map[string]*v1alpha1.Website{},
+	}
+}
+
+// observe records a Website that exists. A held deletion of a recreated Website is dropped.
+func (g *deletionGuard) observe(website *v1alpha1.Website) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := websiteKey(website)
+	g.known[key] = true
+	delete(g.held, key)
+	deletionsHeld.Set(float64(len(g.held)))
+}
+
+// admit reports whether the deletion of a Website may proceed. Deletions that are not
+// admitted are held until they are acknowledged.
+func (g *deletionGuard) admit(website *v1alpha1.Website) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Deletions of Websites that were already admitted, e.g. after draining, pass
+	key := websiteKey(website)
+	if g.percent == 0 || !g.known[key] {
+		return true
+	}
+	if g.paused {
+		g.hold(key, website)
+		return false
+	}
+
+	// Count the deletions within the window, including this one
+	now := time.Now()
+	recent := g.recent[:0]
+	for _, t := range g.recent {
+		if now.Sub(t) < g.window {
+			recent = append(recent, t)
+		}
+	}
+	g.recent = append(recent, now)
+
+	// Deleted Websites are no longer known, so they are added back to the total
+	total := len(g.known) + len(g.recent) - 1
+	if len(g.recent) >= massDeletionMinimum && len(g.recent)*100 > g.percent*total {
+		g.paused = true
+		deletionsPaused.Set(1)
+		g.hold(key, website)
+		return false
+	}
+
+	delete(g.known, key)
+	return true
+}
+
+// hold keeps a deletion until it is acknowledged.
+func (g *deletionGuard) hold(key string, website *v1alpha1.Website) {
+	g.held[key] = website.DeepCopy()
+	deletionsHeld.Set(float64(len(g.held)))
+}
+
+// acknowledge resumes deletions and returns the held ones.
+func (g *deletionGuard) acknowledge() []*v1alpha1.Website {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var websites []*v1alpha1.Website
+	for key, website := range g.held {
+		delete(g.known, key)
+		websites = append(websites, website)
+	}
+	g.held = map[string]*v1alpha1.Website{}
+	g.recent = nil
+	g.paused = false
+	deletionsPaused.Set(0)
+	deletionsHeld.Set(0)
+
+	return websites
+}
+
+// heldCount returns the number of held deletions.
+func (g *deletionGuard) heldCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return len(g.held)
+}
+
+// holdDeletion reports a deletion held back by the deletion guard.
+func (c *WebsiteController) holdDeletion(ctx context.Context, website *v1alpha1.Website) error {
+	held := c.deletionGuard.heldCount()
+	c.log.Info("mass deletion detected, holding Website deletion until acknowledged",
+		"namespace", website.Namespace, "name", website.Name, "held", held,
+		"acknowledge", fmt.Sprintf("annotate nginxglobalconfig %s with %s", v1alpha1.NginxGlobalConfigName, acknowledgeDeletionAnnotation))
+
+	condition := metav1.Condition{
+		Type:    conditionDeletionsPaused,
+		Status:  metav1.ConditionTrue,
+		Reason:  "MassDeletion",
+		Message: fmt.Sprintf("%d Website deletions are held; annotate this object with %s to proceed", held, acknowledgeDeletionAnnotation),
+	}
+	return c.setGlobalConfigCondition(ctx, condition)
+}
+
+// acknowledgeDeletions queues the held deletions again once the default NginxGlobalConfig
+// carries the acknowledgment annotation, and removes the annotation.
+func (c *WebsiteController) acknowledgeDeletions(ctx context.Context, config *v1alpha1.NginxGlobalConfig) error {
+	if _, ok := config.Annotations[acknowledgeDeletionAnnotation]; !ok {
+		return nil
+	}
+
+	// Queue the held deletions
+	websites := c.deletionGuard.acknowledge()
+	for _, website := range websites {
+		c.queue.Add(watch.Event{Type: watch.Deleted, Object: website})
+	}
+	c.log.Info("mass deletion acknowledged", "deletions", len(websites))
+
+	// Remove the annotation, so the acknowledgment is applied only once
+	patched := config.DeepCopy()
+	delete(patched.Annotations, acknowledgeDeletionAnnotation)
+	err := c.client.Patch(ctx, patched, client.MergeFrom(config))
+	if err != nil {
+		return errors.Wrap(err, "failed to remove acknowledgment annotation")
+	}
+
+	condition := metav1.Condition{
+		Type:    conditionDeletionsPaused,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Acknowledged",
+		Message: fmt.Sprintf("%d held Website deletions were acknowledged", len(websites)),
+	}
+	return c.setGlobalConfigCondition(ctx, condition)
+}
+
+// setGlobalConfigCondition sets a condition on the default NginxGlobalConfig, if it exists.
+func (c *WebsiteController) setGlobalConfigCondition(ctx context.Context, condition metav1.Condition) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		config := &v1alpha1.NginxGlobalConfig{}
+		err := c.client.Get(ctx, types.NamespacedName{Name: v1alpha1.NginxGlobalConfigName}, config)
+		if err != nil {
+			return err
+		}
+		meta.SetStatusCondition(&config.Status.Conditions, condition)
+		return c.client.Status().Update(ctx, config)
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to update NginxGlobalConfig status")
+	}
+	return nil
+}
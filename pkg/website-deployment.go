@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultDeploymentImage is the nginx image of the Deployments of Websites in Deployment mode.
+	defaultDeploymentImage = "nginx:alpine"
+
+	// deploymentWebsiteLabel selects the pods of a Website in Deployment mode.
+	deploymentWebsiteLabel = "website-operator.io/website"
+
+	// deploymentConfigAnnotation records the hash of the configuration a Deployment's pods run
+	// with, so they are replaced when it changes.
+	deploymentConfigAnnotation = "website-operator.io/config-sha256"
+)
+
+// deploymentName is the name of the Deployment, ConfigMap and Service of a Website in
+// Deployment mode. It differs from the Service of Ingress mode, which is removed outside it.
+func deploymentName(website *v1alpha1.Website) string {
+	return "website-" + website.Name + "-nginx"
+}
+
+// deploymentUnsupportedFields lists the set fields of a Website that need files only the
+// controller's nginx has, e.g. secret material, scripts and shared servers.
+func deploymentUnsupportedFields(website *v1alpha1.Website) []string {
+	spec := website.Spec
+	set := map[string]bool{
+		"spec.type":                    spec.Type == v1alpha1.WebsiteTypeRedirect,
+		"spec.pathPrefix":              spec.PathPrefix != "",
+		"spec.tls":                     spec.TLS != nil,
+		"spec.basicAuth":               spec.BasicAuth != nil,
+		"spec.upstreamAuth":            spec.UpstreamAuth != nil,
+		"spec.tracing":                 spec.Tracing != nil,
+		"spec.drainPolicy":             spec.DrainPolicy != nil,
+		"spec.listeners":               len(spec.Listeners) > 0,
+		"spec.healthReport":            spec.HealthReport != nil,
+		"spec.transform":               spec.Transform != nil,
+		"spec.redirectMapRef":          spec.RedirectMapRef != nil,
+		"spec.serving.adoptConfigFile": spec.Serving != nil && spec.Serving.AdoptConfigFile != "",
+	}
+	var fields []string
+	for field, isSet := range set {
+		if isSet {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// serveDeployment serves a Website in Deployment mode with an nginx Deployment of its own,
+// removing the nginx server of a Website that was served by the controller before.
+func (c *WebsiteController) serveDeployment(ctx context.Context, website *v1alpha1.Website) error {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "DeploymentApplied",
+		Message:            "The Website is served by the Deployment and Service " + deploymentName(website),
+		ObservedGeneration: website.Generation,
+	}
+
+	// Refuse fields the Deployment cannot serve
+	unsupported := deploymentUnsupportedFields(website)
+	var deploymentErr error
+	if !c.featureEnabled(featureDeploymentMode) {
+		deploymentErr = errors.Errorf("Deployment mode is disabled, enable the %s feature gate", featureDeploymentMode)
+	} else if len(unsupported) > 0 {
+		deploymentErr = errors.Errorf("Deployment mode does not support %s", strings.Join(unsupported, ", "))
+	} else {
+		deploymentErr = c.applyDeployment(ctx, website)
+	}
+	if deploymentErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "DeploymentFailed"
+		condition.Message = deploymentErr.Error()
+	}
+
+	// Stop serving the Website with nginx
+	if deploymentErr == nil {
+		if _, err := os.Stat(nginxConfigPath(website)); err == nil {
+			err = c.deleteNginxServer(website)
+			if err != nil {
+				return errors.Wrap(err, "failed to delete Nginx server")
+			}
+			err = c.removeRevisions(website)
+			if err != nil {
+				return errors.Wrap(err, "failed to delete revision history")
+			}
+		}
+	}
+
+	// Record the outcome in the Website status
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return deploymentErr
+}
+
+// applyDeployment creates or updates the ConfigMap, Deployment and Service of a Website. The
+// configuration is rendered as for the controller's nginx, with a renderer of its own, as the
+// Website does not share the controller's nginx with other Websites.
+func (c *WebsiteController) applyDeployment(ctx context.Context, website *v1alpha1.Website) error {
+	config, err := renderForDiff(ctx, c.client, website)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Nginx configuration")
+	}
+	sum := sha256.Sum256([]byte(config))
+	name := deploymentName(website)
+	labels := map[string]string{deploymentWebsiteLabel: website.Name}
+	objectMeta := metav1.ObjectMeta{Namespace: website.Namespace, Name: name}
+
+	// Apply the configuration
+	configMap := &corev1.ConfigMap{ObjectMeta: objectMeta}
+	err = c.applyOwned(ctx, website, "configmaps", configMap, func() {
+		configMap.Data = map[string]string{"website.conf": config}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Apply the nginx Deployment, replacing its pods when the configuration changes
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{ObjectMeta: objectMeta}
+	err = c.applyOwned(ctx, website, "deployments", deployment, func() {
+		deployment.Spec.Replicas = &replicas
+		deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+		deployment.Spec.Template = corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels:      labels,
+				Annotations: map[string]string{deploymentConfigAnnotation: hex.EncodeToString(sum[:])},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{
+					Name:         "nginx",
+					Image:        c.deploymentImage,
+					Ports:        []corev1.ContainerPort{{Name: "http", ContainerPort: 80, Protocol: corev1.ProtocolTCP}},
+					VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/nginx/conf.d", ReadOnly: true}},
+					ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+						TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("http")},
+					}},
+				}},
+				Volumes: []corev1.Volume{{
+					Name: "config",
+					VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: name},
+					}},
+				}},
+			},
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Apply the Service in front of the Deployment
+	service := &corev1.Service{ObjectMeta: objectMeta}
+	return c.applyOwned(ctx, website, "services", service, func() {
+		service.Spec.Selector = labels
+		service.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromString("http"), Protocol: corev1.ProtocolTCP}}
+	})
+}
+
+// applyOwned creates or updates an object a Website owns, garbage collected with the Website.
+func (c *WebsiteController) applyOwned(ctx context.Context, website *v1alpha1.Website, resource string, object client.Object, mutate func()) error {
+	_, err := controllerutil.CreateOrUpdate(ctx, c.client, object, func() error {
+		mutate()
+		return controllerutil.SetControllerReference(website, object, c.client.Scheme())
+	})
+	c.audit(auditObjectApply, website, resource+"/"+client.ObjectKeyFromObject(object).String(), nil, err)
+	if err != nil {
+		return errors.Wrapf(err, "failed to apply %s %s", resource, object.GetName())
+	}
+	return nil
+}
+
+// removeDeployment deletes the Deployment, ConfigMap and Service of a Website that is no
+// longer in Deployment mode.
+func (c *WebsiteController) removeDeployment(ctx context.Context, website *v1alpha1.Website) error {
+	objectMeta := metav1.ObjectMeta{Namespace: website.Namespace, Name: deploymentName(website)}
+	for _, object := range []client.Object{
+		&appsv1.Deployment{ObjectMeta: objectMeta},
+		&corev1.Service{ObjectMeta: objectMeta},
+		&corev1.ConfigMap{ObjectMeta: objectMeta},
+	} {
+		err := c.client.Get(ctx, client.ObjectKeyFromObject(object), object)
+		if err == nil && metav1.IsControlledBy(object, website) {
+			err = c.client.Delete(ctx, object)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete %s", objectMeta.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// desiredStateDir is where the last-known desired state of every Website is kept, within
+	// the state directory.
+	desiredStateDir = "desired"
+
+	// apiServerProbeTimeout bounds how long the API server is waited for on start.
+	apiServerProbeTimeout = 10 * time.Second
+
+	// apiServerRetryInterval is how often an unreachable API server is probed again.
+	apiServerRetryInterval = 15 * time.Second
+)
+
+// cacheDesiredState persists the desired state of a Website once an event of it is handled,
+// so the controller can start without the API server. The status and other fields changing
+// on every update are not kept.
+func (c *WebsiteController) cacheDesiredState(eventType watch.EventType, website *v1alpha1.Website) {
+	if c.sourceDir != "" {
+		return
+	}
+	path := c.desiredStatePath(website)
+	if eventType == watch.Deleted {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			c.log.Error(err, "failed to forget desired state", "namespace", website.Namespace, "name", website.Name)
+		}
+		return
+	}
+
+	cached := website.DeepCopy()
+	cached.ResourceVersion = ""
+	cached.ManagedFields = nil
+	cached.Status = v1alpha1.WebsiteStatus{}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		c.log.Error(err, "failed to encode desired state", "namespace", website.Namespace, "name", website.Name)
+		return
+	}
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && bytes.Equal(existing, data) {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = writeFileAtomic(path, data, 0644)
+	}
+	if err != nil {
+		c.log.Error(err, "failed to cache desired state", "namespace", website.Namespace, "name", website.Name)
+	}
+}
+
+// loadDesiredState reads the cached desired state of all Websites.
+func (c *WebsiteController) loadDesiredState() ([]*v1alpha1.Website, error) {
+	paths, err := filepath.Glob(filepath.Join(c.stateDir, desiredStateDir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list cached desired state")
+	}
+	websites := make([]*v1alpha1.Website, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read cached desired state")
+		}
+		website := &v1alpha1.Website{}
+		err = json.Unmarshal(data, website)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cached desired state %s", filepath.Base(path))
+		}
+		websites = append(websites, website)
+	}
+	return websites, nil
+}
+
+// waitForAPIServer returns once the API server is reachable. Until then the configuration
+// nginx serves is left alone and the cached desired state is restored, so the edge keeps
+// serving through a control plane outage. Once the API server is back, Websites deleted in
+// the meantime are cleaned up; all others are re-synced as the manager starts. It returns
+// early if the context is cancelled.
+func (c *WebsiteController) waitForAPIServer(ctx context.Context) {
+	cached, err := c.loadDesiredState()
+	if err != nil {
+		c.log.Error(err, "failed to load cached desired state")
+	}
+
+	list := &v1alpha1.WebsiteList{}
+	restored := false
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, apiServerProbeTimeout)
+		err = c.client.List(probeCtx, list)
+		cancel()
+		if err == nil {
+			break
+		}
+		if !restored {
+			c.log.Error(err, "API server is unreachable, serving the cached desired state", "websites", len(cached))
+			c.restoreDesiredState(cached)
+			restored = true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(apiServerRetryInterval):
+		}
+	}
+	if restored {
+		c.log.Info("API server is reachable again, re-syncing Websites")
+	}
+	c.setListed()
+
+	// Clean up the Websites deleted while the controller was not watching
+	existing := map[string]bool{}
+	for i := range list.Items {
+		existing[websiteKey(&list.Items[i])] = true
+	}
+	for _, website := range cached {
+		if !existing[websiteKey(website)] {
+			c.queue.Add(watch.Event{Type: watch.Deleted, Object: website})
+		}
+	}
+}
+
+// restoreDesiredState restores the in-memory state of the cached Websites whose configuration
+// is on disk, so they keep their hostnames and are reported as applied. The oldest Websites
+// claim their hostnames first, as they would win a conflict.
+func (c *WebsiteController) restoreDesiredState(websites []*v1alpha1.Website) {
+	sort.Slice(websites, func(i, j int) bool { return olderWebsite(websites[i], websites[j]) })
+	for _, website := range websites {
+		config, err := ioutil.ReadFile(nginxConfigPath(website))
+		if err != nil {
+			continue
+		}
+		evicted, err := c.hostnames.claim(website)
+		if err != nil {
+			continue
+		}
+		for _, loser := range evicted {
+			c.evictWebsite(context.Background(), loser, website)
+		}
+		c.hostnames.settle(website)
+		c.recordApplied(website, config)
+	}
+}
+
+// desiredStatePath is the path of the cached desired state of a Website.
+func (c *WebsiteController) desiredStatePath(website *v1alpha1.Website) string {
+	return filepath.Join(c.stateDir, desiredStateDir, website.Namespace+"_"+website.Name+".json")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// Change types of a Website in a diff report.
+const (
+	diffAdded     = "added"
+	diffRemoved   = "removed"
+	diffChanged   = "changed"
+	diffUnchanged = "unchanged"
+)
+
+// DiffReport compares the configurations rendered from a Git revision with the ones
+// rendered from the live cluster.
+type DiffReport struct {
+	Ref      string        `json:"ref"`
+	Websites []WebsiteDiff `json:"websites"`
+}
+
+// WebsiteDiff is the difference of a single Website's rendered configuration.
+type WebsiteDiff struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Change    string `json:"change"`
+	Diff      string `json:"diff,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// diffCommand renders the Websites in a Git revision and diffs them against the live cluster.
+func diffCommand(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	repo := flags.String("repo", ".", "path of the Git repository")
+	ref := flags.String("ref", "HEAD", "Git revision to render")
+	dir := flags.String("path", ".", "directory of the Website manifests in the repository")
+	namespace := flags.String("namespace", "default", "namespace of manifests that do not set one")
+	output := flags.String("o", cli.OutputJSON, "output format of the report: json or yaml")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	// Render the Websites of the Git revision
+	desired, err := websitesFromGit(*repo, *ref, *dir, *namespace)
+	if err != nil {
+		return err
+	}
+
+	// Render the Websites of the live cluster
+	c, err := newCommandClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	ctx := context.Background()
+	list := &v1alpha1.WebsiteList{}
+	err = c.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+	live := map[string]*v1alpha1.Website{}
+	for i := range list.Items {
+		live[websiteKey(&list.Items[i])] = &list.Items[i]
+	}
+
+	report := diffWebsites(ctx, c, *ref, desired, live)
+	return cli.PrintReport(*output, report)
+}
+
+// diffWebsites renders both sets of Websites and compares them by namespace and name.
+func diffWebsites(ctx context.Context, c client.Client, ref string, desired, live map[string]*v1alpha1.Website) DiffReport {
+	keys := map[string]bool{}
+	for key := range desired {
+		keys[key] = true
+	}
+	for key := range live {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	report := DiffReport{Ref: ref, Websites: []WebsiteDiff{}}
+	for _, key := range sorted {
+		// Each side gets its own renderer, so cross-Website state does not leak between them
+		from, fromErr := renderForDiff(ctx, c, live[key])
+		to, toErr := renderForDiff(ctx, c, desired[key])
+
+		parts := strings.SplitN(key, "/", 2)
+		diff := WebsiteDiff{Namespace: parts[0], Name: parts[1]}
+		switch {
+		case fromErr != nil:
+			diff.Error = "live: " + fromErr.Error()
+		case toErr != nil:
+			diff.Error = ref + ": " + toErr.Error()
+		}
+		switch {
+		case live[key] == nil:
+			diff.Change = diffAdded
+		case desired[key] == nil:
+			diff.Change = diffRemoved
+		case from == to:
+			diff.Change = diffUnchanged
+		default:
+			diff.Change = diffChanged
+		}
+		if from != to {
+			diff.Diff, _ = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+				A:        difflib.SplitLines(from),
+				B:        difflib.SplitLines(to),
+				FromFile: "live/" + key,
+				ToFile:   ref + "/" + key,
+				Context:  3,
+			})
+		}
+		report.Websites = append(report.Websites, diff)
+	}
+
+	return report
+}
+
+// renderForDiff renders a Website, if any, with a fresh renderer. Middleware and the
+// referenced Service are read from the cluster.
+func renderForDiff(ctx context.Context, c client.Client, website *v1alpha1.Website) (string, error) {
+	if website == nil {
+		return "", nil
+	}
+	r := newRenderer()
+	r.client = c
+	website, err := resolveServiceRef(ctx, c, website)
+	if err != nil {
+		return "", err
+	}
+	err = r.syncMiddleware(ctx, website)
+	if err != nil {
+		return "", err
+	}
+	err = r.loadTemplate(ctx, website)
+	if err != nil {
+		return "", err
+	}
+	return r.createNginxConfig(ctx, website)
+}
+
+// websitesFromGit reads the Website manifests below a directory of a Git revision.
+func websitesFromGit(repo, ref, dir, namespace string) (map[string]*v1alpha1.Website, error) {
+	files, err := git(repo, "ls-tree", "-r", "--name-only", ref, "--", dir)
+	if err != nil {
+		return nil, err
+	}
+
+	websites := map[string]*v1alpha1.Website{}
+	for _, file := range strings.Split(strings.TrimSpace(string(files)), "\n") {
+		ext := path.Ext(file)
+		if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			continue
+		}
+		content, err := git(repo, "show", ref+":"+file)
+		if err != nil {
+			return nil, err
+		}
+		found, err := decodeWebsites(content, namespace)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", file)
+		}
+		for _, website := range found {
+			websites[websiteKey(website)] = website
+		}
+	}
+
+	return websites, nil
+}
+
+// decodeWebsites decodes the Website objects of a multi-document YAML or JSON manifest,
+// skipping objects of other kinds.
+func decodeWebsites(content []byte, namespace string) ([]*v1alpha1.Website, error) {
+	var websites []*v1alpha1.Website
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	for {
+		website := &v1alpha1.Website{}
+		err := decoder.Decode(website)
+		if err == io.EOF {
+			return websites, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if website.Kind != "Website" || website.APIVersion != v1alpha1.GroupVersion.String() {
+			continue
+		}
+		if website.Namespace == "" {
+			website.Namespace = namespace
+		}
+		websites = append(websites, website)
+	}
+}
+
+// git runs a git command in a repository and returns its output.
+func git(repo string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// errDirReadOnly is returned for writes to the objects of a manifest directory, which only
+// change with the directory.
+var errDirReadOnly = errors.New("objects read from a manifest directory cannot be changed by the controller")
+
+// dirClient keeps the objects read from a manifest directory in memory. The controller can
+// read them and update their status; everything else is written by syncing the directory.
+type dirClient struct {
+	scheme *runtime.Scheme
+
+	mu      sync.RWMutex
+	objects map[dirClientKey]*unstructured.Unstructured
+	version int64
+}
+
+// dirClientKey identifies an object of a manifest directory.
+type dirClientKey struct {
+	kind schema.GroupKind
+	name types.NamespacedName
+}
+
+// newDirClient creates an empty dirClient converting objects with a scheme.
+func newDirClient(scheme *runtime.Scheme) *dirClient {
+	return &dirClient{scheme: scheme, objects: map[dirClientKey]*unstructured.Unstructured{}}
+}
+
+// apply stores an object of the directory, replacing the one it was read from before.
+func (d *dirClient) apply(obj *unstructured.Unstructured) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stored := obj.DeepCopy()
+	d.version++
+	stored.SetResourceVersion(strconv.FormatInt(d.version, 10))
+	key := dirClientKey{kind: obj.GroupVersionKind().GroupKind(), name: client.ObjectKeyFromObject(obj)}
+	if previous, ok := d.objects[key]; ok {
+		if status, ok := previous.Object["status"]; ok {
+			stored.Object["status"] = status
+		}
+	}
+	d.objects[key] = stored
+}
+
+// remove forgets an object whose file was removed from the directory.
+func (d *dirClient) remove(obj *unstructured.Unstructured) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.objects, dirClientKey{kind: obj.GroupVersionKind().GroupKind(), name: client.ObjectKeyFromObject(obj)})
+}
+
+// Get reads an object of the directory.
+func (d *dirClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	gvk, err := apiutil.GVKForObject(obj, d.scheme)
+	if err != nil {
+		return err
+	}
+
+	d.mu.RLock()
+	stored, ok := d.objects[dirClientKey{kind: gvk.GroupKind(), name: key}]
+	d.mu.RUnlock()
+	if !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}, key.Name)
+	}
+	return d.convert(stored, obj)
+}
+
+// List reads the objects of the directory of a kind, in a namespace and matching a label
+// selector if the options have them.
+func (d *dirClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	gvk, err := apiutil.GVKForObject(list, d.scheme)
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	options := (&client.ListOptions{}).ApplyOptions(opts)
+	if options.FieldSelector != nil && !options.FieldSelector.Empty() {
+		return errors.New("field selectors are not supported for manifest directories")
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var items []runtime.Object
+	for key, stored := range d.objects {
+		if key.kind != gvk.GroupKind() {
+			continue
+		}
+		if options.Namespace != "" && key.name.Namespace != options.Namespace {
+			continue
+		}
+		if options.LabelSelector != nil && !options.LabelSelector.Matches(labels.Set(stored.GetLabels())) {
+			continue
+		}
+		if _, ok := list.(*unstructured.UnstructuredList); ok {
+			items = append(items, stored.DeepCopy())
+			continue
+		}
+		item, err := d.scheme.New(gvk)
+		if err != nil {
+			return err
+		}
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(stored.Object, item)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	return meta.SetList(list, items)
+}
+
+// convert copies a stored object into obj.
+func (d *dirClient) convert(stored *unstructured.Unstructured, obj client.Object) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		stored.DeepCopyInto(u)
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(stored.DeepCopy().Object, obj)
+}
+
+// updateStatus replaces the status of a stored object, refusing a stale one like the API
+// server does.
+func (d *dirClient) updateStatus(obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, d.scheme)
+	if err != nil {
+		return err
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := dirClientKey{kind: gvk.GroupKind(), name: client.ObjectKeyFromObject(obj)}
+	resource := schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(gvk.Kind)}
+	stored, ok := d.objects[key]
+	if !ok {
+		return apierrors.NewNotFound(resource, key.name.Name)
+	}
+	if obj.GetResourceVersion() != "" && obj.GetResourceVersion() != stored.GetResourceVersion() {
+		return apierrors.NewConflict(resource, key.name.Name, errors.New("the object has been modified"))
+	}
+	updated := stored.DeepCopy()
+	updated.Object["status"] = content["status"]
+	d.version++
+	updated.SetResourceVersion(strconv.FormatInt(d.version, 10))
+	d.objects[key] = updated
+	obj.SetResourceVersion(updated.GetResourceVersion())
+	return nil
+}
+
+// Apply is refused; the directory is the only writer of its objects.
+func (d *dirClient) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.ApplyOption) error {
+	return errDirReadOnly
+}
+
+// Create is refused; the directory is the only writer of its objects.
+func (d *dirClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return errDirReadOnly
+}
+
+// Delete is refused; the directory is the only writer of its objects.
+func (d *dirClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return errDirReadOnly
+}
+
+// Update is refused; the directory is the only writer of its objects.
+func (d *dirClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return errDirReadOnly
+}
+
+// Patch is refused; the directory is the only writer of its objects.
+func (d *dirClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return errDirReadOnly
+}
+
+// DeleteAllOf is refused; the directory is the only writer of its objects.
+func (d *dirClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	return errDirReadOnly
+}
+
+// Status returns the writer of the status of the objects.
+func (d *dirClient) Status() client.SubResourceWriter {
+	return d.SubResource("status")
+}
+
+// SubResource returns a client of a subresource of the objects; only status can be updated.
+func (d *dirClient) SubResource(subResource string) client.SubResourceClient {
+	return &dirSubResourceClient{dir: d, subResource: subResource}
+}
+
+// Scheme returns the scheme objects are converted with.
+func (d *dirClient) Scheme() *runtime.Scheme {
+	return d.scheme
+}
+
+// RESTMapper returns nil, as there is no API server to discover the resources of.
+func (d *dirClient) RESTMapper() meta.RESTMapper {
+	return nil
+}
+
+// GroupVersionKindFor returns the kind of an object.
+func (d *dirClient) GroupVersionKindFor(obj runtime.Object) (schema.GroupVersionKind, error) {
+	return apiutil.GVKForObject(obj, d.scheme)
+}
+
+// IsObjectNamespaced reports whether an object's kind is namespaced.
+func (d *dirClient) IsObjectNamespaced(obj runtime.Object) (bool, error) {
+	gvk, err := apiutil.GVKForObject(obj, d.scheme)
+	if err != nil {
+		return false, err
+	}
+	return !clusterScopedKinds[gvk.Kind] && gvk.Kind != "Namespace", nil
+}
+
+// dirSubResourceClient is the client of a subresource of the objects of a manifest directory.
+type dirSubResourceClient struct {
+	dir         *dirClient
+	subResource string
+}
+
+// Get is not supported for the objects of a manifest directory.
+func (s *dirSubResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	return errors.Errorf("subresource %s is not supported for manifest directories", s.subResource)
+}
+
+// Create is refused; the directory is the only writer of its objects.
+func (s *dirSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	return errDirReadOnly
+}
+
+// Update replaces the status of an object.
+func (s *dirSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if s.subResource != "status" {
+		return errDirReadOnly
+	}
+	return s.dir.updateStatus(obj)
+}
+
+// Patch is refused; the directory is the only writer of its objects.
+func (s *dirSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	return errDirReadOnly
+}
+
+// Apply is refused; the directory is the only writer of its objects.
+func (s *dirSubResourceClient) Apply(ctx context.Context, obj runtime.ApplyConfiguration, opts ...client.SubResourceApplyOption) error {
+	return errDirReadOnly
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// drainDirectives are added to the server block of a draining Website. Disabling keep-alive
+// lets in-flight requests finish while making clients reconnect elsewhere.
+const drainDirectives = "\tkeepalive_timeout 0;\n"
+
+// startDrain switches a deleted Website's server block to draining and removes it once the
+// drain window has passed. The hostname stays claimed until then.
+func (c *WebsiteController) startDrain(ctx context.Context, website *v1alpha1.Website) error {
+	policy := website.Spec.DrainPolicy
+
+	// Render the server block with keep-alive disabled
+	draining := website.DeepCopy()
+	if draining.DeletionTimestamp == nil {
+		now := metav1.Now()
+		draining.DeletionTimestamp = &now
+	}
+	if draining.Spec.Tuning != nil {
+		draining.Spec.Tuning.KeepaliveTimeout = nil
+	}
+	_, err := c.updateNginxServer(ctx, draining)
+	if err != nil {
+		return errors.Wrap(err, "failed to start draining")
+	}
+	c.log.Info("draining Website", "namespace", website.Namespace, "name", website.Name, "duration", policy.Duration.Duration)
+
+	go func() {
+		// Wait out the drain window
+		if !sleepContext(ctx, policy.Duration.Duration) {
+			return
+		}
+
+		// Answer the stragglers with 503
+		if policy.Respond503AfterDrain {
+			err := c.serveUnavailable(website)
+			if err != nil {
+				c.log.Error(err, "failed to serve 503 after drain", "namespace", website.Namespace, "name", website.Name)
+			} else if !sleepContext(ctx, policy.Duration.Duration) {
+				return
+			}
+		}
+
+		// Delete the Website as if it had no drain policy
+		drained := website.DeepCopy()
+		drained.Spec.DrainPolicy = nil
+		c.queue.Add(watch.Event{Type: watch.Deleted, Object: drained})
+	}()
+
+	return nil
+}
+
+// serveUnavailable replaces a drained Website's server block with one answering 503.
+func (c *WebsiteController) serveUnavailable(website *v1alpha1.Website) error {
+	retryAfter := int(website.Spec.DrainPolicy.Duration.Seconds())
+	listeners, err := listenDirectives(website, "")
+	if err != nil {
+		return errors.Wrap(err, "invalid listeners")
+	}
+	directives := fmt.Sprintf("%s\tadd_header Retry-After %d always;\n\treturn 503;\n", c.secretDirectives(website), retryAfter)
+	sni, err := c.sniServers(website, directives, "")
+	if err != nil {
+		return errors.Wrap(err, "invalid TLS configuration")
+	}
+	config := fmt.Sprintf(`
+server {
+%s	server_name %s;
+%s}
+%s`, listeners, website.Spec.Hostname, directives, sni)
+	if website.Spec.PathPrefix != "" {
+		config = fmt.Sprintf(`location ^~ %s {
+	add_header Retry-After %d always;
+	return 503;
+}
+`, website.Spec.PathPrefix, retryAfter)
+	}
+
+	configPath := nginxConfigPath(website)
+	err = writeFileAtomic(configPath, []byte(config), 0644)
+	c.audit(auditConfigWrite, website, configPath, []byte(config), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write Nginx configuration")
+	}
+
+	return c.reloadNginx(website)
+}
+
+// sleepContext sleeps for a duration and reports whether it was not interrupted by the context.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// runDriftDetection checks the configuration files of all Websites against the configurations
+// nginx was reloaded with every resync interval, until the context is cancelled.
+func (c *WebsiteController) runDriftDetection(ctx context.Context) {
+	ticker := time.NewTicker(c.resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.detectDrift(ctx)
+	}
+}
+
+// detectDrift finds Websites whose configuration file no longer holds what the controller wrote,
+// e.g. after a hand edit in /etc/nginx/conf.d, and queues them to be re-rendered, which writes
+// the file again and reloads nginx. Websites without an applied configuration are left to
+// their own retries.
+func (c *WebsiteController) detectDrift(ctx context.Context) {
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		c.log.Error(err, "failed to list Websites for drift detection")
+		return
+	}
+
+	for i := range list.Items {
+		website := &list.Items[i]
+		if !hasClass(website, c.class) || isPaused(website) || website.DeletionTimestamp != nil || servingMode(website) != v1alpha1.ServingModeNginx {
+			continue
+		}
+		c.appliedMu.Lock()
+		applied, ok := c.applied[websiteKey(website)]
+		c.appliedMu.Unlock()
+		if !ok {
+			continue
+		}
+
+		// Compare the file on disk with what nginx was reloaded with
+		path := nginxConfigPath(website)
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			sum := sha256.Sum256(data)
+			if hex.EncodeToString(sum[:]) == applied.SHA256 {
+				continue
+			}
+		}
+
+		message := "The configuration file " + path + " drifted from the Website and is repaired"
+		if err != nil {
+			message = "The configuration file " + path + " is missing and is written again"
+		}
+		c.log.Info("configuration drifted, repairing", "namespace", website.Namespace, "name", website.Name, "path", path)
+		c.event(website, corev1.EventTypeWarning, "DriftDetected", message)
+		configDrifts.WithLabelValues(website.Namespace, website.Name).Inc()
+		c.queue.Add(watch.Event{Type: watch.Modified, Object: website})
+	}
+}
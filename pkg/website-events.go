@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// eventComponent is the source of the events the controller emits.
+const eventComponent = "website-controller"
+
+// newEventRecorder creates a recorder emitting events to the API server.
+func newEventRecorder(config *rest.Config, scheme *runtime.Scheme) (record.EventRecorder, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create clientset")
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme, corev1.EventSource{Component: eventComponent}), nil
+}
+
+// event emits an event for a Website. Events are dropped if the controller has no recorder,
+// e.g. when it reads the Websites from a directory.
+func (c *WebsiteController) event(website *v1alpha1.Website, eventType, reason, message string) {
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Event(website, eventType, reason, message)
+}
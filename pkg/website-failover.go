@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// failoverInterval is how often the local and peer edges are health-checked.
+	failoverInterval = 10 * time.Second
+
+	// failoverThreshold is how many health checks in a row must fail before traffic is shifted
+	// to the peer edge, and succeed before it is shifted back, so a flapping edge does not
+	// flap the DNS records.
+	failoverThreshold = 3
+
+	// edgeLocal and edgePeer identify the weighted DNS records of the two edges.
+	edgeLocal = "local"
+	edgePeer  = "peer"
+)
+
+// dnsEndpointGVK is the kind of external-dns's DNSEndpoints. They are handled as unstructured
+// objects, so the controller does not depend on external-dns's API packages.
+var dnsEndpointGVK = schema.GroupVersionKind{Group: "externaldns.k8s.io", Version: "v1alpha1", Kind: "DNSEndpoint"}
+
+// weightedRecord is a DNS record of a Website's hostname pointing at one of the edges.
+type weightedRecord struct {
+	Identifier string `json:"identifier"`
+	Target     string `json:"target"`
+	Weight     int64  `json:"weight"`
+}
+
+// dnsProvider updates the weighted DNS records of the Websites' hostnames.
+type dnsProvider interface {
+	// setWeights points the hostname of a Website at the records, replacing its earlier ones.
+	setWeights(ctx context.Context, website *v1alpha1.Website, records []weightedRecord) error
+}
+
+// newDNSProvider creates the DNS provider of a --failover-dns-provider value: "external-dns"
+// for DNSEndpoints owned by the Websites, or "webhook:URL" to post the records as JSON.
+func (c *WebsiteController) newDNSProvider(spec string) (dnsProvider, error) {
+	switch {
+	case spec == "external-dns":
+		return &externalDNSProvider{controller: c}, nil
+	case strings.HasPrefix(spec, "webhook:"):
+		return &webhookDNSProvider{
+			endpoint: strings.TrimPrefix(spec, "webhook:"),
+			client:   &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown DNS provider %q, want external-dns or webhook:URL", spec)
+	}
+}
+
+// externalDNSProvider has external-dns publish the records from a DNSEndpoint per Website. The
+// weights are set as the aws/weight provider-specific property, so it takes a provider
+// supporting weighted records, such as Route 53.
+type externalDNSProvider struct {
+	controller *WebsiteController
+}
+
+func (p *externalDNSProvider) setWeights(ctx context.Context, website *v1alpha1.Website, records []weightedRecord) error {
+	endpoints := make([]interface{}, 0, len(records))
+	for _, record := range records {
+		recordType := "CNAME"
+		if net.ParseIP(record.Target) != nil {
+			recordType = "A"
+		}
+		endpoints = append(endpoints, map[string]interface{}{
+			"dnsName":       website.Spec.Hostname,
+			"recordType":    recordType,
+			"targets":       []interface{}{record.Target},
+			"setIdentifier": record.Identifier,
+			"providerSpecific": []interface{}{
+				map[string]interface{}{"name": "aws/weight", "value": strconv.FormatInt(record.Weight, 10)},
+			},
+		})
+	}
+
+	endpoint := &unstructured.Unstructured{}
+	endpoint.SetGroupVersionKind(dnsEndpointGVK)
+	endpoint.SetNamespace(website.Namespace)
+	endpoint.SetName("website-" + website.Name + "-failover")
+	return p.controller.applyOwned(ctx, website, "dnsendpoints", endpoint, func() {
+		endpoint.Object["spec"] = map[string]interface{}{"endpoints": endpoints}
+	})
+}
+
+// webhookDNSProvider posts the records to an HTTP endpoint, which updates them with the DNS
+// provider of the installation.
+type webhookDNSProvider struct {
+	endpoint string
+	client   *http.Client
+}
+
+// DNSWeightsUpdate is the body the webhook DNS provider posts to its endpoint.
+type DNSWeightsUpdate struct {
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Hostname  string           `json:"hostname"`
+	Records   []weightedRecord `json:"records"`
+}
+
+func (p *webhookDNSProvider) setWeights(ctx context.Context, website *v1alpha1.Website, records []weightedRecord) error {
+	body, err := json.Marshal(DNSWeightsUpdate{
+		Namespace: website.Namespace,
+		Name:      website.Name,
+		Hostname:  website.Spec.Hostname,
+		Records:   records,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal DNS records")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create DNS update request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to post DNS records")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("DNS webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// runFailover health-checks the local and peer edges every interval until the context is
+// cancelled, and points the DNS records of the Websites at the active edge. The local edge is
+// active unless it fails the threshold of checks in a row while the peer is healthy; traffic
+// is shifted back once it passes the threshold again. Traffic is never shifted to an
+// unhealthy peer.
+func (c *WebsiteController) runFailover(ctx context.Context) {
+	ticker := time.NewTicker(failoverInterval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: failoverInterval / 2}
+	active, reason := edgeLocal, ""
+	failures, successes := 0, 0
+	synced := map[string]string{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Check the edges
+		localErr := probeEdge(ctx, client, nginxAddress, "localhost", false)
+		peerErr := probeEdge(ctx, client, c.failoverPeerURL, "", true)
+		if localErr != nil {
+			failures, successes = failures+1, 0
+		} else {
+			failures, successes = 0, successes+1
+		}
+
+		// Shift the traffic
+		switch {
+		case active == edgeLocal && failures >= failoverThreshold && peerErr == nil:
+			c.log.Info("local edge unhealthy, failing over to peer edge", "reason", localErr.Error())
+			active, reason = edgePeer, localErr.Error()
+		case active == edgeLocal && failures == failoverThreshold:
+			c.log.Info("local edge unhealthy, but so is the peer edge, not failing over", "reason", localErr.Error(), "peer", peerErr.Error())
+		case active == edgePeer && successes >= failoverThreshold:
+			c.log.Info("local edge healthy again, failing back from peer edge")
+			active, reason = edgeLocal, ""
+		}
+		failoverActive.WithLabelValues(edgeLocal).Set(boolGauge(active == edgeLocal))
+		failoverActive.WithLabelValues(edgePeer).Set(boolGauge(active == edgePeer))
+
+		err := c.syncFailoverRecords(ctx, active, reason, synced)
+		if err != nil {
+			c.log.Error(err, "failed to update failover DNS records")
+		}
+	}
+}
+
+// syncFailoverRecords points the DNS records of the Websites the edge serves at the active
+// edge, unless they already are, and records an event on the Websites whose traffic shifted.
+// Websites below a path prefix share the records of their hostname, and Websites served by an
+// Ingress or Deployment are not served by the edge.
+func (c *WebsiteController) syncFailoverRecords(ctx context.Context, active, reason string, synced map[string]string) error {
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+
+	weights := map[string]int64{edgeLocal: 0, edgePeer: 0}
+	weights[active] = 100
+	records := []weightedRecord{
+		{Identifier: edgeLocal, Target: c.failoverLocalTarget, Weight: weights[edgeLocal]},
+		{Identifier: edgePeer, Target: c.failoverPeerTarget, Weight: weights[edgePeer]},
+	}
+
+	listed := map[string]bool{}
+	var errs []string
+	for i := range list.Items {
+		website := &list.Items[i]
+		if !hasClass(website, c.class) || website.Spec.PathPrefix != "" || website.DeletionTimestamp != nil || servingMode(website) != v1alpha1.ServingModeNginx {
+			continue
+		}
+		key := websiteKey(website)
+		listed[key] = true
+		previous, ok := synced[key]
+		if previous == active {
+			continue
+		}
+
+		err := c.failoverDNS.setWeights(ctx, website, records)
+		if err != nil {
+			errs = append(errs, key+": "+err.Error())
+			continue
+		}
+		synced[key] = active
+		if !ok {
+			continue
+		}
+		if active == edgePeer {
+			c.event(website, corev1.EventTypeWarning, "FailedOver", "Traffic was shifted to the peer edge "+c.failoverPeerTarget+", as the local edge is unhealthy: "+reason)
+		} else {
+			c.event(website, corev1.EventTypeNormal, "FailedBack", "Traffic was shifted back to the local edge "+c.failoverLocalTarget)
+		}
+	}
+	for key := range synced {
+		if !listed[key] {
+			delete(synced, key)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Errorf("failed to update the DNS records of %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// probeEdge checks that an edge answers an HTTP request, with a 2xx status if requireSuccess is
+// set. Any response counts for the local edge, whose default server answers unknown hostnames
+// with 404.
+func probeEdge(ctx context.Context, client *http.Client, url, host string, requireSuccess bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create health check request")
+	}
+	if host != "" {
+		req.Host = host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "health check of %s failed", url)
+	}
+	resp.Body.Close()
+	if requireSuccess && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+		return errors.Errorf("health check of %s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// boolGauge returns the value of a boolean gauge.
+func boolGauge(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
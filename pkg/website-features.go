@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Feature gates of the controller. New subsystems ship behind a gate, so they can be enabled
+// progressively with --feature-gates and disabled again without a rollback of the controller.
+const (
+	// featureDynamicUpstreams weights the upstreams of leastTime Websites by their probed
+	// latency when nginx lacks native least_time.
+	featureDynamicUpstreams = "DynamicUpstreams"
+
+	// featureDeploymentMode serves Websites in Deployment mode with an nginx Deployment each.
+	featureDeploymentMode = "DeploymentMode"
+
+	// featureDriftRepair repairs configuration files edited behind the controller's back.
+	featureDriftRepair = "DriftRepair"
+
+	// featureOrphanCollection deletes on start the configurations of Websites deleted while
+	// the controller was down.
+	featureOrphanCollection = "OrphanCollection"
+
+	// featureACME issues the certificates of spec.tls.acme with the built-in ACME client.
+	featureACME = "ACME"
+
+	// featureDNSFailover shifts the DNS records of the Websites to a peer edge while the local
+	// edge is unhealthy.
+	featureDNSFailover = "DNSFailover"
+
+	// featureTenantTemplates renders the server blocks of Websites with their spec.templateRef.
+	// A template can emit any directive, bypassing WebsitePolicy, the upstream guard and the
+	// validation of middleware and transforms, so it is only for clusters whose tenants are
+	// trusted with the nginx configuration.
+	featureTenantTemplates = "TenantTemplates"
+
+	// featureTenantTransforms runs the njs scripts of spec.transform. njs cannot be sandboxed,
+	// so a script can read the secret material of all Websites and reach internal services.
+	featureTenantTransforms = "TenantTransforms"
+)
+
+// defaultFeatureGates are the known feature gates and whether they are enabled by default.
+var defaultFeatureGates = map[string]bool{
+	featureDynamicUpstreams: true,
+	featureDeploymentMode:   false,
+	featureDriftRepair:      true,
+	featureOrphanCollection: true,
+	featureACME:             false,
+	featureDNSFailover:      false,
+	featureTenantTemplates:  false,
+	featureTenantTransforms: false,
+}
+
+// parseFeatureGates parses a comma-separated list of gate=bool pairs, e.g.
+// "DeploymentMode=true,DriftRepair=false", over the default gates.
+func parseFeatureGates(spec string) (map[string]bool, error) {
+	gates := map[string]bool{}
+	for gate, enabled := range defaultFeatureGates {
+		gates[gate] = enabled
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid feature gate %q, want gate=true or gate=false", pair)
+		}
+		gate := strings.TrimSpace(parts[0])
+		if _, ok := defaultFeatureGates[gate]; !ok {
+			return nil, errors.Errorf("unknown feature gate %q, known gates are %s", gate, strings.Join(knownFeatureGates(), ", "))
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, errors.Errorf("invalid value %q of feature gate %s", parts[1], gate)
+		}
+		gates[gate] = enabled
+	}
+	return gates, nil
+}
+
+// knownFeatureGates returns the names of the known feature gates, sorted.
+func knownFeatureGates() []string {
+	gates := make([]string, 0, len(defaultFeatureGates))
+	for gate := range defaultFeatureGates {
+		gates = append(gates, gate)
+	}
+	sort.Strings(gates)
+	return gates
+}
+
+// featureEnabled reports whether a feature gate is enabled. A controller without parsed gates,
+// e.g. the renderer of the CLI, uses the defaults.
+func (c *WebsiteController) featureEnabled(gate string) bool {
+	if enabled, ok := c.features[gate]; ok {
+		return enabled
+	}
+	return defaultFeatureGates[gate]
+}
+
+// recordFeatureGates exports the state of the feature gates as metrics.
+func recordFeatureGates(gates map[string]bool) {
+	for gate, enabled := range gates {
+		value := 0.0
+		if enabled {
+			value = 1
+		}
+		featureGateEnabled.WithLabelValues(gate).Set(value)
+	}
+}
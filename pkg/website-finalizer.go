@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// websiteFinalizer holds back the deletion of a Website until its nginx configuration is
+// removed, so a Website deleted while the controller is down is cleaned up once it is back.
+const websiteFinalizer = "website-operator.io/nginx-config"
+
+// addFinalizer adds the finalizer to a Website that is about to be served. Websites read from
+// a directory have no finalizer, as nothing but the controller deletes them.
+func (c *WebsiteController) addFinalizer(ctx context.Context, website *v1alpha1.Website) error {
+	if c.sourceDir != "" || controllerutil.ContainsFinalizer(website, websiteFinalizer) {
+		return nil
+	}
+	return c.updateFinalizer(ctx, website, controllerutil.AddFinalizer)
+}
+
+// removeFinalizer removes the finalizer from a Website whose nginx configuration is removed,
+// letting the API server delete it.
+func (c *WebsiteController) removeFinalizer(ctx context.Context, website *v1alpha1.Website) error {
+	if c.sourceDir != "" {
+		return nil
+	}
+	return c.updateFinalizer(ctx, website, controllerutil.RemoveFinalizer)
+}
+
+// updateFinalizer applies a finalizer change to the latest version of a Website. A Website
+// that no longer exists is not an error, and one moved to another class is left to its
+// controller, which shares the finalizer.
+func (c *WebsiteController) updateFinalizer(ctx context.Context, website *v1alpha1.Website, change func(client.Object, string) bool) error {
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &v1alpha1.Website{}
+		err := c.client.Get(ctx, key, latest)
+		if err != nil {
+			return err
+		}
+		if !hasClass(latest, c.class) || !change(latest, websiteFinalizer) {
+			return nil
+		}
+		err = c.client.Update(ctx, latest)
+		c.audit(auditObjectApply, latest, "websites/"+websiteKey(latest), nil, err)
+		return err
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to update Website finalizers")
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// httpRouteGVK and gatewayGVK are the kinds of Gateway API HTTPRoutes and Gateways. They are
+// handled as unstructured objects, so the controller does not depend on the Gateway API
+// packages and runs in clusters without its CRDs.
+var (
+	httpRouteGVK = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "HTTPRoute"}
+	gatewayGVK   = schema.GroupVersionKind{Group: "gateway.networking.k8s.io", Version: "v1", Kind: "Gateway"}
+)
+
+// gatewayObjectName is the name of the HTTPRoute and backend Service emitted for a Website in
+// GatewayAPI mode.
+func gatewayObjectName(website *v1alpha1.Website) string {
+	return "website-" + website.Name + "-route"
+}
+
+// gatewayName is the name of the Gateway emitted for a Website with a GatewayClassName.
+func gatewayName(website *v1alpha1.Website) string {
+	return "website-" + website.Name
+}
+
+// gatewayUnsupportedFields lists the set fields of a Website that cannot be expressed as an
+// HTTPRoute. The fields are those of Ingress mode, except the endpoints of a headless
+// Service, which the HTTPRoute balances across itself, and https upstreams, which need a
+// BackendTLSPolicy.
+func gatewayUnsupportedFields(website *v1alpha1.Website) []string {
+	var fields []string
+	for _, field := range ingressUnsupportedFields(website) {
+		if field == "spec.upstreams" && website.Spec.ServiceRef != nil {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	if strings.HasPrefix(website.Spec.Upstream, "https://") {
+		fields = append(fields, "spec.upstream https")
+	}
+	if website.Spec.UpstreamTLS != nil {
+		fields = append(fields, "spec.upstreamTLS")
+	}
+	return fields
+}
+
+// serveGatewayAPI hands a Website in GatewayAPI mode to the cluster's Gateway implementation,
+// removing the nginx server of a Website that was served by the controller before.
+func (c *WebsiteController) serveGatewayAPI(ctx context.Context, website *v1alpha1.Website) error {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "HTTPRouteApplied",
+		Message:            "The Website is served by the HTTPRoute " + gatewayObjectName(website),
+		ObservedGeneration: website.Generation,
+	}
+
+	// Refuse fields an HTTPRoute cannot express
+	unsupported := gatewayUnsupportedFields(website)
+	var routeErr error
+	if len(unsupported) > 0 {
+		routeErr = errors.Errorf("GatewayAPI mode does not support %s", strings.Join(unsupported, ", "))
+	} else if website.Spec.Serving.GatewayClassName == "" && len(website.Spec.Serving.ParentRefs) == 0 {
+		routeErr = errors.New("GatewayAPI mode requires spec.serving.parentRefs or spec.serving.gatewayClassName")
+	} else {
+		routeErr = c.applyHTTPRoute(ctx, website)
+	}
+	if routeErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "HTTPRouteFailed"
+		condition.Message = routeErr.Error()
+	}
+
+	// Stop serving the Website with nginx
+	if routeErr == nil {
+		if _, err := os.Stat(nginxConfigPath(website)); err == nil {
+			err = c.deleteNginxServer(website)
+			if err != nil {
+				return errors.Wrap(err, "failed to delete Nginx server")
+			}
+			err = c.removeRevisions(website)
+			if err != nil {
+				return errors.Wrap(err, "failed to delete revision history")
+			}
+		}
+	}
+
+	// Record the outcome in the Website status
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return routeErr
+}
+
+// applyHTTPRoute creates or updates the HTTPRoute of a Website, its Gateway if it has a
+// GatewayClassName, and the ExternalName Service pointing at an upstream URL. A Website with
+// a serviceRef routes to the Service directly.
+func (c *WebsiteController) applyHTTPRoute(ctx context.Context, website *v1alpha1.Website) error {
+	serving := website.Spec.Serving
+
+	// Point the route at the Service, or at an ExternalName Service of the upstream
+	backend, err := c.applyGatewayBackend(ctx, website)
+	if err != nil {
+		return err
+	}
+
+	// Apply the Gateway, or remove the one of a former GatewayClassName
+	var parentRefs []interface{}
+	if serving.GatewayClassName != "" {
+		err = c.applyGateway(ctx, website)
+		if err != nil {
+			return err
+		}
+		parentRefs = append(parentRefs, map[string]interface{}{"name": gatewayName(website)})
+	} else {
+		err = c.deleteOwned(ctx, website, gatewayObject(website))
+		if err != nil {
+			return err
+		}
+	}
+	for _, ref := range serving.ParentRefs {
+		parentRef := map[string]interface{}{"name": ref.Name}
+		if ref.Namespace != "" {
+			parentRef["namespace"] = ref.Namespace
+		}
+		if ref.SectionName != "" {
+			parentRef["sectionName"] = ref.SectionName
+		}
+		parentRefs = append(parentRefs, parentRef)
+	}
+
+	// Apply the HTTPRoute
+	path := "/"
+	if website.Spec.PathPrefix != "" {
+		path = website.Spec.PathPrefix
+	}
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetNamespace(website.Namespace)
+	route.SetName(gatewayObjectName(website))
+	return c.applyOwned(ctx, website, "httproutes", route, func() {
+		route.Object["spec"] = map[string]interface{}{
+			"parentRefs": parentRefs,
+			"hostnames":  []interface{}{website.Spec.Hostname},
+			"rules": []interface{}{map[string]interface{}{
+				"matches": []interface{}{map[string]interface{}{
+					"path": map[string]interface{}{"type": "PathPrefix", "value": path},
+				}},
+				"backendRefs": []interface{}{backend},
+			}},
+		}
+	})
+}
+
+// applyGatewayBackend returns the backend reference of a Website's HTTPRoute, applying the
+// ExternalName Service of an upstream URL. A Service in another namespace needs a
+// ReferenceGrant allowing HTTPRoutes of the Website's namespace to refer to it.
+func (c *WebsiteController) applyGatewayBackend(ctx context.Context, website *v1alpha1.Website) (map[string]interface{}, error) {
+	if ref := website.Spec.ServiceRef; ref != nil {
+		backend := map[string]interface{}{"name": ref.Name, "port": int64(ref.Port)}
+		if ref.Namespace != "" && ref.Namespace != website.Namespace {
+			backend["namespace"] = ref.Namespace
+		}
+		return backend, nil
+	}
+
+	upstream, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upstream")
+	}
+	port := 80
+	if upstream.Port() != "" {
+		port, err = strconv.Atoi(upstream.Port())
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid upstream port")
+		}
+	}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: website.Namespace, Name: gatewayObjectName(website)}}
+	err = c.applyOwned(ctx, website, "services", service, func() {
+		service.Spec.Type = corev1.ServiceTypeExternalName
+		service.Spec.ExternalName = upstream.Hostname()
+		service.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: int32(port)}}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"name": service.Name, "port": int64(port)}, nil
+}
+
+// applyGateway creates or updates the Gateway of a Website with a GatewayClassName.
+func (c *WebsiteController) applyGateway(ctx context.Context, website *v1alpha1.Website) error {
+	var listeners []interface{}
+	if !redirectsHTTP(website) {
+		listeners = append(listeners, map[string]interface{}{
+			"name":     "http",
+			"hostname": website.Spec.Hostname,
+			"port":     int64(80),
+			"protocol": "HTTP",
+		})
+	}
+	if ref := tlsSecretRef(website); ref != nil {
+		listeners = append(listeners, map[string]interface{}{
+			"name":     "https",
+			"hostname": website.Spec.Hostname,
+			"port":     int64(443),
+			"protocol": "HTTPS",
+			"tls": map[string]interface{}{
+				"mode":            "Terminate",
+				"certificateRefs": []interface{}{map[string]interface{}{"name": ref.Name}},
+			},
+		})
+	}
+
+	gateway := gatewayObject(website)
+	return c.applyOwned(ctx, website, "gateways", gateway, func() {
+		gateway.Object["spec"] = map[string]interface{}{
+			"gatewayClassName": website.Spec.Serving.GatewayClassName,
+			"listeners":        listeners,
+		}
+	})
+}
+
+// gatewayObject returns an empty Gateway named after a Website.
+func gatewayObject(website *v1alpha1.Website) *unstructured.Unstructured {
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(gatewayGVK)
+	gateway.SetNamespace(website.Namespace)
+	gateway.SetName(gatewayName(website))
+	return gateway
+}
+
+// removeHTTPRoute deletes the HTTPRoute, Gateway and Service of a Website that is no longer in
+// GatewayAPI mode.
+func (c *WebsiteController) removeHTTPRoute(ctx context.Context, website *v1alpha1.Website) error {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetNamespace(website.Namespace)
+	route.SetName(gatewayObjectName(website))
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: website.Namespace, Name: gatewayObjectName(website)}}
+	return c.deleteOwned(ctx, website, route, gatewayObject(website), service)
+}
+
+// deleteOwned deletes the objects a Website owns, if they exist. Objects whose kind is not
+// installed in the cluster, e.g. without the Gateway API CRDs, do not exist.
+func (c *WebsiteController) deleteOwned(ctx context.Context, website *v1alpha1.Website, objects ...client.Object) error {
+	for _, object := range objects {
+		err := c.client.Get(ctx, client.ObjectKeyFromObject(object), object)
+		if err == nil && metav1.IsControlledBy(object, website) {
+			err = c.client.Delete(ctx, object)
+		}
+		if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return errors.Wrapf(err, "failed to delete %s", object.GetName())
+		}
+	}
+	return nil
+}
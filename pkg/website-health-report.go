@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// healthReportPath is where the health report of a Website is served.
+	healthReportPath = "/.well-known/health"
+
+	// healthReportDir holds the health reports within the state directory.
+	healthReportDir = "health"
+
+	// healthReportInterval is how often the health reports are regenerated.
+	healthReportInterval = 30 * time.Second
+)
+
+// healthReportTokenPattern matches the bearer tokens of RFC 6750, which are rendered into the
+// configuration verbatim.
+var healthReportTokenPattern = regexp.MustCompile(`^[A-Za-z0-9._~+/-]+=*$`)
+
+// HealthReport is the health of a Website as served at /.well-known/health.
+type HealthReport struct {
+	Website string `json:"website"`
+	URL     string `json:"url"`
+	Updated string `json:"updated"`
+
+	// Ready is the Website's Ready condition.
+	Ready HealthReportCondition `json:"ready"`
+
+	// CertificateExpiry is when the Website's certificate expires, if it is served with one.
+	CertificateExpiry string `json:"certificateExpiry,omitempty"`
+
+	// Upstream is whether the primary upstream answered a probe.
+	Upstream HealthReportUpstream `json:"upstream"`
+}
+
+// HealthReportCondition is a condition in a health report.
+type HealthReportCondition struct {
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// HealthReportUpstream is the reachability of an upstream in a health report.
+type HealthReportUpstream struct {
+	Reachable bool   `json:"reachable"`
+	Latency   string `json:"latency,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// healthReportToken returns the Secret key holding a Website's health report token, if any.
+func healthReportToken(website *v1alpha1.Website) *corev1.SecretKeySelector {
+	if website.Spec.HealthReport == nil {
+		return nil
+	}
+	return &website.Spec.HealthReport.TokenSecretRef
+}
+
+// renderHealthReportAuth reads a health report token from its Secret and renders the include
+// rejecting requests without it.
+func (c *WebsiteController) renderHealthReportAuth(ctx context.Context, namespace string, ref *corev1.SecretKeySelector) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Secret %s", ref.Name)
+	}
+	token, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, errors.Errorf("Secret %s has no key %s", ref.Name, ref.Key)
+	}
+
+	// The token is validated without echoing it in the error
+	if !healthReportTokenPattern.Match(token) {
+		return nil, errors.Errorf("Secret %s holds an invalid health report token", ref.Name)
+	}
+	return []byte(fmt.Sprintf("if ($http_authorization != \"Bearer %s\") {\n\treturn 401;\n}\n", token)), nil
+}
+
+// healthReportDirectives renders the location serving a Website's health report, which is
+// exempt from basic and forward authentication but requires the report token.
+func (c *WebsiteController) healthReportDirectives(website *v1alpha1.Website, forwardAuth bool) (string, error) {
+	if website.Spec.HealthReport == nil {
+		return "", nil
+	}
+	if website.Spec.PathPrefix != "" {
+		return "", errors.Errorf("%s is outside the path prefix %s", healthReportPath, website.Spec.PathPrefix)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\tlocation = %s {\n", healthReportPath)
+	b.WriteString("\t\tauth_basic off;\n")
+	if forwardAuth {
+		b.WriteString("\t\tauth_request off;\n")
+	}
+	b.WriteString("\t\taccess_log off;\n")
+	fmt.Fprintf(&b, "\t\tinclude %s;\n", c.secretFile(website, "health-auth.conf"))
+	b.WriteString("\t\tdefault_type application/json;\n")
+	b.WriteString("\t\tadd_header Cache-Control \"no-store\" always;\n")
+	fmt.Fprintf(&b, "\t\talias %s;\n", c.healthReportFile(website))
+	b.WriteString("\t}\n")
+	return b.String(), nil
+}
+
+// runHealthReports regenerates the health reports of the Websites until the context is
+// cancelled. nginx reads the reports on every request, so no reload is needed.
+func (c *WebsiteController) runHealthReports(ctx context.Context) {
+	ticker := time.NewTicker(healthReportInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.syncHealthReports(ctx)
+		if err != nil {
+			c.log.Error(err, "failed to generate health reports")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncHealthReports writes the health report of every Website that serves one and removes
+// the reports of all others.
+func (c *WebsiteController) syncHealthReports(ctx context.Context) error {
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+
+	dir := filepath.Join(c.stateDir, healthReportDir)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create health report directory")
+	}
+	reports := map[string]bool{}
+	for i := range list.Items {
+		website, err := applyOverlay(&list.Items[i], c.environment)
+		if err != nil || !hasClass(website, c.class) || website.Spec.HealthReport == nil {
+			continue
+		}
+		path := c.healthReportFile(website)
+		reports[path] = true
+
+		report, err := json.MarshalIndent(c.healthReport(ctx, website), "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed to render health report")
+		}
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && bytes.Equal(existing, report) {
+			continue
+		}
+		err = writeFileAtomic(path, report, 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to write health report")
+		}
+	}
+
+	// Remove the reports of deleted Websites and Websites that no longer serve one
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list health reports")
+	}
+	for _, path := range paths {
+		if !reports[path] {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
+
+// healthReport assembles the health report of a Website, probing its primary upstream.
+func (c *WebsiteController) healthReport(ctx context.Context, website *v1alpha1.Website) HealthReport {
+	report := HealthReport{
+		Website: websiteKey(website),
+		URL:     websiteURL(website),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Ready:   HealthReportCondition{Status: "Unknown"},
+	}
+
+	ready := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionReady)
+	if ready != nil {
+		report.Ready = HealthReportCondition{Status: string(ready.Status), Reason: ready.Reason, Message: ready.Message}
+	}
+
+	if hasTLSMaterial(website) {
+		expiry, err := certificateExpiry(c.secretFile(website, "tls.crt"))
+		if err == nil {
+			report.CertificateExpiry = expiry.UTC().Format(time.RFC3339)
+		}
+	}
+
+	latency, err := probePrimaryUpstream(ctx, website)
+	if err != nil {
+		report.Upstream.Error = err.Error()
+	} else {
+		report.Upstream.Reachable = true
+		report.Upstream.Latency = latency.String()
+	}
+	return report
+}
+
+// probePrimaryUpstream probes the primary upstream of a Website as the latency probes do.
+func probePrimaryUpstream(ctx context.Context, website *v1alpha1.Website) (time.Duration, error) {
+	primary, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return 0, err
+	}
+	server, err := upstreamServer(website.Spec.Upstream, primary.Scheme)
+	if err != nil {
+		return 0, err
+	}
+	aliases, err := hostAliases(website)
+	if err != nil {
+		return 0, err
+	}
+	return probeUpstream(ctx, website, primary, aliasedServer(server, aliases))
+}
+
+// healthReportFile is the path of a Website's health report.
+func (c *WebsiteController) healthReportFile(website *v1alpha1.Website) string {
+	return filepath.Join(c.stateDir, healthReportDir, website.Namespace+"_"+website.Name+".json")
+}
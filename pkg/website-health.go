@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// watchCheckInterval is how often the watch of Websites is checked for changes it missed.
+	watchCheckInterval = 30 * time.Second
+
+	// watchStallTimeout is how long a change of a Website may go unseen by the watch, or an
+	// event wait in the queue, before the controller is considered wedged and fails liveness.
+	watchStallTimeout = 5 * time.Minute
+)
+
+// serveHealth serves the liveness probe at /healthz and the readiness probe at /readyz until
+// the context is cancelled. The controller is ready once it listed the Websites and the nginx
+// master process is reachable, and live unless its watch or queue is stuck.
+func (c *WebsiteController) serveHealth(ctx context.Context, address string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, c.checkLive())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeProbe(w, c.checkReady())
+	})
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// writeProbe answers a probe with 200 if it passed, or 503 and the reason if it failed.
+func writeProbe(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err.Error())
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// checkReady reports why the controller is not ready to serve, if it is not.
+func (c *WebsiteController) checkReady() error {
+	c.healthMu.Lock()
+	listed := c.listed
+	c.healthMu.Unlock()
+	if !listed {
+		return errors.New("Websites not listed yet")
+	}
+
+	// Signal 0 only checks that the nginx master process exists and can be signalled to reload
+	err := signalNginx(c.nginxPidFile, 0)
+	if err != nil {
+		return errors.Wrap(err, "nginx is not reachable")
+	}
+	return nil
+}
+
+// checkLive reports why the controller is wedged, if it is.
+func (c *WebsiteController) checkLive() error {
+	c.healthMu.Lock()
+	stalled := c.watchStalled
+	c.healthMu.Unlock()
+	if stalled != nil {
+		return stalled
+	}
+
+	oldest, ok := c.queue.Oldest()
+	if ok && time.Since(oldest) > watchStallTimeout {
+		return errors.Errorf("queue stuck, oldest event waiting since %s", oldest.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// setListed marks the Websites as listed, so the controller reports ready.
+func (c *WebsiteController) setListed() {
+	c.healthMu.Lock()
+	defer c.healthMu.Unlock()
+	c.listed = true
+}
+
+// checkWatch lists the Websites from the API server every interval until the context is
+// cancelled, and compares them with the ones the watch delivered to the reconciler, ignoring
+// the changes websitePredicate drops. A change the watch does not deliver within the stall timeout marks it stuck. An
+// unreachable API server does not, as restarting the controller would not help.
+func (c *WebsiteController) checkWatch(ctx context.Context, r *websiteReconciler) {
+	ticker := time.NewTicker(watchCheckInterval)
+	defer ticker.Stop()
+
+	// unseen holds when a resource version of a Website was first missing from the watch
+	type version struct {
+		resourceVersion string
+		since           time.Time
+	}
+	unseen := map[types.NamespacedName]version{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		list := &v1alpha1.WebsiteList{}
+		err := c.client.List(ctx, list)
+		if err != nil {
+			c.log.V(1).Info("failed to list Websites to check the watch", "error", err.Error())
+			continue
+		}
+
+		// Find the Websites whose latest version the watch did not deliver
+		r.mu.Lock()
+		current := map[types.NamespacedName]version{}
+		for i := range list.Items {
+			website := &list.Items[i]
+			if !hasClass(website, c.class) {
+				continue
+			}
+			key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+			observed, ok := r.observed[key]
+			if ok && !watchedChange(observed, website) {
+				continue
+			}
+			current[key] = version{resourceVersion: website.ResourceVersion, since: time.Now()}
+			if previous, ok := unseen[key]; ok && previous.resourceVersion == website.ResourceVersion {
+				current[key] = previous
+			}
+		}
+		r.mu.Unlock()
+		unseen = current
+
+		// Report a Website the watch missed for longer than the stall timeout
+		var stalled error
+		for key, v := range unseen {
+			if time.Since(v.since) > watchStallTimeout {
+				stalled = errors.Errorf("watch stuck, version %s of Website %s not seen since %s", v.resourceVersion, key, v.since.Format(time.RFC3339))
+				break
+			}
+		}
+		c.healthMu.Lock()
+		if stalled != nil && c.watchStalled == nil {
+			c.log.Error(stalled, "Website watch is stuck")
+		}
+		c.watchStalled = stalled
+		c.healthMu.Unlock()
+	}
+}
+
+// watchedChange reports whether a Website changed since it was observed in a way that
+// websitePredicate lets through.
+func watchedChange(observed, website *v1alpha1.Website) bool {
+	return observed.Generation != website.Generation ||
+		!reflect.DeepEqual(observed.Labels, website.Labels) ||
+		!reflect.DeepEqual(observed.Annotations, website.Annotations)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// rollbackAnnotation requests that a Website is rolled back to a revision of its history.
+	// It is set by `kubectl website rollback` and removed by the controller once applied.
+	rollbackAnnotation = "website-operator.io/rollback-to-revision"
+
+	// defaultStateDir is the writable directory the controller keeps its state in.
+	defaultStateDir = "/var/lib/website-controller"
+
+	// historyDir is where the revision history of every Website is kept, within the state directory.
+	historyDir = "history"
+
+	// defaultRevisionHistoryLimit is how many revisions are kept if a Website does not say.
+	defaultRevisionHistoryLimit = 10
+)
+
+// revisionFile is a rendered configuration kept in the revision history.
+// Its file name is "<revision>-<generation>.conf".
+type revisionFile struct {
+	path   string
+	status v1alpha1.RevisionStatus
+}
+
+// recordRevision adds the configuration just written for a Website to its revision history,
+// unless it is identical to the latest revision, and publishes the history in the status.
+func (c *WebsiteController) recordRevision(ctx context.Context, website *v1alpha1.Website) error {
+	config, err := ioutil.ReadFile(nginxConfigPath(website))
+	if err != nil {
+		return errors.Wrap(err, "failed to read Nginx configuration")
+	}
+	revisions, err := c.listRevisions(website)
+	if err != nil {
+		return err
+	}
+
+	// Add a revision if the configuration changed
+	sum := sha256.Sum256(config)
+	hash := hex.EncodeToString(sum[:])
+	if len(revisions) == 0 || revisions[len(revisions)-1].status.SHA256 != hash {
+		var next int64 = 1
+		if len(revisions) > 0 {
+			next = revisions[len(revisions)-1].status.Revision + 1
+		}
+		dir := c.revisionDir(website)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return errors.Wrap(err, "failed to create revision directory")
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%d-%d.conf", next, website.Generation))
+		err = writeFileAtomic(path, config, 0644)
+		c.audit(auditConfigWrite, website, path, config, err)
+		if err != nil {
+			return errors.Wrap(err, "failed to write revision")
+		}
+		revisions = append(revisions, revisionFile{path: path, status: v1alpha1.RevisionStatus{
+			Revision:   next,
+			SHA256:     hash,
+			Generation: website.Generation,
+			Time:       metav1.Now(),
+		}})
+	}
+
+	// Prune the oldest revisions beyond the limit
+	limit := defaultRevisionHistoryLimit
+	if website.Spec.RevisionHistoryLimit != nil && *website.Spec.RevisionHistoryLimit > 0 {
+		limit = int(*website.Spec.RevisionHistoryLimit)
+	}
+	for len(revisions) > limit {
+		err = os.Remove(revisions[0].path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to prune revision")
+		}
+		revisions = revisions[1:]
+	}
+
+	// Publish the history; rendering from the spec ends any rollback
+	statuses := make([]v1alpha1.RevisionStatus, 0, len(revisions))
+	for _, revision := range revisions {
+		statuses = append(statuses, revision.status)
+	}
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	return c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		status.Revisions = statuses
+		status.Rollback = nil
+	})
+}
+
+// handleRollback applies a requested rollback and reports whether a rolled back revision is
+// being served. A rollback stays in effect until the Website's spec changes.
+func (c *WebsiteController) handleRollback(ctx context.Context, website *v1alpha1.Website) (bool, error) {
+	value, ok := website.Annotations[rollbackAnnotation]
+	if !ok {
+		rollback := website.Status.Rollback
+		return rollback != nil && rollback.Generation == website.Generation, nil
+	}
+
+	// Find the requested revision
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false, errors.Errorf("invalid %s annotation %q", rollbackAnnotation, value)
+	}
+	revisions, err := c.listRevisions(website)
+	if err != nil {
+		return false, err
+	}
+	var target *revisionFile
+	for i := range revisions {
+		if revisions[i].status.Revision == revision {
+			target = &revisions[i]
+		}
+	}
+	if target == nil {
+		return false, errors.Errorf("revision %d is not in the history", revision)
+	}
+
+	// Restore the revision
+	config, err := ioutil.ReadFile(target.path)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read revision")
+	}
+	// Validate the revision like any other configuration, keeping the served one if nginx
+	// rejects it
+	configPath := nginxConfigPath(website)
+	err = c.validateConfig(ctx, website, configPath, config, readPreviousConfig(configPath))
+	if err != nil {
+		return false, err
+	}
+	err = writeFileAtomic(configPath, config, 0644)
+	c.audit(auditConfigWrite, website, configPath, config, err)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to write Nginx configuration")
+	}
+	err = c.reloadNginxThen(website, func(err error) {
+		if err == nil {
+			c.recordApplied(website, config)
+		}
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to reload Nginx configuration")
+	}
+	c.log.Info("rolled back Website", "namespace", website.Namespace, "name", website.Name, "revision", revision)
+
+	// Record the rollback
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err = c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		status.Rollback = &v1alpha1.RollbackStatus{
+			Revision:   revision,
+			Generation: website.Generation,
+			Time:       metav1.Now(),
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// Remove the annotation, so the rollback is applied only once
+	patched := website.DeepCopy()
+	delete(patched.Annotations, rollbackAnnotation)
+	err = c.client.Patch(ctx, patched, client.MergeFrom(website))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to remove rollback annotation")
+	}
+
+	return true, nil
+}
+
+// removeRevisions deletes the revision history of a Website.
+func (c *WebsiteController) removeRevisions(website *v1alpha1.Website) error {
+	err := os.RemoveAll(c.revisionDir(website))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete revisions")
+	}
+	return nil
+}
+
+// listRevisions lists the revision history of a Website, oldest first.
+func (c *WebsiteController) listRevisions(website *v1alpha1.Website) ([]revisionFile, error) {
+	dir := c.revisionDir(website)
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list revisions")
+	}
+
+	var revisions []revisionFile
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSuffix(entry.Name(), ".conf"), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		revision, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		generation, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		config, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read revision")
+		}
+		sum := sha256.Sum256(config)
+		revisions = append(revisions, revisionFile{path: path, status: v1alpha1.RevisionStatus{
+			Revision:   revision,
+			SHA256:     hex.EncodeToString(sum[:]),
+			Generation: generation,
+			Time:       metav1.NewTime(entry.ModTime().Truncate(time.Second)),
+		}})
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].status.Revision < revisions[j].status.Revision
+	})
+
+	return revisions, nil
+}
+
+// revisionDir is the directory holding the revision history of a Website.
+func (c *WebsiteController) revisionDir(website *v1alpha1.Website) string {
+	return filepath.Join(c.stateDir, historyDir, website.Namespace+"_"+website.Name)
+}
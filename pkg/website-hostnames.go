@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// hostnameRegistry tracks which Website serves each route, so a route moving between
+// Websites is never served by two Websites at once. A route is a hostname, followed by
+// the path prefix for Websites sharing a hostname. Websites claiming overlapping routes are
+// resolved by age: the oldest Website by creation timestamp serves the route. The hostnames
+// of a Website's SNI certificates are routes too.
+type hostnameRegistry struct {
+	mu sync.Mutex
+
+	// owners maps routes to the key of the Website serving them.
+	owners map[string]string
+
+	// websites holds the Website objects of the owners by key, which are evicted by older
+	// Websites claiming their routes.
+	websites map[string]*v1alpha1.Website
+
+	// waiting holds Websites that want a route overlapping one still served by another Website,
+	// keyed by the route they wait for.
+	waiting map[string]map[string]*v1alpha1.Website
+}
+
+// newHostnameRegistry creates an empty hostnameRegistry.
+func newHostnameRegistry() *hostnameRegistry {
+	return &hostnameRegistry{
+		owners:   map[string]string{},
+		websites: map[string]*v1alpha1.Website{},
+		waiting:  map[string]map[string]*v1alpha1.Website{},
+	}
+}
+
+// claim reserves a Website's routes. Younger Websites serving an overlapping route are evicted:
+// their routes are freed and they are returned, so their configuration can be removed before
+// the Website is served. If an older Website serves an overlapping route, the Website is
+// remembered and handed out again by settle or release once that route is freed. A Website
+// keeps its previous routes until settle is called. An SNI hostname served by an older Website
+// is left to it, without keeping the Website from being served.
+func (r *hostnameRegistry) claim(website *v1alpha1.Website) ([]*v1alpha1.Website, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := websiteKey(website)
+	route := websiteRoute(website)
+	younger := map[string]bool{}
+	lost := map[string]bool{}
+	waitingFor := map[string]bool{}
+	for _, claimed := range append([]string{route}, sniHostnames(website)...) {
+		for owned, owner := range r.owners {
+			if owner == key || !routesOverlap(owned, claimed) {
+				continue
+			}
+			if existing := r.websites[owner]; existing != nil && olderWebsite(website, existing) {
+				younger[owner] = true
+				continue
+			}
+			if r.waiting[owned] == nil {
+				r.waiting[owned] = map[string]*v1alpha1.Website{}
+			}
+			r.waiting[owned][key] = website.DeepCopy()
+			switch {
+			case claimed != route:
+				lost[claimed] = true
+				waitingFor[owned] = true
+			case owned == route:
+				return nil, errors.Errorf("hostname %s is served by Website %s", route, owner)
+			default:
+				return nil, errors.Errorf("route %s overlaps route %s served by Website %s", route, owned, owner)
+			}
+		}
+	}
+
+	// Evict the younger Websites from all their routes
+	var evicted []*v1alpha1.Website
+	owners := make([]string, 0, len(younger))
+	for owner := range younger {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		if r.websites[owner] == nil {
+			continue
+		}
+		for owned, o := range r.owners {
+			if o == owner {
+				delete(r.owners, owned)
+			}
+		}
+		evicted = append(evicted, r.websites[owner])
+		delete(r.websites, owner)
+	}
+
+	r.owners[route] = key
+	for _, hostname := range sniHostnames(website) {
+		if !lost[hostname] {
+			r.owners[hostname] = key
+		}
+	}
+	r.websites[key] = website.DeepCopy()
+	for owned, waiting := range r.waiting {
+		if !waitingFor[owned] {
+			delete(waiting, key)
+		}
+	}
+	return evicted, nil
+}
+
+// registeredRoute is a route and the key of the Website serving it.
+type registeredRoute struct {
+	route string
+	key   string
+}
+
+// overlappingOwner returns a route overlapping route that a Website other than key serves.
+// The registry must be locked.
+func (r *hostnameRegistry) overlappingOwner(key, route string) (registeredRoute, bool) {
+	for owned, owner := range r.owners {
+		if owner != key && routesOverlap(owned, route) {
+			return registeredRoute{route: owned, key: owner}, true
+		}
+	}
+	return registeredRoute{}, false
+}
+
+// lostSNIHostnames returns the SNI hostnames of a Website that another Website serves, so
+// their certificates are not rendered.
+func (r *hostnameRegistry) lostSNIHostnames(website *v1alpha1.Website) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lost := map[string]string{}
+	for _, hostname := range sniHostnames(website) {
+		if owner, ok := r.overlappingOwner(websiteKey(website), hostname); ok {
+			lost[hostname] = owner.key
+		}
+	}
+	return lost
+}
+
+// owner returns the key of the Website serving a hostname.
+func (r *hostnameRegistry) owner(hostname string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.owners[hostname]
+	return key, ok
+}
+
+// conflict returns the key of another Website serving a route overlapping the Website's route.
+func (r *hostnameRegistry) conflict(website *v1alpha1.Website) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := websiteKey(website)
+	for route, owner := range r.owners {
+		if owner != key && routesOverlap(route, websiteRoute(website)) {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+// routeOwner returns the key of the Website serving a request, preferring the Website with
+// the longest path prefix matching the URI.
+func (r *hostnameRegistry) routeOwner(hostname, uri string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, found, longest := "", false, -1
+	for route, owner := range r.owners {
+		host, prefix := splitRoute(route)
+		if host != hostname || !strings.HasPrefix(uri, prefix) || len(prefix) <= longest {
+			continue
+		}
+		key, found, longest = owner, true, len(prefix)
+	}
+	return key, found
+}
+
+// settle frees all routes of a Website except the ones it now serves, and returns the
+// Websites that were waiting for them.
+func (r *hostnameRegistry) settle(website *v1alpha1.Website) []*v1alpha1.Website {
+	keep := map[string]bool{websiteRoute(website): true}
+	for _, hostname := range sniHostnames(website) {
+		keep[hostname] = true
+	}
+	return r.free(websiteKey(website), keep)
+}
+
+// release frees all routes of a deleted Website, and returns the Websites that were
+// waiting for them.
+func (r *hostnameRegistry) release(website *v1alpha1.Website) []*v1alpha1.Website {
+	r.mu.Lock()
+	for _, waiting := range r.waiting {
+		delete(waiting, websiteKey(website))
+	}
+	delete(r.websites, websiteKey(website))
+	r.mu.Unlock()
+
+	return r.free(websiteKey(website), nil)
+}
+
+// free frees the routes owned by key, except those to keep.
+func (r *hostnameRegistry) free(key string, keep map[string]bool) []*v1alpha1.Website {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var waiters []*v1alpha1.Website
+	for route, owner := range r.owners {
+		if owner != key || keep[route] {
+			continue
+		}
+		delete(r.owners, route)
+		for _, website := range r.waiting[route] {
+			waiters = append(waiters, website)
+		}
+		delete(r.waiting, route)
+	}
+	sort.Slice(waiters, func(i, j int) bool { return websiteKey(waiters[i]) < websiteKey(waiters[j]) })
+	return waiters
+}
+
+// claimHostname reserves a Website's route. The configuration of the younger Websites it
+// evicts is removed first, so two Websites never serve the route. A Website losing the route
+// to an older Website is marked with the HostnameConflict condition.
+func (c *WebsiteController) claimHostname(ctx context.Context, website *v1alpha1.Website) error {
+	evicted, err := c.hostnames.claim(website)
+	if err != nil {
+		c.setHostnameConflict(ctx, website, err.Error())
+		return err
+	}
+	for _, loser := range evicted {
+		c.evictWebsite(ctx, loser, website)
+	}
+	for hostname, owner := range c.hostnames.lostSNIHostnames(website) {
+		c.event(website, corev1.EventTypeWarning, "HostnameConflict", fmt.Sprintf("hostname %s of tls.certificates is served by Website %s and is not served", hostname, owner))
+	}
+
+	// Lift a conflict the Website lost before
+	if meta.IsStatusConditionTrue(website.Status.Conditions, v1alpha1.ConditionHostnameConflict) {
+		key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+		return c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionHostnameConflict,
+				Status:             metav1.ConditionFalse,
+				Reason:             "Resolved",
+				Message:            fmt.Sprintf("The Website serves %s", websiteRoute(website)),
+				ObservedGeneration: website.Generation,
+			})
+		})
+	}
+	return nil
+}
+
+// evictWebsite removes the configuration of a Website that lost its route to an older Website
+// and queues it to wait for the route. nginx is reloaded by the Website taking the route over.
+func (c *WebsiteController) evictWebsite(ctx context.Context, loser, winner *v1alpha1.Website) {
+	path := nginxConfigPath(loser)
+	if _, err := os.Stat(path); err == nil {
+		err = os.Remove(path)
+		c.audit(auditConfigDelete, loser, path, nil, err)
+		if err != nil {
+			c.log.Error(err, "failed to delete Nginx configuration of evicted Website", "namespace", loser.Namespace, "name", loser.Name)
+		}
+	}
+	c.forgetApplied(loser)
+	c.log.Info("evicted Website from its hostname", "namespace", loser.Namespace, "name", loser.Name, "hostname", loser.Spec.Hostname, "winner", websiteKey(winner))
+
+	c.setHostnameConflict(ctx, loser, fmt.Sprintf("hostname %s is served by older Website %s", websiteRoute(winner), websiteKey(winner)))
+	c.queue.Add(watch.Event{Type: watch.Modified, Object: loser})
+}
+
+// setHostnameConflict marks a Website that is not served because an older Website serves its
+// hostname.
+func (c *WebsiteController) setHostnameConflict(ctx context.Context, website *v1alpha1.Website, message string) {
+	c.event(website, corev1.EventTypeWarning, "HostnameConflict", message)
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionHostnameConflict,
+			Status:             metav1.ConditionTrue,
+			Reason:             "OlderWebsite",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "HostnameConflict",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		c.log.Error(err, "failed to record hostname conflict", "namespace", website.Namespace, "name", website.Name)
+	}
+}
+
+// requeueWaiters queues the Websites that were waiting for a freed hostname.
+func (c *WebsiteController) requeueWaiters(waiters []*v1alpha1.Website) {
+	for _, website := range waiters {
+		c.log.Info("hostname freed, requeueing Website", "namespace", website.Namespace, "name", website.Name, "hostname", website.Spec.Hostname)
+		c.queue.Add(watch.Event{Type: watch.Modified, Object: website})
+	}
+}
+
+// olderWebsite reports whether Website a was created before Website b, which wins a route
+// both claim. Websites created within the same second are ordered by namespace and name.
+func olderWebsite(a, b *v1alpha1.Website) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return websiteKey(a) < websiteKey(b)
+}
+
+// websiteRoute returns the route a Website serves.
+func websiteRoute(website *v1alpha1.Website) string {
+	return website.Spec.Hostname + website.Spec.PathPrefix
+}
+
+// routesOverlap reports whether two routes can match the same request. A hostname without
+// a path prefix overlaps every route of the hostname.
+func routesOverlap(a, b string) bool {
+	hostA, pathA := splitRoute(a)
+	hostB, pathB := splitRoute(b)
+	if hostA != hostB {
+		return false
+	}
+	return pathA == "" || pathB == "" || strings.HasPrefix(pathA, pathB) || strings.HasPrefix(pathB, pathA)
+}
+
+// splitRoute splits a route into its hostname and path prefix.
+func splitRoute(route string) (string, string) {
+	i := strings.IndexByte(route, '/')
+	if i < 0 {
+		return route, ""
+	}
+	return route[:i], route[i:]
+}
+
+// websiteKey identifies a Website across namespaces.
+func websiteKey(website *v1alpha1.Website) string {
+	return website.Namespace + "/" + website.Name
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// registeredWebsite is a Website created age minutes ago, serving a hostname and the SNI
+// hostnames of a certificate.
+func registeredWebsite(name string, age int, hostname string, sniHostnames ...string) *v1alpha1.Website {
+	website := &v1alpha1.Website{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "team",
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Duration(age) * time.Minute)),
+		},
+		Spec: v1alpha1.WebsiteSpec{Hostname: hostname},
+	}
+	if len(sniHostnames) > 0 {
+		website.Spec.TLS = &v1alpha1.TLSSpec{
+			SecretRef:    &corev1.LocalObjectReference{Name: name + "-tls"},
+			Certificates: []v1alpha1.TLSCertificate{{SecretRef: corev1.LocalObjectReference{Name: name + "-sni"}, Hostnames: sniHostnames}},
+		}
+	}
+	return website
+}
+
+func TestHostnameRegistrySNIHostnames(t *testing.T) {
+	tests := []struct {
+		name        string
+		served      *v1alpha1.Website
+		claiming    *v1alpha1.Website
+		wantErr     bool
+		wantEvicted []string
+		wantLost    map[string]string
+	}{
+		{
+			name:     "free SNI hostname",
+			served:   registeredWebsite("blog", 1, "blog.example.com"),
+			claiming: registeredWebsite("shop", 2, "shop.example.com", "shop.example.de"),
+			wantLost: map[string]string{},
+		},
+		{
+			name:     "SNI hostname of an older Website",
+			served:   registeredWebsite("blog", 2, "blog.example.com"),
+			claiming: registeredWebsite("shop", 1, "shop.example.com", "blog.example.com"),
+			wantLost: map[string]string{"blog.example.com": "team/blog"},
+		},
+		{
+			name:        "SNI hostname of a younger Website",
+			served:      registeredWebsite("blog", 1, "blog.example.com"),
+			claiming:    registeredWebsite("shop", 2, "shop.example.com", "blog.example.com"),
+			wantEvicted: []string{"team/blog"},
+			wantLost:    map[string]string{},
+		},
+		{
+			name:     "hostname served as an SNI hostname by an older Website",
+			served:   registeredWebsite("shop", 2, "shop.example.com", "blog.example.com"),
+			claiming: registeredWebsite("blog", 1, "blog.example.com"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newHostnameRegistry()
+			if _, err := r.claim(tt.served); err != nil {
+				t.Fatal(err)
+			}
+
+			evicted, err := r.claim(tt.claiming)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("claim succeeded, want a conflict")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, website := range evicted {
+				keys = append(keys, websiteKey(website))
+			}
+			if !reflect.DeepEqual(keys, tt.wantEvicted) {
+				t.Errorf("evicted %v, want %v", keys, tt.wantEvicted)
+			}
+			if lost := r.lostSNIHostnames(tt.claiming); !reflect.DeepEqual(lost, tt.wantLost) {
+				t.Errorf("lost %v, want %v", lost, tt.wantLost)
+			}
+		})
+	}
+}
+
+func TestHostnameRegistrySettleKeepsSNIHostnames(t *testing.T) {
+	r := newHostnameRegistry()
+	shop := registeredWebsite("shop", 1, "shop.example.com", "shop.example.de")
+	if _, err := r.claim(shop); err != nil {
+		t.Fatal(err)
+	}
+	r.settle(shop)
+	if owner, _ := r.owner("shop.example.de"); owner != "team/shop" {
+		t.Errorf("shop.example.de is served by %q after settle, want team/shop", owner)
+	}
+
+	// Dropping the certificate frees its hostnames
+	shop.Spec.TLS.Certificates = nil
+	if _, err := r.claim(shop); err != nil {
+		t.Fatal(err)
+	}
+	r.settle(shop)
+	if owner, ok := r.owner("shop.example.de"); ok {
+		t.Errorf("shop.example.de is served by %q after its certificate was dropped", owner)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// ingressNginxPrefix prefixes the annotations of the ingress-nginx controller.
+const ingressNginxPrefix = "nginx.ingress.kubernetes.io/"
+
+// servingMode returns how a Website is served.
+func servingMode(website *v1alpha1.Website) string {
+	if website.Spec.Serving == nil || website.Spec.Serving.Mode == "" {
+		return v1alpha1.ServingModeNginx
+	}
+	return website.Spec.Serving.Mode
+}
+
+// ingressName is the name of the Ingress emitted for a Website, or of the Ingress it adopts.
+func ingressName(website *v1alpha1.Website) string {
+	if website.Spec.Serving != nil && website.Spec.Serving.AdoptIngress != "" {
+		return website.Spec.Serving.AdoptIngress
+	}
+	return ingressServiceName(website)
+}
+
+// ingressServiceName is the name of the Service emitted for a Website in Ingress mode.
+func ingressServiceName(website *v1alpha1.Website) string {
+	return "website-" + website.Name
+}
+
+// ingressUnsupportedFields lists the set fields of a Website that cannot be expressed as an Ingress.
+func ingressUnsupportedFields(website *v1alpha1.Website) []string {
+	spec := website.Spec
+	set := map[string]bool{
+		"spec.type":             spec.Type == v1alpha1.WebsiteTypeRedirect,
+		"spec.upstreams":        len(spec.Upstreams) > 0,
+		"spec.loadBalancing":    spec.LoadBalancing != nil,
+		"spec.hostAliases":      len(spec.HostAliases) > 0,
+		"spec.upstreamAuth":     spec.UpstreamAuth != nil,
+		"spec.security":         spec.Security != nil,
+		"spec.cookies":          spec.Cookies != nil,
+		"spec.tls.vaultRef":     spec.TLS != nil && spec.TLS.VaultRef != nil,
+		"spec.tls.spiffe":       spec.TLS != nil && spec.TLS.SPIFFE != nil,
+		"spec.tls.acme":         spec.TLS != nil && spec.TLS.ACME,
+		"spec.basicAuth":        spec.BasicAuth != nil,
+		"spec.tracing":          spec.Tracing != nil,
+		"spec.tuning":           spec.Tuning != nil,
+		"spec.drainPolicy":      spec.DrainPolicy != nil,
+		"spec.verification":     spec.Verification != nil,
+		"spec.healthEndpoint":   spec.HealthEndpoint != nil,
+		"spec.healthReport":     spec.HealthReport != nil,
+		"spec.middleware":       len(spec.Middleware) > 0,
+		"spec.realIP":           spec.RealIP != nil,
+		"spec.indexing":         spec.Indexing == "deny",
+		"spec.content":          spec.Content != nil,
+		"spec.assets":           spec.Assets != nil,
+		"spec.listeners":        len(spec.Listeners) > 0,
+		"spec.bodyFilters":      len(spec.BodyFilters) > 0,
+		"spec.transform":        spec.Transform != nil,
+		"spec.redirectMapRef":   spec.RedirectMapRef != nil,
+		"spec.routes":           len(spec.Routes) > 0,
+		"spec.protection":       spec.Protection != "",
+		"spec.upstreamProtocol": spec.UpstreamProtocol == v1alpha1.UpstreamProtocolH2C || spec.UpstreamProtocol == v1alpha1.UpstreamProtocolAuto,
+		"spec.upstream path":    upstreamPath(spec.Upstream) != "",
+		"spec.upstream scheme":  !strings.HasPrefix(spec.Upstream, "http://") && !strings.HasPrefix(spec.Upstream, "https://"),
+	}
+	var fields []string
+	for field, isSet := range set {
+		if isSet {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// upstreamPath returns the path of an upstream URL, if it is not the root.
+func upstreamPath(upstream string) string {
+	u, err := url.Parse(upstream)
+	if err != nil || u.Path == "/" {
+		return ""
+	}
+	return u.Path
+}
+
+// ingressObjects renders the Ingress of a Website and the ExternalName Service pointing at its upstream.
+func ingressObjects(website *v1alpha1.Website) (*networkingv1.Ingress, *corev1.Service, error) {
+	upstream, err := url.Parse(website.Spec.Upstream)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid upstream")
+	}
+	port := 80
+	if upstream.Scheme == "https" {
+		port = 443
+	}
+	if upstream.Port() != "" {
+		port, err = strconv.Atoi(upstream.Port())
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid upstream port")
+		}
+	}
+	owner := []metav1.OwnerReference{*metav1.NewControllerRef(website, v1alpha1.GroupVersion.WithKind("Website"))}
+	name := ingressName(website)
+	serviceName := ingressServiceName(website)
+
+	// Point a Service at the upstream, so the Ingress has a backend
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: website.Namespace, Name: serviceName, OwnerReferences: owner},
+		Spec: corev1.ServiceSpec{
+			Type:         corev1.ServiceTypeExternalName,
+			ExternalName: upstream.Hostname(),
+			Ports:        []corev1.ServicePort{{Name: upstream.Scheme, Port: int32(port)}},
+		},
+	}
+
+	// Derive the ingress-nginx annotations from the spec
+	annotations := map[string]string{
+		ingressNginxPrefix + "upstream-vhost": upstream.Hostname(),
+	}
+	if upstream.Scheme == "https" {
+		annotations[ingressNginxPrefix+"backend-protocol"] = "HTTPS"
+		annotations[ingressNginxPrefix+"proxy-ssl-server-name"] = "on"
+		annotations[ingressNginxPrefix+"proxy-ssl-name"] = upstream.Hostname()
+		if tls := website.Spec.UpstreamTLS; tls != nil {
+			if tls.SNI != "" {
+				annotations[ingressNginxPrefix+"proxy-ssl-name"] = tls.SNI
+			}
+			if tls.VerifyHostname {
+				annotations[ingressNginxPrefix+"proxy-ssl-verify"] = "on"
+			}
+		}
+	}
+	for key, value := range website.Spec.Serving.Annotations {
+		annotations[key] = value
+	}
+
+	path := "/"
+	if website.Spec.PathPrefix != "" {
+		path = website.Spec.PathPrefix
+	}
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: website.Namespace, Name: name, Annotations: annotations, OwnerReferences: owner},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: website.Spec.Hostname,
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     path,
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: serviceName,
+							Port: networkingv1.ServiceBackendPort{Number: int32(port)},
+						}},
+					}},
+				}},
+			}},
+		},
+	}
+	if class := website.Spec.Serving.IngressClassName; class != "" {
+		ingress.Spec.IngressClassName = &class
+	}
+	if ref := tlsSecretRef(website); ref != nil {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{website.Spec.Hostname}, SecretName: ref.Name}}
+		if !redirectsHTTP(website) {
+			annotations[ingressNginxPrefix+"ssl-redirect"] = "false"
+		}
+	}
+
+	return ingress, service, nil
+}
+
+// serveIngress hands a Website in Ingress mode to the cluster's ingress controller, removing
+// the nginx server of a Website that was served by the controller before.
+func (c *WebsiteController) serveIngress(ctx context.Context, website *v1alpha1.Website) error {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "IngressCreated",
+		Message:            "The Website is served by the Ingress " + ingressName(website),
+		ObservedGeneration: website.Generation,
+	}
+
+	// Refuse fields an Ingress cannot express
+	unsupported := ingressUnsupportedFields(website)
+	var ingressErr error
+	if len(unsupported) > 0 {
+		ingressErr = errors.Errorf("Ingress mode does not support %s", strings.Join(unsupported, ", "))
+	} else {
+		ingressErr = c.applyIngress(ctx, website)
+	}
+	if ingressErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "IngressFailed"
+		condition.Message = ingressErr.Error()
+	}
+
+	// Stop serving the Website with nginx
+	if ingressErr == nil {
+		if _, err := os.Stat(nginxConfigPath(website)); err == nil {
+			err = c.deleteNginxServer(website)
+			if err != nil {
+				return errors.Wrap(err, "failed to delete Nginx server")
+			}
+			err = c.removeRevisions(website)
+			if err != nil {
+				return errors.Wrap(err, "failed to delete revision history")
+			}
+		}
+	}
+
+	// Record the outcome in the Website status
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return ingressErr
+}
+
+// applyIngress creates or updates the Ingress and Service of a Website.
+func (c *WebsiteController) applyIngress(ctx context.Context, website *v1alpha1.Website) error {
+	ingress, service, err := ingressObjects(website)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: website.Namespace, Name: ingressName(website)}
+	serviceKey := types.NamespacedName{Namespace: website.Namespace, Name: ingressServiceName(website)}
+
+	// Apply the Service
+	existingService := &corev1.Service{}
+	err = c.client.Get(ctx, serviceKey, existingService)
+	switch {
+	case apierrors.IsNotFound(err):
+		err = c.client.Create(ctx, service)
+	case err == nil:
+		existingService.OwnerReferences = service.OwnerReferences
+		existingService.Spec.Type = service.Spec.Type
+		existingService.Spec.ExternalName = service.Spec.ExternalName
+		existingService.Spec.Ports = service.Spec.Ports
+		err = c.client.Update(ctx, existingService)
+	}
+	c.audit(auditObjectApply, website, "services/"+serviceKey.String(), nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to apply Service")
+	}
+
+	// Apply the Ingress
+	existingIngress := &networkingv1.Ingress{}
+	err = c.client.Get(ctx, key, existingIngress)
+	switch {
+	case apierrors.IsNotFound(err):
+		err = c.client.Create(ctx, ingress)
+	case err == nil && !metav1.IsControlledBy(existingIngress, website) && key.Name != ingressServiceName(website):
+		// Take over an adopted Ingress only if it routes like the Website's
+		if mismatch := ingressRoutesMismatch(existingIngress, ingress); mismatch != "" {
+			return errors.Errorf("the Ingress %s cannot be adopted: %s", key.Name, mismatch)
+		}
+		existingIngress.OwnerReferences = ingress.OwnerReferences
+		existingIngress.Annotations = ingress.Annotations
+		existingIngress.Spec = ingress.Spec
+		err = c.client.Update(ctx, existingIngress)
+		c.event(website, corev1.EventTypeNormal, "Adopted", "The Ingress "+key.Name+" was taken over")
+	case err == nil:
+		existingIngress.OwnerReferences = ingress.OwnerReferences
+		existingIngress.Annotations = ingress.Annotations
+		existingIngress.Spec = ingress.Spec
+		err = c.client.Update(ctx, existingIngress)
+	}
+	c.audit(auditObjectApply, website, "ingresses/"+key.String(), nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to apply Ingress")
+	}
+
+	return nil
+}
+
+// removeIngress deletes the Ingress and Service of a Website that is no longer in Ingress mode.
+// An Ingress to adopt is left alone unless it was taken over.
+func (c *WebsiteController) removeIngress(ctx context.Context, website *v1alpha1.Website) error {
+	ingress := &networkingv1.Ingress{}
+	err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: ingressName(website)}, ingress)
+	if err == nil && metav1.IsControlledBy(ingress, website) {
+		err = c.client.Delete(ctx, ingress)
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete Ingress")
+	}
+	objectMeta := metav1.ObjectMeta{Namespace: website.Namespace, Name: ingressServiceName(website)}
+	err = c.client.Delete(ctx, &corev1.Service{ObjectMeta: objectMeta})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete Service")
+	}
+	return nil
+}
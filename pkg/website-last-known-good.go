@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// lastKnownGoodDir is where the last configuration of every Website that passed validation
+// and was reloaded is kept, within the state directory.
+const lastKnownGoodDir = "last-known-good"
+
+// lastKnownGoodPath is the path of the last known good configuration of a Website.
+func (c *WebsiteController) lastKnownGoodPath(website *v1alpha1.Website) string {
+	return filepath.Join(c.stateDir, lastKnownGoodDir, websiteIdentifier(website)+".conf")
+}
+
+// saveLastKnownGood keeps the configuration a Website was just reloaded with, to restore it
+// if a later configuration fails. Failing to keep it does not fail the reconciliation.
+func (c *WebsiteController) saveLastKnownGood(website *v1alpha1.Website) {
+	config, err := ioutil.ReadFile(nginxConfigPath(website))
+	if os.IsNotExist(err) {
+		return
+	}
+	path := c.lastKnownGoodPath(website)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		err = writeFileAtomic(path, config, 0644)
+	}
+	if err != nil {
+		c.log.Error(err, "failed to keep last known good configuration", "namespace", website.Namespace, "name", website.Name)
+	}
+}
+
+// lastKnownGood returns the configuration a Website's new configuration is rolled back to: the
+// last known good one, or the one on disk if none was kept, e.g. after an upgrade. It returns
+// nil if there is neither.
+func (c *WebsiteController) lastKnownGood(website *v1alpha1.Website, path string) []byte {
+	good, err := ioutil.ReadFile(c.lastKnownGoodPath(website))
+	if err == nil {
+		return good
+	}
+	return readPreviousConfig(path)
+}
+
+// forgetLastKnownGood deletes the last known good configuration of a deleted Website.
+func (c *WebsiteController) forgetLastKnownGood(website *v1alpha1.Website) error {
+	err := os.Remove(c.lastKnownGoodPath(website))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete last known good configuration")
+	}
+	return nil
+}
+
+// restoreLastKnownGood replaces the configuration of a Website that failed to reload with its
+// last known good one, or removes it if the Website was never served.
+func (c *WebsiteController) restoreLastKnownGood(website *v1alpha1.Website) error {
+	path := nginxConfigPath(website)
+	good, err := ioutil.ReadFile(c.lastKnownGoodPath(website))
+	if err == nil {
+		err = writeFileAtomic(path, good, 0644)
+		c.audit(auditConfigWrite, website, path, good, err)
+	} else {
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		c.audit(auditConfigDelete, website, path, nil, err)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to restore last known good configuration")
+	}
+	return nil
+}
+
+// reloadOutcome is the outcome of a reload for each Website it was signalled on behalf of.
+type reloadOutcome struct {
+	err error
+	// rolledBack holds the reload failure of the Websites whose configuration was replaced by
+	// its last known good one, by key.
+	rolledBack map[string]*rolledBackError
+}
+
+// of returns the outcome of the reload for the Website with the given key.
+func (o reloadOutcome) of(key string) error {
+	if rolledBack, ok := o.rolledBack[key]; ok {
+		return rolledBack
+	}
+	return o.err
+}
+
+// rolledBackError is the outcome of a reload for a Website whose configuration was rolled back
+// after nginx failed to reload. faulty tells whether nginx -t rejected the configuration, so
+// the failure counts against the Website.
+type rolledBackError struct {
+	cause  error
+	faulty bool
+}
+
+func (e *rolledBackError) Error() string {
+	return "nginx failed to reload the configuration, the last known good one was restored: " + e.cause.Error()
+}
+
+// reloadWithRollback reloads nginx on behalf of the given Websites. If the reload fails, the
+// configurations nginx -t rejects are rolled back to their last known good ones and nginx is
+// reloaded once more with them, so only the Websites at fault are held back. Only changed
+// configurations are considered; a lone one is at fault whatever nginx -t says, and if nginx -t
+// rejects none of several, e.g. without the nginx binary, they are all rolled back but no
+// Website is held responsible.
+func (c *WebsiteController) reloadWithRollback(websites []*v1alpha1.Website) reloadOutcome {
+	err := c.signalReload(websites)
+	if err == nil {
+		return reloadOutcome{}
+	}
+	c.log.Error(err, "failed to reload Nginx configuration", "websites", len(websites))
+
+	// Find the configurations at fault among the ones nginx was not reloaded with before
+	var changed []*v1alpha1.Website
+	for _, website := range websites {
+		if website.DeletionTimestamp == nil && c.configChanged(website) {
+			changed = append(changed, website)
+		}
+	}
+	faulty := changed
+	if len(changed) > 1 {
+		faulty = c.faultyConfigs(changed)
+	}
+	held := len(faulty) > 0
+	if !held {
+		faulty = changed
+	}
+	if len(faulty) == 0 {
+		return reloadOutcome{err: err}
+	}
+
+	// Restore their last known good configurations
+	outcome := reloadOutcome{rolledBack: map[string]*rolledBackError{}}
+	for _, website := range faulty {
+		restoreErr := c.restoreLastKnownGood(website)
+		if restoreErr != nil {
+			c.log.Error(restoreErr, "failed to roll back Nginx configuration", "namespace", website.Namespace, "name", website.Name)
+			continue
+		}
+		outcome.rolledBack[websiteKey(website)] = &rolledBackError{cause: err, faulty: held}
+	}
+
+	// Reload once more on behalf of the Websites whose configurations were kept
+	var kept []*v1alpha1.Website
+	for _, website := range websites {
+		if outcome.rolledBack[websiteKey(website)] == nil {
+			kept = append(kept, website)
+		}
+	}
+	outcome.err = c.signalReload(kept)
+	if outcome.err != nil {
+		c.log.Error(outcome.err, "failed to reload rolled back Nginx configuration", "websites", len(websites))
+	}
+	return outcome
+}
+
+// configChanged reports whether a Website's configuration differs from its last known good one.
+func (c *WebsiteController) configChanged(website *v1alpha1.Website) bool {
+	config, err := ioutil.ReadFile(nginxConfigPath(website))
+	if err != nil {
+		return false
+	}
+	good, err := ioutil.ReadFile(c.lastKnownGoodPath(website))
+	return err != nil || !bytes.Equal(config, good)
+}
+
+// faultyConfigs returns the Websites whose configuration nginx -t rejects when the other
+// Websites are set back to their last known good configurations.
+func (c *WebsiteController) faultyConfigs(websites []*v1alpha1.Website) []*v1alpha1.Website {
+	good := map[string][]byte{}
+	for _, website := range websites {
+		config, err := ioutil.ReadFile(c.lastKnownGoodPath(website))
+		if err != nil {
+			config = nil
+		}
+		good[nginxConfigPath(website)] = config
+	}
+	var faulty []*v1alpha1.Website
+	for _, website := range websites {
+		path := nginxConfigPath(website)
+		config, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		configs := map[string][]byte{}
+		for other, config := range good {
+			configs[other] = config
+		}
+		configs[path] = config
+		if c.testConfigs(configs) != nil {
+			faulty = append(faulty, website)
+		}
+	}
+	return faulty
+}
+
+// recordReload records the outcome of reloading nginx with a Website's configuration. A
+// Website whose configuration was rolled back is marked RolledBack, and the failure counts
+// towards its quarantine if its configuration was at fault. A failed reload marks the Website
+// not Ready; with a reload debounce, a successful one marks it Ready here.
+func (c *WebsiteController) recordReload(ctx context.Context, website *v1alpha1.Website, config []byte, err error) {
+	c.recordSync(ctx, website, nil, err)
+	var rolledBack *rolledBackError
+	switch {
+	case errors.As(err, &rolledBack):
+		c.setRolledBack(ctx, website, rolledBack.Error())
+		if rolledBack.faulty {
+			c.countNginxFailure(ctx, website, rolledBack.cause)
+		}
+	case err == nil:
+		c.recordApplied(website, config)
+		c.clearFailures(website)
+	}
+	if err != nil || c.reloads == nil || website.DeletionTimestamp != nil {
+		return
+	}
+
+	// Verify the Website outside of the batch, retrying through the queue if it fails
+	go func() {
+		err := c.markReady(ctx, website)
+		if err != nil {
+			c.log.Error(err, "failed to mark Website ready", "namespace", website.Namespace, "name", website.Name)
+			c.requeueAfter(website, defaultVerificationTimeout)
+		}
+	}()
+}
+
+// setRolledBack marks a Website whose new configuration was replaced by its last known good one.
+func (c *WebsiteController) setRolledBack(ctx context.Context, website *v1alpha1.Website, message string) {
+	c.event(website, corev1.EventTypeWarning, "RolledBack", message)
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionRolledBack,
+			Status:             metav1.ConditionTrue,
+			Reason:             "LastKnownGood",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		c.log.Error(err, "failed to record rollback", "namespace", website.Namespace, "name", website.Name)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// defaultLeaderElectionID is the name of the Lease the controller replicas elect a leader with.
+	defaultLeaderElectionID = "website-controller"
+
+	// serviceAccountNamespaceFile holds the namespace of the controller's pod.
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// The timing of the election: a leader that did not renew its Lease for leaseDuration is
+	// replaced, and gives up leading if it cannot renew it within renewDeadline.
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// electLeader campaigns for the Lease of the controller replicas until the context is
+// cancelled. The returned channel is closed once this replica leads; until then it runs as a
+// warm standby. lost is called when the replica stops leading, as another replica may already
+// write the configuration.
+func electLeader(ctx context.Context, cfg *rest.Config, namespace, name string, lost func()) (<-chan struct{}, error) {
+	if namespace == "" {
+		namespace = podNamespace()
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get hostname")
+	}
+	identity := hostname + "_" + string(uuid.NewUUID())
+
+	lock, err := resourcelock.NewFromKubeconfig(resourcelock.LeasesResourceLock, namespace, name,
+		resourcelock.ResourceLockConfig{Identity: identity}, cfg, renewDeadline)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create leader election lock")
+	}
+
+	elected := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            name,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) { close(elected) },
+			OnStoppedLeading: lost,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create leader elector")
+	}
+	go elector.Run(ctx)
+	return elected, nil
+}
+
+// podNamespace returns the namespace of the controller's pod, or "default" outside a cluster.
+func podNamespace() string {
+	data, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+	if err != nil || strings.TrimSpace(string(data)) == "" {
+		return "default"
+	}
+	return strings.TrimSpace(string(data))
+}
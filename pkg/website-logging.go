@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// Log formats, selected with --log-format.
+const (
+	logFormatJSON    = "json"
+	logFormatConsole = "console"
+)
+
+// newLogger creates the zap-backed logger of the controller. The level is "error", "info",
+// "debug" or a verbosity, where "debug" is verbosity 1 and verbosity 2 logs the rendered
+// configurations. The format is "json", for log aggregation, or "console".
+func newLogger(level, format string) (logr.Logger, error) {
+	var enabler zapcore.Level
+	switch level {
+	case "error":
+		enabler = zapcore.ErrorLevel
+	case "info":
+		enabler = zapcore.InfoLevel
+	case "debug":
+		enabler = zapcore.DebugLevel
+	default:
+		verbosity, err := strconv.Atoi(level)
+		if err != nil || verbosity < 0 {
+			return logr.Logger{}, errors.Errorf("invalid log level %q: must be error, info, debug or a verbosity", level)
+		}
+		enabler = zapcore.Level(-verbosity)
+	}
+
+	var encoder zap.Opts
+	switch format {
+	case logFormatJSON:
+		encoder = zap.JSONEncoder()
+	case logFormatConsole:
+		encoder = zap.ConsoleEncoder()
+	default:
+		return logr.Logger{}, errors.Errorf("invalid log format %q: must be json or console", format)
+	}
+	return zap.New(zap.Level(enabler), encoder), nil
+}
+
+// websiteLog returns the logger of the controller with the fields identifying a Website and
+// the generation being handled.
+func (c *WebsiteController) websiteLog(website *v1alpha1.Website) logr.Logger {
+	return c.log.WithValues("namespace", website.Namespace, "name", website.Name, "generation", website.Generation)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+var (
+	// websitesReconciled counts the handled watch events of Websites.
+	websitesReconciled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "website_controller_websites_reconciled_total",
+		Help: "Watch events of Websites handled, by event type and result.",
+	}, []string{"event", "result"})
+
+	// reconcileDuration tracks how long handling a watch event of a Website took.
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "website_controller_reconcile_duration_seconds",
+		Help:    "Time handling a watch event of a Website took, by event type.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"event"})
+
+	// managedWebsites is the number of Websites whose configuration nginx has loaded.
+	managedWebsites = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "website_controller_managed_websites",
+		Help: "Websites whose configuration is loaded in nginx.",
+	})
+
+	// nginxReloads counts the signalled nginx reloads.
+	nginxReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "website_controller_nginx_reloads_total",
+		Help: "nginx reloads signalled, including failed ones.",
+	})
+
+	// nginxReloadFailures counts the nginx reloads that could not be signalled.
+	nginxReloadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "website_controller_nginx_reload_failures_total",
+		Help: "nginx reloads that failed.",
+	})
+
+	// reloadDuration tracks the wall time of nginx reloads per Website.
+	reloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "website_controller_nginx_reload_duration_seconds",
+		Help:    "Wall time of nginx reloads triggered by a Website.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"namespace", "website"})
+
+	// reloadWorkerLinger tracks how long old nginx workers kept running after a reload.
+	reloadWorkerLinger = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "website_controller_nginx_reload_worker_linger_seconds",
+		Help:    "Time old nginx worker processes kept running after a reload triggered by a Website.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 14),
+	}, []string{"namespace", "website"})
+
+	// reloadDroppedConnections counts connections dropped while old workers were draining.
+	reloadDroppedConnections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "website_controller_nginx_reload_dropped_connections_total",
+		Help: "Connections accepted but not handled by nginx during reloads triggered by a Website.",
+	}, []string{"namespace", "website"})
+
+	// queueWaitDuration tracks how long watch events waited before being handled.
+	queueWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "website_controller_queue_wait_duration_seconds",
+		Help:    "Time watch events waited in the queue, by Website priority band.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 12),
+	}, []string{"priority_band"})
+
+	// deletionsPaused is 1 while Website deletions are held after a mass deletion.
+	deletionsPaused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "website_controller_deletions_paused",
+		Help: "Whether Website deletions are held until a mass deletion is acknowledged.",
+	})
+
+	// deletionsHeld is the number of Website deletions waiting for acknowledgment.
+	deletionsHeld = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "website_controller_deletions_held",
+		Help: "Website deletions held until a mass deletion is acknowledged.",
+	})
+
+	// nginxInfo exposes the nginx version.
+	nginxInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_nginx_info",
+		Help: "Version of the nginx data plane.",
+	}, []string{"version"})
+
+	// nginxCapability is 1 for each capability the nginx data plane supports, and 0 otherwise.
+	nginxCapability = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_nginx_capability",
+		Help: "Whether the nginx data plane supports a capability.",
+	}, []string{"capability"})
+
+	// sloBurnRate tracks how fast Websites spend the error budget of their SLOs.
+	sloBurnRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_slo_burn_rate",
+		Help: "Rate the error budget of a Website's SLO objective is spent at, relative to the sustainable rate, by window.",
+	}, []string{"namespace", "website", "objective", "window"})
+
+	// sloBudgetRemaining tracks the error budget left within the SLO window.
+	sloBudgetRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_slo_error_budget_remaining_ratio",
+		Help: "Fraction of the error budget of a Website's SLO objective left within the SLO window.",
+	}, []string{"namespace", "website", "objective"})
+
+	// configDrifts counts configuration files found changed behind the controller's back.
+	configDrifts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "website_controller_config_drifts_total",
+		Help: "Configuration files of a Website found to differ from what nginx was reloaded with, and repaired.",
+	}, []string{"namespace", "website"})
+
+	// featureGateEnabled is 1 for each enabled feature gate of the controller, and 0 otherwise.
+	featureGateEnabled = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_feature_enabled",
+		Help: "Whether a feature gate of the controller is enabled.",
+	}, []string{"name"})
+
+	// tenantDiskUsage tracks the disk space the on-disk artifacts of a namespace use.
+	tenantDiskUsage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_tenant_disk_usage_bytes",
+		Help: "Disk space used by the temporary files and logs of a namespace's Websites.",
+	}, []string{"namespace"})
+
+	// failoverActive is 1 for the edge the DNS records of the Websites point at, and 0 otherwise.
+	failoverActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_failover_active_edge",
+		Help: "Whether the DNS records of the Websites point at the local or the peer edge.",
+	}, []string{"edge"})
+
+	// quarantinedWebsites is 1 for each quarantined Website, to alert on.
+	quarantinedWebsites = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "website_controller_website_quarantined",
+		Help: "Whether a Website is quarantined after its configuration failed repeatedly.",
+	}, []string{"namespace", "website"})
+
+	// renderCacheLookups counts the lookups of rendered configurations in the render cache.
+	renderCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "website_controller_render_cache_lookups_total",
+		Help: "Lookups of rendered configurations in the render cache, by result (hit or miss).",
+	}, []string{"result"})
+
+	// renderCacheEntries is the number of rendered configurations in the render cache.
+	renderCacheEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "website_controller_render_cache_entries",
+		Help: "Rendered configurations held in the render cache.",
+	})
+
+	// unknownHostRequests counts requests for hostnames no Website serves.
+	unknownHostRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "website_controller_unknown_host_requests_total",
+		Help: "Requests answered by the default server, by requested hostname.",
+	}, []string{"hostname"})
+)
+
+func init() {
+	prometheus.MustRegister(websitesReconciled, reconcileDuration, managedWebsites, nginxReloads, nginxReloadFailures,
+		reloadDuration, reloadWorkerLinger, reloadDroppedConnections, queueWaitDuration, deletionsPaused, deletionsHeld,
+		nginxInfo, nginxCapability, sloBurnRate, sloBudgetRemaining,
+		unknownHostRequests, configDrifts, featureGateEnabled, tenantDiskUsage, failoverActive, quarantinedWebsites,
+		renderCacheLookups, renderCacheEntries)
+}
+
+// recordReloadMetrics records the impact of a reload triggered by a Website.
+func recordReloadMetrics(website *v1alpha1.Website, reload v1alpha1.ReloadStatus) {
+	reloadDuration.WithLabelValues(website.Namespace, website.Name).Observe(reload.Duration.Seconds())
+	reloadWorkerLinger.WithLabelValues(website.Namespace, website.Name).Observe(reload.WorkerLinger.Seconds())
+	reloadDroppedConnections.WithLabelValues(website.Namespace, website.Name).Add(float64(reload.DroppedConnections))
+}
+
+// recordReconcileMetrics records the outcome of handling a watch event of a Website.
+func recordReconcileMetrics(event string, duration float64, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	websitesReconciled.WithLabelValues(event, result).Inc()
+	reconcileDuration.WithLabelValues(event).Observe(duration)
+}
+
+// serveMetrics serves the metrics of the controller and of its Kubernetes clients at /metrics
+// until the context is cancelled.
+func (c *WebsiteController) serveMetrics(ctx context.Context, address string) error {
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, ctrlmetrics.Registry}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
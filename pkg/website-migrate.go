@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// MigrateReport is the output of the migrate command.
+type MigrateReport struct {
+	Websites []v1alpha1.Website `json:"websites"`
+	Skipped  []MigrateSkip      `json:"skipped"`
+}
+
+// MigrateSkip is a configuration file the migrate command found no Website for.
+type MigrateSkip struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// migrateCommand turns the hand-written configuration files of a conf.d directory into
+// Websites adopting them, one per file serving a single hostname from a single upstream. The
+// controller only takes a file over if the Website renders the same directives, so the
+// Websites are a starting point to review rather than a faithful translation.
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dir := flags.String("dir", "/etc/nginx/conf.d", "directory of the hand-written configuration files")
+	namespace := flags.String("namespace", "default", "namespace of the Websites")
+	output := flags.String("o", "", "output format of the report: json or yaml, the Website manifests if empty")
+	_, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(*dir, "*.conf"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list configuration files")
+	}
+	sort.Strings(paths)
+	report := MigrateReport{Websites: []v1alpha1.Website{}, Skipped: []MigrateSkip{}}
+	for _, path := range paths {
+		website, reason := migrateConfigFile(path, *namespace)
+		if reason != "" {
+			report.Skipped = append(report.Skipped, MigrateSkip{File: path, Reason: reason})
+			continue
+		}
+		report.Websites = append(report.Websites, *website)
+	}
+
+	if *output != "" {
+		return cli.PrintReport(*output, report)
+	}
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s: %s\n", skipped.File, skipped.Reason)
+	}
+	for _, website := range report.Websites {
+		manifest, err := yaml.Marshal(website)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode Website")
+		}
+		fmt.Printf("---\n%s", manifest)
+	}
+	return nil
+}
+
+// migrateConfigFile returns the Website adopting a configuration file, or the reason there is
+// none.
+func migrateConfigFile(path, namespace string) (*v1alpha1.Website, string) {
+	if key, ok := configOwner(path); ok {
+		return nil, "managed by the controller for Website " + key
+	}
+	name := strings.TrimSuffix(filepath.Base(path), ".conf")
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return nil, fmt.Sprintf("the file name is not a valid Website name: %s", strings.Join(errs, ", "))
+	}
+	config, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	// Find the hostname and the upstream of the root location of the only server block
+	var hostnames []string
+	var upstream string
+	servers := 0
+	var blocks []string
+	tokens := configTokens(string(config))
+	for i := 0; i < len(tokens); i++ {
+		switch token := tokens[i]; {
+		case token == "{":
+			name := ""
+			if i > 0 {
+				name = tokens[i-1]
+			}
+			if i > 1 && tokens[i-2] == "location" {
+				name = "location " + tokens[i-1]
+			}
+			if len(blocks) == 0 && name == "server" {
+				servers++
+			}
+			blocks = append(blocks, name)
+		case token == "}":
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+		case token == "server_name" && len(blocks) == 1 && blocks[0] == "server":
+			for i++; i < len(tokens) && tokens[i] != ";"; i++ {
+				hostnames = append(hostnames, tokens[i])
+			}
+		case token == "proxy_pass" && len(blocks) == 2 && blocks[1] == "location /" && i+1 < len(tokens):
+			upstream = tokens[i+1]
+		}
+	}
+	switch {
+	case servers != 1:
+		return nil, fmt.Sprintf("it has %d server blocks, a Website has one", servers)
+	case len(hostnames) != 1:
+		return nil, fmt.Sprintf("it serves %d hostnames, a Website serves one", len(hostnames))
+	case upstream == "":
+		return nil, "it does not proxy location / to an upstream"
+	}
+
+	return &v1alpha1.Website{
+		TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.GroupVersion.String(), Kind: "Website"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: v1alpha1.WebsiteSpec{
+			Hostname: hostnames[0],
+			Upstream: upstream,
+			Serving:  &v1alpha1.ServingSpec{AdoptConfigFile: filepath.Join("/etc/nginx/conf.d", filepath.Base(path))},
+		},
+	}, ""
+}
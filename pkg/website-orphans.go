@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// configMarker starts every configuration file rendered for a Website. It names the Website,
+// so files left behind by Websites deleted while the controller was down can be told apart
+// from files the controller does not manage.
+const configMarker = "# Managed by website-controller for Website "
+
+// markConfig prefixes a Website's configuration with the marker naming the Website.
+func markConfig(website *v1alpha1.Website, config string) string {
+	return configMarker + websiteKey(website) + "\n" + config
+}
+
+// configOwner returns the key of the Website a configuration file was rendered for, if the
+// file carries the marker.
+func configOwner(path string) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, configMarker) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, configMarker)), true
+}
+
+// collectOrphanedConfigs deletes the configuration files of Websites deleted while the
+// controller was down, and reloads nginx once if it deleted any. Only files carrying the
+// marker are considered, so hand-written files in conf.d are left alone.
+func (c *WebsiteController) collectOrphanedConfigs(ctx context.Context) error {
+	// List the Websites, waiting for the API server
+	list := &v1alpha1.WebsiteList{}
+	for {
+		err := c.client.List(ctx, list)
+		if err == nil {
+			break
+		}
+		c.log.Error(err, "failed to list Websites, retrying to collect orphaned configurations")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(apiServerRetryInterval):
+		}
+	}
+	existing := map[string]bool{}
+	for i := range list.Items {
+		existing[websiteKey(&list.Items[i])] = true
+	}
+
+	// Find the marked files of Websites that no longer exist
+	paths, err := filepath.Glob(filepath.Join("/etc/nginx/conf.d", "*.conf"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list Nginx configurations")
+	}
+	locations, err := filepath.Glob(filepath.Join(subpathDir, "*", "*.conf"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list location files")
+	}
+	removed := 0
+	for _, path := range append(paths, locations...) {
+		key, ok := configOwner(path)
+		if !ok || existing[key] {
+			continue
+		}
+
+		// Confirm the Website is gone, as it may have been created since it was listed
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		err = c.client.Get(ctx, types.NamespacedName{Namespace: parts[0], Name: parts[1]}, &v1alpha1.Website{})
+		if !apierrors.IsNotFound(err) {
+			continue
+		}
+
+		err = os.Remove(path)
+		orphan := &v1alpha1.Website{}
+		orphan.Namespace, orphan.Name = parts[0], parts[1]
+		c.audit(auditConfigDelete, orphan, path, nil, err)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to delete orphaned Nginx configuration")
+		}
+		c.log.Info("deleted orphaned Nginx configuration", "website", key, "path", path)
+		removed++
+
+		// Drop the shared server block of a hostname no Website serves anymore
+		if filepath.Dir(filepath.Dir(path)) == subpathDir {
+			err = c.removeSharedServer(filepath.Base(filepath.Dir(path)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if removed == 0 {
+		return nil
+	}
+
+	// Reload once for all deleted files
+	return c.reloadNginx(nil)
+}
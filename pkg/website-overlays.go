@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// applyOverlay returns a Website with the overlay of an environment merged into its spec.
+// Websites without an overlay for the environment are returned unchanged.
+func applyOverlay(website *v1alpha1.Website, environment string) (*v1alpha1.Website, error) {
+	overlay, ok := website.Spec.Overlays[environment]
+	if environment == "" || !ok {
+		return website, nil
+	}
+
+	// Merge the overlay into the spec, without the overlays themselves
+	patched := website.DeepCopy()
+	patched.Spec.Overlays = nil
+	spec, err := json.Marshal(patched.Spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode spec")
+	}
+	spec, err = jsonpatch.MergePatch(spec, overlay.Raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to apply overlay %q", environment)
+	}
+	patched.Spec = v1alpha1.WebsiteSpec{}
+	err = json.Unmarshal(spec, &patched.Spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid overlay %q", environment)
+	}
+	patched.Spec.Overlays = nil
+
+	return patched, nil
+}
+
+// applyEnvironment applies the overlay of the controller's environment to a Website and
+// resolves the Service and certificate it references. An invalid overlay, unresolvable
+// Service or unmanageable Certificate marks the Website not Ready; a deleted Website is then removed as rendered without the overlay.
+func (c *WebsiteController) applyEnvironment(ctx context.Context, eventType watch.EventType, website *v1alpha1.Website) (*v1alpha1.Website, error) {
+	patched, err := applyOverlay(website, c.environment)
+	reason := "InvalidOverlay"
+	if err == nil && eventType != watch.Deleted {
+		patched, err = resolveServiceRef(ctx, c.client, patched)
+		reason = "ServiceUnresolved"
+	}
+	if err == nil && eventType != watch.Deleted {
+		patched, err = c.resolveUpstreamProtocol(ctx, patched)
+		reason = "InvalidConfiguration"
+	}
+	if err == nil && eventType != watch.Deleted {
+		err = c.loadTemplate(ctx, patched)
+		reason = "TemplateInvalid"
+	}
+	if err == nil && eventType != watch.Deleted && patched.DeletionTimestamp == nil {
+		patched, err = c.resolveACME(ctx, patched)
+		reason = "CertificateFailed"
+	}
+	if err == nil && eventType != watch.Deleted && patched.DeletionTimestamp == nil {
+		patched, err = c.resolveIssuerRef(ctx, patched)
+		reason = "CertificateFailed"
+	}
+	if err == nil {
+		return patched, nil
+	}
+	if eventType == watch.Deleted {
+		return website, nil
+	}
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	statusErr := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             reason,
+			Message:            err.Error(),
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if statusErr != nil {
+		return nil, errors.Wrap(statusErr, "failed to update Ready condition")
+	}
+	return nil, err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// pausedAnnotation set to "true" pauses the reconciliation of a Website, so operators can
+// hand-tune its nginx configuration during an incident without the controller reverting it.
+const pausedAnnotation = "website-operator.io/paused"
+
+// isPaused reports whether the reconciliation of a Website is paused.
+func isPaused(website *v1alpha1.Website) bool {
+	return website.Annotations[pausedAnnotation] == "true"
+}
+
+// checkPaused reports whether a Website is paused, in which case its configuration and secret
+// material are neither written nor reloaded, and records the pause in the Paused condition.
+// Deleting a paused Website still removes its configuration.
+func (c *WebsiteController) checkPaused(ctx context.Context, eventType watch.EventType, website *v1alpha1.Website) (bool, error) {
+	if eventType == watch.Deleted || website.DeletionTimestamp != nil {
+		return false, nil
+	}
+	paused := isPaused(website)
+	if paused {
+		// Stop refreshing the secret material, which reloads nginx
+		c.untrackVaultWebsite(website)
+	}
+
+	// Record a change of the pause
+	if paused == meta.IsStatusConditionTrue(website.Status.Conditions, v1alpha1.ConditionPaused) {
+		return paused, nil
+	}
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionPaused,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Resumed",
+		Message:            "The Website is reconciled",
+		ObservedGeneration: website.Generation,
+	}
+	if paused {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "PausedByAnnotation"
+		condition.Message = "Reconciliation is paused by the " + pausedAnnotation + " annotation; the nginx configuration of the Website is neither written nor reloaded"
+	}
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update Paused condition")
+	}
+	if paused {
+		c.log.Info("paused reconciliation", "namespace", website.Namespace, "name", website.Name)
+	} else {
+		c.log.Info("resumed reconciliation", "namespace", website.Namespace, "name", website.Name)
+	}
+	c.event(website, corev1.EventTypeNormal, condition.Reason, condition.Message)
+	return paused, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/controller/util"
+)
+
+// policyPrograms caches the compiled CEL programs of policy rules by expression.
+var policyPrograms = struct {
+	sync.Mutex
+	programs map[string]cel.Program
+}{programs: map[string]cel.Program{}}
+
+// compilePolicyRule compiles the expression of a policy rule, reusing earlier compilations.
+func compilePolicyRule(expression string) (cel.Program, error) {
+	policyPrograms.Lock()
+	defer policyPrograms.Unlock()
+
+	if program, ok := policyPrograms.programs[expression]; ok {
+		return program, nil
+	}
+	env, err := cel.NewEnv(cel.Variable("website", cel.DynType), cel.Variable("namespace", cel.DynType))
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	policyPrograms.programs[expression] = program
+	return program, nil
+}
+
+// policyDenials evaluates the WebsitePolicies applying to a Website's namespace and returns
+// why the Website is denied, if it is. Rules that fail to evaluate deny the Website, so a
+// broken policy fails closed.
+func (c *WebsiteController) policyDenials(ctx context.Context, website *v1alpha1.Website) ([]string, error) {
+	policies := &v1alpha1.WebsitePolicyList{}
+	err := c.client.List(ctx, policies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list WebsitePolicies")
+	}
+	if len(policies.Items) == 0 {
+		return nil, nil
+	}
+
+	// Expose the Website and its Namespace to the rules
+	namespace := &corev1.Namespace{}
+	err = c.client.Get(ctx, types.NamespacedName{Name: website.Namespace}, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Namespace")
+	}
+	websiteObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(website)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert Website")
+	}
+	namespaceObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert Namespace")
+	}
+	vars := map[string]interface{}{"website": websiteObject, "namespace": namespaceObject}
+
+	var denials []string
+	for _, policy := range policies.Items {
+		applies, err := policyApplies(policy, namespace)
+		if err != nil {
+			denials = append(denials, fmt.Sprintf("%s: %s", policy.Name, err))
+			continue
+		}
+		if !applies {
+			continue
+		}
+		for _, rule := range policy.Spec.Rules {
+			allowed, err := evaluatePolicyRule(rule, vars)
+			if err != nil {
+				denials = append(denials, fmt.Sprintf("%s/%s: %s", policy.Name, rule.Name, err))
+				continue
+			}
+			if !allowed {
+				message := rule.Message
+				if message == "" {
+					message = "rule " + rule.Expression + " does not hold"
+				}
+				denials = append(denials, fmt.Sprintf("%s/%s: %s", policy.Name, rule.Name, message))
+			}
+		}
+	}
+	sort.Strings(denials)
+	return denials, nil
+}
+
+// policyApplies reports whether a WebsitePolicy selects a namespace.
+func policyApplies(policy v1alpha1.WebsitePolicy, namespace *corev1.Namespace) (bool, error) {
+	if policy.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid namespace selector")
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// grantedVaultRoles returns the Vault roles the WebsitePolicies selecting a namespace grant to
+// its Websites. Policies with an invalid namespace selector grant nothing.
+func (c *WebsiteController) grantedVaultRoles(ctx context.Context, namespaceName string) (map[string]bool, error) {
+	policies := &v1alpha1.WebsitePolicyList{}
+	err := c.client.List(ctx, policies)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list WebsitePolicies")
+	}
+	namespace := &corev1.Namespace{}
+	err = c.client.Get(ctx, types.NamespacedName{Name: namespaceName}, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get Namespace")
+	}
+
+	roles := map[string]bool{}
+	for _, policy := range policies.Items {
+		applies, err := policyApplies(policy, namespace)
+		if err != nil || !applies {
+			continue
+		}
+		for _, role := range policy.Spec.VaultRoles {
+			roles[role] = true
+		}
+	}
+	return roles, nil
+}
+
+// evaluatePolicyRule reports whether a rule holds.
+func evaluatePolicyRule(rule v1alpha1.PolicyRule, vars map[string]interface{}) (bool, error) {
+	program, err := compilePolicyRule(rule.Expression)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid expression")
+	}
+	out, _, err := program.Eval(vars)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to evaluate expression")
+	}
+	allowed, ok := out.Value().(bool)
+	if !ok {
+		return false, errors.Errorf("expression returned %T instead of a bool", out.Value())
+	}
+	return allowed, nil
+}
+
+// checkPolicies refuses to serve a Website that a WebsitePolicy denies, marking it not Ready
+// with the denials.
+func (c *WebsiteController) checkPolicies(ctx context.Context, website *v1alpha1.Website) error {
+	denials, err := c.policyDenials(ctx, website)
+	if err != nil {
+		return err
+	}
+	if len(denials) == 0 {
+		return nil
+	}
+	message := "The Website is denied by policy: " + strings.Join(denials, "; ")
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err = c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "PolicyDenied",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return errors.New(message)
+}
+
+// watchPolicies requeues all Websites when a WebsitePolicy changes, so newly denied Websites
+// stop being served and newly allowed ones are served.
+func (c *WebsiteController) watchPolicies(ctx context.Context) error {
+	w := util.NewWatch(ctx, &v1alpha1.WebsitePolicy{})
+
+	err := w.Watch(func(event watch.Event) error {
+		list := &v1alpha1.WebsiteList{}
+		err := c.client.List(ctx, list)
+		if err != nil {
+			c.log.Error(err, "failed to list Websites")
+			return nil
+		}
+		for i := range list.Items {
+			c.queue.Add(watch.Event{Type: watch.Modified, Object: &list.Items[i]})
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch for WebsitePolicy objects")
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// defaultQuarantineThreshold is how many times in a row the configuration of a Website's
+// generation may fail validation or reload before the Website is quarantined.
+const defaultQuarantineThreshold = 5
+
+// nginxFailures counts the validation and reload failures of a generation of a Website.
+type nginxFailures struct {
+	generation int64
+	count      int
+}
+
+// isQuarantined reports whether the current generation of a Website is quarantined. A new
+// generation, e.g. a fixed spec, releases it.
+func isQuarantined(website *v1alpha1.Website) bool {
+	condition := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionQuarantined)
+	return condition != nil && condition.Status == metav1.ConditionTrue && condition.ObservedGeneration == website.Generation
+}
+
+// checkQuarantine reports whether a Website is quarantined, in which case it is not served,
+// and releases a quarantined Website whose spec changed. Deleting a quarantined Website is
+// handled as usual.
+func (c *WebsiteController) checkQuarantine(ctx context.Context, eventType watch.EventType, website *v1alpha1.Website) (bool, error) {
+	if eventType == watch.Deleted || website.DeletionTimestamp != nil {
+		c.clearFailures(website)
+		quarantinedWebsites.DeleteLabelValues(website.Namespace, website.Name)
+		return false, nil
+	}
+	if isQuarantined(website) {
+		return true, nil
+	}
+	if !meta.IsStatusConditionTrue(website.Status.Conditions, v1alpha1.ConditionQuarantined) {
+		return false, nil
+	}
+
+	// Release the Website, whose new generation is served again
+	quarantinedWebsites.DeleteLabelValues(website.Namespace, website.Name)
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionQuarantined,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Released",
+			Message:            "The Website was released from quarantine as its spec changed",
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update Quarantined condition")
+	}
+	c.log.Info("released Website from quarantine", "namespace", website.Namespace, "name", website.Name)
+	c.event(website, corev1.EventTypeNormal, "Released", "The Website was released from quarantine as its spec changed")
+	return false, nil
+}
+
+// countNginxFailure counts a validation or reload failure of a Website's configuration, and
+// quarantines the Website once its generation failed the threshold of times in a row: its
+// configuration is removed, so it cannot hold back the configurations of other Websites.
+func (c *WebsiteController) countNginxFailure(ctx context.Context, website *v1alpha1.Website, cause error) {
+	if c.quarantineThreshold <= 0 {
+		return
+	}
+	key := websiteKey(website)
+
+	c.quarantineMu.Lock()
+	failures, ok := c.nginxFailures[key]
+	if !ok || failures.generation != website.Generation {
+		failures = &nginxFailures{generation: website.Generation}
+		c.nginxFailures[key] = failures
+	}
+	failures.count++
+	count := failures.count
+	c.quarantineMu.Unlock()
+	if count < c.quarantineThreshold {
+		return
+	}
+
+	// Stop serving the Website
+	err := c.deleteNginxServer(website)
+	if err != nil {
+		c.log.Error(err, "failed to remove configuration of quarantined Website", "namespace", website.Namespace, "name", website.Name)
+		return
+	}
+	c.clearFailures(website)
+	quarantinedWebsites.WithLabelValues(website.Namespace, website.Name).Set(1)
+
+	// Record the quarantine
+	message := fmt.Sprintf("The configuration failed %d times in a row and is no longer served until the spec changes: %s", count, cause.Error())
+	c.log.Info("quarantined Website", "namespace", website.Namespace, "name", website.Name, "failures", count, "reason", cause.Error())
+	c.event(website, corev1.EventTypeWarning, "Quarantined", message)
+	statusKey := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err = c.updateStatus(ctx, statusKey, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionQuarantined,
+			Status:             metav1.ConditionTrue,
+			Reason:             "RepeatedFailures",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "Quarantined",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		c.log.Error(err, "failed to record quarantine", "namespace", website.Namespace, "name", website.Name)
+	}
+}
+
+// clearFailures forgets the failures of a Website, whose configuration was served or removed.
+func (c *WebsiteController) clearFailures(website *v1alpha1.Website) {
+	c.quarantineMu.Lock()
+	defer c.quarantineMu.Unlock()
+
+	delete(c.nginxFailures, websiteKey(website))
+}
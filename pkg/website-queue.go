@@ -0,0 +1,258 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff of failed events.
+	retryBaseDelay = 5 * time.Millisecond
+	retryMaxDelay  = 5 * time.Minute
+)
+
+// websiteQueue holds pending watch events and hands them out by Website priority.
+// Events of equal priority are handed out in the order they were added. Failed events
+// are retried with exponential backoff per Website until a newer event supersedes them.
+type websiteQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  queueItems
+	seq    uint64
+	closed bool
+
+	limiter workqueue.RateLimiter
+	latest  map[string]uint64
+}
+
+// queueItem is a watch event waiting to be handled.
+type queueItem struct {
+	event    watch.Event
+	key      string
+	priority int32
+	seq      uint64
+	added    time.Time
+}
+
+// newWebsiteQueue creates an empty websiteQueue.
+func newWebsiteQueue() *websiteQueue {
+	q := &websiteQueue{
+		limiter: workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay),
+		latest:  map[string]uint64{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add queues a watch event.
+func (q *websiteQueue) Add(event watch.Event) {
+	_ = q.TryAdd(event)
+}
+
+// TryAdd queues a watch event, and fails if the queue was closed and the event would never
+// be handled.
+func (q *websiteQueue) TryAdd(event watch.Event) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errors.New("the queue is closed")
+	}
+	q.seq++
+	key := eventKey(event)
+	q.latest[key] = q.seq
+	heap.Push(&q.items, &queueItem{
+		event:    event,
+		key:      key,
+		priority: eventPriority(event),
+		seq:      q.seq,
+		added:    time.Now(),
+	})
+	q.cond.Signal()
+	return nil
+}
+
+// Retry queues a failed event again after its Website's backoff, unless a newer event of
+// the Website was added in the meantime. It returns the backoff.
+func (q *websiteQueue) Retry(item *queueItem) time.Duration {
+	delay := q.limiter.When(item.key)
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if q.closed || q.latest[item.key] != item.seq {
+			return
+		}
+		item.added = time.Now()
+		heap.Push(&q.items, item)
+		q.cond.Signal()
+	})
+	return delay
+}
+
+// Forget resets the backoff of a Website once one of its events was handled.
+func (q *websiteQueue) Forget(item *queueItem) {
+	q.limiter.Forget(item.key)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.latest[item.key] == item.seq {
+		delete(q.latest, item.key)
+	}
+}
+
+// Get blocks until an event is available and returns the one with the highest priority.
+// Events superseded by a newer event of their Website are dropped, so a stale event never
+// follows a newer one of higher priority. It returns false once the queue is closed.
+func (q *websiteQueue) Get() (*queueItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			return nil, false
+		}
+
+		item := heap.Pop(&q.items).(*queueItem)
+		if q.latest[item.key] == item.seq {
+			return item, true
+		}
+	}
+}
+
+// Oldest returns when the event waiting the longest was added, and false if none is waiting.
+func (q *websiteQueue) Oldest() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var oldest time.Time
+	for _, item := range q.items {
+		if q.latest[item.key] != item.seq {
+			continue
+		}
+		if oldest.IsZero() || item.added.Before(oldest) {
+			oldest = item.added
+		}
+	}
+	return oldest, !oldest.IsZero()
+}
+
+// Close wakes up all waiting callers of Get.
+func (q *websiteQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// processQueue handles queued events until the context is cancelled.
+func (c *WebsiteController) processQueue(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		c.queue.Close()
+	}()
+
+	for {
+		item, ok := c.queue.Get()
+		if !ok {
+			return
+		}
+
+		// Record how long the event waited in its priority band
+		queueWaitDuration.WithLabelValues(priorityBand(item.priority)).Observe(time.Since(item.added).Seconds())
+
+		// Handle the event, retrying it with backoff if it fails
+		start := time.Now()
+		err := c.handleEvent(ctx, item.event)
+		recordReconcileMetrics(string(item.event.Type), time.Since(start).Seconds(), err)
+		c.rollouts.observe(item.key, err)
+		if err != nil {
+			delay := c.queue.Retry(item)
+			c.log.Error(err, "failed to handle watch event", "type", item.event.Type, "key", item.key, "retryIn", delay)
+			continue
+		}
+		c.queue.Forget(item)
+	}
+}
+
+// requeueAfter queues a Website again after a delay, as it is then, e.g. to act on a deadline
+// or poll a dependency. The returned timer cancels it. A Website deleted meanwhile is not queued.
+func (c *WebsiteController) requeueAfter(website *v1alpha1.Website, delay time.Duration) *time.Timer {
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	return time.AfterFunc(delay, func() {
+		latest := &v1alpha1.Website{}
+		err := c.client.Get(context.Background(), key, latest)
+		if err != nil {
+			return
+		}
+		c.queue.Add(watch.Event{Type: watch.Modified, Object: latest})
+	})
+}
+
+// eventKey returns the namespace/name key of the Website in a watch event.
+func eventKey(event watch.Event) string {
+	website, ok := event.Object.(*v1alpha1.Website)
+	if !ok {
+		return ""
+	}
+	return websiteKey(website)
+}
+
+// eventPriority returns the priority of the Website in a watch event.
+func eventPriority(event watch.Event) int32 {
+	website, ok := event.Object.(*v1alpha1.Website)
+	if !ok {
+		return 0
+	}
+	return website.Spec.Priority
+}
+
+// priorityBand groups priorities into the bands queue wait time is exported for.
+func priorityBand(priority int32) string {
+	switch {
+	case priority >= 100:
+		return "high"
+	case priority < 0:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// queueItems implements heap.Interface, ordering by descending priority and then by arrival.
+type queueItems []*queueItem
+
+func (q queueItems) Len() int { return len(q) }
+
+func (q queueItems) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queueItems) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *queueItems) Push(x interface{}) { *q = append(*q, x.(*queueItem)) }
+
+func (q *queueItems) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
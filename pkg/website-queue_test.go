@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// queuedWebsite is a watch event of a Website for the queue tests.
+func queuedWebsite(name string, priority int32, generation int64) watch.Event {
+	return watch.Event{Type: watch.Modified, Object: &v1alpha1.Website{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Generation: generation},
+		Spec:       v1alpha1.WebsiteSpec{Priority: priority},
+	}}
+}
+
+// drainQueue gets events until none is waiting and returns their Websites as name@generation.
+func drainQueue(t *testing.T, q *websiteQueue) []string {
+	t.Helper()
+	got := []string{}
+	for {
+		if _, ok := q.Oldest(); !ok {
+			return got
+		}
+		item, ok := q.Get()
+		if !ok {
+			t.Fatal("Get returned false for an open queue")
+		}
+		website := item.event.Object.(*v1alpha1.Website)
+		got = append(got, fmt.Sprintf("%s@%d", website.Name, website.Generation))
+	}
+}
+
+func TestWebsiteQueueOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []watch.Event
+		want   []string
+	}{
+		{
+			name:   "equal priorities in arrival order",
+			events: []watch.Event{queuedWebsite("a", 0, 1), queuedWebsite("b", 0, 1), queuedWebsite("c", 0, 1)},
+			want:   []string{"a@1", "b@1", "c@1"},
+		},
+		{
+			name:   "higher priorities first",
+			events: []watch.Event{queuedWebsite("low", -1, 1), queuedWebsite("normal", 0, 1), queuedWebsite("high", 100, 1)},
+			want:   []string{"high@1", "normal@1", "low@1"},
+		},
+		{
+			name:   "superseded events are dropped",
+			events: []watch.Event{queuedWebsite("a", 0, 1), queuedWebsite("b", 0, 1), queuedWebsite("a", 0, 2)},
+			want:   []string{"b@1", "a@2"},
+		},
+		{
+			name:   "a stale event of higher priority does not follow a newer one",
+			events: []watch.Event{queuedWebsite("a", 100, 1), queuedWebsite("a", 0, 2)},
+			want:   []string{"a@2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newWebsiteQueue()
+			for _, event := range tt.events {
+				q.Add(event)
+			}
+			got := drainQueue(t, q)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebsiteQueueOldestSkipsSuperseded(t *testing.T) {
+	q := newWebsiteQueue()
+	q.Add(queuedWebsite("a", 0, 1))
+	first, _ := q.Oldest()
+	time.Sleep(time.Millisecond)
+	q.Add(queuedWebsite("a", 0, 2))
+
+	oldest, ok := q.Oldest()
+	if !ok {
+		t.Fatal("Oldest reported no waiting event")
+	}
+	if !oldest.After(first) {
+		t.Errorf("Oldest returned the superseded event added at %s", first)
+	}
+}
+
+func TestWebsiteQueueRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		supersede   bool
+		wantRetried bool
+	}{
+		{name: "failed event is retried", wantRetried: true},
+		{name: "superseded event is not retried", supersede: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newWebsiteQueue()
+			q.Add(queuedWebsite("a", 0, 1))
+			item, _ := q.Get()
+			if tt.supersede {
+				q.Add(queuedWebsite("a", 0, 2))
+				q.Get()
+			}
+			delay := q.Retry(item)
+			time.Sleep(delay + 50*time.Millisecond)
+
+			_, retried := q.Oldest()
+			if retried != tt.wantRetried {
+				t.Errorf("event waiting after retry = %t, want %t", retried, tt.wantRetried)
+			}
+		})
+	}
+}
+
+func TestWebsiteQueueClose(t *testing.T) {
+	q := newWebsiteQueue()
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Get()
+		done <- ok
+	}()
+	q.Close()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Error("Get returned an event from a closed queue")
+		}
+	case <-time.After(time.Second):
+		t.Error("Get did not return after Close")
+	}
+}
+
+func TestWebsiteQueueTryAdd(t *testing.T) {
+	q := newWebsiteQueue()
+	if err := q.TryAdd(queuedWebsite("a", 0, 1)); err != nil {
+		t.Fatalf("TryAdd failed on an open queue: %v", err)
+	}
+	q.Close()
+	if err := q.TryAdd(queuedWebsite("b", 0, 1)); err == nil {
+		t.Error("TryAdd queued an event on a closed queue")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// websiteReconciler turns the reconcile requests of the controller-runtime manager into
+// events of the priority queue. The manager's informer relists and resyncs, so no change of
+// a Website is lost, and the queue retries failed events with exponential backoff.
+type websiteReconciler struct {
+	reader   client.Reader
+	queue    *websiteQueue
+	class    string
+	template types.NamespacedName
+
+	// observed holds the last seen state of every Website, which is handled when it is deleted.
+	mu       sync.Mutex
+	observed map[types.NamespacedName]*v1alpha1.Website
+}
+
+// Reconcile queues an Added event for a Website seen for the first time, a Modified event for
+// a known one, and a Deleted event for one that is being deleted, which is held back by the
+// finalizer until it is cleaned up. A Website without the finalizer is handled as deleted with
+// its last seen state once it no longer exists, and so is a Website moved to another class.
+//
+// The queue retries failed events with its own backoff; an event that cannot be queued is
+// returned as an error, so the manager retries the request.
+func (r *websiteReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	website := &v1alpha1.Website{}
+	err := r.reader.Get(ctx, req.NamespacedName, website)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return reconcile.Result{}, errors.Wrap(err, "failed to get Website")
+	}
+	gone := err != nil || !hasClass(website, r.class)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	last, known := r.observed[req.NamespacedName]
+	var event *watch.Event
+	switch {
+	case gone && known && last.DeletionTimestamp != nil:
+	case gone && known:
+		event = &watch.Event{Type: watch.Deleted, Object: last}
+	case gone:
+	case website.DeletionTimestamp != nil:
+		if !known || last.DeletionTimestamp == nil {
+			event = &watch.Event{Type: watch.Deleted, Object: website.DeepCopy()}
+		}
+	case known:
+		event = &watch.Event{Type: watch.Modified, Object: website.DeepCopy()}
+	default:
+		event = &watch.Event{Type: watch.Added, Object: website.DeepCopy()}
+	}
+
+	// Remember the state only once its event was queued, so a retry queues it again
+	if event != nil {
+		err = r.queue.TryAdd(*event)
+		if err != nil {
+			return reconcile.Result{}, errors.Wrapf(err, "failed to queue %s event", event.Type)
+		}
+	}
+	if gone {
+		delete(r.observed, req.NamespacedName)
+	} else {
+		r.observed[req.NamespacedName] = website
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// websitePredicate lets through the changes of a Website the controller acts on: its spec,
+// which bumps the generation, and its labels and annotations, which pause, roll back and
+// template it. Status writes, including the controller's own, are dropped, so they do not
+// render and test the configuration again. Changes of referenced objects are mapped to
+// requests by the other watches and not filtered.
+var websitePredicate = predicate.Or(
+	predicate.GenerationChangedPredicate{},
+	predicate.LabelChangedPredicate{},
+	predicate.AnnotationChangedPredicate{},
+)
+
+// dependents maps a Secret or ConfigMap to the Websites of its namespace that reference it by
+// one of the given names, so they are reconciled when it changes.
+func (r *websiteReconciler) dependents(names func(*v1alpha1.Website) []string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		var requests []reconcile.Request
+		for key, website := range r.observed {
+			if key.Namespace == obj.GetNamespace() && containsString(names(website), obj.GetName()) {
+				requests = append(requests, reconcile.Request{NamespacedName: key})
+			}
+		}
+		return requests
+	}
+}
+
+// configMapDependents maps a ConfigMap to the Websites of its namespace that reference it, and
+// the controller's template ConfigMap to the Websites rendered with it.
+func (r *websiteReconciler) configMapDependents(ctx context.Context, obj client.Object) []reconcile.Request {
+	requests := r.dependents(configMapNames)(ctx, obj)
+	if r.template.Name == "" || client.ObjectKeyFromObject(obj) != r.template {
+		return requests
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, website := range r.observed {
+		if website.Spec.TemplateRef == nil {
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
+
+// serviceDependents maps a Service, or an EndpointSlice by the Service it belongs to, to the
+// Websites proxying to it, which may live in other namespaces.
+func (r *websiteReconciler) serviceDependents(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetName()
+	if _, ok := obj.GetLabels()[discoveryv1.LabelServiceName]; ok {
+		name = obj.GetLabels()[discoveryv1.LabelServiceName]
+	}
+	service := types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var requests []reconcile.Request
+	for key, website := range r.observed {
+		if website.Spec.ServiceRef != nil && serviceRefKey(website) == service {
+			requests = append(requests, reconcile.Request{NamespacedName: key})
+		}
+	}
+	return requests
+}
+
+// hasClass reports whether a Website belongs to the class of a controller.
+func hasClass(website *v1alpha1.Website, class string) bool {
+	return website.Spec.ClassName == class
+}
+
+// runManager reconciles Website objects through a controller-runtime manager until the
+// context is cancelled. The manager serves no metrics and elects no leader, which the
+// controller does itself.
+//
+// Secrets, ConfigMaps, Services and EndpointSlices are numerous and large, so only their
+// metadata is cached: a change requeues the Websites referencing the object, which read its
+// content from the API server.
+func (c *WebsiteController) runManager(ctx context.Context) error {
+	if c.restConfig == nil {
+		return errors.New("no REST config to reconcile Websites with")
+	}
+
+	mgr, err := manager.New(c.restConfig, manager.Options{
+		Scheme:  c.client.Scheme(),
+		Metrics: metricsserver.Options{BindAddress: "0"},
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create manager")
+	}
+
+	r := &websiteReconciler{
+		reader:   mgr.GetClient(),
+		queue:    c.queue,
+		class:    c.class,
+		template: c.templateConfigMap,
+		observed: map[types.NamespacedName]*v1alpha1.Website{},
+	}
+	err = builder.ControllerManagedBy(mgr).
+		For(&v1alpha1.Website{}, builder.WithPredicates(websitePredicate)).
+		WatchesMetadata(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.dependents(secretNames))).
+		WatchesMetadata(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.configMapDependents)).
+		WatchesMetadata(&corev1.Service{}, handler.EnqueueRequestsFromMapFunc(r.serviceDependents)).
+		WatchesMetadata(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(r.serviceDependents)).
+		Complete(r)
+	if err != nil {
+		return errors.Wrap(err, "failed to create Website controller")
+	}
+
+	// Check that the watch keeps delivering the changes of Websites
+	go c.checkWatch(ctx, r)
+
+	return mgr.Start(ctx)
+}
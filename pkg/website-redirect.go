@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// websiteType returns the type of a Website, defaulting to Proxy.
+func websiteType(website *v1alpha1.Website) string {
+	if website.Spec.Type == "" {
+		return v1alpha1.WebsiteTypeProxy
+	}
+	return website.Spec.Type
+}
+
+// redirectUnsupportedFields lists the spec fields a Redirect Website sets that need an
+// upstream or a path prefix, and have no meaning for a redirect.
+func redirectUnsupportedFields(website *v1alpha1.Website) []string {
+	spec := website.Spec
+	set := map[string]bool{
+		"spec.pathPrefix":       spec.PathPrefix != "",
+		"spec.upstream":         spec.Upstream != "",
+		"spec.upstreams":        len(spec.Upstreams) > 0,
+		"spec.loadBalancing":    spec.LoadBalancing != nil,
+		"spec.hostAliases":      len(spec.HostAliases) > 0,
+		"spec.upstreamAuth":     spec.UpstreamAuth != nil,
+		"spec.upstreamTLS":      spec.UpstreamTLS != nil,
+		"spec.basicAuth":        spec.BasicAuth != nil,
+		"spec.tracing":          spec.Tracing != nil,
+		"spec.verification":     spec.Verification != nil,
+		"spec.healthEndpoint":   spec.HealthEndpoint != nil,
+		"spec.healthReport":     spec.HealthReport != nil,
+		"spec.middleware":       len(spec.Middleware) > 0,
+		"spec.realIP":           spec.RealIP != nil,
+		"spec.transform":        spec.Transform != nil,
+		"spec.content":          spec.Content != nil,
+		"spec.assets":           spec.Assets != nil,
+		"spec.cookies":          spec.Cookies != nil,
+		"spec.bodyFilters":      len(spec.BodyFilters) > 0,
+		"spec.redirectMapRef":   spec.RedirectMapRef != nil,
+		"spec.routes":           len(spec.Routes) > 0,
+		"spec.serviceRef":       spec.ServiceRef != nil,
+		"spec.protection":       spec.Protection != "",
+		"spec.tls.certificates": len(sniCertificates(website)) > 0,
+	}
+	var fields []string
+	for field, isSet := range set {
+		if isSet {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// redirectConfig renders the server block of a Redirect Website, which answers every request
+// with a redirect to the target.
+func redirectConfig(website *v1alpha1.Website, listeners, directives string) (string, error) {
+	redirect := website.Spec.Redirect
+	if redirect == nil {
+		return "", errors.New("a Website of type Redirect requires spec.redirect")
+	}
+	if fields := redirectUnsupportedFields(website); len(fields) > 0 {
+		return "", errors.Errorf("a Website of type Redirect cannot set %s", strings.Join(fields, ", "))
+	}
+
+	// Validate the target, as it is rendered into the configuration verbatim
+	target, err := url.Parse(redirect.Target)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid redirect target")
+	}
+	if (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return "", errors.Errorf("invalid redirect target %q: must be an absolute http(s) URL", redirect.Target)
+	}
+	if strings.ContainsAny(redirect.Target, " \t\n;{}'\"\\$") {
+		return "", errors.Errorf("invalid redirect target %q", redirect.Target)
+	}
+
+	code := redirect.Code
+	if code == 0 {
+		code = 301
+	}
+	switch code {
+	case 301, 302, 307, 308:
+	default:
+		return "", errors.Errorf("invalid redirect code %d", code)
+	}
+
+	// Keep the path and query of the request unless the target has a path
+	location := redirect.Target
+	if target.Path == "" || target.Path == "/" {
+		location = strings.TrimSuffix(location, "/") + "$request_uri"
+	}
+
+	return fmt.Sprintf(`
+server {
+%s	server_name %s;
+%s	location / {
+		return %d %s;
+	}
+}
+`, listeners, website.Spec.Hostname, directives, code, location), nil
+}
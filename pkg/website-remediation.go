@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"sort"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// defaultRemediations are the runbook hints for the reasons of failing Website conditions.
+// A hints file given with --remediation-hints adds to and overrides them.
+var defaultRemediations = map[string]string{
+	"AdoptionMismatch":          "Align the Website's spec with the adopted file, or remove spec.serving.adoptConfigFile once the file is gone.",
+	"CertificateFailed":         "Check that the controller may manage cert-manager Certificates and that cert-manager is installed.",
+	"DeploymentFailed":          "Remove the fields Deployment mode does not support, or check the Deployment's events with kubectl describe.",
+	"FastBurn":                  "Check the upstream's error rate and latency; roll back the upstream's last release if it coincides.",
+	"HTTPRouteFailed":           "Remove the fields GatewayAPI mode does not support, or check that the Gateway API CRDs are installed and the parent Gateways exist.",
+	"HostnameConflict":          "Pick another hostname or pathPrefix, or delete the older Website that serves it.",
+	"HostnameNotCovered":        "Issue a certificate whose SANs cover spec.hostname and update the referenced Secret.",
+	"Issuing":                   "Check the Certificate's events with kubectl describe certificate; the issuer may be unready or the ACME challenge failing.",
+	"IssuanceFailed":            "Check that the hostname resolves to the controller's nginx on port 80 and the ACME CA can reach it.",
+	"IngressFailed":             "Remove the fields Ingress mode does not support, or check that the ingress class exists.",
+	"InvalidConfiguration":      "Fix the spec according to the condition message; run `website diff` to see the rendered configuration.",
+	"LastKnownGood":             "Fix the spec so nginx accepts it; nginx serves the last known good configuration meanwhile.",
+	"OlderWebsite":              "Pick another hostname, or delete the older Website that serves it.",
+	"PolicyDenied":              "Change the spec to satisfy the WebsitePolicy named in the message, or ask its owner for an exception.",
+	"PortConflict":              "Pick a port no other StreamRoute or Website listens on.",
+	"RepeatedFailures":          "Fix the spec according to the Ready condition's message; changing the spec releases the Website from quarantine.",
+	"ReloadFailed":              "Check the nginx error log of the controller pod; nginx keeps serving its previous configuration.",
+	"SecretMaterialUnavailable": "Create the referenced Secret or Vault path with tls.crt and tls.key, and check the controller may read it.",
+	"TemplateInvalid":           "Fix the server template in the ConfigMap named in the message; run `website diff` to see what it renders.",
+	"UnsupportedFeature":        "Remove the field, or run an nginx build with the module named in the message.",
+	"UpstreamDenied":            "Point the upstream at a public address, or allow its range in the Namespace's website-operator.io/allowed-upstream-cidrs annotation.",
+	"VerificationFailed":        "Check that the upstream is reachable from the controller pod and answers for the hostname.",
+	"WriteFailed":               "Check that the nginx configuration directory is mounted writable and has free space.",
+}
+
+// loadRemediations reads a YAML map of condition reasons to runbook hints and merges it over
+// the default hints. An empty path keeps the defaults.
+func loadRemediations(path string) (map[string]string, error) {
+	remediations := map[string]string{}
+	for reason, hint := range defaultRemediations {
+		remediations[reason] = hint
+	}
+	if path == "" {
+		return remediations, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read remediation hints")
+	}
+	hints := map[string]string{}
+	err = yaml.Unmarshal(data, &hints)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse remediation hints")
+	}
+	for reason, hint := range hints {
+		remediations[reason] = hint
+	}
+	return remediations, nil
+}
+
+// conditionRemediations returns the runbook hints for the conditions of a Website whose
+// reason has one. Only failure reasons have hints, so healthy conditions are left out.
+func (c *WebsiteController) conditionRemediations(conditions []metav1.Condition) []v1alpha1.ConditionRemediation {
+	var remediations []v1alpha1.ConditionRemediation
+	for _, condition := range conditions {
+		hint, ok := c.remediations[condition.Reason]
+		if !ok || hint == "" {
+			continue
+		}
+		remediations = append(remediations, v1alpha1.ConditionRemediation{
+			Type:        condition.Type,
+			Reason:      condition.Reason,
+			Remediation: hint,
+		})
+	}
+	sort.Slice(remediations, func(i, j int) bool { return remediations[i].Type < remediations[j].Type })
+	return remediations
+}
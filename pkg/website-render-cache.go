@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// builtinTemplateVersion is the template version of the configurations rendered by the
+// controller's built-in renderer.
+const builtinTemplateVersion = "builtin"
+
+// renderCache holds the last rendered configuration of every Website with the key of the
+// inputs it was rendered from, so a resync of an unchanged Website skips rendering. Only
+// successful renders are cached.
+type renderCache struct {
+	mu      sync.Mutex
+	entries map[string]renderEntry
+}
+
+// renderEntry is a rendered configuration and the key of its inputs.
+type renderEntry struct {
+	key    string
+	config string
+}
+
+// renderInputs is everything a configuration is rendered from besides the controller's
+// options, which do not change while it runs.
+type renderInputs struct {
+	TemplateVersion  string                           `json:"templateVersion"`
+	Namespace        string                           `json:"namespace"`
+	Name             string                           `json:"name"`
+	Labels           map[string]string                `json:"labels,omitempty"`
+	Annotations      map[string]string                `json:"annotations,omitempty"`
+	Deleting         bool                             `json:"deleting,omitempty"`
+	Spec             v1alpha1.WebsiteSpec             `json:"spec"`
+	UpstreamProtocol string                           `json:"upstreamProtocol,omitempty"`
+	Listen           string                           `json:"listen,omitempty"`
+	Middleware       []v1alpha1.WebsiteMiddlewareSpec `json:"middleware,omitempty"`
+	Weights          map[string]int32                 `json:"weights,omitempty"`
+}
+
+// renderKey hashes the inputs a Website's configuration is rendered from. encoding/json
+// sorts map keys, so equal inputs always hash alike.
+func (c *WebsiteController) renderKey(website *v1alpha1.Website, templateVersion, listen string) (string, error) {
+	c.middlewareMu.Lock()
+	middleware := c.middleware[websiteKey(website)]
+	c.middlewareMu.Unlock()
+
+	inputs, err := json.Marshal(renderInputs{
+		TemplateVersion:  templateVersion,
+		Namespace:        website.Namespace,
+		Name:             website.Name,
+		Labels:           website.Labels,
+		Annotations:      website.Annotations,
+		Deleting:         website.DeletionTimestamp != nil,
+		Spec:             website.Spec,
+		UpstreamProtocol: website.Status.UpstreamProtocol,
+		Listen:           listen,
+		Middleware:       middleware,
+		Weights:          c.upstreamWeights(website),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal render inputs")
+	}
+	sum := sha256.Sum256(inputs)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// get returns the cached configuration of a Website if it was rendered from the same inputs.
+func (r *renderCache) get(website *v1alpha1.Website, key string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[websiteKey(website)]
+	if !ok || entry.key != key {
+		renderCacheLookups.WithLabelValues("miss").Inc()
+		return "", false
+	}
+	renderCacheLookups.WithLabelValues("hit").Inc()
+	return entry.config, true
+}
+
+// put caches the configuration of a Website rendered from the inputs of a key.
+func (r *renderCache) put(website *v1alpha1.Website, key, config string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[websiteKey(website)] = renderEntry{key: key, config: config}
+	renderCacheEntries.Set(float64(len(r.entries)))
+}
+
+// forget drops the cached configuration of a deleted Website.
+func (r *renderCache) forget(website *v1alpha1.Website) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.entries, websiteKey(website))
+	renderCacheEntries.Set(float64(len(r.entries)))
+}
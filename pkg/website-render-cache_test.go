@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// cachedWebsite is a Website for the render cache tests.
+func cachedWebsite() *v1alpha1.Website {
+	return &v1alpha1.Website{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "shop", Labels: map[string]string{"team": "a"}},
+		Spec:       v1alpha1.WebsiteSpec{Hostname: "shop.example.com", Upstream: "http://shop.default.svc:8080"},
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	tests := []struct {
+		name            string
+		change          func(c *WebsiteController, website *v1alpha1.Website)
+		templateVersion string
+		listen          string
+		wantHit         bool
+	}{
+		{
+			name:    "unchanged Website",
+			change:  func(c *WebsiteController, website *v1alpha1.Website) {},
+			wantHit: true,
+		},
+		{
+			name: "unrelated metadata",
+			change: func(c *WebsiteController, website *v1alpha1.Website) {
+				website.ResourceVersion = "42"
+				website.Status.Conditions = []metav1.Condition{{Type: v1alpha1.ConditionReady, Status: metav1.ConditionTrue}}
+			},
+			wantHit: true,
+		},
+		{
+			name: "changed spec",
+			change: func(c *WebsiteController, website *v1alpha1.Website) {
+				website.Spec.Upstream = "http://shop-v2.default.svc:8080"
+			},
+		},
+		{
+			name: "changed labels",
+			change: func(c *WebsiteController, website *v1alpha1.Website) {
+				website.Labels["team"] = "b"
+			},
+		},
+		{
+			name: "deleting",
+			change: func(c *WebsiteController, website *v1alpha1.Website) {
+				now := metav1.Now()
+				website.DeletionTimestamp = &now
+			},
+		},
+		{
+			name: "changed middleware",
+			change: func(c *WebsiteController, website *v1alpha1.Website) {
+				c.middleware[websiteKey(website)] = []v1alpha1.WebsiteMiddlewareSpec{{}}
+			},
+		},
+		{
+			name:            "changed template",
+			change:          func(c *WebsiteController, website *v1alpha1.Website) {},
+			templateVersion: "default/templates/server.tmpl@2",
+		},
+		{
+			name:   "changed listen options",
+			change: func(c *WebsiteController, website *v1alpha1.Website) {},
+			listen: "reuseport",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newRenderer()
+			website := cachedWebsite()
+			key, err := c.renderKey(website, builtinTemplateVersion, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.renders.put(website, key, "server {}")
+
+			tt.change(c, website)
+			templateVersion := tt.templateVersion
+			if templateVersion == "" {
+				templateVersion = builtinTemplateVersion
+			}
+			key, err = c.renderKey(website, templateVersion, tt.listen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, hit := c.renders.get(website, key)
+			if hit != tt.wantHit {
+				t.Fatalf("hit = %t, want %t", hit, tt.wantHit)
+			}
+			if hit && config != "server {}" {
+				t.Errorf("got cached config %q", config)
+			}
+		})
+	}
+}
+
+func TestRenderCacheForget(t *testing.T) {
+	c := newRenderer()
+	website := cachedWebsite()
+	key, err := c.renderKey(website, builtinTemplateVersion, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.renders.put(website, key, "server {}")
+	c.renders.forget(website)
+
+	if _, hit := c.renders.get(website, key); hit {
+		t.Error("forgotten Website is still cached")
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// RenderReport is the output of the render command.
+type RenderReport struct {
+	Websites []RenderedWebsite `json:"websites"`
+}
+
+// RenderedWebsite is the configuration a single Website renders to.
+type RenderedWebsite struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Config    string `json:"config,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ValidateReport is the output of the validate command.
+type ValidateReport struct {
+	Websites []WebsiteValidation `json:"websites"`
+}
+
+// WebsiteValidation is the outcome of validating a single Website's configuration.
+type WebsiteValidation struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Valid     bool   `json:"valid"`
+	Error     string `json:"error,omitempty"`
+}
+
+// renderCommand renders the nginx configuration of the Websites of a manifest. Middleware and
+// referenced Services are read from the cluster, like the controller does.
+func renderCommand(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	file := flags.String("f", "", "manifest of the Websites to render")
+	namespace := flags.String("namespace", "default", "namespace of manifests that do not set one")
+	output := flags.String("o", "", "output format of the report: json or yaml, the configurations if empty")
+	_, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	websites, c, err := readCommandWebsites(*file, *namespace)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	report := RenderReport{Websites: []RenderedWebsite{}}
+	for _, website := range websites {
+		config, err := renderForDiff(ctx, c, website)
+		rendered := RenderedWebsite{Namespace: website.Namespace, Name: website.Name, Path: nginxConfigPath(website), Config: config}
+		if err != nil {
+			rendered.Error = err.Error()
+		}
+		report.Websites = append(report.Websites, rendered)
+	}
+
+	if *output != "" {
+		return cli.PrintReport(*output, report)
+	}
+	failed := 0
+	for _, rendered := range report.Websites {
+		if rendered.Error != "" {
+			fmt.Fprintf(os.Stderr, "website/%s: %s\n", rendered.Name, rendered.Error)
+			failed++
+			continue
+		}
+		fmt.Printf("# %s\n%s\n", rendered.Path, rendered.Config)
+	}
+	if failed > 0 {
+		return errors.Errorf("%d of %d Websites failed to render", failed, len(report.Websites))
+	}
+	return nil
+}
+
+// validateCommand renders the Websites of a manifest and tests each configuration with nginx -t
+// against a copy of the local nginx configuration, so CI can reject a change before it is
+// applied. It fails if any Website is invalid.
+func validateCommand(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	file := flags.String("f", "", "manifest of the Websites to validate")
+	namespace := flags.String("namespace", "default", "namespace of manifests that do not set one")
+	output := flags.String("o", "", "output format of the report: json or yaml, a line per Website if empty")
+	_, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	binary, err := exec.LookPath("nginx")
+	if err != nil {
+		return errors.Wrap(err, "failed to find the nginx binary")
+	}
+	websites, c, err := readCommandWebsites(*file, *namespace)
+	if err != nil {
+		return err
+	}
+	stateDir, err := ioutil.TempDir("", "website-validate-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create validation directory")
+	}
+	defer os.RemoveAll(stateDir)
+	tester := newRenderer()
+	tester.nginxBinary = binary
+	tester.stateDir = stateDir
+
+	// Render and test each Website on its own
+	ctx := context.Background()
+	report := ValidateReport{Websites: []WebsiteValidation{}}
+	invalid := 0
+	for _, website := range websites {
+		validation := WebsiteValidation{Namespace: website.Namespace, Name: website.Name}
+		config, err := renderForDiff(ctx, c, website)
+		if err == nil {
+			err = tester.testCandidate(nginxConfigPath(website), []byte(config))
+		}
+		if err != nil {
+			validation.Error = err.Error()
+			invalid++
+		} else {
+			validation.Valid = true
+		}
+		report.Websites = append(report.Websites, validation)
+	}
+
+	if *output != "" {
+		err = cli.PrintReport(*output, report)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, validation := range report.Websites {
+			if validation.Valid {
+				fmt.Printf("website/%s valid\n", validation.Name)
+			} else {
+				fmt.Printf("website/%s invalid: %s\n", validation.Name, validation.Error)
+			}
+		}
+	}
+	if invalid > 0 {
+		return errors.Errorf("%d of %d Websites are invalid", invalid, len(report.Websites))
+	}
+	return nil
+}
+
+// readCommandWebsites reads the Websites of a manifest, sorted by namespace and name, and
+// creates the client their middleware and Services are read with.
+func readCommandWebsites(file, namespace string) ([]*v1alpha1.Website, client.Client, error) {
+	if file == "" {
+		return nil, nil, errors.New("a manifest must be given with -f")
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read manifest")
+	}
+	websites, err := decodeWebsites(content, namespace)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to decode %s", file)
+	}
+	sort.Slice(websites, func(i, j int) bool {
+		return websiteKey(websites[i]) < websiteKey(websites[j])
+	})
+
+	c, err := newCommandClient()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create client")
+	}
+	return websites, c, nil
+}
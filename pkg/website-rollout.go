@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// defaultRolloutBatchSize is how many Websites a rollout re-renders at once if it does not say.
+	defaultRolloutBatchSize = 10
+
+	// rolloutPollInterval is how often a paused rollout checks whether it was resumed.
+	rolloutPollInterval = 5 * time.Second
+
+	// rolloutBatchTimeout is how long a rollout waits for the Websites of a batch. Websites
+	// that were not re-rendered by then are counted as failed.
+	rolloutBatchTimeout = 5 * time.Minute
+)
+
+// rolloutResult is the outcome of re-rendering a Website for a rollout.
+type rolloutResult struct {
+	key string
+	err error
+}
+
+// rolloutTracker hands the outcome of the Websites a rollout queued back to the rollout.
+type rolloutTracker struct {
+	mu      sync.Mutex
+	pending map[string]chan rolloutResult
+}
+
+// newRolloutTracker creates a rolloutTracker without pending Websites.
+func newRolloutTracker() *rolloutTracker {
+	return &rolloutTracker{pending: map[string]chan rolloutResult{}}
+}
+
+// expect registers the Websites of a batch and returns the channel their outcomes are sent to.
+func (t *rolloutTracker) expect(keys []string) <-chan rolloutResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results := make(chan rolloutResult, len(keys))
+	for _, key := range keys {
+		t.pending[key] = results
+	}
+	return results
+}
+
+// observe reports the outcome of handling a Website's event, if a rollout waits for it. Only the
+// first attempt is reported; failed events are still retried by the queue.
+func (t *rolloutTracker) observe(key string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results, ok := t.pending[key]
+	if !ok {
+		return
+	}
+	delete(t.pending, key)
+	results <- rolloutResult{key: key, err: err}
+}
+
+// forget stops waiting for the Websites of a batch.
+func (t *rolloutTracker) forget(keys []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range keys {
+		delete(t.pending, key)
+	}
+}
+
+// settingsFingerprint identifies the global settings the Websites are rendered under: the
+// controller's flags, the nginx it renders for and the TLS defaults of the NginxGlobalConfig.
+func (c *WebsiteController) settingsFingerprint() string {
+	settings := struct {
+		Environment  string   `json:"environment"`
+		SecretsDir   string   `json:"secretsDir"`
+		SPIFFEDir    string   `json:"spiffeDir"`
+		Nginx        string   `json:"nginx"`
+		Capabilities []string `json:"capabilities"`
+		SSL          string   `json:"ssl"`
+	}{
+		Environment: c.environment,
+		SecretsDir:  c.secretsDir,
+		SPIFFEDir:   c.spiffeDir,
+		SSL:         c.globalSSL,
+	}
+	if c.capabilities != nil {
+		settings.Nginx = c.capabilities.version
+		settings.Capabilities = c.capabilities.list()
+	}
+	data, _ := json.Marshal(settings)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// rolloutGlobalConfig records the TLS defaults of an applied NginxGlobalConfig and rolls the
+// global settings out to all Websites if they changed.
+func (c *WebsiteController) rolloutGlobalConfig(ctx context.Context, config *v1alpha1.NginxGlobalConfig) {
+	ssl, _ := json.Marshal(config.Spec.SSL)
+	c.globalSSL = string(ssl)
+	err := c.checkRollout(ctx, "global settings changed")
+	if err != nil {
+		c.log.Error(err, "failed to roll out global settings")
+	}
+}
+
+// checkRollout starts a WebsiteRollout if the global settings changed since the last one, or
+// resumes the rollout of the current settings if it did not complete. A running rollout of
+// other settings is superseded.
+func (c *WebsiteController) checkRollout(ctx context.Context, reason string) error {
+	fingerprint := c.settingsFingerprint()
+	name := "rollout-" + fingerprint
+
+	c.rolloutMu.Lock()
+	defer c.rolloutMu.Unlock()
+
+	if c.rolloutName == name {
+		return nil
+	}
+	rollout := &v1alpha1.WebsiteRollout{}
+	err := c.client.Get(ctx, types.NamespacedName{Name: name}, rollout)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to get WebsiteRollout")
+	}
+	create := apierrors.IsNotFound(err)
+	if !create && rollout.Status.Phase == v1alpha1.RolloutComplete {
+		// The settings were rolled out before the controller started
+		if c.rolloutName == "" {
+			c.rolloutName = name
+			return nil
+		}
+		// The settings changed back to ones rolled out earlier
+		err = c.client.Delete(ctx, rollout)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to delete completed WebsiteRollout")
+		}
+		create = true
+	}
+	if create {
+		rollout = &v1alpha1.WebsiteRollout{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.WebsiteRolloutSpec{Settings: fingerprint, Reason: reason},
+		}
+		err = c.client.Create(ctx, rollout)
+		if err != nil {
+			return errors.Wrap(err, "failed to create WebsiteRollout")
+		}
+		c.log.Info("global settings changed, rolling out to all Websites", "rollout", name, "reason", reason)
+	}
+
+	// Supersede the running rollout
+	if c.rolloutCancel != nil {
+		c.rolloutCancel()
+	}
+	rolloutCtx, cancel := context.WithCancel(ctx)
+	c.rolloutName, c.rolloutCancel = name, cancel
+	go func() {
+		err := c.runRollout(rolloutCtx, name)
+		if err != nil && rolloutCtx.Err() == nil {
+			c.log.Error(err, "failed to roll out global settings", "rollout", name)
+		}
+	}()
+	return nil
+}
+
+// runRollout requeues all Websites in batches and records their outcome in the status of the
+// rollout, waiting while it is paused.
+func (c *WebsiteController) runRollout(ctx context.Context, name string) error {
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+	websites := make([]*v1alpha1.Website, 0, len(list.Items))
+	for i := range list.Items {
+		websites = append(websites, &list.Items[i])
+	}
+	sort.Slice(websites, func(i, j int) bool { return websiteKey(websites[i]) < websiteKey(websites[j]) })
+
+	err = c.updateRolloutStatus(ctx, name, func(status *v1alpha1.WebsiteRolloutStatus) {
+		status.Phase = v1alpha1.RolloutProgressing
+		status.Total = int32(len(websites))
+		status.Updated, status.Failed, status.Failures = 0, 0, nil
+		if status.StartTime == nil {
+			now := metav1.Now()
+			status.StartTime = &now
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var updated, failed int32
+	var failures []v1alpha1.WebsiteRolloutFailure
+	for start := 0; start < len(websites); {
+		rollout, err := c.waitForRolloutResumed(ctx, name)
+		if err != nil {
+			return err
+		}
+		size := int(rollout.Spec.BatchSize)
+		if size < 1 {
+			size = defaultRolloutBatchSize
+		}
+		end := start + size
+		if end > len(websites) {
+			end = len(websites)
+		}
+		batch := websites[start:end]
+		start = end
+
+		// Re-render the batch and wait for the outcome
+		keys := make([]string, 0, len(batch))
+		for _, website := range batch {
+			keys = append(keys, websiteKey(website))
+		}
+		results := c.rollouts.expect(keys)
+		for _, website := range batch {
+			c.queue.Add(watch.Event{Type: watch.Modified, Object: website})
+		}
+		outcomes := c.collectRolloutResults(ctx, keys, results)
+		c.rollouts.forget(keys)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		for _, key := range keys {
+			if outcome := outcomes[key]; outcome != "" {
+				parts := strings.SplitN(key, "/", 2)
+				failed++
+				failures = append(failures, v1alpha1.WebsiteRolloutFailure{Namespace: parts[0], Name: parts[1], Message: outcome})
+				continue
+			}
+			updated++
+		}
+		err = c.updateRolloutStatus(ctx, name, func(status *v1alpha1.WebsiteRolloutStatus) {
+			status.Updated, status.Failed, status.Failures = updated, failed, failures
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.updateRolloutStatus(ctx, name, func(status *v1alpha1.WebsiteRolloutStatus) {
+		now := metav1.Now()
+		status.Phase = v1alpha1.RolloutComplete
+		status.CompletionTime = &now
+	})
+}
+
+// collectRolloutResults waits for the outcome of a batch and returns the failure of every
+// Website that failed or timed out, by key.
+func (c *WebsiteController) collectRolloutResults(ctx context.Context, keys []string, results <-chan rolloutResult) map[string]string {
+	failures := map[string]string{}
+	reported := map[string]bool{}
+	timeout := time.NewTimer(rolloutBatchTimeout)
+	defer timeout.Stop()
+	for len(reported) < len(keys) {
+		select {
+		case <-ctx.Done():
+			return failures
+		case <-timeout.C:
+			for _, key := range keys {
+				if !reported[key] {
+					failures[key] = "timed out waiting for the Website to be re-rendered"
+				}
+			}
+			return failures
+		case result := <-results:
+			reported[result.key] = true
+			if result.err != nil {
+				failures[result.key] = result.err.Error()
+			}
+		}
+	}
+	return failures
+}
+
+// waitForRolloutResumed returns the rollout once it is not paused, marking it Paused meanwhile.
+func (c *WebsiteController) waitForRolloutResumed(ctx context.Context, name string) (*v1alpha1.WebsiteRollout, error) {
+	paused := false
+	for {
+		rollout := &v1alpha1.WebsiteRollout{}
+		err := c.client.Get(ctx, types.NamespacedName{Name: name}, rollout)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get WebsiteRollout")
+		}
+		if !rollout.Spec.Paused {
+			if paused {
+				err = c.updateRolloutStatus(ctx, name, func(status *v1alpha1.WebsiteRolloutStatus) {
+					status.Phase = v1alpha1.RolloutProgressing
+				})
+			}
+			return rollout, err
+		}
+		if !paused {
+			err = c.updateRolloutStatus(ctx, name, func(status *v1alpha1.WebsiteRolloutStatus) {
+				status.Phase = v1alpha1.RolloutPaused
+			})
+			if err != nil {
+				return nil, err
+			}
+			paused = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+// updateRolloutStatus updates the status of a WebsiteRollout, retrying on conflicts.
+func (c *WebsiteController) updateRolloutStatus(ctx context.Context, name string, update func(*v1alpha1.WebsiteRolloutStatus)) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		rollout := &v1alpha1.WebsiteRollout{}
+		err := c.client.Get(ctx, types.NamespacedName{Name: name}, rollout)
+		if err != nil {
+			return err
+		}
+		update(&rollout.Status)
+		return c.client.Status().Update(ctx, rollout)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update WebsiteRollout status")
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// serveCommand runs the controller until it is interrupted.
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	source := flags.String("source", "cluster", `where Websites are read from: "cluster", or "dir:PATH" for a directory of manifests`)
+	var options WebsiteControllerOptions
+	flags.StringVar(&options.AuditLog, "audit-log", "", "file path or http(s) URL mutations are audited to")
+	flags.StringVar(&options.VaultAddress, "vault-address", "", "address of the Vault server")
+	flags.StringVar(&options.SecretsDir, "secrets-dir", defaultSecretsDir, "tmpfs directory secret material is written to")
+	flags.IntVar(&options.SecretsGroup, "secrets-group", 0, "group id nginx reads secret material as, 0 for the controller's group")
+	flags.StringVar(&options.StateDir, "state-dir", defaultStateDir, "writable directory the revision history and status pages are kept in")
+	flags.StringVar(&options.NginxPidFile, "nginx-pid-file", defaultNginxPidFile, "pid file of the nginx master process signalled to reload")
+	flags.StringVar(&options.SPIFFEDir, "spiffe-dir", defaultSPIFFEDir, "directory the X.509 SVIDs of spec.tls.spiffe are read from")
+	flags.StringVar(&options.StatusPageDomain, "status-page-domain", "", "domain the per-namespace status pages are served under")
+	flags.IntVar(&options.MassDeletionPercent, "mass-deletion-percent", 0, "percentage of Websites deleted within the window that holds further deletions")
+	flags.StringVar(&options.UsageEndpoint, "usage-endpoint", "", "http(s) URL usage reports are posted to")
+	flags.StringVar(&options.ChaosAddress, "chaos-address", "", "address of the chaos API, for staging only")
+	flags.StringVar(&options.MetricsAddress, "metrics-address", ":8080", "address the Prometheus metrics are served on at /metrics, empty to disable")
+	flags.StringVar(&options.HealthAddress, "health-address", ":8081", "address the liveness and readiness probes are served on at /healthz and /readyz, empty to disable")
+	flags.StringVar(&options.WebhookAddress, "webhook-address", "", "address the validating admission webhook is served on over TLS")
+	flags.StringVar(&options.WebhookCertFile, "webhook-cert", "/etc/website-controller/webhook/tls.crt", "certificate of the admission webhook")
+	flags.StringVar(&options.WebhookKeyFile, "webhook-key", "/etc/website-controller/webhook/tls.key", "private key of the admission webhook")
+	deniedUpstreamCIDRs := flags.String("denied-upstream-cidrs", strings.Join(defaultDeniedUpstreamCIDRs, ","), "comma-separated address ranges Websites may not proxy to, empty to allow all")
+	flags.StringVar(&options.DeploymentImage, "deployment-image", defaultDeploymentImage, "nginx image of the Deployments of Websites in Deployment mode")
+	flags.StringVar(&options.RemediationHintsFile, "remediation-hints", "", "YAML file of runbook hints by condition reason, reported with failing conditions")
+	flags.StringVar(&options.APIAddress, "api-address", "", "address of the API reporting the configuration applied to nginx")
+	flags.StringVar(&options.Environment, "environment", "", "environment whose spec.overlays entry the Websites are rendered with")
+	flags.StringVar(&options.TenantDir, "tenant-dir", "", "directory the temporary files and error logs of the Websites are partitioned into by namespace")
+	tenantDiskQuota := flags.String("tenant-disk-quota", "0", "disk space a namespace's tenant directory may use, e.g. 1Gi, 0 for no limit")
+	flags.StringVar(&options.ACMEDirectoryURL, "acme-directory", acme.LetsEncryptURL, "directory URL of the ACME CA issuing the certificates of spec.tls.acme")
+	flags.StringVar(&options.ACMEEmail, "acme-email", "", "contact address of the controller's ACME account")
+	flags.StringVar(&options.ACMEWebroot, "acme-webroot", defaultACMEWebroot, "directory the ACME HTTP-01 challenge responses are served from by nginx")
+	flags.StringVar(&options.FailoverPeerURL, "failover-peer", "", "health check URL of the peer edge the Websites fail over to, empty to disable failover")
+	flags.StringVar(&options.FailoverLocalTarget, "failover-local-target", "", "IP or hostname the DNS records of the Websites point at for the local edge")
+	flags.StringVar(&options.FailoverPeerTarget, "failover-peer-target", "", "IP or hostname the DNS records of the Websites point at for the peer edge")
+	flags.StringVar(&options.FailoverDNSProvider, "failover-dns-provider", "external-dns", `updater of the weighted DNS records: "external-dns", or "webhook:URL"`)
+	flags.StringVar(&options.Class, "class", "", "class of the Websites served, matched against their spec.className, empty for Websites without one")
+	flags.IntVar(&options.QuarantineThreshold, "quarantine-after", defaultQuarantineThreshold, "failed validations or reloads in a row after which a Website is quarantined, -1 to never quarantine")
+	logLevel := flags.String("log-level", "info", "log level: error, info, debug, or a verbosity, where 2 logs the rendered configurations")
+	logFormat := flags.String("log-format", logFormatJSON, "log format: json or console")
+	flags.StringVar(&options.TemplateConfigMap, "template-configmap", "", "ConfigMap, as namespace/name, whose "+templateConfigMapKey+" key holds the Go template of the server blocks")
+	flags.StringVar(&options.FeatureGates, "feature-gates", "", "comma-separated gate=bool pairs enabling gated subsystems: "+strings.Join(knownFeatureGates(), ", "))
+	flags.DurationVar(&options.ResyncInterval, "resync-interval", 10*time.Minute, "interval configuration files are checked for drift and repaired, 0 to disable")
+	flags.DurationVar(&options.ReloadDebounce, "reload-debounce", 0, "window nginx reloads are batched in, 0 to reload immediately")
+	leaderElect := flags.Bool("leader-elect", false, "elect a leader among the controller replicas, the others run as warm standbys")
+	leaderElectionNamespace := flags.String("leader-election-namespace", "", "namespace of the leader election Lease, defaults to the pod's namespace")
+	leaderElectionID := flags.String("leader-election-id", defaultLeaderElectionID, "name of the leader election Lease")
+	flags.Parse(args)
+	options.DeniedUpstreamCIDRs = strings.Split(*deniedUpstreamCIDRs, ",")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Log as configured, including the logs of controller-runtime
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		return err
+	}
+	log.SetLogger(logger)
+
+	// Parse the tenant disk quota
+	quota, err := resource.ParseQuantity(*tenantDiskQuota)
+	if err != nil {
+		return errors.Wrap(err, "invalid tenant disk quota")
+	}
+	options.TenantDiskQuota = quota.Value()
+
+	// Connect to the source of the Websites
+	var c client.Client
+	switch {
+	case *source == "cluster":
+		c, err = newCommandClient()
+		if err != nil {
+			return errors.Wrap(err, "failed to create client")
+		}
+		options.RESTConfig, err = config.GetConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to load kubeconfig")
+		}
+		options.EventRecorder, err = newEventRecorder(options.RESTConfig, c.Scheme())
+		if err != nil {
+			return errors.Wrap(err, "failed to create event recorder")
+		}
+	case strings.HasPrefix(*source, "dir:"):
+		options.SourceDir = strings.TrimPrefix(*source, "dir:")
+		if _, err := os.Stat(options.SourceDir); err != nil {
+			return errors.Wrap(err, "invalid source directory")
+		}
+		c, err = newSourceDirClient()
+		if err != nil {
+			return errors.Wrap(err, "failed to create client")
+		}
+	default:
+		return errors.Errorf("invalid source %q", *source)
+	}
+
+	// Run as a warm standby until this replica leads, and stop once it no longer does
+	lost := make(chan struct{})
+	if *leaderElect {
+		if options.RESTConfig == nil {
+			return errors.New("leader election requires the cluster source")
+		}
+		options.Elected, err = electLeader(ctx, options.RESTConfig, *leaderElectionNamespace, *leaderElectionID, func() {
+			if ctx.Err() == nil {
+				close(lost)
+			}
+			stop()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	controller, err := NewWebsiteController(logger, c, options)
+	if err != nil {
+		return err
+	}
+
+	err = controller.Run(ctx)
+	select {
+	case <-lost:
+		if err == nil {
+			err = errors.New("lost leadership")
+		}
+	default:
+	}
+	return err
+}
+
+// newSourceDirClient creates the in-memory client objects read from a manifest directory are
+// kept in.
+func newSourceDirClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	err := clientgoscheme.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	err = v1alpha1.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	return newDirClient(scheme), nil
+}
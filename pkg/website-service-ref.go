@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// serviceRefNamespacesAnnotation on a Namespace lists comma-separated namespaces whose Websites
+// may reference its Services, or * for all. Websites may always reference the Services of their
+// own namespace.
+const serviceRefNamespacesAnnotation = "website-operator.io/service-ref-namespaces"
+
+// resolveServiceRef resolves the Service a Website references into its upstreams: the
+// ClusterIP of the Service, or the ready endpoints of a headless Service. Websites without a
+// Service reference are returned as they are.
+func resolveServiceRef(ctx context.Context, reader client.Reader, website *v1alpha1.Website) (*v1alpha1.Website, error) {
+	ref := website.Spec.ServiceRef
+	if ref == nil {
+		return website, nil
+	}
+	if website.Spec.Upstream != "" {
+		return nil, errors.New("spec.upstream and spec.serviceRef are mutually exclusive")
+	}
+	scheme := ref.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	service := &corev1.Service{}
+	key := serviceRefKey(website)
+	err := checkServiceRefGrant(ctx, reader, website)
+	if err != nil {
+		return nil, err
+	}
+	err = reader.Get(ctx, key, service)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get Service %s", key)
+	}
+	var port *corev1.ServicePort
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Port == ref.Port {
+			port = &service.Spec.Ports[i]
+		}
+	}
+	if port == nil {
+		return nil, errors.Errorf("Service %s has no port %d", key, ref.Port)
+	}
+
+	// Proxy to the ClusterIP, which balances across the endpoints itself
+	var addresses []string
+	if service.Spec.ClusterIP != "" && service.Spec.ClusterIP != corev1.ClusterIPNone {
+		addresses = append(addresses, net.JoinHostPort(service.Spec.ClusterIP, strconv.Itoa(int(ref.Port))))
+	} else {
+		addresses, err = serviceEndpoints(ctx, reader, key, port)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, errors.Errorf("Service %s has no ready endpoints", key)
+	}
+
+	resolved := website.DeepCopy()
+	resolved.Spec.Upstream = fmt.Sprintf("%s://%s", scheme, addresses[0])
+	for _, address := range addresses[1:] {
+		resolved.Spec.Upstreams = append(resolved.Spec.Upstreams, fmt.Sprintf("%s://%s", scheme, address))
+	}
+	return resolved, nil
+}
+
+// serviceEndpoints returns the ready endpoints of a headless Service's port as host:port,
+// sorted so the configuration only changes with the endpoints.
+func serviceEndpoints(ctx context.Context, reader client.Reader, key types.NamespacedName, port *corev1.ServicePort) ([]string, error) {
+	slices := &discoveryv1.EndpointSliceList{}
+	err := reader.List(ctx, slices, client.InNamespace(key.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: key.Name})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list endpoints of Service %s", key)
+	}
+
+	seen := map[string]bool{}
+	var addresses []string
+	for _, slice := range slices.Items {
+		for _, slicePort := range slice.Ports {
+			if slicePort.Port == nil || (slicePort.Name != nil && *slicePort.Name != port.Name) {
+				continue
+			}
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+					continue
+				}
+				for _, ip := range endpoint.Addresses {
+					address := net.JoinHostPort(ip, strconv.Itoa(int(*slicePort.Port)))
+					if !seen[address] {
+						seen[address] = true
+						addresses = append(addresses, address)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// serviceRefKey returns the namespace and name of the Service a Website references.
+func serviceRefKey(website *v1alpha1.Website) types.NamespacedName {
+	ref := website.Spec.ServiceRef
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = website.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: ref.Name}
+}
+
+// checkServiceRefGrant refuses a Website referencing a Service in another namespace unless that
+// namespace grants it with the service-ref-namespaces annotation.
+func checkServiceRefGrant(ctx context.Context, reader client.Reader, website *v1alpha1.Website) error {
+	key := serviceRefKey(website)
+	if key.Namespace == website.Namespace {
+		return nil
+	}
+
+	namespace := &corev1.Namespace{}
+	err := reader.Get(ctx, types.NamespacedName{Name: key.Namespace}, namespace)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get Namespace %s", key.Namespace)
+	}
+	for _, granted := range strings.Split(namespace.Annotations[serviceRefNamespacesAnnotation], ",") {
+		granted = strings.TrimSpace(granted)
+		if granted == "*" || granted == website.Namespace {
+			return nil
+		}
+	}
+	return errors.Errorf("Namespace %s does not grant Websites of namespace %s access to Service %s with the %s annotation", key.Namespace, website.Namespace, key.Name, serviceRefNamespacesAnnotation)
+}
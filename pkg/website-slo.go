@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// sloInterval is how often the request log is aggregated and the SLOs are evaluated.
+	sloInterval = time.Minute
+
+	// defaultSLOWindow is the error budget window of SLOs that do not set one.
+	defaultSLOWindow = 30 * 24 * time.Hour
+
+	// sloFastBurnRate is the burn rate that flags a violation. Sustained for an hour, it
+	// consumes 2% of a 30 day error budget.
+	sloFastBurnRate = 14.4
+)
+
+// sloBurnWindows are the windows burn rates are reported for. An SLO is violated while the
+// burn rate of both exceeds sloFastBurnRate, so a short spike does not flag a violation and
+// a recovered Website is cleared quickly.
+var sloBurnWindows = []struct {
+	name    string
+	minutes int
+}{{"5m", 5}, {"1h", 60}}
+
+// sloBucket counts the requests of a Website within a period.
+type sloBucket struct {
+	total int64
+	// failed counts requests that failed with a 5xx status.
+	failed int64
+	// slow counts requests slower than the latency threshold.
+	slow int64
+}
+
+// add adds the counts of another bucket.
+func (b *sloBucket) add(o sloBucket) {
+	b.total += o.total
+	b.failed += o.failed
+	b.slow += o.slow
+}
+
+// sloSeries holds the request counts of a Website: a bucket per minute for the last hour, and
+// a bucket per hour for the error budget window. Counts are kept in memory only, so the window
+// starts over when the controller restarts.
+type sloSeries struct {
+	// minutes are the last hour's buckets, oldest first.
+	minutes []sloBucket
+	// hours are the buckets of the window, oldest first. The last one is the current hour.
+	hours []sloBucket
+	// elapsed counts the minutes added to the current hour.
+	elapsed int
+}
+
+// push adds the bucket of the last minute and drops the buckets that left the window.
+func (s *sloSeries) push(minute sloBucket, window time.Duration) {
+	s.minutes = append(s.minutes, minute)
+	if len(s.minutes) > 60 {
+		s.minutes = s.minutes[len(s.minutes)-60:]
+	}
+
+	if len(s.hours) == 0 || s.elapsed == 60 {
+		s.hours = append(s.hours, sloBucket{})
+		s.elapsed = 0
+	}
+	s.hours[len(s.hours)-1].add(minute)
+	s.elapsed++
+	hours := int(window / time.Hour)
+	if hours < 1 {
+		hours = 1
+	}
+	if len(s.hours) > hours {
+		s.hours = s.hours[len(s.hours)-hours:]
+	}
+}
+
+// recent sums the buckets of the last minutes.
+func (s *sloSeries) recent(minutes int) sloBucket {
+	var sum sloBucket
+	for i := len(s.minutes) - 1; i >= 0 && i >= len(s.minutes)-minutes; i-- {
+		sum.add(s.minutes[i])
+	}
+	return sum
+}
+
+// total sums the buckets of the window.
+func (s *sloSeries) total() sloBucket {
+	var sum sloBucket
+	for _, hour := range s.hours {
+		sum.add(hour)
+	}
+	return sum
+}
+
+// sloObjective is an objective of a Website's SLO, compiled from the spec.
+type sloObjective struct {
+	name string
+	// target is the fraction of requests that must be good.
+	target float64
+	// bad returns the number of requests of a bucket that violate the objective.
+	bad func(sloBucket) int64
+}
+
+// burnRate returns how many times faster than sustainable the error budget is spent.
+func (o sloObjective) burnRate(b sloBucket) float64 {
+	if b.total == 0 {
+		return 0
+	}
+	return float64(o.bad(b)) / float64(b.total) / (1 - o.target)
+}
+
+// budgetRemaining returns the fraction of the error budget of the window not spent yet.
+func (o sloObjective) budgetRemaining(b sloBucket) float64 {
+	return 1 - o.burnRate(b)
+}
+
+// sloObjectives compiles the objectives of a Website's SLO.
+func sloObjectives(slo *v1alpha1.SLOSpec) ([]sloObjective, error) {
+	var objectives []sloObjective
+	if slo.Availability != "" {
+		target, err := sloTarget(slo.Availability)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid availability objective")
+		}
+		objectives = append(objectives, sloObjective{"availability", target, func(b sloBucket) int64 { return b.failed }})
+	}
+	if slo.Latency != nil {
+		target, err := sloTarget(slo.Latency.Target)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid latency objective")
+		}
+		if slo.Latency.Threshold.Duration <= 0 {
+			return nil, errors.New("invalid latency objective: threshold must be positive")
+		}
+		objectives = append(objectives, sloObjective{"latency", target, func(b sloBucket) int64 { return b.slow }})
+	}
+	return objectives, nil
+}
+
+// sloTarget parses an objective percentage into a fraction.
+func sloTarget(percent string) (float64, error) {
+	target, err := strconv.ParseFloat(percent, 64)
+	if err != nil {
+		return 0, err
+	}
+	if target <= 0 || target >= 100 {
+		return 0, errors.Errorf("%s%% is not between 0%% and 100%%", percent)
+	}
+	return target / 100, nil
+}
+
+// sloWindow returns the error budget window of an SLO.
+func sloWindow(slo *v1alpha1.SLOSpec) time.Duration {
+	if slo.Window == nil || slo.Window.Duration <= 0 {
+		return defaultSLOWindow
+	}
+	return slo.Window.Duration
+}
+
+// runSLOs aggregates the request log of the Websites with an SLO every interval and evaluates
+// their objectives until the context is cancelled.
+func (c *WebsiteController) runSLOs(ctx context.Context) {
+	ticker := time.NewTicker(sloInterval)
+	defer ticker.Stop()
+
+	tail := &logTail{path: tailLogPath}
+	series := map[string]*sloSeries{}
+	enabled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Find the Websites with an SLO
+		list := &v1alpha1.WebsiteList{}
+		err := c.client.List(ctx, list)
+		if err != nil {
+			c.log.Error(err, "failed to list Websites")
+			continue
+		}
+		websites := map[string]*v1alpha1.Website{}
+		for i := range list.Items {
+			website, err := applyOverlay(&list.Items[i], c.environment)
+			if err == nil && hasClass(website, c.class) && website.Spec.SLO != nil {
+				websites[websiteKey(website)] = website
+			}
+		}
+
+		// Log the requests once a Website has an SLO
+		if !enabled {
+			if len(websites) == 0 {
+				continue
+			}
+			err = c.enableTailLog()
+			if err != nil {
+				c.log.Error(err, "failed to enable request logging for SLOs")
+				continue
+			}
+			tail.read(func(string) {})
+			enabled = true
+			continue
+		}
+
+		// Count the requests of the last interval
+		minute := map[string]*sloBucket{}
+		err = tail.read(func(line string) {
+			record := TailRecord{}
+			if json.Unmarshal([]byte(line), &record) != nil {
+				return
+			}
+			key, ok := c.hostnames.routeOwner(record.ServerName, record.URI)
+			website := websites[key]
+			if !ok || website == nil {
+				return
+			}
+			bucket, ok := minute[key]
+			if !ok {
+				bucket = &sloBucket{}
+				minute[key] = bucket
+			}
+			bucket.total++
+			if record.Status >= 500 {
+				bucket.failed++
+			}
+			if latency := website.Spec.SLO.Latency; latency != nil && record.RequestTime > latency.Threshold.Seconds() {
+				bucket.slow++
+			}
+		})
+		if err != nil {
+			c.log.Error(err, "failed to read request log")
+		}
+
+		// Evaluate the objectives
+		for key, website := range websites {
+			s, ok := series[key]
+			if !ok {
+				s = &sloSeries{}
+				series[key] = s
+			}
+			var counts sloBucket
+			if bucket, ok := minute[key]; ok {
+				counts = *bucket
+			}
+			s.push(counts, sloWindow(website.Spec.SLO))
+
+			err := c.evaluateSLO(ctx, website, s)
+			if err != nil {
+				c.log.Error(err, "failed to evaluate SLO", "namespace", website.Namespace, "name", website.Name)
+			}
+		}
+
+		// Forget the Websites that no longer have an SLO
+		for key := range series {
+			if _, ok := websites[key]; !ok {
+				delete(series, key)
+				parts := strings.SplitN(key, "/", 2)
+				labels := prometheus.Labels{"namespace": parts[0], "website": parts[1]}
+				sloBurnRate.DeletePartialMatch(labels)
+				sloBudgetRemaining.DeletePartialMatch(labels)
+			}
+		}
+	}
+}
+
+// evaluateSLO exports the burn rates and error budgets of a Website and sets its SLOViolated
+// condition. The status is only written when the condition changes.
+func (c *WebsiteController) evaluateSLO(ctx context.Context, website *v1alpha1.Website, s *sloSeries) error {
+	objectives, err := sloObjectives(website.Spec.SLO)
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionSLOViolated,
+		Status:             metav1.ConditionFalse,
+		Reason:             "WithinBudget",
+		Message:            "The error budget is not burning fast",
+		ObservedGeneration: website.Generation,
+	}
+	for _, objective := range objectives {
+		violated := true
+		for _, window := range sloBurnWindows {
+			rate := objective.burnRate(s.recent(window.minutes))
+			sloBurnRate.WithLabelValues(website.Namespace, website.Name, objective.name, window.name).Set(rate)
+			violated = violated && rate > sloFastBurnRate
+		}
+		remaining := objective.budgetRemaining(s.total())
+		sloBudgetRemaining.WithLabelValues(website.Namespace, website.Name, objective.name).Set(remaining)
+
+		if violated {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "FastBurn"
+			condition.Message = fmt.Sprintf("The %s error budget burns over %.1fx faster than sustainable, %.1f%% of it remains",
+				objective.name, sloFastBurnRate, remaining*100)
+			break
+		}
+	}
+
+	existing := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionSLOViolated)
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason {
+		return nil
+	}
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	return c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+}
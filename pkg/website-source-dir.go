@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// dirPollInterval is how often a manifest directory is checked for changes.
+	dirPollInterval = 2 * time.Second
+
+	// dirDefaultNamespace is the namespace of namespaced manifests that do not set one.
+	dirDefaultNamespace = "default"
+)
+
+// clusterScopedKinds are the kinds that have no namespace.
+var clusterScopedKinds = map[string]bool{
+	"NginxGlobalConfig": true,
+	"NginxCapabilities": true,
+}
+
+// dirObject is a manifest read from a directory, with the hash of its content.
+type dirObject struct {
+	object *unstructured.Unstructured
+	hash   string
+}
+
+// watchDir stands in for the API server watches when the controller runs from a directory of
+// manifests. Objects are kept in the controller's in-memory client, so references and status
+// updates resolve as they would in a cluster, and changed files are turned into watch events.
+func (c *WebsiteController) watchDir(ctx context.Context, dir string) error {
+	known := map[string]dirObject{}
+	ticker := time.NewTicker(dirPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.syncDir(ctx, dir, known)
+		if err != nil {
+			c.log.Error(err, "failed to read manifests", "dir", dir)
+		} else {
+			c.setListed()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncDir applies the changes of a manifest directory since the last sync. Referenced objects
+// are applied before the Websites referencing them.
+func (c *WebsiteController) syncDir(ctx context.Context, dir string, known map[string]dirObject) error {
+	store, ok := c.client.(*dirClient)
+	if !ok {
+		return errors.Errorf("the client of a manifest directory is a %T", c.client)
+	}
+	current, err := readManifestDir(dir)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(current))
+	for key := range current {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		iWebsite := current[keys[i]].object.GetKind() == "Website"
+		jWebsite := current[keys[j]].object.GetKind() == "Website"
+		if iWebsite != jWebsite {
+			return jWebsite
+		}
+		return keys[i] < keys[j]
+	})
+
+	// Create and update the objects of changed files
+	for _, key := range keys {
+		obj := current[key]
+		previous, existed := known[key]
+		if existed && previous.hash == obj.hash {
+			continue
+		}
+		eventType := watch.Added
+		if existed {
+			eventType = watch.Modified
+		}
+		store.apply(obj.object)
+		known[key] = obj
+		c.dispatchDirEvent(ctx, eventType, obj.object)
+	}
+
+	// Delete the objects of removed files
+	for key, obj := range known {
+		if _, ok := current[key]; ok {
+			continue
+		}
+		store.remove(obj.object)
+		delete(known, key)
+		c.dispatchDirEvent(ctx, watch.Deleted, obj.object)
+	}
+
+	return nil
+}
+
+// dispatchDirEvent hands a changed object to the handler its watch would have called.
+func (c *WebsiteController) dispatchDirEvent(ctx context.Context, eventType watch.EventType, obj *unstructured.Unstructured) {
+	switch obj.GetKind() {
+	case "Website":
+		website := &v1alpha1.Website{}
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, website)
+		if err == nil && eventType != watch.Deleted {
+			err = c.client.Get(ctx, client.ObjectKeyFromObject(website), website)
+		}
+		if err != nil {
+			c.log.Error(err, "failed to read Website", "namespace", obj.GetNamespace(), "name", obj.GetName())
+			return
+		}
+		c.queue.Add(watch.Event{Type: eventType, Object: website})
+
+	case "NginxGlobalConfig":
+		if obj.GetName() != v1alpha1.NginxGlobalConfigName {
+			return
+		}
+		config := &v1alpha1.NginxGlobalConfig{}
+		if eventType != watch.Deleted {
+			err := c.client.Get(ctx, client.ObjectKey{Name: obj.GetName()}, config)
+			if err != nil {
+				c.log.Error(err, "failed to read NginxGlobalConfig")
+				return
+			}
+		}
+		err := c.applyGlobalConfig(ctx, config)
+		if err != nil {
+			c.log.Error(err, "failed to apply global nginx configuration")
+		}
+		err = c.acknowledgeDeletions(ctx, config)
+		if err != nil {
+			c.log.Error(err, "failed to acknowledge held deletions")
+		}
+
+	default:
+		// Middleware, ConfigMaps and Secrets are referenced from Websites of their namespace
+		list := &v1alpha1.WebsiteList{}
+		err := c.client.List(ctx, list, client.InNamespace(obj.GetNamespace()))
+		if err != nil {
+			c.log.Error(err, "failed to list Websites", "namespace", obj.GetNamespace())
+			return
+		}
+		for i := range list.Items {
+			c.queue.Add(watch.Event{Type: watch.Modified, Object: &list.Items[i]})
+		}
+	}
+}
+
+// readManifestDir reads the objects of the YAML and JSON manifests below a directory, by kind,
+// namespace and name.
+func readManifestDir(dir string) (map[string]dirObject, error) {
+	objects := map[string]dirObject{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if info.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+		for {
+			obj := &unstructured.Unstructured{}
+			err := decoder.Decode(&obj.Object)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to decode %s", path)
+			}
+			if obj.Object == nil || obj.GetKind() == "" {
+				continue
+			}
+			if obj.GetNamespace() == "" && !clusterScopedKinds[obj.GetKind()] {
+				obj.SetNamespace(dirDefaultNamespace)
+			}
+
+			raw, err := json.Marshal(obj.Object)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(raw)
+			key := obj.GetKind() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+			objects[key] = dirObject{object: obj, hash: hex.EncodeToString(sum[:])}
+		}
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest directory")
+	}
+	return objects, nil
+}
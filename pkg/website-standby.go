@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// standbyEntry is the desired state of a Website cached by a warm standby.
+type standbyEntry struct {
+	website *v1alpha1.Website
+	config  string
+	err     error
+}
+
+// observeStandby keeps the desired state of a warm standby in sync with a watch event,
+// without touching nginx or the Website status. It reports whether the controller is a standby.
+func (c *WebsiteController) observeStandby(ctx context.Context, eventType watch.EventType, website *v1alpha1.Website) bool {
+	c.standbyMu.Lock()
+	defer c.standbyMu.Unlock()
+
+	if c.standby == nil {
+		return false
+	}
+
+	key := websiteKey(website)
+	if eventType == watch.Deleted {
+		delete(c.standby, key)
+		c.releaseMiddleware(website)
+		c.releaseReusePort(website)
+		return true
+	}
+
+	// Resolve the dependencies and render the configuration ahead of the takeover
+	entry := &standbyEntry{website: website.DeepCopy()}
+	entry.err = c.syncMiddleware(ctx, website)
+	if entry.err == nil {
+		entry.config, entry.err = c.createNginxConfig(ctx, website)
+	}
+	c.standby[key] = entry
+	return true
+}
+
+// takeover ends the warm standby once the controller leads. Websites whose cached configuration
+// is already served are adopted without a write or reload; all others are queued to be reconciled.
+// Events are not handled until the takeover is complete.
+func (c *WebsiteController) takeover(ctx context.Context) {
+	c.standbyMu.Lock()
+	defer c.standbyMu.Unlock()
+
+	entries := make([]*standbyEntry, 0, len(c.standby))
+	for _, entry := range c.standby {
+		entries = append(entries, entry)
+	}
+	c.standby = nil
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].website.Spec.Priority > entries[j].website.Spec.Priority
+	})
+
+	adopted := 0
+	for _, entry := range entries {
+		if c.adopt(entry) {
+			adopted++
+			continue
+		}
+		c.queue.Add(watch.Event{Type: watch.Modified, Object: entry.website})
+	}
+	c.log.Info("took over from standby", "adopted", adopted, "queued", len(entries)-adopted)
+}
+
+// adopt takes over a Website whose cached configuration is already on disk, restoring the
+// in-memory state a reconcile would have built. It reports whether the Website was adopted.
+func (c *WebsiteController) adopt(entry *standbyEntry) bool {
+	if entry.err != nil {
+		return false
+	}
+	existing, err := ioutil.ReadFile(nginxConfigPath(entry.website))
+	if err != nil || string(existing) != entry.config {
+		return false
+	}
+
+	evicted, err := c.hostnames.claim(entry.website)
+	if err != nil {
+		return false
+	}
+	for _, loser := range evicted {
+		c.evictWebsite(context.Background(), loser, entry.website)
+	}
+	c.hostnames.settle(entry.website)
+	c.trackVaultWebsite(entry.website)
+	return c.syncOtelExporter(entry.website) == nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// StatusReport is the output of the status command.
+type StatusReport struct {
+	Websites []WebsiteStatusSummary `json:"websites"`
+}
+
+// WebsiteStatusSummary is the state of a single Website as the controller reports it.
+type WebsiteStatusSummary struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Hostname   string `json:"hostname"`
+	URL        string `json:"url,omitempty"`
+	Generation int64  `json:"generation"`
+
+	// Ready is the status of the Ready condition, "Unknown" if it is not reported, and Current
+	// whether it was reported for the Website's generation.
+	Ready   metav1.ConditionStatus `json:"ready"`
+	Current bool                   `json:"current"`
+	Reason  string                 `json:"reason,omitempty"`
+	Message string                 `json:"message,omitempty"`
+
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// statusCommand lists the Websites of a namespace, or all namespaces, with their readiness.
+func statusCommand(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	namespace := flags.String("namespace", "", "namespace of the Websites, all namespaces if empty")
+	output := flags.String("o", "", "output format of the report: json or yaml, a table if empty")
+	_, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCommandClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+	list := &v1alpha1.WebsiteList{}
+	err = c.List(context.Background(), list, client.InNamespace(*namespace))
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return websiteKey(&list.Items[i]) < websiteKey(&list.Items[j])
+	})
+
+	report := StatusReport{Websites: []WebsiteStatusSummary{}}
+	for _, website := range list.Items {
+		summary := WebsiteStatusSummary{
+			Namespace:  website.Namespace,
+			Name:       website.Name,
+			Hostname:   website.Spec.Hostname,
+			URL:        website.Status.URL,
+			Generation: website.Generation,
+			Ready:      metav1.ConditionUnknown,
+			Conditions: website.Status.Conditions,
+		}
+		if ready := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionReady); ready != nil {
+			summary.Ready = ready.Status
+			summary.Current = ready.ObservedGeneration == website.Generation
+			summary.Reason = ready.Reason
+			summary.Message = ready.Message
+		}
+		report.Websites = append(report.Websites, summary)
+	}
+
+	if *output != "" {
+		return cli.PrintReport(*output, report)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tHOSTNAME\tREADY\tCURRENT\tREASON")
+	for _, summary := range report.Websites {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%s\n", summary.Namespace, summary.Name, summary.Hostname, summary.Ready, summary.Current, summary.Reason)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// updateStatus applies mutate to the latest version of a Website's status and writes it back.
+// A Website that no longer exists is not an error.
+func (c *WebsiteController) updateStatus(ctx context.Context, key types.NamespacedName, mutate func(*v1alpha1.WebsiteStatus)) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// Get the latest version of the Website
+		website := &v1alpha1.Website{}
+		err := c.client.Get(ctx, key, website)
+		if err != nil {
+			return err
+		}
+
+		// Update the status
+		mutate(&website.Status)
+		website.Status.Remediations = c.conditionRemediations(website.Status.Conditions)
+		err = c.client.Status().Update(ctx, website)
+		if err == nil {
+			status, _ := json.Marshal(website.Status)
+			c.audit(auditStatusUpdate, website, "", status, nil)
+		}
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to update Website status")
+	}
+
+	return nil
+}
+
+// recordSync records whether a Website's configuration was written and nginx reloaded with it
+// in the ConfigWritten and ReloadSucceeded conditions and in an event. A failed write leaves the
+// reload as it was; a failed reload marks the Website not Ready. Failing to record the conditions is logged only, so it does not mask the outcome.
+func (c *WebsiteController) recordSync(ctx context.Context, website *v1alpha1.Website, writeErr, reloadErr error) {
+	written := metav1.Condition{
+		Type:               v1alpha1.ConditionConfigWritten,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Written",
+		Message:            "The configuration was written to " + nginxConfigPath(website),
+		ObservedGeneration: website.Generation,
+	}
+	reloaded := metav1.Condition{
+		Type:               v1alpha1.ConditionReloadSucceeded,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Reloaded",
+		Message:            "nginx was reloaded with the configuration",
+		ObservedGeneration: website.Generation,
+	}
+	switch {
+	case writeErr != nil:
+		written.Status = metav1.ConditionFalse
+		written.Reason = "WriteFailed"
+		written.Message = writeErr.Error()
+		c.event(website, corev1.EventTypeWarning, written.Reason, written.Message)
+	case reloadErr != nil:
+		reloaded.Status = metav1.ConditionFalse
+		reloaded.Reason = "ReloadFailed"
+		reloaded.Message = reloadErr.Error()
+		c.event(website, corev1.EventTypeWarning, reloaded.Reason, reloaded.Message)
+	default:
+		c.event(website, corev1.EventTypeNormal, "ConfigWritten", written.Message+" and nginx was reloaded")
+	}
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		status.ObservedGeneration = website.Generation
+		meta.SetStatusCondition(&status.Conditions, written)
+		if writeErr == nil {
+			meta.SetStatusCondition(&status.Conditions, reloaded)
+		}
+		if writeErr == nil && reloadErr != nil {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionReady,
+				Status:             metav1.ConditionFalse,
+				Reason:             reloaded.Reason,
+				Message:            reloaded.Message,
+				ObservedGeneration: website.Generation,
+			})
+		}
+		// Lift a rollback once the latest configuration is served
+		if writeErr == nil && reloadErr == nil && meta.FindStatusCondition(status.Conditions, v1alpha1.ConditionRolledBack) != nil {
+			meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type:               v1alpha1.ConditionRolledBack,
+				Status:             metav1.ConditionFalse,
+				Reason:             "Applied",
+				Message:            "nginx serves the latest configuration",
+				ObservedGeneration: website.Generation,
+			})
+		}
+	})
+	if err != nil {
+		c.log.Error(err, "failed to record configuration sync", "namespace", website.Namespace, "name", website.Name)
+	}
+}
+
+// markSecretMaterialUnavailable marks a Website not Ready because its TLS or basic auth
+// material cannot be read, e.g. a missing Secret or Vault path, and returns the cause.
+func (c *WebsiteController) markSecretMaterialUnavailable(ctx context.Context, website *v1alpha1.Website, cause error) error {
+	cause = errors.Wrap(cause, "failed to write secret material")
+	c.event(website, corev1.EventTypeWarning, "SecretMaterialUnavailable", cause.Error())
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "SecretMaterialUnavailable",
+			Message:            cause.Error(),
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		c.log.Error(err, "failed to record unavailable secret material", "namespace", website.Namespace, "name", website.Name)
+	}
+	return cause
+}
+
+// websiteURL returns the URL a Website is served under.
+func websiteURL(website *v1alpha1.Website) string {
+	scheme := "http"
+	if hasTLSMaterial(website) {
+		scheme = "https"
+	}
+	path := website.Spec.PathPrefix
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + website.Spec.Hostname + path
+}
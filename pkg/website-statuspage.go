@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// statusPageDir holds the generated status pages within the state directory, one directory
+	// per namespace.
+	statusPageDir = "status"
+
+	// statusPageInterval is how often the status pages are regenerated.
+	statusPageInterval = 30 * time.Second
+
+	// statusPageConfigPrefix prefixes the server block files of the status pages.
+	statusPageConfigPrefix = "00-website-controller-status-"
+)
+
+// statusPageTemplate renders the status page of a namespace. It refreshes itself in the browser.
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="30">
+<title>Websites in {{.Namespace}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.4em 1em; text-align: left; }
+.ready { color: #080; } .unready { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Websites in {{.Namespace}}</h1>
+<table>
+<tr><th>Name</th><th>URL</th><th>Health</th><th>Certificate expires</th></tr>
+{{range .Websites}}<tr>
+<td>{{.Name}}</td>
+<td><a href="{{.URL}}">{{.URL}}</a></td>
+<td class="{{if .Ready}}ready{{else}}unready{{end}}">{{.Health}}</td>
+<td>{{.CertificateExpiry}}</td>
+</tr>
+{{end}}</table>
+<p>Updated {{.Updated}}</p>
+</body>
+</html>
+`))
+
+// statusPage is the data of a namespace's status page.
+type statusPage struct {
+	Namespace string
+	Websites  []statusPageEntry
+	Updated   string
+}
+
+// statusPageEntry is a Website on a status page.
+type statusPageEntry struct {
+	Name              string
+	URL               string
+	Ready             bool
+	Health            string
+	CertificateExpiry string
+}
+
+// runStatusPages regenerates the status page of every namespace with Websites until the
+// context is cancelled.
+func (c *WebsiteController) runStatusPages(ctx context.Context) {
+	ticker := time.NewTicker(statusPageInterval)
+	defer ticker.Stop()
+
+	for {
+		err := c.syncStatusPages(ctx)
+		if err != nil {
+			c.log.Error(err, "failed to generate status pages")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncStatusPages writes the status pages and their server blocks, removes the ones of
+// namespaces without Websites, and reloads nginx if the set of server blocks changed.
+func (c *WebsiteController) syncStatusPages(ctx context.Context) error {
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+
+	// Group the Websites by namespace
+	pages := map[string]*statusPage{}
+	updated := time.Now().UTC().Format(time.RFC1123)
+	for i := range list.Items {
+		website := &list.Items[i]
+		if !hasClass(website, c.class) {
+			continue
+		}
+		page, ok := pages[website.Namespace]
+		if !ok {
+			page = &statusPage{Namespace: website.Namespace, Updated: updated}
+			pages[website.Namespace] = page
+		}
+		page.Websites = append(page.Websites, c.statusPageEntry(website))
+	}
+
+	changed := false
+	for namespace, page := range pages {
+		sort.Slice(page.Websites, func(i, j int) bool { return page.Websites[i].Name < page.Websites[j].Name })
+
+		// Write the page
+		var html bytes.Buffer
+		err := statusPageTemplate.Execute(&html, page)
+		if err != nil {
+			return errors.Wrap(err, "failed to render status page")
+		}
+		dir := filepath.Join(c.stateDir, statusPageDir, namespace)
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return errors.Wrap(err, "failed to create status page directory")
+		}
+		err = writeFileAtomic(filepath.Join(dir, "index.html"), html.Bytes(), 0644)
+		if err != nil {
+			return errors.Wrap(err, "failed to write status page")
+		}
+
+		// Write its server block
+		config := fmt.Sprintf(`
+server {
+	listen 80;
+	server_name %s;
+	root %s;
+	index index.html;
+	add_header Cache-Control "no-store" always;
+}
+`, c.statusPageHostname(namespace), dir)
+		configPath := filepath.Join("/etc/nginx/conf.d", statusPageConfigPrefix+namespace+".conf")
+		existing, err := ioutil.ReadFile(configPath)
+		if err == nil && string(existing) == config {
+			continue
+		}
+		err = writeFileAtomic(configPath, []byte(config), 0644)
+		c.audit(auditConfigWrite, nil, configPath, []byte(config), err)
+		if err != nil {
+			return errors.Wrap(err, "failed to write status page configuration")
+		}
+		changed = true
+	}
+
+	// Remove the pages of namespaces without Websites
+	configs, err := filepath.Glob(filepath.Join("/etc/nginx/conf.d", statusPageConfigPrefix+"*.conf"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list status page configurations")
+	}
+	for _, configPath := range configs {
+		namespace := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(configPath), statusPageConfigPrefix), ".conf")
+		if _, ok := pages[namespace]; ok {
+			continue
+		}
+		err = os.Remove(configPath)
+		c.audit(auditConfigDelete, nil, configPath, nil, err)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete status page configuration")
+		}
+		os.RemoveAll(filepath.Join(c.stateDir, statusPageDir, namespace))
+		changed = true
+	}
+
+	if changed {
+		return c.reloadNginx(nil)
+	}
+	return nil
+}
+
+// statusPageEntry summarizes a Website for its namespace's status page.
+func (c *WebsiteController) statusPageEntry(website *v1alpha1.Website) statusPageEntry {
+	entry := statusPageEntry{
+		Name:              website.Name,
+		URL:               "http://" + website.Spec.Hostname,
+		Health:            "Unknown",
+		CertificateExpiry: "-",
+	}
+
+	ready := meta.FindStatusCondition(website.Status.Conditions, v1alpha1.ConditionReady)
+	if ready != nil {
+		entry.Ready = ready.Status == "True"
+		entry.Health = ready.Reason
+	}
+
+	if hasTLSMaterial(website) {
+		entry.URL = "https://" + website.Spec.Hostname
+		expiry, err := certificateExpiry(c.secretFile(website, "tls.crt"))
+		if err == nil {
+			entry.CertificateExpiry = expiry.UTC().Format("2006-01-02")
+		}
+	}
+
+	return entry
+}
+
+// statusPageHostname is the hostname the status page of a namespace is served under.
+func (c *WebsiteController) statusPageHostname(namespace string) string {
+	return "status-" + namespace + "." + c.statusPageDomain
+}
+
+// certificateExpiry returns when the leaf certificate of a PEM file expires.
+func certificateExpiry(path string) (time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, errors.New("certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/controller/util"
+)
+
+const (
+	// streamConfigDir holds the server blocks of the StreamRoutes. nginx.conf must include
+	// it in its stream context: stream { include /etc/nginx/website-controller/stream/*.conf; }
+	streamConfigDir = "/etc/nginx/website-controller/stream"
+
+	// streamRefreshInterval is how often the StreamRoutes are re-synced to pick up rotated
+	// certificates.
+	streamRefreshInterval = time.Minute
+)
+
+// watchStreamRoutes watches for StreamRoute objects and syncs all of them on every change,
+// as the routes compete for ports.
+func (c *WebsiteController) watchStreamRoutes(ctx context.Context) error {
+	w := util.NewWatch(ctx, &v1alpha1.StreamRoute{})
+
+	err := w.Watch(func(event watch.Event) error {
+		if _, ok := event.Object.(*v1alpha1.StreamRoute); !ok {
+			return errors.Errorf("object is not a StreamRoute: %T", event.Object)
+		}
+		err := c.syncStreamRoutes(ctx)
+		if err != nil {
+			c.log.Error(err, "failed to sync StreamRoutes")
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to watch for StreamRoute objects")
+	}
+
+	return nil
+}
+
+// refreshStreamRoutes re-syncs the StreamRoutes every interval until the context is cancelled.
+func (c *WebsiteController) refreshStreamRoutes(ctx context.Context) {
+	ticker := time.NewTicker(streamRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := c.syncStreamRoutes(ctx)
+		if err != nil {
+			c.log.Error(err, "failed to sync StreamRoutes")
+		}
+	}
+}
+
+// syncStreamRoutes renders all StreamRoutes into the stream configuration directory, removes
+// the configuration of deleted routes and reloads nginx once if anything changed. The outcome
+// of every route is recorded in its Ready condition.
+func (c *WebsiteController) syncStreamRoutes(ctx context.Context) error {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+
+	list := &v1alpha1.StreamRouteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list StreamRoutes")
+	}
+	routes := make([]*v1alpha1.StreamRoute, 0, len(list.Items))
+	for i := range list.Items {
+		routes = append(routes, &list.Items[i])
+	}
+
+	// The oldest route claiming a port is served
+	sort.Slice(routes, func(i, j int) bool {
+		if !routes[i].CreationTimestamp.Equal(&routes[j].CreationTimestamp) {
+			return routes[i].CreationTimestamp.Before(&routes[j].CreationTimestamp)
+		}
+		return streamRouteKey(routes[i]) < streamRouteKey(routes[j])
+	})
+
+	err = os.MkdirAll(streamConfigDir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create stream configuration directory")
+	}
+	changed := false
+	claimed, err := c.reservedStreamPorts(ctx)
+	if err != nil {
+		return err
+	}
+	configs := map[string]bool{}
+	for _, route := range routes {
+		condition := metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionTrue,
+			Reason:             "Served",
+			Message:            fmt.Sprintf("Port %d is proxied to %s", route.Spec.Port, route.Spec.Upstream),
+			ObservedGeneration: route.Generation,
+		}
+
+		// Render the route unless a Website, the controller or an older route claims its port
+		listen := fmt.Sprintf("%d/%s", route.Spec.Port, streamProtocol(route))
+		denials, err := c.streamRouteDenials(ctx, route)
+		if err != nil {
+			return err
+		}
+		config, err := c.renderStreamRoute(ctx, route)
+		switch {
+		case claimed[listen] != "":
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "PortConflict"
+			condition.Message = fmt.Sprintf("Port %s is claimed by %s", listen, claimed[listen])
+		case len(denials) > 0:
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "UpstreamDenied"
+			condition.Message = "The StreamRoute's upstream is denied: " + strings.Join(denials, "; ")
+		case err != nil:
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "InvalidConfiguration"
+			condition.Message = err.Error()
+		default:
+			claimed[listen] = "StreamRoute " + streamRouteKey(route)
+			path := streamConfigPath(route)
+			configs[path] = true
+			existing, readErr := ioutil.ReadFile(path)
+			if readErr != nil || !bytes.Equal(existing, config) {
+				err = writeFileAtomic(path, config, 0644)
+				c.audit(auditConfigWrite, nil, path, config, err)
+				if err != nil {
+					return errors.Wrap(err, "failed to write stream configuration")
+				}
+				changed = true
+			}
+		}
+
+		err = c.setStreamRouteCondition(ctx, route, condition)
+		if err != nil {
+			c.log.Error(err, "failed to update StreamRoute status", "namespace", route.Namespace, "name", route.Name)
+		}
+	}
+
+	// Remove the configuration of deleted and conflicting routes
+	paths, err := filepath.Glob(filepath.Join(streamConfigDir, "*.conf"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list stream configurations")
+	}
+	for _, path := range paths {
+		if configs[path] {
+			continue
+		}
+		err = os.Remove(path)
+		c.audit(auditConfigDelete, nil, path, nil, err)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete stream configuration")
+		}
+		os.RemoveAll(c.streamSecretDir(strings.TrimSuffix(filepath.Base(path), ".conf")))
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return c.reloadNginx(nil)
+}
+
+// reservedStreamPorts returns the TCP ports StreamRoutes may not claim, keyed like the ports of
+// the routes, with what binds them: the listeners of the Websites of the controller's class,
+// which share the nginx, and the servers of the controller itself.
+func (c *WebsiteController) reservedStreamPorts(ctx context.Context) (map[string]string, error) {
+	reserved := map[string]string{}
+	websites := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, websites)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list Websites")
+	}
+	sort.Slice(websites.Items, func(i, j int) bool {
+		return objectKey(&websites.Items[i]) < objectKey(&websites.Items[j])
+	})
+	for i := range websites.Items {
+		website := &websites.Items[i]
+		if !hasClass(website, c.class) {
+			continue
+		}
+		for _, listener := range website.Spec.Listeners {
+			listen := fmt.Sprintf("%d/%s", listener.Port, v1alpha1.StreamProtocolTCP)
+			if reserved[listen] == "" {
+				reserved[listen] = "Website " + objectKey(website)
+			}
+		}
+	}
+
+	servers := []struct{ name, address string }{
+		{"metrics", c.metricsAddress},
+		{"health", c.healthAddress},
+		{"webhook", c.webhookAddress},
+		{"API", c.apiAddress},
+		{"chaos API", c.chaosAddress},
+	}
+	for _, server := range servers {
+		_, port, err := net.SplitHostPort(server.address)
+		if err != nil || port == "" {
+			continue
+		}
+		reserved[port+"/"+v1alpha1.StreamProtocolTCP] = fmt.Sprintf("the controller's %s server", server.name)
+	}
+	return reserved, nil
+}
+
+// renderStreamRoute renders the server block of a StreamRoute, writing the certificate of a
+// terminating route to the secrets directory.
+func (c *WebsiteController) renderStreamRoute(ctx context.Context, route *v1alpha1.StreamRoute) ([]byte, error) {
+	spec := route.Spec
+	protocol := streamProtocol(route)
+	if protocol == v1alpha1.StreamProtocolTCP && (spec.Port == 80 || spec.Port == 443) {
+		return nil, errors.Errorf("TCP port %d is reserved for Websites", spec.Port)
+	}
+
+	// Validate the upstream, as it is rendered into the configuration verbatim
+	host, port, err := net.SplitHostPort(spec.Upstream)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid upstream %q", spec.Upstream)
+	}
+	if host == "" || strings.ContainsAny(host, " \t;{}'\"$") {
+		return nil, errors.Errorf("invalid upstream %q: missing or invalid host", spec.Upstream)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return nil, errors.Errorf("invalid upstream %q: invalid port", spec.Upstream)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# StreamRoute %s\nserver {\n", streamRouteKey(route))
+	mode := v1alpha1.StreamTLSPassthrough
+	if spec.TLS != nil && spec.TLS.Mode != "" {
+		mode = spec.TLS.Mode
+	}
+	switch {
+	case spec.TLS == nil || mode == v1alpha1.StreamTLSPassthrough:
+		if protocol == v1alpha1.StreamProtocolUDP {
+			fmt.Fprintf(&b, "\tlisten %d udp;\n", spec.Port)
+		} else {
+			fmt.Fprintf(&b, "\tlisten %d;\n", spec.Port)
+		}
+	case protocol == v1alpha1.StreamProtocolUDP:
+		return nil, errors.New("TLS cannot be terminated for UDP routes")
+	case mode == v1alpha1.StreamTLSTerminate:
+		if spec.TLS.SecretRef == nil {
+			return nil, errors.New("TLS mode Terminate requires a secretRef")
+		}
+		cert, key, err := c.writeStreamCertificate(ctx, route)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "\tlisten %d ssl;\n", spec.Port)
+		fmt.Fprintf(&b, "\tssl_certificate %s;\n", cert)
+		fmt.Fprintf(&b, "\tssl_certificate_key %s;\n", key)
+	default:
+		return nil, errors.Errorf("unknown TLS mode %q", mode)
+	}
+	fmt.Fprintf(&b, "\tproxy_pass %s;\n}\n", spec.Upstream)
+	return []byte(b.String()), nil
+}
+
+// writeStreamCertificate writes the certificate and key of a terminating StreamRoute to the
+// secrets directory and returns their paths.
+func (c *WebsiteController) writeStreamCertificate(ctx context.Context, route *v1alpha1.StreamRoute) (string, string, error) {
+	err := requireTmpfs(c.secretsDir)
+	if err != nil {
+		return "", "", err
+	}
+	cert, key, err := c.readTLSSecret(ctx, route.Namespace, route.Spec.TLS.SecretRef.Name)
+	if err != nil {
+		return "", "", err
+	}
+
+	dir := c.streamSecretDir(streamRouteFile(route))
+	err = c.makeSecretDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	files := map[string][]byte{"tls.crt": cert, "tls.key": key}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if secretFileCurrent(path, content) {
+			continue
+		}
+		err = writeFileAtomic(path, content, secretFileMode)
+		c.audit(auditConfigWrite, nil, path, content, err)
+		if err != nil {
+			return "", "", errors.Wrap(err, "failed to write secret material")
+		}
+	}
+	return filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), nil
+}
+
+// setStreamRouteCondition records the Ready condition of a StreamRoute if it changed.
+func (c *WebsiteController) setStreamRouteCondition(ctx context.Context, route *v1alpha1.StreamRoute, condition metav1.Condition) error {
+	existing := meta.FindStatusCondition(route.Status.Conditions, condition.Type)
+	if existing != nil && existing.Status == condition.Status && existing.Reason == condition.Reason &&
+		existing.Message == condition.Message && existing.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &v1alpha1.StreamRoute{}
+		err := c.client.Get(ctx, types.NamespacedName{Namespace: route.Namespace, Name: route.Name}, latest)
+		if err != nil {
+			return err
+		}
+		meta.SetStatusCondition(&latest.Status.Conditions, condition)
+		return c.client.Status().Update(ctx, latest)
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// streamProtocol returns the protocol of a StreamRoute, defaulting to TCP.
+func streamProtocol(route *v1alpha1.StreamRoute) string {
+	if route.Spec.Protocol == "" {
+		return v1alpha1.StreamProtocolTCP
+	}
+	return route.Spec.Protocol
+}
+
+// streamRouteKey returns the namespace/name key of a StreamRoute.
+func streamRouteKey(route *v1alpha1.StreamRoute) string {
+	return route.Namespace + "/" + route.Name
+}
+
+// streamRouteFile is the base name of the files of a StreamRoute.
+func streamRouteFile(route *v1alpha1.StreamRoute) string {
+	return route.Namespace + "_" + route.Name
+}
+
+// streamConfigPath is the path of the server block of a StreamRoute.
+func streamConfigPath(route *v1alpha1.StreamRoute) string {
+	return filepath.Join(streamConfigDir, streamRouteFile(route)+".conf")
+}
+
+// streamSecretDir is the directory holding the secret material of a StreamRoute.
+func (c *WebsiteController) streamSecretDir(file string) string {
+	return filepath.Join(c.secretsDir, "stream_"+file)
+}
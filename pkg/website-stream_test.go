@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+func TestReservedStreamPorts(t *testing.T) {
+	listening := func(name, class string, ports ...int32) *v1alpha1.Website {
+		website := &v1alpha1.Website{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: name},
+			Spec:       v1alpha1.WebsiteSpec{ClassName: class},
+		}
+		for _, port := range ports {
+			website.Spec.Listeners = append(website.Spec.Listeners, v1alpha1.Listener{Port: port})
+		}
+		return website
+	}
+	c := newTestController(t,
+		listening("shop", "", 8080, 8443),
+		listening("blog", "", 8443),
+		listening("other", "internal", 9000),
+	)
+	c.metricsAddress = ":9090"
+	c.healthAddress = "127.0.0.1:9091"
+	c.webhookAddress = ""
+
+	reserved, err := c.reservedStreamPorts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"8080/TCP": "Website team/shop",
+		"8443/TCP": "Website team/blog",
+		"9090/TCP": "the controller's metrics server",
+		"9091/TCP": "the controller's health server",
+	}
+	if !reflect.DeepEqual(reserved, want) {
+		t.Errorf("got %v, want %v", reserved, want)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// subpathDir holds a directory per shared hostname with a location file per Website.
+// It is outside conf.d, so the files are only included by their hostname's server block.
+const subpathDir = "/etc/nginx/website-controller/paths"
+
+// subpathConfigPath returns the path of the location file of a Website sharing a hostname.
+func subpathConfigPath(website *v1alpha1.Website) string {
+	return filepath.Join(subpathDir, website.Spec.Hostname, fmt.Sprintf("%s_%s.conf", website.Namespace, website.Name))
+}
+
+// sharedServerPath returns the path of the server block of a shared hostname.
+func sharedServerPath(hostname string) string {
+	return filepath.Join("/etc/nginx/conf.d", fmt.Sprintf("00-website-controller-host-%s.conf", hostname))
+}
+
+// subpathLocation wraps the directives of a Website sharing a hostname into a location of its
+// path prefix. Directives that are only valid in the server or http context are rejected.
+func subpathLocation(website *v1alpha1.Website, http, listen, directives, proxyPass string) (string, error) {
+	switch {
+	case website.Spec.TLS != nil:
+		return "", errors.New("tls cannot be configured for a path prefix")
+	case listen != "":
+		return "", errors.New("reuseport cannot be configured for a path prefix")
+	case len(website.Spec.Listeners) > 0:
+		return "", errors.New("listeners cannot be configured for a path prefix")
+	case len(website.Spec.Routes) > 0:
+		return "", errors.New("routes cannot be configured for a path prefix")
+	case http != "":
+		return "", errors.New("tracing, load balancing, host aliases, rate limits, real IP, protection, transforms, content types, asset caching, body filters and redirect maps cannot be configured for a path prefix")
+	case website.Spec.HealthEndpoint != nil && !strings.HasPrefix(website.Spec.HealthEndpoint.Path, website.Spec.PathPrefix):
+		return "", errors.Errorf("health endpoint path must be below the path prefix %s", website.Spec.PathPrefix)
+	}
+
+	// Indent the server-level directives into the location
+	var b strings.Builder
+	fmt.Fprintf(&b, "location ^~ %s {\n", website.Spec.PathPrefix)
+	for _, line := range strings.SplitAfter(directives, "\n") {
+		if line != "" {
+			b.WriteString("\t" + line)
+		}
+	}
+	fmt.Fprintf(&b, "\t%s;\n}\n", upstreamPass(website, proxyPass))
+	return b.String(), nil
+}
+
+// placeConfig removes the configuration a Website left in the other layout or under a previous
+// hostname, and prepares the server block of its shared hostname.
+func (c *WebsiteController) placeConfig(website *v1alpha1.Website) error {
+	current := nginxConfigPath(website)
+
+	// Remove the configuration of a previous layout or hostname
+	stale, err := filepath.Glob(filepath.Join(subpathDir, "*", fmt.Sprintf("%s_%s.conf", website.Namespace, website.Name)))
+	if err != nil {
+		return errors.Wrap(err, "failed to list location files")
+	}
+	stale = append(stale, filepath.Join("/etc/nginx/conf.d", fmt.Sprintf("%s.conf", website.Name)))
+	for _, path := range stale {
+		if path == current {
+			continue
+		}
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		c.audit(auditConfigDelete, website, path, nil, err)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete stale Nginx configuration")
+		}
+		if filepath.Dir(path) != "/etc/nginx/conf.d" {
+			err = c.removeSharedServer(filepath.Base(filepath.Dir(path)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Include the location file in the server block of the shared hostname
+	if website.Spec.PathPrefix == "" {
+		return nil
+	}
+	hostname := website.Spec.Hostname
+	err = os.MkdirAll(filepath.Join(subpathDir, hostname), 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create location directory")
+	}
+	config := fmt.Sprintf(`
+server {
+	listen 80;
+	server_name %s;
+	include %s/*.conf;
+}
+`, hostname, filepath.Join(subpathDir, hostname))
+	path := sharedServerPath(hostname)
+	existing, err := ioutil.ReadFile(path)
+	if err == nil && string(existing) == config {
+		return nil
+	}
+	err = writeFileAtomic(path, []byte(config), 0644)
+	c.audit(auditConfigWrite, website, path, []byte(config), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write shared server block")
+	}
+	return nil
+}
+
+// removeSharedServer removes the server block of a shared hostname once no Website serves
+// a path of it anymore.
+func (c *WebsiteController) removeSharedServer(hostname string) error {
+	dir := filepath.Join(subpathDir, hostname)
+	remaining, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+	if err != nil {
+		return errors.Wrap(err, "failed to list location files")
+	}
+	if len(remaining) > 0 {
+		return nil
+	}
+
+	path := sharedServerPath(hostname)
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	c.audit(auditConfigDelete, nil, path, nil, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete shared server block")
+	}
+	os.Remove(dir)
+	return nil
+}
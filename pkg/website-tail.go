@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// tailConfigPath is the managed include that logs every request for the tail endpoint.
+	tailConfigPath = "/etc/nginx/conf.d/00-website-controller-tail.conf"
+
+	// tailLogPath is the log the tail endpoint streams from.
+	tailLogPath = "/var/log/nginx/website-tail.log"
+
+	// tailPollInterval is how often the tail endpoint checks the log for new requests.
+	tailPollInterval = 500 * time.Millisecond
+)
+
+// tailConfig logs every request as JSON. Like the usage log, it adds to nginx's own access log.
+const tailConfig = `log_format website_tail escape=json '{"time":"$time_iso8601","host":"$host","serverName":"$server_name",'
+	'"remoteAddr":"$remote_addr","method":"$request_method","uri":"$uri","request":"$request_uri",'
+	'"status":$status,"bytesSent":$bytes_sent,"requestTime":$request_time,"userAgent":"$http_user_agent"}';
+access_log ` + tailLogPath + ` website_tail;
+`
+
+// TailRecord is a request in the access log of a Website.
+type TailRecord struct {
+	Time        string  `json:"time"`
+	Host        string  `json:"host"`
+	ServerName  string  `json:"serverName"`
+	RemoteAddr  string  `json:"remoteAddr"`
+	Method      string  `json:"method"`
+	URI         string  `json:"uri"`
+	Request     string  `json:"request"`
+	Status      int     `json:"status"`
+	BytesSent   int64   `json:"bytesSent"`
+	RequestTime float64 `json:"requestTime"`
+	UserAgent   string  `json:"userAgent"`
+}
+
+// enableTailLog writes the managed include logging requests for the tail endpoint.
+func (c *WebsiteController) enableTailLog() error {
+	existing, err := os.ReadFile(tailConfigPath)
+	if err == nil && string(existing) == tailConfig {
+		return nil
+	}
+	err = writeFileAtomic(tailConfigPath, []byte(tailConfig), 0644)
+	c.audit(auditConfigWrite, nil, tailConfigPath, []byte(tailConfig), err)
+	if err != nil {
+		return errors.Wrap(err, "failed to write tail log configuration")
+	}
+	return c.reloadNginx(nil)
+}
+
+// serveTail streams the requests of a Website as JSON lines until the client disconnects.
+// The "status" query parameter keeps requests of a status class ("5xx") or code ("404"), the
+// "path" parameter keeps requests below a path prefix.
+func (c *WebsiteController) serveTail(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Get the hostname and path prefix the Website is served under
+	website := &v1alpha1.Website{}
+	err := c.client.Get(r.Context(), types.NamespacedName{Namespace: namespace, Name: name}, website)
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to get Website").Error(), http.StatusNotFound)
+		return
+	}
+	website, err = applyOverlay(website, c.environment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		path = "/"
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	tail := &logTail{path: tailLogPath}
+	tail.read(func(string) {})
+	encoder := json.NewEncoder(w)
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := tail.read(func(line string) {
+			record := TailRecord{}
+			if json.Unmarshal([]byte(line), &record) != nil {
+				return
+			}
+			if record.ServerName != website.Spec.Hostname || !strings.HasPrefix(record.URI, website.Spec.PathPrefix) {
+				return
+			}
+			if !strings.HasPrefix(record.URI, path) || !matchStatus(record.Status, status) {
+				return
+			}
+			encoder.Encode(record)
+		})
+		if err != nil {
+			c.log.Error(err, "failed to read tail log")
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// matchStatus reports whether a status code matches a status class such as "5xx" or a
+// status code. An empty filter matches every status.
+func matchStatus(status int, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	code := strconv.Itoa(status)
+	if len(filter) == 3 && strings.HasSuffix(strings.ToLower(filter), "xx") {
+		return code[:1] == filter[:1]
+	}
+	return code == filter
+}
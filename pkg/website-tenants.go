@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// tenantSweepInterval is how often the disk usage of the namespaces is measured.
+	tenantSweepInterval = time.Minute
+
+	// tenantLogDir holds the error logs of a namespace's Websites, within its tenant directory.
+	tenantLogDir = "logs"
+)
+
+// tenantTempDirs are the directories nginx buffers request and response bodies of a
+// namespace's Websites in, within its tenant directory.
+var tenantTempDirs = []string{"client_body", "proxy"}
+
+// tenantDir is the directory holding the on-disk artifacts of a namespace's Websites.
+func (c *WebsiteController) tenantDir(namespace string) string {
+	return filepath.Join(c.tenantRoot, namespace)
+}
+
+// prepareTenantDir creates the tenant directory of a Website's namespace. nginx workers write
+// into it, so it must be on a volume they can write to.
+func (c *WebsiteController) prepareTenantDir(website *v1alpha1.Website) error {
+	if c.tenantRoot == "" {
+		return nil
+	}
+	dir := c.tenantDir(website.Namespace)
+	for _, sub := range append([]string{tenantLogDir}, tenantTempDirs...) {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0770)
+		if err != nil {
+			return errors.Wrap(err, "failed to create tenant directory")
+		}
+	}
+	return nil
+}
+
+// tenantDirectives confines the temporary files and error log of a Website to the tenant
+// directory of its namespace, so one namespace's Websites cannot fill or read the buffers of
+// another's.
+func (c *WebsiteController) tenantDirectives(website *v1alpha1.Website) string {
+	if c.tenantRoot == "" {
+		return ""
+	}
+	dir := c.tenantDir(website.Namespace)
+	var b strings.Builder
+	fmt.Fprintf(&b, "\tclient_body_temp_path %s;\n", filepath.Join(dir, "client_body"))
+	fmt.Fprintf(&b, "\tproxy_temp_path %s;\n", filepath.Join(dir, "proxy"))
+	fmt.Fprintf(&b, "\terror_log %s warn;\n", filepath.Join(dir, tenantLogDir, website.Name+".error.log"))
+	return b.String()
+}
+
+// runTenantSweep enforces the disk quota of the namespaces and removes the tenant directories
+// of deleted namespaces every interval, until the context is cancelled.
+func (c *WebsiteController) runTenantSweep(ctx context.Context) {
+	ticker := time.NewTicker(tenantSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := c.sweepTenants(ctx)
+		if err != nil {
+			c.log.Error(err, "failed to sweep tenant directories")
+		}
+	}
+}
+
+// sweepTenants measures the disk usage of every tenant directory. A namespace over its quota
+// has its error logs truncated, as they are the files nginx does not clean up itself. The
+// directories of namespaces without Websites that no longer exist are removed.
+func (c *WebsiteController) sweepTenants(ctx context.Context) error {
+	list := &v1alpha1.WebsiteList{}
+	err := c.client.List(ctx, list)
+	if err != nil {
+		return errors.Wrap(err, "failed to list Websites")
+	}
+	served := map[string]bool{}
+	for i := range list.Items {
+		served[list.Items[i].Namespace] = true
+	}
+
+	entries, err := ioutil.ReadDir(c.tenantRoot)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to list tenant directories")
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		namespace := entry.Name()
+		dir := c.tenantDir(namespace)
+
+		// Remove the artifacts of a deleted namespace
+		if !served[namespace] {
+			err = c.client.Get(ctx, types.NamespacedName{Name: namespace}, &corev1.Namespace{})
+			if apierrors.IsNotFound(err) {
+				err = os.RemoveAll(dir)
+				if err != nil {
+					return errors.Wrap(err, "failed to remove tenant directory")
+				}
+				tenantDiskUsage.DeleteLabelValues(namespace)
+				c.log.Info("removed tenant directory of deleted namespace", "namespace", namespace)
+				continue
+			}
+		}
+
+		// Enforce the quota
+		usage := directorySize(dir)
+		if c.tenantDiskQuota > 0 && usage > c.tenantDiskQuota {
+			c.log.Info("tenant directory over quota, truncating error logs", "namespace", namespace, "usage", usage, "quota", c.tenantDiskQuota)
+			logs, _ := filepath.Glob(filepath.Join(dir, tenantLogDir, "*.log"))
+			for _, path := range logs {
+				os.Truncate(path, 0)
+			}
+			usage = directorySize(dir)
+		}
+		tenantDiskUsage.WithLabelValues(namespace).Set(float64(usage))
+	}
+	return nil
+}
+
+// directorySize returns the size of the regular files below a directory, in bytes.
+func directorySize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
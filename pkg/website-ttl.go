@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// websiteExpiry returns when a Website expires for its TTL, if it has one.
+func websiteExpiry(website *v1alpha1.Website) (time.Time, bool) {
+	if website.Spec.TTL == nil || website.CreationTimestamp.IsZero() {
+		return time.Time{}, false
+	}
+	return website.CreationTimestamp.Add(website.Spec.TTL.Duration), true
+}
+
+// checkExpiry deletes a Website whose TTL expired, which removes its configuration through the
+// finalizer like any deletion. A Website that has yet to expire has its expiry recorded in
+// status.expiresAt and is queued again when it expires. It reports whether the Website expired.
+func (c *WebsiteController) checkExpiry(ctx context.Context, eventType watch.EventType, website *v1alpha1.Website) (bool, error) {
+	key := websiteKey(website)
+	if eventType == watch.Deleted || website.DeletionTimestamp != nil {
+		c.stopExpiryTimer(key)
+		return false, nil
+	}
+	expiresAt, ok := websiteExpiry(website)
+	if !ok {
+		c.stopExpiryTimer(key)
+		if website.Status.ExpiresAt == nil {
+			return false, nil
+		}
+		// The TTL was removed
+		return false, c.recordExpiry(ctx, website, nil)
+	}
+
+	// Delete the expired Website, unless it was replaced by one of the same name
+	if !time.Now().Before(expiresAt) {
+		c.stopExpiryTimer(key)
+		uid := website.UID
+		err := c.client.Delete(ctx, website, client.Preconditions{UID: &uid})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return false, errors.Wrap(err, "failed to delete expired Website")
+		}
+		c.log.Info("deleted expired Website", "namespace", website.Namespace, "name", website.Name, "ttl", website.Spec.TTL.Duration)
+		c.event(website, corev1.EventTypeNormal, "Expired", "The Website was deleted as its TTL of "+website.Spec.TTL.Duration.String()+" expired")
+		return true, nil
+	}
+
+	// Queue the Website again when it expires
+	c.expiryMu.Lock()
+	if timer, ok := c.expiryTimers[key]; ok {
+		timer.Stop()
+	}
+	c.expiryTimers[key] = c.requeueAfter(website, time.Until(expiresAt))
+	c.expiryMu.Unlock()
+
+	if website.Status.ExpiresAt != nil && website.Status.ExpiresAt.Time.Equal(expiresAt) {
+		return false, nil
+	}
+	expiry := metav1.NewTime(expiresAt)
+	return false, c.recordExpiry(ctx, website, &expiry)
+}
+
+// recordExpiry records when a Website expires in its status.
+func (c *WebsiteController) recordExpiry(ctx context.Context, website *v1alpha1.Website, expiresAt *metav1.Time) error {
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		status.ExpiresAt = expiresAt
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to record expiry")
+	}
+	return nil
+}
+
+// stopExpiryTimer stops queueing a Website again when it expires.
+func (c *WebsiteController) stopExpiryTimer(key string) {
+	c.expiryMu.Lock()
+	defer c.expiryMu.Unlock()
+
+	if timer, ok := c.expiryTimers[key]; ok {
+		timer.Stop()
+		delete(c.expiryTimers, key)
+	}
+}
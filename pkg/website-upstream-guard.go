@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// allowedUpstreamCIDRsAnnotation on a Namespace lists comma-separated CIDRs its Websites may
+	// proxy to although they are denied, e.g. the address range of a team's internal services.
+	allowedUpstreamCIDRsAnnotation = "website-operator.io/allowed-upstream-cidrs"
+
+	// upstreamResolveTimeout bounds resolving the hostname of an upstream.
+	upstreamResolveTimeout = 2 * time.Second
+)
+
+// defaultDeniedUpstreamCIDRs are the private, loopback, link-local and shared address ranges
+// Websites may not proxy to by default. They cover pod and service networks in RFC 1918 space
+// and the cloud metadata endpoints.
+var defaultDeniedUpstreamCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// parseCIDRs parses a list of CIDRs.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid CIDR %q", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// upstreamTarget is an address nginx connects to on behalf of a Website: a host to resolve, or
+// the addresses nginx uses directly.
+type upstreamTarget struct {
+	name string
+	host string
+	ips  []net.IP
+}
+
+// upstreamTargets returns the addresses nginx connects to for a Website: the upstreams its
+// owner chose, with the host aliases nginx uses instead of DNS, the endpoints of its Service
+// that are not Pods, the forward auth services of its middleware and its tracing endpoint.
+// The ClusterIP of a Service is not a target; the endpoints behind it are.
+func (c *WebsiteController) upstreamTargets(ctx context.Context, website *v1alpha1.Website) ([]upstreamTarget, error) {
+	var targets []upstreamTarget
+
+	// Invalid host aliases fail rendering, so they need no check here
+	aliases, _ := hostAliases(website)
+	var upstreams []string
+	if website.Spec.ServiceRef == nil {
+		if website.Spec.Upstream != "" {
+			upstreams = append(upstreams, website.Spec.Upstream)
+		}
+		upstreams = append(upstreams, website.Spec.Upstreams...)
+	}
+	for _, route := range website.Spec.Routes {
+		upstreams = append(upstreams, route.Upstream)
+	}
+	for _, upstream := range upstreams {
+		// Upstreams without a host are denied, as nothing tells where nginx connects to
+		target := upstreamTarget{name: "upstream " + upstream}
+		if u, err := url.Parse(upstream); err == nil {
+			target.host = u.Hostname()
+		}
+		if ip, ok := aliases[strings.ToLower(target.host)]; ok && target.host != "" {
+			target.ips = []net.IP{net.ParseIP(ip)}
+		}
+		targets = append(targets, target)
+	}
+
+	// Check the endpoints of the Service that Kubernetes does not manage
+	if website.Spec.ServiceRef != nil {
+		endpoints, err := c.serviceRefTargets(ctx, website)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, endpoints...)
+	}
+
+	// Check the forward auth services of the middleware
+	for _, ref := range website.Spec.Middleware {
+		m := &v1alpha1.WebsiteMiddleware{}
+		err := c.client.Get(ctx, types.NamespacedName{Namespace: website.Namespace, Name: ref.Name}, m)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get WebsiteMiddleware %s", ref.Name)
+		}
+		if m.Spec.ForwardAuth == nil {
+			continue
+		}
+		target := upstreamTarget{name: fmt.Sprintf("forwardAuth of middleware %s", ref.Name)}
+		if u, err := url.Parse(m.Spec.ForwardAuth.URL); err == nil {
+			target.host = u.Hostname()
+		}
+		targets = append(targets, target)
+	}
+
+	// Check the tracing endpoint
+	if otel := otelSpec(website); otel != nil && otel.Endpoint != "" {
+		host, _, err := net.SplitHostPort(otel.Endpoint)
+		if err != nil {
+			host = otel.Endpoint
+		}
+		targets = append(targets, upstreamTarget{name: "tracing endpoint " + otel.Endpoint, host: host})
+	}
+	return targets, nil
+}
+
+// serviceRefTargets returns the endpoints of a Website's Service that do not belong to a Pod,
+// e.g. those of a Service without a selector, whose addresses its owner chose. A Service that
+// does not exist yet has none; resolving it fails until it does.
+func (c *WebsiteController) serviceRefTargets(ctx context.Context, website *v1alpha1.Website) ([]upstreamTarget, error) {
+	key := serviceRefKey(website)
+	slices := &discoveryv1.EndpointSliceList{}
+	err := c.client.List(ctx, slices, client.InNamespace(key.Namespace), client.MatchingLabels{discoveryv1.LabelServiceName: key.Name})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list endpoints of Service %s", key)
+	}
+
+	var targets []upstreamTarget
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				target := upstreamTarget{name: fmt.Sprintf("endpoint %s of Service %s", address, key), host: address}
+				if ip := net.ParseIP(address); ip != nil {
+					target.ips = []net.IP{ip}
+				}
+				targets = append(targets, target)
+			}
+		}
+	}
+	return targets, nil
+}
+
+// upstreamDenials returns why a Website's upstreams are denied, if they are: an address nginx
+// connects to that lies in a denied range, unless the Website's Namespace allows that address.
+// Hosts that cannot be parsed or resolved are denied, so the guard fails closed.
+func (c *WebsiteController) upstreamDenials(ctx context.Context, website *v1alpha1.Website) ([]string, error) {
+	if len(c.deniedUpstreamCIDRs) == 0 {
+		return nil, nil
+	}
+	targets, err := c.upstreamTargets(ctx, website)
+	if err != nil {
+		return nil, err
+	}
+	return c.targetDenials(ctx, website.Namespace, targets)
+}
+
+// streamRouteDenials returns why a StreamRoute's upstream is denied, if it is.
+func (c *WebsiteController) streamRouteDenials(ctx context.Context, route *v1alpha1.StreamRoute) ([]string, error) {
+	if len(c.deniedUpstreamCIDRs) == 0 {
+		return nil, nil
+	}
+	target := upstreamTarget{name: "upstream " + route.Spec.Upstream}
+	if host, _, err := net.SplitHostPort(route.Spec.Upstream); err == nil {
+		target.host = host
+	}
+	return c.targetDenials(ctx, route.Namespace, []upstreamTarget{target})
+}
+
+// targetDenials returns why the targets of an object in a namespace are denied, if they are.
+func (c *WebsiteController) targetDenials(ctx context.Context, namespaceName string, targets []upstreamTarget) ([]string, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	// Read the ranges the Namespace allows; Websites read from a directory have no Namespace
+	namespace := &corev1.Namespace{}
+	err := c.client.Get(ctx, types.NamespacedName{Name: namespaceName}, namespace)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "failed to get Namespace")
+	}
+	var denials []string
+	allowed, err := parseCIDRs(strings.Split(namespace.Annotations[allowedUpstreamCIDRsAnnotation], ","))
+	if err != nil {
+		denials = append(denials, fmt.Sprintf("annotation %s of Namespace %s: %s", allowedUpstreamCIDRsAnnotation, namespaceName, err))
+	}
+
+	for _, target := range targets {
+		if target.host == "" && target.ips == nil {
+			denials = append(denials, fmt.Sprintf("%s cannot be checked: it has no valid host", target.name))
+			continue
+		}
+		ips := target.ips
+		if ips == nil {
+			ips, err = resolveUpstreamHost(ctx, target.host)
+			if err != nil {
+				denials = append(denials, fmt.Sprintf("%s cannot be checked: %s", target.name, err))
+				continue
+			}
+		}
+		for _, ip := range ips {
+			denied := containingNetwork(c.deniedUpstreamCIDRs, ip)
+			if denied != nil && containingNetwork(allowed, ip) == nil {
+				denials = append(denials, fmt.Sprintf("%s resolves to %s in the denied range %s", target.name, ip, denied))
+				break
+			}
+		}
+	}
+	sort.Strings(denials)
+	return denials, nil
+}
+
+// resolveUpstreamHost returns the addresses of an upstream's host.
+func resolveUpstreamHost(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, upstreamResolveTimeout)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %s", host)
+	}
+	ips := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		ips = append(ips, address.IP)
+	}
+	return ips, nil
+}
+
+// containingNetwork returns the first network containing an address, if any.
+func containingNetwork(networks []*net.IPNet, ip net.IP) *net.IPNet {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// checkUpstreams refuses to serve a Website proxying to a denied address, marking it not Ready
+// with the denials. The admission webhook rejects such Websites up front; this catches
+// Websites admitted without it and upstreams whose addresses changed since.
+func (c *WebsiteController) checkUpstreams(ctx context.Context, website *v1alpha1.Website) error {
+	denials, err := c.upstreamDenials(ctx, website)
+	if err != nil {
+		return err
+	}
+	if len(denials) == 0 {
+		return nil
+	}
+	message := "The Website's upstreams are denied: " + strings.Join(denials, "; ")
+
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err = c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "UpstreamDenied",
+			Message:            message,
+			ObservedGeneration: website.Generation,
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	return errors.New(message)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// newTestController creates a renderer reading the objects from a fake cluster and denying
+// upstreams in the default denied ranges.
+func newTestController(t *testing.T, objects ...client.Object) *WebsiteController {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	denied, err := parseCIDRs(defaultDeniedUpstreamCIDRs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newRenderer()
+	c.client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithStatusSubresource(&v1alpha1.Website{}).Build()
+	c.deniedUpstreamCIDRs = denied
+	return c
+}
+
+// testNamespace is a Namespace with annotations.
+func testNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+// guardedWebsite is a Website in the team namespace for the upstream guard tests.
+func guardedWebsite(spec v1alpha1.WebsiteSpec) *v1alpha1.Website {
+	spec.Hostname = "shop.example.com"
+	return &v1alpha1.Website{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "shop"}, Spec: spec}
+}
+
+func TestUpstreamDenials(t *testing.T) {
+	tests := []struct {
+		name    string
+		website *v1alpha1.Website
+		objects []client.Object
+		want    []string
+	}{
+		{
+			name:    "public address",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10:8080"}),
+		},
+		{
+			name:    "private address",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://10.0.0.5:8080"}),
+			want:    []string{"upstream http://10.0.0.5:8080 resolves to 10.0.0.5 in the denied range 10.0.0.0/8"},
+		},
+		{
+			name:    "metadata endpoint among the upstreams",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10", Upstreams: []string{"http://169.254.169.254"}}),
+			want:    []string{"upstream http://169.254.169.254 resolves to 169.254.169.254 in the denied range 169.254.0.0/16"},
+		},
+		{
+			name:    "upstream without a scheme",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "169.254.169.254:80"}),
+			want:    []string{"upstream 169.254.169.254:80 cannot be checked: it has no valid host"},
+		},
+		{
+			name: "route upstream",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Routes: []v1alpha1.Route{
+				{Path: "/api", Upstream: "http://127.0.0.1:9000"},
+			}}),
+			want: []string{"upstream http://127.0.0.1:9000 resolves to 127.0.0.1 in the denied range 127.0.0.0/8"},
+		},
+		{
+			name: "host alias to a private address",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{
+				Upstream:    "http://backend.example.com",
+				HostAliases: []v1alpha1.HostAlias{{Hostname: "backend.example.com", IP: "192.168.1.20"}},
+			}),
+			want: []string{"upstream http://backend.example.com resolves to 192.168.1.20 in the denied range 192.168.0.0/16"},
+		},
+		{
+			name:    "address allowed by the Namespace",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://10.0.0.5:8080"}),
+			objects: []client.Object{testNamespace("team", map[string]string{allowedUpstreamCIDRsAnnotation: "10.0.0.0/24"})},
+		},
+		{
+			name:    "invalid allowed ranges allow nothing",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://10.0.0.5:8080"}),
+			objects: []client.Object{testNamespace("team", map[string]string{allowedUpstreamCIDRsAnnotation: "10.0.0.0/33"})},
+			want: []string{
+				`annotation website-operator.io/allowed-upstream-cidrs of Namespace team: invalid CIDR "10.0.0.0/33": invalid CIDR address: 10.0.0.0/33`,
+				"upstream http://10.0.0.5:8080 resolves to 10.0.0.5 in the denied range 10.0.0.0/8",
+			},
+		},
+		{
+			name: "forward auth of a middleware",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{
+				Upstream:   "http://203.0.113.10",
+				Middleware: []corev1.LocalObjectReference{{Name: "auth"}},
+			}),
+			objects: []client.Object{&v1alpha1.WebsiteMiddleware{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "auth"},
+				Spec:       v1alpha1.WebsiteMiddlewareSpec{ForwardAuth: &v1alpha1.ForwardAuthSpec{URL: "http://172.16.0.1/auth"}},
+			}},
+			want: []string{"forwardAuth of middleware auth resolves to 172.16.0.1 in the denied range 172.16.0.0/12"},
+		},
+		{
+			name:    "Service endpoints of Pods",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{ServiceRef: &v1alpha1.ServiceReference{Name: "shop", Port: 80}}),
+			objects: []client.Object{testEndpointSlice(&corev1.ObjectReference{Kind: "Pod", Name: "shop-0"}, "10.1.2.3")},
+		},
+		{
+			name:    "Service endpoints without a Pod",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{ServiceRef: &v1alpha1.ServiceReference{Name: "shop", Port: 80}}),
+			objects: []client.Object{testEndpointSlice(nil, "169.254.169.254")},
+			want:    []string{"endpoint 169.254.169.254 of Service team/shop resolves to 169.254.169.254 in the denied range 169.254.0.0/16"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, tt.objects...)
+			got, err := c.upstreamDenials(context.Background(), tt.website)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamRouteDenials(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		want     []string
+	}{
+		{name: "public address", upstream: "203.0.113.10:5432"},
+		{name: "private address", upstream: "10.0.0.5:5432", want: []string{"upstream 10.0.0.5:5432 resolves to 10.0.0.5 in the denied range 10.0.0.0/8"}},
+		{name: "no port", upstream: "10.0.0.5", want: []string{"upstream 10.0.0.5 cannot be checked: it has no valid host"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t)
+			route := &v1alpha1.StreamRoute{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "db"},
+				Spec:       v1alpha1.StreamRouteSpec{Port: 5432, Upstream: tt.upstream},
+			}
+			got, err := c.streamRouteDenials(context.Background(), route)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamDenialsUnresolvable(t *testing.T) {
+	c := newTestController(t)
+	got, err := c.upstreamDenials(context.Background(), guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://backend.invalid"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !strings.HasPrefix(got[0], "upstream http://backend.invalid cannot be checked: ") {
+		t.Errorf("got %q, want the unresolvable host denied", got)
+	}
+}
+
+func TestUpstreamDenialsDisabled(t *testing.T) {
+	c := newTestController(t)
+	c.deniedUpstreamCIDRs = nil
+	got, err := c.upstreamDenials(context.Background(), guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://10.0.0.5"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %q without denied ranges", got)
+	}
+}
+
+// testEndpointSlice is an EndpointSlice of the shop Service in the team namespace.
+func testEndpointSlice(targetRef *corev1.ObjectReference, address string) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "team",
+			Name:      "shop-abcde",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "shop"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   []discoveryv1.Endpoint{{Addresses: []string{address}, TargetRef: targetRef}},
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// usageConfigPath is the managed include that logs the served bytes of every request.
+	usageConfigPath = "/etc/nginx/conf.d/00-website-controller-usage.conf"
+
+	// usageLogPath is the log the usage is aggregated from.
+	usageLogPath = "/var/log/nginx/website-usage.log"
+
+	// usagePendingLimit bounds the usage reports kept while the endpoint is unavailable.
+	usagePendingLimit = 100
+)
+
+// usageConfig logs the server name and the bytes sent of every request. Access logs declared
+// in the http context add up, so nginx's own access log is kept.
+const usageConfig = `log_format website_usage '$server_name $bytes_sent';
+access_log ` + usageLogPath + ` website_usage;
+`
+
+// UsageReport holds the usage of all Websites within a period.
+type UsageReport struct {
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Records []UsageRecord `json:"records"`
+}
+
+// UsageRecord is the usage of a single Website within a period.
+type UsageRecord struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Hostname  string `json:"hostname"`
+	Requests  int64  `json:"requests"`
+	BytesSent int64  `json:"bytesSent"`
+}
+
+// logTail reads the lines appended to a log since it was last read.
+type logTail struct {
+	path   string
+	info   os.FileInfo
+	offset int64
+}
+
+// runUsageAccounting aggregates the usage log into a report per interval and posts the reports
+// to the usage endpoint until the context is cancelled.
+func (c *WebsiteController) runUsageAccounting(ctx context.Context) {
+	// Log the usage of every request
+	existing, err := os.ReadFile(usageConfigPath)
+	if err != nil || string(existing) != usageConfig {
+		err = writeFileAtomic(usageConfigPath, []byte(usageConfig), 0644)
+		c.audit(auditConfigWrite, nil, usageConfigPath, []byte(usageConfig), err)
+		if err == nil {
+			err = c.reloadNginx(nil)
+		}
+		if err != nil {
+			c.log.Error(err, "failed to enable usage logging")
+			return
+		}
+	}
+
+	// Start at the end of the log, so usage reported by a previous run is not reported twice
+	tail := &logTail{path: usageLogPath}
+	tail.read(func(string) {})
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(c.usageInterval)
+	defer ticker.Stop()
+
+	var pending []UsageReport
+	start := time.Now().UTC()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Aggregate the usage of the period
+		report := c.aggregateUsage(tail, start)
+		start = report.End
+		pending = append(pending, report)
+		if len(pending) > usagePendingLimit {
+			c.log.Info("dropping usage reports, the usage endpoint is unavailable", "dropped", len(pending)-usagePendingLimit)
+			pending = pending[len(pending)-usagePendingLimit:]
+		}
+
+		// Post the reports, keeping them for the next period if the endpoint fails
+		for len(pending) > 0 {
+			err := postUsage(client, c.usageEndpoint, pending[0])
+			if err != nil {
+				c.log.Error(err, "failed to post usage report", "pending", len(pending))
+				break
+			}
+			pending = pending[1:]
+		}
+	}
+}
+
+// aggregateUsage reads the usage logged since the last period and attributes it to Websites.
+// Requests for hostnames not served by a Website are not reported.
+func (c *WebsiteController) aggregateUsage(tail *logTail, start time.Time) UsageReport {
+	usage := map[string]*UsageRecord{}
+	err := tail.read(func(line string) {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return
+		}
+		bytesSent, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return
+		}
+		record, ok := usage[fields[0]]
+		if !ok {
+			record = &UsageRecord{Hostname: fields[0]}
+			usage[fields[0]] = record
+		}
+		record.Requests++
+		record.BytesSent += bytesSent
+	})
+	if err != nil {
+		c.log.Error(err, "failed to read usage log")
+	}
+
+	report := UsageReport{Start: start, End: time.Now().UTC(), Records: []UsageRecord{}}
+	for hostname, record := range usage {
+		key, ok := c.hostnames.owner(hostname)
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(key, "/", 2)
+		record.Namespace, record.Name = parts[0], parts[1]
+		report.Records = append(report.Records, *record)
+	}
+	sort.Slice(report.Records, func(i, j int) bool { return report.Records[i].Hostname < report.Records[j].Hostname })
+
+	return report
+}
+
+// read passes the complete lines appended to the log since the last read to fn. The first
+// read starts at the end of the log. A rotated or truncated log is read from its start.
+func (t *logTail) read(fn func(line string)) error {
+	info, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t.info == nil || !os.SameFile(t.info, info) || info.Size() < t.offset {
+		if t.info != nil {
+			t.offset = 0
+		} else {
+			t.offset = info.Size()
+		}
+	}
+	t.info = info
+
+	file, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Seek(t.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	// Partial lines are left for the next read
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		t.offset += int64(len(line))
+		fn(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// postUsage posts a usage report to the usage endpoint as JSON.
+func postUsage(client *http.Client, endpoint string, report UsageReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal usage report")
+	}
+
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to post usage report")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("usage endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+const (
+	// nginxAddress is where the local nginx serves the Websites.
+	nginxAddress = "http://127.0.0.1:80"
+
+	// defaultVerificationTimeout is how long a verification probe is retried by default.
+	defaultVerificationTimeout = 10 * time.Second
+
+	// maxVerificationTimeout bounds the verification timeout of a Website, as the probe holds
+	// up the reconciliation of all Websites.
+	maxVerificationTimeout = time.Minute
+
+	// verificationRetryInterval is the pause between two verification probes.
+	verificationRetryInterval = 500 * time.Millisecond
+)
+
+// markReady verifies that a Website is routed correctly, if it requests verification,
+// and records the outcome in its Ready condition.
+func (c *WebsiteController) markReady(ctx context.Context, website *v1alpha1.Website) error {
+	condition := metav1.Condition{
+		Type:               v1alpha1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Served",
+		Message:            "The Website is served by nginx",
+		ObservedGeneration: website.Generation,
+	}
+
+	// Verify the Website through the local nginx
+	verifyErr := verifyHostname(ctx, website)
+	if verifyErr != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "VerificationFailed"
+		condition.Message = verifyErr.Error()
+	}
+
+	// Record the outcome in the Website status
+	key := types.NamespacedName{Namespace: website.Namespace, Name: website.Name}
+	err := c.updateStatus(ctx, key, func(status *v1alpha1.WebsiteStatus) {
+		status.ObservedGeneration = website.Generation
+		status.URL = websiteURL(website)
+		meta.SetStatusCondition(&status.Conditions, condition)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update Ready condition")
+	}
+
+	if verifyErr != nil {
+		return errors.Wrap(verifyErr, "failed to verify Website")
+	}
+
+	return nil
+}
+
+// verifyHostname requests the verification path of a Website through the local nginx
+// until the expected status is returned or the verification timeout expires.
+// Websites without a verification spec are not probed.
+func verifyHostname(ctx context.Context, website *v1alpha1.Website) error {
+	verification := website.Spec.Verification
+	if verification == nil {
+		return nil
+	}
+
+	path := verification.Path
+	if path == "" {
+		path = "/"
+		if website.Spec.PathPrefix != "" {
+			path = website.Spec.PathPrefix
+		}
+	}
+	if !strings.HasPrefix(path, "/") {
+		return errors.Errorf("verification path %q must start with /", path)
+	}
+	expected := verification.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	timeout := defaultVerificationTimeout
+	if verification.Timeout != nil && verification.Timeout.Duration > 0 {
+		timeout = verification.Timeout.Duration
+	}
+	if timeout > maxVerificationTimeout {
+		timeout = maxVerificationTimeout
+	}
+
+	// The reload is asynchronous, so the probe is retried until the new workers serve the Website
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	scheme, port, proxyProtocol := verificationListener(website)
+	nginx, err := url.Parse(nginxAddress)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse nginx address")
+	}
+	target := &url.URL{Scheme: scheme, Host: net.JoinHostPort(nginx.Hostname(), strconv.Itoa(int(port))), Path: path}
+	transport := &http.Transport{}
+	client := &http.Client{
+		Transport: transport,
+		// Redirects are part of the response being verified
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	// Websites served over HTTPS are probed there; their certificate is checked separately
+	if scheme == "https" {
+		transport.TLSClientConfig = &tls.Config{ServerName: website.Spec.Hostname, InsecureSkipVerify: true}
+	}
+
+	// Listeners behind a load balancer expect the PROXY protocol header first
+	if proxyProtocol {
+		dialer := &net.Dialer{}
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			_, err = fmt.Fprintf(conn, "PROXY TCP4 127.0.0.1 127.0.0.1 %d %d\r\n", port, port)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		}
+	}
+	var lastErr error
+	for {
+		lastErr = probeHostname(ctx, client, target, website.Spec.Hostname, expected)
+		if lastErr == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return lastErr
+		case <-time.After(verificationRetryInterval):
+		}
+	}
+}
+
+// verificationListener returns the scheme and port a Website is verified on and whether the
+// port expects the PROXY protocol: the HTTPS listener of a Website redirecting to HTTPS, its
+// HTTP listener otherwise, or the first listener if it has none of that protocol. Without
+// listeners, a Website is served on 80 and 443.
+func verificationListener(website *v1alpha1.Website) (string, int32, bool) {
+	protocol := v1alpha1.ListenerHTTP
+	if redirectsHTTP(website) {
+		protocol = v1alpha1.ListenerHTTPS
+	}
+	if len(website.Spec.Listeners) == 0 {
+		if protocol == v1alpha1.ListenerHTTPS {
+			return "https", 443, false
+		}
+		return "http", 80, false
+	}
+
+	listener := website.Spec.Listeners[0]
+	for _, l := range website.Spec.Listeners {
+		if l.Protocol == protocol || (l.Protocol == "" && protocol == v1alpha1.ListenerHTTP) {
+			listener = l
+			break
+		}
+	}
+	scheme := "http"
+	if listener.Protocol == v1alpha1.ListenerHTTPS {
+		scheme = "https"
+	}
+	return scheme, listener.Port, listener.ProxyProtocol
+}
+
+// probeHostname sends a single verification request.
+func probeHostname(ctx context.Context, client *http.Client, target *url.URL, hostname string, expected int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create verification request")
+	}
+	req.Host = hostname
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "verification request for %s%s failed", hostname, target.Path)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		return errors.Errorf("verification request for %s%s returned %d, expected %d", hostname, target.Path, resp.StatusCode, expected)
+	}
+
+	return nil
+}
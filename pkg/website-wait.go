@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+	"github.com/website-operator/pkg/cli"
+)
+
+// waitCommand waits until a condition of a Website has the expected status for its current
+// generation, so deployment scripts can gate a release on the edge serving the new
+// configuration. It fails if the timeout expires or the Website is deleted.
+func waitCommand(args []string) error {
+	flags := flag.NewFlagSet("wait", flag.ExitOnError)
+	condition := flags.String("for", v1alpha1.ConditionReady, "condition to wait for, as Type or Type=Status, e.g. Ready or CertificateReady=True")
+	timeout := flags.Duration("timeout", 2*time.Minute, "how long to wait for the condition")
+	output := flags.String("o", "", "output format of the report: json or yaml, a line if empty")
+	args, err := cli.ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: website-controller wait <namespace>/<name> [--for=Type[=Status]] [--timeout=DURATION] [-o json|yaml]")
+	}
+	err = cli.CheckOptionalOutputFormat(*output)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: "default", Name: args[0]}
+	if parts := strings.SplitN(args[0], "/", 2); len(parts) == 2 {
+		key = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+	conditionType, status, err := cli.ParseWaitCondition(*condition)
+	if err != nil {
+		return err
+	}
+
+	c, err := newCommandClient()
+	if err != nil {
+		return errors.Wrap(err, "failed to create client")
+	}
+
+	// Watch the Website until the condition is met for its generation
+	report, err := cli.Wait(c, key, conditionType, status, *timeout)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return cli.PrintReport(*output, report)
+	}
+	fmt.Printf("website/%s condition met: %s=%s\n", key.Name, conditionType, status)
+	return nil
+}
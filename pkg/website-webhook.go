@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// webhookPath is where the validating admission webhook for Websites is served.
+const webhookPath = "/validate-website"
+
+// serveWebhook serves the validating admission webhook for Websites over TLS until the context
+// is cancelled. A ValidatingWebhookConfiguration must send the CREATE and UPDATE requests of
+// websites to webhookPath.
+func (c *WebsiteController) serveWebhook(ctx context.Context, address, certFile, keyFile string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		review := &admissionv1.AdmissionReview{}
+		err := json.NewDecoder(r.Body).Decode(review)
+		if err != nil || review.Request == nil {
+			http.Error(w, "invalid admission review", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionv1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+		problems, err := c.admitWebsite(r.Context(), review.Request)
+		switch {
+		case err != nil:
+			response.Allowed = false
+			response.Result = &metav1.Status{Code: http.StatusInternalServerError, Message: err.Error()}
+		case len(problems) > 0:
+			response.Allowed = false
+			response.Result = &metav1.Status{Code: http.StatusUnprocessableEntity, Message: strings.Join(problems, "; ")}
+		}
+		review.Response = response
+		review.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	})
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	err := server.ListenAndServeTLS(certFile, keyFile)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// admitWebsite returns the problems of a Website that is created or updated.
+func (c *WebsiteController) admitWebsite(ctx context.Context, request *admissionv1.AdmissionRequest) ([]string, error) {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return nil, nil
+	}
+	website := &v1alpha1.Website{}
+	err := json.Unmarshal(request.Object.Raw, website)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode Website")
+	}
+	if website.Namespace == "" {
+		website.Namespace = request.Namespace
+	}
+	return c.validateWebsite(ctx, website)
+}
+
+// validateWebsite checks the hostnames and upstreams of a Website, including that they are not
+// in a denied address range, and that no other Website claims an overlapping route or one of
+// the hostnames of its SNI certificates.
+func (c *WebsiteController) validateWebsite(ctx context.Context, website *v1alpha1.Website) ([]string, error) {
+	var problems []string
+	spec := website.Spec
+
+	// Check the hostname
+	if errs := validation.IsDNS1123Subdomain(spec.Hostname); len(errs) > 0 {
+		problems = append(problems, fmt.Sprintf("spec.hostname %q is invalid: %s", spec.Hostname, strings.Join(errs, ", ")))
+	}
+	problems = append(problems, sniHostnameProblems(website)...)
+
+	// Check the upstream schemes
+	upstreams := append([]string{}, spec.Upstreams...)
+	if spec.Upstream != "" {
+		upstreams = append([]string{spec.Upstream}, upstreams...)
+	}
+	for _, route := range spec.Routes {
+		upstreams = append(upstreams, route.Upstream)
+	}
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(upstream, " \t\n;{}'\"\\$") {
+			problems = append(problems, fmt.Sprintf("upstream %q is invalid: must be an http(s) URL", upstream))
+		}
+	}
+
+	// Check that a Service in another namespace may be referenced
+	if spec.ServiceRef != nil {
+		err := checkServiceRefGrant(ctx, c.client, website)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// Check that the upstreams are not internal addresses
+	denials, err := c.upstreamDenials(ctx, website)
+	if err != nil {
+		return nil, err
+	}
+	problems = append(problems, denials...)
+
+	// Check that no other Website of the class claims the route or the SNI hostnames
+	list := &v1alpha1.WebsiteList{}
+	err = c.client.List(ctx, list)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list Websites")
+	}
+	for i := range list.Items {
+		other := &list.Items[i]
+		if websiteKey(other) == websiteKey(website) || other.DeletionTimestamp != nil || !hasClass(other, website.Spec.ClassName) {
+			continue
+		}
+		if routesOverlap(websiteRoute(other), websiteRoute(website)) {
+			problems = append(problems, fmt.Sprintf("route %s is already claimed by Website %s", websiteRoute(website), websiteKey(other)))
+		}
+		if hostname, ok := sharedSNIHostname(website, other); ok {
+			problems = append(problems, fmt.Sprintf("hostname %s is already claimed by Website %s", hostname, websiteKey(other)))
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/website-operator/pkg/apis/website/v1alpha1"
+)
+
+// claimingWebsite is a Website of another namespace claiming a route.
+func claimingWebsite(name, hostname, pathPrefix, className string) *v1alpha1.Website {
+	return &v1alpha1.Website{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: name},
+		Spec: v1alpha1.WebsiteSpec{
+			Hostname:   hostname,
+			PathPrefix: pathPrefix,
+			ClassName:  className,
+			Upstream:   "http://203.0.113.20",
+		},
+	}
+}
+
+func TestValidateWebsite(t *testing.T) {
+	tests := []struct {
+		name    string
+		website *v1alpha1.Website
+		objects []client.Object
+		want    []string
+	}{
+		{
+			name:    "valid",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10"}),
+		},
+		{
+			name: "invalid hostname",
+			website: func() *v1alpha1.Website {
+				website := guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10"})
+				website.Spec.Hostname = "Shop_Example"
+				return website
+			}(),
+			want: []string{`spec.hostname "Shop_Example" is invalid: a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')`},
+		},
+		{
+			name:    "upstream scheme",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "ftp://203.0.113.10", Upstreams: []string{"203.0.113.11"}}),
+			want: []string{
+				`upstream "ftp://203.0.113.10" is invalid: must be an http(s) URL`,
+				`upstream "203.0.113.11" is invalid: must be an http(s) URL`,
+				"upstream 203.0.113.11 cannot be checked: it has no valid host",
+			},
+		},
+		{
+			name:    "upstream with nginx directives",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10/x; return 200 pwned"}),
+			want:    []string{`upstream "http://203.0.113.10/x; return 200 pwned" is invalid: must be an http(s) URL`},
+		},
+		{
+			name:    "internal upstream",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://10.0.0.5"}),
+			want:    []string{"upstream http://10.0.0.5 resolves to 10.0.0.5 in the denied range 10.0.0.0/8"},
+		},
+		{
+			name:    "Service of another namespace without a grant",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{ServiceRef: &v1alpha1.ServiceReference{Name: "shop", Namespace: "backend", Port: 80}}),
+			want:    []string{"Namespace backend does not grant Websites of namespace team access to Service shop with the website-operator.io/service-ref-namespaces annotation"},
+		},
+		{
+			name:    "Service of another namespace with a grant",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{ServiceRef: &v1alpha1.ServiceReference{Name: "shop", Namespace: "backend", Port: 80}}),
+			objects: []client.Object{testNamespace("backend", map[string]string{serviceRefNamespacesAnnotation: "frontend, team"})},
+		},
+		{
+			name:    "route claimed by another Website",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10"}),
+			objects: []client.Object{claimingWebsite("shop-old", "shop.example.com", "/api", "")},
+			want:    []string{"route shop.example.com is already claimed by Website other/shop-old"},
+		},
+		{
+			name: "SNI hostname claimed by another Website",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{
+				Upstream: "http://203.0.113.10",
+				TLS: &v1alpha1.TLSSpec{
+					SecretRef: &corev1.LocalObjectReference{Name: "shop-tls"},
+					Certificates: []v1alpha1.TLSCertificate{
+						{SecretRef: corev1.LocalObjectReference{Name: "blog-tls"}, Hostnames: []string{"blog.example.com"}},
+					},
+				},
+			}),
+			objects: []client.Object{claimingWebsite("blog", "blog.example.com", "", "")},
+			want:    []string{"hostname blog.example.com is already claimed by Website other/blog"},
+		},
+		{
+			name:    "route of another class",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10"}),
+			objects: []client.Object{claimingWebsite("shop-old", "shop.example.com", "", "internal")},
+		},
+		{
+			name:    "disjoint path prefix",
+			website: guardedWebsite(v1alpha1.WebsiteSpec{Upstream: "http://203.0.113.10", PathPrefix: "/shop"}),
+			objects: []client.Object{claimingWebsite("blog", "shop.example.com", "/blog", "")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(t, tt.objects...)
+			got, err := c.validateWebsite(context.Background(), tt.website)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}